@@ -24,10 +24,13 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	// Init DB (Oracle via wallet/TNS)
+	// Init DB (Oracle via wallet/TNS, PostgreSQL, or embedded SQLite)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	dbpool, err := repo.NewDB(ctx, repo.DBConfig{
+	dbpool, err := repo.Open(ctx, repo.DBConfig{
+		Driver:        cfg.DBDriver,
+		DSN:           cfg.DatabaseURL,
+		SQLitePath:    cfg.SQLitePath,
 		User:          cfg.DBUser,
 		Password:      cfg.DBPassword,
 		ConnectString: cfg.DBConnectString,
@@ -38,19 +41,49 @@ func main() {
 	}
 	defer dbpool.Close()
 
-	if err := repo.BootstrapSchema(ctx, dbpool); err != nil {
-		log.Fatalf("failed to bootstrap schema: %v", err)
+	// Apply pending schema migrations (disable with AUTO_MIGRATE=false and use tools/bootstrap).
+	// Backfills can outlast the connect timeout above, so they run without a deadline.
+	if cfg.AutoMigrate {
+		applied, err := repo.MigrateUp(context.Background(), dbpool, 0)
+		if err != nil {
+			log.Fatalf("failed to migrate schema: %v", err)
+		}
+		for _, m := range applied {
+			log.Printf("applied migration %d %s", m.Version, m.Name)
+		}
 	}
 
 	// Repos
 	userRepo := repo.NewUserRepo(dbpool)
-	patientRepo := repo.NewPatientRepo(dbpool)
-	paymentRepo := repo.NewPaymentRepo(dbpool)
+	patientRepo := repo.NewPatientRepo(dbpool, cfg.PhoneCountry)
+	paymentRepo := repo.NewPaymentRepo(dbpool, cfg.Currency)
+	invoiceRepo := repo.NewInvoiceRepo(dbpool, cfg.Currency)
+	appointmentRepo := repo.NewAppointmentRepo(dbpool)
+	exerciseRepo := repo.NewExerciseRepo(dbpool)
+	libraryRepo := repo.NewExerciseLibraryRepo(dbpool)
+	measurementRepo := repo.NewMeasurementRepo(dbpool)
+	questionnaireRepo := repo.NewQuestionnaireRepo(dbpool)
+	visitNoteRepo := repo.NewVisitNoteRepo(dbpool)
+	episodeRepo := repo.NewEpisodeRepo(dbpool)
+	dischargeRepo := repo.NewDischargeRepo(dbpool, cfg.Currency)
+	receiptRepo := repo.NewReceiptRepo(dbpool)
+	exportRepo := repo.NewExportRepo(dbpool)
 
 	// Handlers
 	authHandler := handlers.NewAuthHandler(userRepo, cfg.JWTSecret, cfg.JWTIssuer, cfg.JWTExpiry)
 	patientHandler := handlers.NewPatientHandler(patientRepo)
 	paymentHandler := handlers.NewPaymentHandler(paymentRepo)
+	invoiceHandler := handlers.NewInvoiceHandler(invoiceRepo)
+	appointmentHandler := handlers.NewAppointmentHandler(appointmentRepo)
+	exerciseHandler := handlers.NewExerciseHandler(exerciseRepo)
+	libraryHandler := handlers.NewExerciseLibraryHandler(libraryRepo)
+	measurementHandler := handlers.NewMeasurementHandler(measurementRepo)
+	questionnaireHandler := handlers.NewQuestionnaireHandler(questionnaireRepo)
+	visitNoteHandler := handlers.NewVisitNoteHandler(visitNoteRepo)
+	episodeHandler := handlers.NewEpisodeHandler(episodeRepo)
+	dischargeHandler := handlers.NewDischargeHandler(dischargeRepo)
+	receiptHandler := handlers.NewReceiptHandler(receiptRepo)
+	exportHandler := handlers.NewExportHandler(exportRepo)
 
 	router := gin.Default()
 
@@ -71,14 +104,98 @@ func main() {
 	// Patients
 	api.POST("/patients", patientHandler.Create)
 	api.GET("/patients", patientHandler.List)
+	api.GET("/patients/trash", patientHandler.Trash)
+	api.GET("/patients/search", patientHandler.Search)
+	api.GET("/patients/autocomplete", patientHandler.Autocomplete)
+	api.GET("/patients/duplicates", patientHandler.Duplicates)
 	api.GET("/patients/:id", patientHandler.GetByID)
 	api.PATCH("/patients/:id", patientHandler.Update)
+	api.DELETE("/patients/:id", patientHandler.Archive)
+	api.POST("/patients/:id/restore", patientHandler.Restore)
+	api.DELETE("/patients/:id/purge", patientHandler.Purge)
+	api.POST("/patients/:id/merge", patientHandler.Merge)
+	api.GET("/patients/:id/history", patientHandler.History)
+	api.POST("/patients/:id/history/revert", patientHandler.Revert)
+	api.GET("/patients/:id/status-history", patientHandler.StatusHistory)
+	api.GET("/patients/:id/balance", paymentHandler.Balance)
+	api.GET("/patients/:id/exercises", exerciseHandler.List)
+	api.POST("/patients/:id/exercises", exerciseHandler.Create)
+	api.PUT("/patients/:id/exercises", exerciseHandler.Replace)
+	api.GET("/patients/:id/exercises/:exercise_id", exerciseHandler.GetByID)
+	api.PATCH("/patients/:id/exercises/:exercise_id", exerciseHandler.Update)
+	api.DELETE("/patients/:id/exercises/:exercise_id", exerciseHandler.Delete)
+	api.POST("/patients/:id/measurements", measurementHandler.Create)
+	api.GET("/patients/:id/measurements", measurementHandler.List)
+	api.GET("/patients/:id/measurements/series", measurementHandler.Series)
+	api.GET("/patients/:id/measurements/:measurement_id", measurementHandler.GetByID)
+	api.PATCH("/patients/:id/measurements/:measurement_id", measurementHandler.Update)
+	api.DELETE("/patients/:id/measurements/:measurement_id", measurementHandler.Delete)
+	api.POST("/patients/:id/questionnaires", questionnaireHandler.Submit)
+	api.GET("/patients/:id/questionnaires", questionnaireHandler.History)
+	api.GET("/patients/:id/questionnaires/:response_id", questionnaireHandler.GetResponse)
+	api.DELETE("/patients/:id/questionnaires/:response_id", questionnaireHandler.DeleteResponse)
+	api.POST("/patients/:id/notes", visitNoteHandler.Create)
+	api.GET("/patients/:id/notes", visitNoteHandler.List)
+	api.GET("/patients/:id/notes/:note_id", visitNoteHandler.GetByID)
+	api.PATCH("/patients/:id/notes/:note_id", visitNoteHandler.Update)
+	api.DELETE("/patients/:id/notes/:note_id", visitNoteHandler.Delete)
+	api.GET("/patients/:id/timeline", visitNoteHandler.Timeline)
+	api.GET("/patients/:id/episodes", episodeHandler.List)
+	api.POST("/patients/:id/episodes", episodeHandler.Open)
+	api.GET("/patients/:id/episodes/:episode_id", episodeHandler.GetByID)
+	api.PATCH("/patients/:id/episodes/:episode_id", episodeHandler.Update)
+	api.POST("/patients/:id/discharge", dischargeHandler.Discharge)
+	api.GET("/patients/:id/discharges", dischargeHandler.List)
+	api.GET("/patients/:id/discharges/:discharge_id", dischargeHandler.GetByID)
+	api.GET("/patients/:id/discharges/:discharge_id/summary", dischargeHandler.Summary)
 
 	// Payments
 	api.POST("/payments", paymentHandler.Create)
 	api.GET("/payments", paymentHandler.List)
+	api.GET("/payments/:id", paymentHandler.GetByID)
 	api.PATCH("/payments/:id", paymentHandler.Update)
 	api.DELETE("/payments/:id", paymentHandler.Delete)
+	api.GET("/payments/:id/receipt.pdf", receiptHandler.Receipt)
+
+	// Billing
+	api.GET("/clinic", receiptHandler.Clinic)
+	api.PUT("/clinic", receiptHandler.PutClinic)
+	api.GET("/fees", invoiceHandler.Fees)
+	api.PUT("/fees/:code", invoiceHandler.PutFee)
+	api.DELETE("/fees/:code", invoiceHandler.DeleteFee)
+	api.POST("/invoices", invoiceHandler.Create)
+	api.GET("/invoices", invoiceHandler.List)
+	api.GET("/invoices/:id", invoiceHandler.GetByID)
+	api.PATCH("/invoices/:id", invoiceHandler.Update)
+	api.DELETE("/invoices/:id", invoiceHandler.Delete)
+
+	// Export
+	api.GET("/export.xlsx", exportHandler.Workbook)
+	api.GET("/export/patients.csv", exportHandler.PatientsCSV)
+	api.GET("/export/payments.csv", exportHandler.PaymentsCSV)
+
+	// Exercise library
+	api.GET("/exercise-library", libraryHandler.Search)
+	api.POST("/exercise-library", libraryHandler.Create)
+	api.GET("/exercise-library/:id", libraryHandler.GetByID)
+	api.PATCH("/exercise-library/:id", libraryHandler.Update)
+	api.DELETE("/exercise-library/:id", libraryHandler.Delete)
+
+	// Questionnaires
+	api.GET("/questionnaires", questionnaireHandler.List)
+	api.GET("/questionnaires/:code", questionnaireHandler.Get)
+	api.PUT("/questionnaires/:code", questionnaireHandler.Put)
+	api.DELETE("/questionnaires/:code", questionnaireHandler.Delete)
+
+	// Appointments
+	api.POST("/appointments", appointmentHandler.Create)
+	api.GET("/appointments", appointmentHandler.List)
+	api.GET("/appointments/calendar", appointmentHandler.Calendar)
+	api.POST("/appointments/series", appointmentHandler.CreateSeries)
+	api.DELETE("/appointments/series/:id", appointmentHandler.CancelSeries)
+	api.GET("/appointments/:id", appointmentHandler.GetByID)
+	api.PATCH("/appointments/:id", appointmentHandler.Update)
+	api.DELETE("/appointments/:id", appointmentHandler.Delete)
 
 	port := cfg.Port
 	if port == "" {
@@ -4,19 +4,28 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
+
+	"phsio_track_backend/internal/core"
 )
 
 // Config holds runtime configuration values.
 type Config struct {
 	Env             string
 	Port            string
+	DBDriver        string
+	DatabaseURL     string
+	SQLitePath      string
 	DBUser          string
 	DBPassword      string
 	DBConnectString string
 	TNSAdmin        string
+	AutoMigrate     bool
+	PhoneCountry    string
+	Currency        string
 	LogFile         string
 	JWTSecret       string
 	JWTIssuer       string
@@ -30,19 +39,34 @@ func Load() Config {
 	cfg := Config{
 		Env:             getEnv("APP_ENV", "development"),
 		Port:            getEnv("PORT", "8080"),
+		DBDriver:        getEnv("DB_DRIVER", "oracle"),
+		DatabaseURL:     getEnv("DATABASE_URL", ""),
+		SQLitePath:      getEnv("SQLITE_PATH", "physio_track.db"),
 		DBUser:          getEnv("DB_USER", ""),
 		DBPassword:      getEnv("DB_PASSWORD", ""),
 		DBConnectString: getEnv("DB_CONNECT_STRING", ""),
 		TNSAdmin:        getEnv("TNS_ADMIN", ""),
+		AutoMigrate:     getEnvBool("AUTO_MIGRATE", true),
+		PhoneCountry:    getEnv("PHONE_DEFAULT_COUNTRY", "IN"),
+		Currency:        strings.ToUpper(getEnv("CURRENCY", "INR")),
 		LogFile:         getEnv("LOG_FILE", ""),
 		JWTSecret:       getEnv("JWT_SECRET", "dev-secret"),
 		JWTIssuer:       getEnv("JWT_ISSUER", "phsio-track"),
 		JWTExpiry:       getEnvDuration("JWT_EXPIRY_MIN", 60) * time.Minute,
 	}
 
-	if cfg.DBUser == "" || cfg.DBPassword == "" || cfg.DBConnectString == "" || cfg.TNSAdmin == "" {
+	if cfg.DBDriver == "oracle" && (cfg.DBUser == "" || cfg.DBPassword == "" || cfg.DBConnectString == "" || cfg.TNSAdmin == "") {
 		log.Println("warning: database connection env vars incomplete (need DB_USER, DB_PASSWORD, DB_CONNECT_STRING, TNS_ADMIN)")
 	}
+	if !core.IsPhoneCountry(cfg.PhoneCountry) {
+		log.Printf("warning: PHONE_DEFAULT_COUNTRY %q is not a known country; national phone numbers will be rejected", cfg.PhoneCountry)
+	}
+	if !core.IsCurrency(cfg.Currency) {
+		log.Printf("warning: CURRENCY %q is not a supported currency; payments will be rejected", cfg.Currency)
+	}
+	if cfg.DBDriver == "postgres" && cfg.DatabaseURL == "" {
+		log.Println("warning: DB_DRIVER=postgres needs DATABASE_URL")
+	}
 	return cfg
 }
 
@@ -53,6 +77,15 @@ func getEnv(key, def string) string {
 	return def
 }
 
+func getEnvBool(key string, def bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
 func getEnvDuration(key string, defMinutes int) time.Duration {
 	if v := os.Getenv(key); v != "" {
 		if n, err := strconv.Atoi(v); err == nil && n > 0 {
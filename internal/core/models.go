@@ -3,6 +3,7 @@ package core
 import (
 	"database/sql/driver"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -30,41 +31,927 @@ type Patient struct {
 	Diagnosis      string   `json:"diagnosis"`
 	CreatedTime    JSONTime `json:"created_time,omitempty"`
 	UpdatedTime    JSONTime `json:"updated_time,omitempty"`
-	LastPaidAmount float64  `json:"last_paid_amount"`
+	LastPaidAmount *Money   `json:"last_paid_amount"`
+	LastPaidDate   JSONTime `json:"last_paid_date"`
 	Status         string   `json:"status"`
+	ArchivedTime   JSONTime `json:"archived_time"`
+	Version        int      `json:"version"`
 	OwnerUsername  string   `json:"-"`
 }
 
 type PatientUpdate struct {
-	FullName       *string  `json:"full_name,omitempty"`
-	PhoneNumber    *string  `json:"phone_number,omitempty"`
-	Age            *int     `json:"age,omitempty"`
-	Gender         *string  `json:"gender,omitempty"`
-	ChiefComplaint *string  `json:"chief_complaint,omitempty"`
-	PresentHistory *string  `json:"present_history,omitempty"`
-	MedicalHistory *string  `json:"medical_history,omitempty"`
-	Observation    *string  `json:"observation,omitempty"`
-	Palpation      *string  `json:"palpation,omitempty"`
-	Examination    *string  `json:"examination,omitempty"`
-	Rehab          *string  `json:"rehab,omitempty"`
-	Diagnosis      *string  `json:"diagnosis,omitempty"`
-	LastPaidAmount *float64 `json:"last_paid_amount,omitempty"`
-	Status         *string  `json:"status,omitempty"`
+	FullName       *string `json:"full_name,omitempty"`
+	PhoneNumber    *string `json:"phone_number,omitempty"`
+	Age            *int    `json:"age,omitempty"`
+	Gender         *string `json:"gender,omitempty"`
+	ChiefComplaint *string `json:"chief_complaint,omitempty"`
+	PresentHistory *string `json:"present_history,omitempty"`
+	MedicalHistory *string `json:"medical_history,omitempty"`
+	Observation    *string `json:"observation,omitempty"`
+	Palpation      *string `json:"palpation,omitempty"`
+	Examination    *string `json:"examination,omitempty"`
+	Rehab          *string `json:"rehab,omitempty"`
+	Diagnosis      *string `json:"diagnosis,omitempty"`
+	Status         *string `json:"status,omitempty"`
+	// StatusReason is recorded with the status change it accompanies.
+	StatusReason *string `json:"status_reason,omitempty"`
+}
+
+// Patient statuses. An enquiry becomes an active patient, who may be put on hold and is
+// eventually discharged; a discharged patient who returns is active again. ARCHIVED
+// closes the record from any status: archived patients are the ones in the trash, out
+// of patient lists.
+const (
+	PatientEnquiry    = "ENQUIRY"
+	PatientActive     = "ACTIVE"
+	PatientOnHold     = "ON_HOLD"
+	PatientDischarged = "DISCHARGED"
+	PatientArchived   = "ARCHIVED"
+)
+
+// PatientStatuses lists every patient status in lifecycle order.
+var PatientStatuses = []string{PatientEnquiry, PatientActive, PatientOnHold, PatientDischarged, PatientArchived}
+
+// patientTransitions lists the statuses a patient can move to from each status.
+var patientTransitions = map[string][]string{
+	PatientEnquiry:    {PatientActive, PatientArchived},
+	PatientActive:     {PatientOnHold, PatientDischarged, PatientArchived},
+	PatientOnHold:     {PatientActive, PatientDischarged, PatientArchived},
+	PatientDischarged: {PatientActive, PatientArchived},
+	PatientArchived:   {PatientActive},
+}
+
+// PatientTransitions returns the statuses a patient in status from can move to.
+func PatientTransitions(from string) []string {
+	return patientTransitions[from]
+}
+
+// CanTransitionPatient reports whether a patient may move from one status to another.
+func CanTransitionPatient(from, to string) bool {
+	for _, s := range patientTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizePatientStatus reads a status as typed, e.g. "On hold" as ON_HOLD. The
+// boolean is false for anything that is not a patient status.
+func NormalizePatientStatus(s string) (string, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.NewReplacer(" ", "_", "-", "_").Replace(s)
+	_, ok := patientTransitions[s]
+	return s, ok
+}
+
+// PatientStatusChange is one move of a patient between statuses. From is empty for the
+// status a patient was created with.
+type PatientStatusChange struct {
+	From        string   `json:"from"`
+	To          string   `json:"to"`
+	Reason      string   `json:"reason"`
+	ChangedBy   string   `json:"changed_by"`
+	ChangedTime JSONTime `json:"changed_time"`
+}
+
+// PatientHistoryFields are the editable patient fields tracked in revision history, by JSON name.
+var PatientHistoryFields = []string{
+	"full_name", "phone_number", "age", "gender", "chief_complaint", "present_history", "medical_history",
+	"observation", "palpation", "examination", "rehab", "diagnosis", "status",
+}
+
+// FieldValue returns the string form of an editable field, keyed by its JSON name.
+func (p Patient) FieldValue(field string) (string, bool) {
+	switch field {
+	case "full_name":
+		return p.FullName, true
+	case "phone_number":
+		return p.PhoneNumber, true
+	case "age":
+		return strconv.Itoa(p.Age), true
+	case "gender":
+		return p.Gender, true
+	case "chief_complaint":
+		return p.ChiefComplaint, true
+	case "present_history":
+		return p.PresentHistory, true
+	case "medical_history":
+		return p.MedicalHistory, true
+	case "observation":
+		return p.Observation, true
+	case "palpation":
+		return p.Palpation, true
+	case "examination":
+		return p.Examination, true
+	case "rehab":
+		return p.Rehab, true
+	case "diagnosis":
+		return p.Diagnosis, true
+	case "status":
+		return p.Status, true
+	}
+	return "", false
+}
+
+// SetField assigns an editable field from its string form, keyed by its JSON name.
+func (u *PatientUpdate) SetField(field, value string) error {
+	switch field {
+	case "full_name":
+		u.FullName = &value
+	case "phone_number":
+		u.PhoneNumber = &value
+	case "age":
+		n := 0
+		if value != "" {
+			var err error
+			if n, err = strconv.Atoi(value); err != nil {
+				return fmt.Errorf("age: %w", err)
+			}
+		}
+		u.Age = &n
+	case "gender":
+		u.Gender = &value
+	case "chief_complaint":
+		u.ChiefComplaint = &value
+	case "present_history":
+		u.PresentHistory = &value
+	case "medical_history":
+		u.MedicalHistory = &value
+	case "observation":
+		u.Observation = &value
+	case "palpation":
+		u.Palpation = &value
+	case "examination":
+		u.Examination = &value
+	case "rehab":
+		u.Rehab = &value
+	case "diagnosis":
+		u.Diagnosis = &value
+	case "status":
+		u.Status = &value
+	default:
+		return fmt.Errorf("unknown patient field %q", field)
+	}
+	return nil
+}
+
+// PatientRevision is one recorded PatientUpdate: who made it, when, and what changed.
+type PatientRevision struct {
+	ID          string        `json:"id"`
+	PatientID   string        `json:"patient_id"`
+	ChangedBy   string        `json:"changed_by"`
+	ChangedTime JSONTime      `json:"changed_time"`
+	Changes     []FieldChange `json:"changes"`
+}
+
+// FieldChange is the old and new value of a single field within a revision.
+type FieldChange struct {
+	Field    string `json:"field"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+}
+
+// PatientSortKeys are the accepted values of PatientQuery.Sort.
+var PatientSortKeys = []string{"created_time", "updated_time", "full_name", "age"}
+
+// PatientQuery filters, sorts and pages a patient listing. Zero values mean "no filter".
+// Date ranges are half-open: From is inclusive, To is exclusive.
+type PatientQuery struct {
+	Statuses        []string
+	Genders         []string
+	MinAge          *int
+	MaxAge          *int
+	CreatedFrom     time.Time
+	CreatedTo       time.Time
+	UpdatedFrom     time.Time
+	UpdatedTo       time.Time
+	Diagnosis       string
+	IncludeArchived bool
+	Sort            string
+	Desc            bool
+	Limit           int
+	Cursor          string
+}
+
+// PatientPage is one page of a patient listing. NextCursor is empty on the last page.
+type PatientPage struct {
+	Items      []Patient `json:"items"`
+	Total      int       `json:"total"`
+	NextCursor string    `json:"next_cursor"`
+}
+
+// PatientSummary is the light listing projection of a patient: no clinical notes,
+// plus the sum of all their payments. Amounts are nil for patients who never paid.
+type PatientSummary struct {
+	ID             string
+	FullName       string
+	PhoneNumber    string
+	Age            int
+	Gender         string
+	Diagnosis      string
+	Status         string
+	CreatedTime    JSONTime
+	UpdatedTime    JSONTime
+	LastPaidAmount *Money
+	LastPaidDate   JSONTime
+	TotalPaid      *Money
+	ArchivedTime   JSONTime
+	Version        int
+}
+
+// PatientSummaryFields are the selectable PatientSummary fields, by JSON name.
+var PatientSummaryFields = []string{
+	"id", "full_name", "phone_number", "age", "gender", "diagnosis", "status", "created_time", "updated_time",
+	"last_paid_amount", "last_paid_date", "total_paid", "archived_time", "version",
+}
+
+// DefaultPatientSummaryFields are returned when no field set is requested.
+var DefaultPatientSummaryFields = []string{
+	"id", "full_name", "phone_number", "diagnosis", "status", "last_paid_date", "total_paid",
+}
+
+// Project returns only the requested fields, keyed by JSON name. Unknown names are skipped.
+func (s PatientSummary) Project(fields []string) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		switch f {
+		case "id":
+			out[f] = s.ID
+		case "full_name":
+			out[f] = s.FullName
+		case "phone_number":
+			out[f] = s.PhoneNumber
+		case "age":
+			out[f] = s.Age
+		case "gender":
+			out[f] = s.Gender
+		case "diagnosis":
+			out[f] = s.Diagnosis
+		case "status":
+			out[f] = s.Status
+		case "created_time":
+			out[f] = s.CreatedTime
+		case "updated_time":
+			out[f] = s.UpdatedTime
+		case "last_paid_amount":
+			out[f] = s.LastPaidAmount
+		case "last_paid_date":
+			out[f] = s.LastPaidDate
+		case "total_paid":
+			out[f] = s.TotalPaid
+		case "archived_time":
+			out[f] = s.ArchivedTime
+		case "version":
+			out[f] = s.Version
+		}
+	}
+	return out
+}
+
+// PatientSummaryPage is one page of a summary listing, paged like PatientPage.
+type PatientSummaryPage struct {
+	Items      []PatientSummary
+	Total      int
+	NextCursor string
+}
+
+// PatientSearchFields are the fields searched by GET /patients/search, by JSON name.
+var PatientSearchFields = []string{"full_name", "phone_number", "chief_complaint", "diagnosis"}
+
+// PatientSearch is a ranked search request. NameOnly restricts matching to full_name
+// for autocomplete.
+type PatientSearch struct {
+	Query           string
+	NameOnly        bool
+	IncludeArchived bool
+	Limit           int
+}
+
+// PatientSearchHit is one search result with its relevance score and the fields that matched.
+type PatientSearchHit struct {
+	Patient PatientSummary
+	Score   int
+	Matched []string
+}
+
+// DuplicateMatch is an existing patient sharing a phone number or name with another one.
+type DuplicateMatch struct {
+	Patient PatientSummary
+	Matched []string
+}
+
+// DuplicateCluster groups patients linked by a shared phone number or name, and
+// lists which of the two linked them.
+type DuplicateCluster struct {
+	Matched  []string
+	Patients []PatientSummary
+}
+
+// PatientMergeFields are the fields a merge picks a winner for, by JSON name.
+var PatientMergeFields = []string{
+	"full_name", "phone_number", "age", "gender", "chief_complaint", "present_history", "medical_history",
+	"observation", "palpation", "examination", "rehab", "diagnosis",
+}
+
+// PatientMergeTextFields are the merge fields whose values can be combined with "both".
+var PatientMergeTextFields = []string{
+	"chief_complaint", "present_history", "medical_history", "observation", "palpation", "examination", "diagnosis",
+}
+
+// PatientMerge folds the source patient into a target. Fields maps a field's JSON name
+// to "target", "source" or, for text fields, "both" (target text, then source text).
+// Fields left out keep the target value, or take the source value when the target's is empty.
+type PatientMerge struct {
+	SourceID string            `json:"source_id"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+// PatientMergeResult is the merged target and what moved over from the source.
+type PatientMergeResult struct {
+	Patient             Patient `json:"patient"`
+	SourceID            string  `json:"source_id"`
+	PaymentsMoved       int     `json:"payments_moved"`
+	InvoicesMoved       int     `json:"invoices_moved"`
+	AppointmentsMoved   int     `json:"appointments_moved"`
+	ExercisesMoved      int     `json:"exercises_moved"`
+	MeasurementsMoved   int     `json:"measurements_moved"`
+	QuestionnairesMoved int     `json:"questionnaires_moved"`
+	VisitNotesMoved     int     `json:"visit_notes_moved"`
+	EpisodesMoved       int     `json:"episodes_moved"`
+	DischargesMoved     int     `json:"discharges_moved"`
 }
 
 type Payment struct {
+	ID        string   `json:"id"`
+	PatientID string   `json:"patient_id"`
+	Amount    Money    `json:"amount"`
+	Mode      string   `json:"mode"`
+	Date      JSONTime `json:"date"`
+	// EpisodeID is the episode of care the payment is for; by default the one the
+	// patient was in on the payment date.
+	EpisodeID string `json:"episode_id,omitempty"`
+	// Allocations say which invoices the payment settles. Sent on create they pick
+	// the invoices; whatever is left over is applied to the oldest open invoices.
+	Allocations   []Allocation `json:"allocations,omitempty"`
+	Version       int          `json:"version"`
+	OwnerUsername string       `json:"-"`
+}
+
+type PaymentUpdate struct {
+	Amount    *Money    `json:"amount,omitempty"`
+	Mode      *string   `json:"mode,omitempty"`
+	Date      *JSONTime `json:"date,omitempty"`
+	EpisodeID *string   `json:"episode_id,omitempty"`
+}
+
+// Allocation is the part of a payment applied to one invoice.
+type Allocation struct {
+	PaymentID string `json:"payment_id,omitempty"`
+	InvoiceID string `json:"invoice_id"`
+	Amount    Money  `json:"amount"`
+}
+
+// Fee units: a fee is charged per treatment session or once per service.
+const (
+	FeePerSession = "SESSION"
+	FeePerService = "SERVICE"
+)
+
+// Fee is an entry in an owner's price list, referenced by invoice lines through its code.
+type Fee struct {
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Unit   string `json:"unit"`
+	Amount Money  `json:"amount"`
+}
+
+// Invoice statuses. DRAFT invoices can still be edited and are not owed; PARTIALLY_PAID
+// and PAID follow from the payments allocated to an ISSUED invoice; VOID cancels it.
+const (
+	InvoiceDraft      = "DRAFT"
+	InvoiceIssued     = "ISSUED"
+	InvoicePartlyPaid = "PARTIALLY_PAID"
+	InvoicePaid       = "PAID"
+	InvoiceVoid       = "VOID"
+)
+
+// InvoiceLine is one charge on an invoice. UnitPrice may be left out when FeeCode
+// names a fee; Amount is Quantity × UnitPrice.
+type InvoiceLine struct {
+	FeeCode     string   `json:"fee_code,omitempty"`
+	Description string   `json:"description"`
+	Quantity    int      `json:"quantity"`
+	UnitPrice   *Money   `json:"unit_price,omitempty"`
+	Amount      Money    `json:"amount"`
+	ServiceDate JSONTime `json:"service_date"`
+}
+
+// Invoice bills a patient. Paid is what payments have settled so far and Due the rest.
+// Lines are left out of invoice listings.
+type Invoice struct {
+	ID          string        `json:"id"`
+	PatientID   string        `json:"patient_id"`
+	Status      string        `json:"status"`
+	IssuedDate  JSONTime      `json:"issued_date"`
+	DueDate     JSONTime      `json:"due_date"`
+	Notes       string        `json:"notes"`
+	Lines       []InvoiceLine `json:"lines,omitempty"`
+	Total       Money         `json:"total"`
+	Paid        Money         `json:"paid"`
+	Due         Money         `json:"due"`
+	Allocations []Allocation  `json:"allocations,omitempty"`
+	CreatedTime JSONTime      `json:"created_time"`
+	UpdatedTime JSONTime      `json:"updated_time"`
+	Version     int           `json:"version"`
+}
+
+// InvoiceUpdate changes an invoice; nil fields are left as they are. Lines can only
+// be replaced on a draft, and Status only moves DRAFT to ISSUED or anything to VOID.
+type InvoiceUpdate struct {
+	Status     *string        `json:"status,omitempty"`
+	IssuedDate *JSONTime      `json:"issued_date,omitempty"`
+	DueDate    *JSONTime      `json:"due_date,omitempty"`
+	Notes      *string        `json:"notes,omitempty"`
+	Lines      *[]InvoiceLine `json:"lines,omitempty"`
+}
+
+// PatientBalance is a patient's ledger: Billed sums issued invoices, Paid sums payments,
+// Due is what the open invoices still owe and Credit is paid money not yet allocated.
+type PatientBalance struct {
+	PatientID    string    `json:"patient_id"`
+	Billed       Money     `json:"billed"`
+	Paid         Money     `json:"paid"`
+	Due          Money     `json:"due"`
+	Credit       Money     `json:"credit"`
+	OpenInvoices []Invoice `json:"open_invoices"`
+}
+
+// Appointment statuses. BOOKED and ATTENDED appointments hold the therapist's time.
+const (
+	AppointmentBooked    = "BOOKED"
+	AppointmentAttended  = "ATTENDED"
+	AppointmentCancelled = "CANCELLED"
+	AppointmentNoShow    = "NO_SHOW"
+)
+
+// AppointmentStatuses lists every appointment status.
+var AppointmentStatuses = []string{AppointmentBooked, AppointmentAttended, AppointmentCancelled, AppointmentNoShow}
+
+// Appointment is a session of a patient with a therapist from Start until End.
+// Appointments created as part of a recurring series share a SeriesID.
+type Appointment struct {
+	ID          string   `json:"id"`
+	PatientID   string   `json:"patient_id"`
+	Therapist   string   `json:"therapist"`
+	Start       JSONTime `json:"start"`
+	End         JSONTime `json:"end"`
+	Status      string   `json:"status"`
+	SeriesID    string   `json:"series_id,omitempty"`
+	Notes       string   `json:"notes"`
+	CreatedTime JSONTime `json:"created_time"`
+	UpdatedTime JSONTime `json:"updated_time"`
+	Version     int      `json:"version"`
+}
+
+// AppointmentUpdate reschedules or changes an appointment; nil fields are left as they are.
+type AppointmentUpdate struct {
+	Therapist *string   `json:"therapist,omitempty"`
+	Start     *JSONTime `json:"start,omitempty"`
+	End       *JSONTime `json:"end,omitempty"`
+	Status    *string   `json:"status,omitempty"`
+	Notes     *string   `json:"notes,omitempty"`
+}
+
+// AppointmentQuery selects appointments starting in [From, To). Empty fields match everything.
+type AppointmentQuery struct {
+	From      time.Time
+	To        time.Time
+	Therapist string
+	PatientID string
+	Statuses  []string
+}
+
+// AppointmentSeries books the same slot on the given weekdays ("MON".."SUN") for
+// Weeks weeks, or up to and including Until, starting with the day of Start. Start and
+// End give the time of day and length of every session. With SkipConflicts the slots
+// that clash with existing appointments are left out instead of failing the series.
+type AppointmentSeries struct {
+	PatientID     string   `json:"patient_id"`
+	Therapist     string   `json:"therapist"`
+	Start         JSONTime `json:"start"`
+	End           JSONTime `json:"end"`
+	Weekdays      []string `json:"weekdays"`
+	Weeks         int      `json:"weeks"`
+	Until         JSONTime `json:"until"`
+	Notes         string   `json:"notes"`
+	SkipConflicts bool     `json:"skip_conflicts"`
+}
+
+// AppointmentSeriesResult is what booking a series created and the slots it left out.
+type AppointmentSeriesResult struct {
+	SeriesID     string        `json:"series_id"`
+	Appointments []Appointment `json:"appointments"`
+	Skipped      []JSONTime    `json:"skipped"`
+}
+
+// CalendarDay is one day of a calendar view.
+type CalendarDay struct {
+	Date         string        `json:"date"`
+	Appointments []Appointment `json:"appointments"`
+}
+
+// Calendar is a day or week (Monday to Sunday) of appointments.
+type Calendar struct {
+	View string        `json:"view"`
+	From JSONTime      `json:"from"`
+	To   JSONTime      `json:"to"`
+	Days []CalendarDay `json:"days"`
+}
+
+// ExercisePrescription is one row of a patient's rehab programme. Sets, Reps and
+// HoldSeconds are 0 when not prescribed; StopDate is empty while the exercise is ongoing.
+// A prescription picked from the exercise library names it in LibraryID and starts
+// with its name and default dosage.
+type ExercisePrescription struct {
+	ID          string   `json:"id"`
+	PatientID   string   `json:"patient_id"`
+	LibraryID   string   `json:"library_id,omitempty"`
+	Position    int      `json:"position"`
+	Exercise    string   `json:"exercise"`
+	Sets        int      `json:"sets"`
+	Reps        int      `json:"reps"`
+	HoldSeconds int      `json:"hold_seconds"`
+	Frequency   string   `json:"frequency"`
+	Progression string   `json:"progression"`
+	StartDate   JSONTime `json:"start_date"`
+	StopDate    JSONTime `json:"stop_date"`
+	CreatedTime JSONTime `json:"created_time"`
+	UpdatedTime JSONTime `json:"updated_time"`
+	Version     int      `json:"version"`
+}
+
+// ExercisePrescriptionUpdate changes a prescription; nil fields are left as they are.
+type ExercisePrescriptionUpdate struct {
+	Position    *int      `json:"position,omitempty"`
+	Exercise    *string   `json:"exercise,omitempty"`
+	Sets        *int      `json:"sets,omitempty"`
+	Reps        *int      `json:"reps,omitempty"`
+	HoldSeconds *int      `json:"hold_seconds,omitempty"`
+	Frequency   *string   `json:"frequency,omitempty"`
+	Progression *string   `json:"progression,omitempty"`
+	StartDate   *JSONTime `json:"start_date,omitempty"`
+	StopDate    *JSONTime `json:"stop_date,omitempty"`
+}
+
+// LibraryExercise is an entry in an owner's exercise catalog. Sets, Reps, HoldSeconds
+// and Frequency are the default dosage; Attachments are references (URLs or file names)
+// to pictures or videos of the exercise.
+type LibraryExercise struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	BodyRegion  string   `json:"body_region"`
+	Description string   `json:"description"`
+	Sets        int      `json:"sets"`
+	Reps        int      `json:"reps"`
+	HoldSeconds int      `json:"hold_seconds"`
+	Frequency   string   `json:"frequency"`
+	Tags        []string `json:"tags"`
+	Attachments []string `json:"attachments"`
+	CreatedTime JSONTime `json:"created_time"`
+	UpdatedTime JSONTime `json:"updated_time"`
+	Version     int      `json:"version"`
+}
+
+// LibraryExerciseUpdate changes a library entry; nil fields are left as they are.
+type LibraryExerciseUpdate struct {
+	Name        *string   `json:"name,omitempty"`
+	BodyRegion  *string   `json:"body_region,omitempty"`
+	Description *string   `json:"description,omitempty"`
+	Sets        *int      `json:"sets,omitempty"`
+	Reps        *int      `json:"reps,omitempty"`
+	HoldSeconds *int      `json:"hold_seconds,omitempty"`
+	Frequency   *string   `json:"frequency,omitempty"`
+	Tags        *[]string `json:"tags,omitempty"`
+	Attachments *[]string `json:"attachments,omitempty"`
+}
+
+// LibraryQuery searches the exercise library. Query matches words starting with each of
+// its words in the name, tags, body region or description; BodyRegion and Tag must
+// match exactly (ignoring case).
+type LibraryQuery struct {
+	Query      string
+	BodyRegion string
+	Tag        string
+	Limit      int
+}
+
+// Measurement kinds. VAS is pain on a 0-10 cm line, NRS pain as a whole number 0-10,
+// ROM a joint's range in degrees, MMT a manual muscle test grade 0-5 ("4+" and "4-"
+// count as 4.33 and 3.67), GIRTH a circumference in cm, and CUSTOM any other number.
+const (
+	MeasureVAS    = "VAS"
+	MeasureNRS    = "NRS"
+	MeasureROM    = "ROM"
+	MeasureMMT    = "MMT"
+	MeasureGirth  = "GIRTH"
+	MeasureCustom = "CUSTOM"
+)
+
+// MeasurementKinds lists every measurement kind.
+var MeasurementKinds = []string{MeasureVAS, MeasureNRS, MeasureROM, MeasureMMT, MeasureGirth, MeasureCustom}
+
+// Sides of the body a measurement can be taken on.
+const (
+	SideLeft      = "LEFT"
+	SideRight     = "RIGHT"
+	SideBilateral = "BILATERAL"
+)
+
+// Measurement is one dated reading for a patient, optionally taken at an appointment.
+// ROM and MMT name the Joint and Movement, GIRTH the Site and CUSTOM its Name; Side
+// applies to all but pain. Grade is the MMT grade as written, e.g. "4+".
+type Measurement struct {
 	ID            string   `json:"id"`
 	PatientID     string   `json:"patient_id"`
-	Amount        float64  `json:"amount"`
-	Mode          string   `json:"mode"`
-	Date          JSONTime `json:"date"`
-	OwnerUsername string   `json:"-"`
+	AppointmentID string   `json:"appointment_id,omitempty"`
+	Kind          string   `json:"kind"`
+	Joint         string   `json:"joint,omitempty"`
+	Side          string   `json:"side,omitempty"`
+	Movement      string   `json:"movement,omitempty"`
+	Site          string   `json:"site,omitempty"`
+	Name          string   `json:"name,omitempty"`
+	Value         float64  `json:"value"`
+	Grade         string   `json:"grade,omitempty"`
+	Unit          string   `json:"unit"`
+	MeasuredAt    JSONTime `json:"measured_at"`
+	Notes         string   `json:"notes"`
+	CreatedTime   JSONTime `json:"created_time"`
+	UpdatedTime   JSONTime `json:"updated_time"`
+	Version       int      `json:"version"`
 }
 
-type PaymentUpdate struct {
-	Amount *float64  `json:"amount,omitempty"`
-	Mode   *string   `json:"mode,omitempty"`
-	Date   *JSONTime `json:"date,omitempty"`
+// MeasurementUpdate corrects a reading; nil fields are left as they are. What is
+// measured (kind, joint, side, movement, site, name) cannot change.
+type MeasurementUpdate struct {
+	AppointmentID *string   `json:"appointment_id,omitempty"`
+	Value         *float64  `json:"value,omitempty"`
+	Grade         *string   `json:"grade,omitempty"`
+	Unit          *string   `json:"unit,omitempty"`
+	MeasuredAt    *JSONTime `json:"measured_at,omitempty"`
+	Notes         *string   `json:"notes,omitempty"`
+}
+
+// MeasurementQuery selects a patient's readings taken in [From, To). Empty fields match everything.
+type MeasurementQuery struct {
+	Kind          string
+	Joint         string
+	Side          string
+	Movement      string
+	Site          string
+	Name          string
+	AppointmentID string
+	From          time.Time
+	To            time.Time
+}
+
+// MeasurementPoint is one reading of a series.
+type MeasurementPoint struct {
+	ID            string   `json:"id"`
+	MeasuredAt    JSONTime `json:"measured_at"`
+	Value         float64  `json:"value"`
+	Grade         string   `json:"grade,omitempty"`
+	AppointmentID string   `json:"appointment_id,omitempty"`
+}
+
+// MeasurementSeries is every reading of one measure (kind plus joint, side, movement,
+// site or name), oldest first, ready to chart. Label names the measure for a legend.
+type MeasurementSeries struct {
+	Kind     string             `json:"kind"`
+	Joint    string             `json:"joint,omitempty"`
+	Side     string             `json:"side,omitempty"`
+	Movement string             `json:"movement,omitempty"`
+	Site     string             `json:"site,omitempty"`
+	Name     string             `json:"name,omitempty"`
+	Unit     string             `json:"unit"`
+	Label    string             `json:"label"`
+	Points   []MeasurementPoint `json:"points"`
+}
+
+// VisitNote is the SOAP record of one session: what the patient reported, what was found,
+// the therapist's assessment and plan, and the treatment and modalities given.
+type VisitNote struct {
+	ID            string   `json:"id"`
+	PatientID     string   `json:"patient_id"`
+	AppointmentID string   `json:"appointment_id,omitempty"`
+	Therapist     string   `json:"therapist"`
+	VisitedAt     JSONTime `json:"visited_at"`
+	Subjective    string   `json:"subjective"`
+	Objective     string   `json:"objective"`
+	Assessment    string   `json:"assessment"`
+	Plan          string   `json:"plan"`
+	Treatment     string   `json:"treatment"`
+	Modalities    []string `json:"modalities"`
+	CreatedTime   JSONTime `json:"created_time"`
+	UpdatedTime   JSONTime `json:"updated_time"`
+	Version       int      `json:"version"`
+}
+
+// VisitNoteUpdate edits a visit note; nil fields are left as they are.
+type VisitNoteUpdate struct {
+	AppointmentID *string   `json:"appointment_id,omitempty"`
+	Therapist     *string   `json:"therapist,omitempty"`
+	VisitedAt     *JSONTime `json:"visited_at,omitempty"`
+	Subjective    *string   `json:"subjective,omitempty"`
+	Objective     *string   `json:"objective,omitempty"`
+	Assessment    *string   `json:"assessment,omitempty"`
+	Plan          *string   `json:"plan,omitempty"`
+	Treatment     *string   `json:"treatment,omitempty"`
+	Modalities    *[]string `json:"modalities,omitempty"`
+}
+
+// Timeline entry kinds.
+const (
+	TimelineNote        = "NOTE"
+	TimelinePayment     = "PAYMENT"
+	TimelineMeasurement = "MEASUREMENT"
+)
+
+// TimelineKinds lists every timeline entry kind.
+var TimelineKinds = []string{TimelineNote, TimelinePayment, TimelineMeasurement}
+
+// TimelineEntry is one dated event of a patient; exactly one of Note, Payment and
+// Measurement is set, as Kind says.
+type TimelineEntry struct {
+	Kind        string       `json:"kind"`
+	At          JSONTime     `json:"at"`
+	Note        *VisitNote   `json:"note,omitempty"`
+	Payment     *Payment     `json:"payment,omitempty"`
+	Measurement *Measurement `json:"measurement,omitempty"`
+}
+
+// TimelineQuery selects the entries of the given kinds (all when empty) dated in
+// [From, To), oldest first unless Descending.
+type TimelineQuery struct {
+	Kinds      []string
+	From       time.Time
+	To         time.Time
+	Descending bool
+}
+
+// Episode statuses. A patient has at most one OPEN episode.
+const (
+	EpisodeOpen   = "OPEN"
+	EpisodeClosed = "CLOSED"
+)
+
+// Episode is one course of care: the complaint a patient came with, its history and
+// findings, the diagnosis and plan, and the payments made for it. The patient's own
+// clinical fields mirror the current episode, the open one or else the latest opened,
+// with rehab holding its plan.
+type Episode struct {
+	ID             string    `json:"id"`
+	PatientID      string    `json:"patient_id"`
+	Title          string    `json:"title"`
+	ChiefComplaint string    `json:"chief_complaint"`
+	PresentHistory string    `json:"present_history"`
+	Observation    string    `json:"observation"`
+	Palpation      string    `json:"palpation"`
+	Examination    string    `json:"examination"`
+	Diagnosis      string    `json:"diagnosis"`
+	Plan           string    `json:"plan"`
+	Status         string    `json:"status"`
+	OpenedOn       JSONTime  `json:"opened_on"`
+	ClosedOn       JSONTime  `json:"closed_on"`
+	Current        bool      `json:"current"`
+	Payments       []Payment `json:"payments,omitempty"`
+	CreatedTime    JSONTime  `json:"created_time"`
+	UpdatedTime    JSONTime  `json:"updated_time"`
+	Version        int       `json:"version"`
+}
+
+// EpisodeUpdate edits an episode; nil fields are left as they are. Status CLOSED closes
+// it (ClosedOn defaults to today) and OPEN reopens it.
+type EpisodeUpdate struct {
+	Title          *string   `json:"title,omitempty"`
+	ChiefComplaint *string   `json:"chief_complaint,omitempty"`
+	PresentHistory *string   `json:"present_history,omitempty"`
+	Observation    *string   `json:"observation,omitempty"`
+	Palpation      *string   `json:"palpation,omitempty"`
+	Examination    *string   `json:"examination,omitempty"`
+	Diagnosis      *string   `json:"diagnosis,omitempty"`
+	Plan           *string   `json:"plan,omitempty"`
+	Status         *string   `json:"status,omitempty"`
+	OpenedOn       *JSONTime `json:"opened_on,omitempty"`
+	ClosedOn       *JSONTime `json:"closed_on,omitempty"`
+}
+
+// Discharge outcomes: whether the goals of the episode were met, or why care ended early.
+const (
+	OutcomeGoalsMet       = "GOALS_MET"
+	OutcomeGoalsPartlyMet = "GOALS_PARTLY_MET"
+	OutcomeGoalsNotMet    = "GOALS_NOT_MET"
+	OutcomeSelfDischarged = "SELF_DISCHARGED"
+	OutcomeReferred       = "REFERRED"
+)
+
+// DischargeOutcomes lists every discharge outcome.
+var DischargeOutcomes = []string{
+	OutcomeGoalsMet, OutcomeGoalsPartlyMet, OutcomeGoalsNotMet, OutcomeSelfDischarged, OutcomeReferred,
+}
+
+// Discharge formally closes a patient's case: it closes their open episode and moves
+// them to DISCHARGED. Measurements are the final readings taken with it.
+type Discharge struct {
+	ID           string        `json:"id"`
+	PatientID    string        `json:"patient_id"`
+	EpisodeID    string        `json:"episode_id,omitempty"`
+	DischargedOn JSONTime      `json:"discharged_on"`
+	Outcome      string        `json:"outcome"`
+	OutcomeNotes string        `json:"outcome_notes"`
+	HomeAdvice   string        `json:"home_advice"`
+	Measurements []Measurement `json:"measurements"`
+	CreatedTime  JSONTime      `json:"created_time"`
+}
+
+// MeasureProgress is how one measure changed over an episode: its first and last reading.
+type MeasureProgress struct {
+	Label string           `json:"label"`
+	Unit  string           `json:"unit"`
+	First MeasurementPoint `json:"first"`
+	Last  MeasurementPoint `json:"last"`
+}
+
+// DischargeSummary is everything the discharge summary document shows: the discharge,
+// the patient, the episode it closed (nil for a patient without one), the home programme
+// running on the discharge day, measure progress over the episode and payment totals.
+type DischargeSummary struct {
+	Discharge   Discharge              `json:"discharge"`
+	Patient     Patient                `json:"patient"`
+	Episode     *Episode               `json:"episode"`
+	Exercises   []ExercisePrescription `json:"exercises"`
+	Progress    []MeasureProgress      `json:"progress"`
+	EpisodePaid Money                  `json:"episode_paid"`
+	Balance     PatientBalance         `json:"balance"`
+	Therapist   string                 `json:"therapist"`
+}
+
+// ClinicProfile is the practice printed at the head of receipts.
+type ClinicProfile struct {
+	Name               string `json:"name"`
+	Address            string `json:"address"`
+	RegistrationNumber string `json:"registration_number"`
+	// Logo is a PNG or JPEG image, base64 encoded in JSON.
+	Logo        []byte   `json:"logo,omitempty"`
+	UpdatedTime JSONTime `json:"updated_time"`
+}
+
+// Receipt is a payment receipt as printed. Numbers run from 1 per owner without gaps;
+// a payment keeps the number it was first given.
+type Receipt struct {
+	Number      int64         `json:"number"`
+	IssuedTime  JSONTime      `json:"issued_time"`
+	Payment     Payment       `json:"payment"`
+	PatientName string        `json:"patient_name"`
+	Clinic      ClinicProfile `json:"clinic"`
+}
+
+// ExportQuery selects the patients created and the payments made in [From, To). Zero
+// bounds are open.
+type ExportQuery struct {
+	From time.Time
+	To   time.Time
+}
+
+// ExportedPayment is a payment with the name of the patient who made it.
+type ExportedPayment struct {
+	Payment
+	PatientName string `json:"patient_name"`
+}
+
+// VersionMatch is the precondition of a conditional write, read from If-Match: the row
+// versions the client accepts, or Any for "*". The zero value is unconditional.
+type VersionMatch struct {
+	Versions []int
+	Any      bool
+}
+
+// Conditional reports whether the write depends on the stored row.
+func (m VersionMatch) Conditional() bool {
+	return m.Any || len(m.Versions) > 0
+}
+
+// Matches reports whether a row at version may be written.
+func (m VersionMatch) Matches(version int) bool {
+	if !m.Conditional() || m.Any {
+		return true
+	}
+	for _, v := range m.Versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
 }
 
 // JSONTime supports flexible JSON parsing (RFC3339 or "2006-01-02T15:04:05").
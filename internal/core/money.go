@@ -0,0 +1,168 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidMoney is returned for amounts that are malformed, in an unknown currency
+// or more precise than their currency allows.
+var ErrInvalidMoney = errors.New("invalid amount")
+
+// currencyDigits is the number of minor-unit digits of each accepted ISO 4217 currency.
+var currencyDigits = map[string]int{
+	"INR": 2, "USD": 2, "EUR": 2, "GBP": 2, "AUD": 2, "CAD": 2, "NZD": 2, "SGD": 2, "MYR": 2,
+	"AED": 2, "SAR": 2, "QAR": 2, "NPR": 2, "LKR": 2, "BDT": 2, "PKR": 2,
+	"KWD": 3, "BHD": 3, "OMR": 3,
+	"JPY": 0, "KRW": 0,
+}
+
+// IsCurrency reports whether code is an accepted currency.
+func IsCurrency(code string) bool {
+	_, ok := currencyDigits[code]
+	return ok
+}
+
+// Money is an exact amount held as an integer count of the currency's minor units
+// (paise for INR). In JSON it is {"amount":"1500.50","currency":"INR"}; a bare number
+// or string is accepted on input and gets its currency from Resolve.
+type Money struct {
+	Minor    int64
+	Currency string
+	// pending holds a decimal read from JSON without a currency until Resolve parses it
+	pending string
+}
+
+// NewMoney builds Money from minor units.
+func NewMoney(minor int64, currency string) Money {
+	return Money{Minor: minor, Currency: currency}
+}
+
+// ParseMoney parses a plain decimal such as "1500", "1500.5" or "-20.25" in currency.
+// Amounts with more fraction digits than the currency has are rejected, not rounded.
+func ParseMoney(s, currency string) (Money, error) {
+	digits, ok := currencyDigits[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w: unknown currency %q", ErrInvalidMoney, currency)
+	}
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimLeft(s, "+-")
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	if len(frac) > digits {
+		return Money{}, fmt.Errorf("%w: %s allows %d decimal places, got %q", ErrInvalidMoney, currency, digits, s)
+	}
+	// 15 whole digits keep any currency's minor units inside int64
+	if len(strings.TrimLeft(whole, "0")) > 15 {
+		return Money{}, fmt.Errorf("%w: %q is too large", ErrInvalidMoney, s)
+	}
+	minor, _ := strconv.ParseInt(whole+frac+strings.Repeat("0", digits-len(frac)), 10, 64)
+	if neg {
+		minor = -minor
+	}
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Resolve finishes an amount read from JSON: one given without a currency is taken to
+// be in defaultCurrency and checked against its precision.
+func (m Money) Resolve(defaultCurrency string) (Money, error) {
+	currency := m.Currency
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	if m.pending != "" {
+		return ParseMoney(m.pending, currency)
+	}
+	if !IsCurrency(currency) {
+		return Money{}, fmt.Errorf("%w: unknown currency %q", ErrInvalidMoney, currency)
+	}
+	return Money{Minor: m.Minor, Currency: currency}, nil
+}
+
+// Add sums two amounts of the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: cannot add %s to %s", ErrInvalidMoney, o.Currency, m.Currency)
+	}
+	return Money{Minor: m.Minor + o.Minor, Currency: m.Currency}, nil
+}
+
+// String formats the amount as a plain decimal with the currency's digits, e.g. "1500.50".
+func (m Money) String() string {
+	digits := currencyDigits[m.Currency]
+	minor := m.Minor
+	sign := ""
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	s := strconv.FormatInt(minor, 10)
+	if digits == 0 {
+		return sign + s
+	}
+	if len(s) <= digits {
+		s = strings.Repeat("0", digits-len(s)+1) + s
+	}
+	return sign + s[:len(s)-digits] + "." + s[len(s)-digits:]
+}
+
+type moneyJSON struct {
+	Amount   json.RawMessage `json:"amount"`
+	Currency string          `json:"currency"`
+}
+
+// MarshalJSON writes {"amount":"1500.50","currency":"INR"}; the amount is a string so
+// clients cannot lose precision by reading it as a float.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{m.String(), m.Currency})
+}
+
+// UnmarshalJSON accepts {"amount":...,"currency":...}, a JSON number or a numeric string.
+// The number is read from its literal text, never through float64.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	currency := ""
+	if len(b) > 0 && b[0] == '{' {
+		var v moneyJSON
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		b, currency = bytes.TrimSpace(v.Amount), strings.ToUpper(strings.TrimSpace(v.Currency))
+	}
+	raw := string(b)
+	if len(b) > 0 && b[0] == '"' {
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return err
+		}
+	}
+	if raw == "" || raw == "null" {
+		return fmt.Errorf("%w: missing amount", ErrInvalidMoney)
+	}
+	*m = Money{Currency: currency, pending: raw}
+	if currency != "" {
+		parsed, err := ParseMoney(raw, currency)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+	}
+	return nil
+}
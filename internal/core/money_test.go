@@ -0,0 +1,172 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name, s, currency string
+		want              int64
+		err               error
+	}{
+		{"whole", "1500", "INR", 150000, nil},
+		{"one decimal", "1500.5", "INR", 150050, nil},
+		{"two decimals", "1500.55", "INR", 150055, nil},
+		{"negative", "-20.25", "INR", -2025, nil},
+		{"leading plus", "+7", "INR", 700, nil},
+		{"fraction only", ".5", "INR", 50, nil},
+		{"trailing point", "12.", "INR", 1200, nil},
+		{"spaces", " 10 ", "INR", 1000, nil},
+		{"three digit currency", "1.234", "KWD", 1234, nil},
+		{"zero digit currency", "500", "JPY", 500, nil},
+		{"fifteen whole digits", "999999999999999", "INR", 99999999999999900, nil},
+		{"too precise for INR", "1.234", "INR", 0, ErrInvalidMoney},
+		{"too precise for KWD", "1.2345", "KWD", 0, ErrInvalidMoney},
+		{"too precise for JPY", "10.5", "JPY", 0, ErrInvalidMoney},
+		{"sixteen whole digits", "1000000000000000", "INR", 0, ErrInvalidMoney},
+		{"empty", "", "INR", 0, ErrInvalidMoney},
+		{"point only", ".", "INR", 0, ErrInvalidMoney},
+		{"letters", "12a", "INR", 0, ErrInvalidMoney},
+		{"grouping", "1,500", "INR", 0, ErrInvalidMoney},
+		{"exponent", "1e3", "INR", 0, ErrInvalidMoney},
+		{"two points", "1.2.3", "INR", 0, ErrInvalidMoney},
+		{"unknown currency", "10", "XYZ", 0, ErrInvalidMoney},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMoney(tt.s, tt.currency)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseMoney(%q, %s) error = %v, want %v", tt.s, tt.currency, err, tt.err)
+			}
+			if err == nil && (got.Minor != tt.want || got.Currency != tt.currency) {
+				t.Errorf("ParseMoney(%q, %s) = %d %s, want %d %s", tt.s, tt.currency, got.Minor, got.Currency, tt.want, tt.currency)
+			}
+		})
+	}
+}
+
+func TestMoneyResolve(t *testing.T) {
+	tests := []struct {
+		name, json, def string
+		want            Money
+		err             error
+	}{
+		{"number takes the default", `1500.5`, "INR", NewMoney(150050, "INR"), nil},
+		{"string takes the default", `"20"`, "KWD", NewMoney(20000, "KWD"), nil},
+		{"object keeps its currency", `{"amount":"3","currency":"usd"}`, "INR", NewMoney(300, "USD"), nil},
+		{"object without currency", `{"amount":7}`, "JPY", NewMoney(7, "JPY"), nil},
+		{"too precise for the default", `1.234`, "INR", Money{}, ErrInvalidMoney},
+		{"too precise for JPY", `"1.5"`, "JPY", Money{}, ErrInvalidMoney},
+		{"unknown default", `10`, "XYZ", Money{}, ErrInvalidMoney},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Money
+			if err := json.Unmarshal([]byte(tt.json), &m); err != nil {
+				t.Fatalf("unmarshal %s: %v", tt.json, err)
+			}
+			got, err := m.Resolve(tt.def)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Resolve(%s) error = %v, want %v", tt.def, err, tt.err)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("Resolve(%s) = %+v, want %+v", tt.def, got, tt.want)
+			}
+		})
+	}
+
+	// a resolved amount keeps its currency so callers can spot a mismatch
+	if got, err := NewMoney(100, "USD").Resolve("INR"); err != nil || got.Currency != "USD" {
+		t.Errorf("Resolve kept %+v, %v; want USD", got, err)
+	}
+	if _, err := NewMoney(100, "").Resolve("XYZ"); !errors.Is(err, ErrInvalidMoney) {
+		t.Errorf("Resolve to an unknown currency: err = %v", err)
+	}
+}
+
+func TestMoneyAdd(t *testing.T) {
+	sum, err := NewMoney(150, "INR").Add(NewMoney(-50, "INR"))
+	if err != nil || sum != NewMoney(100, "INR") {
+		t.Errorf("Add = %+v, %v; want 100 INR", sum, err)
+	}
+	if _, err := NewMoney(150, "INR").Add(NewMoney(50, "USD")); !errors.Is(err, ErrInvalidMoney) {
+		t.Errorf("Add across currencies: err = %v, want ErrInvalidMoney", err)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{NewMoney(150050, "INR"), "1500.50"},
+		{NewMoney(5, "INR"), "0.05"},
+		{NewMoney(0, "INR"), "0.00"},
+		{NewMoney(-2025, "INR"), "-20.25"},
+		{NewMoney(-5, "INR"), "-0.05"},
+		{NewMoney(1234, "KWD"), "1.234"},
+		{NewMoney(500, "JPY"), "500"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("%d %s = %q, want %q", tt.m.Minor, tt.m.Currency, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{NewMoney(150050, "INR"), `{"amount":"1500.50","currency":"INR"}`},
+		{NewMoney(-2025, "USD"), `{"amount":"-20.25","currency":"USD"}`},
+		{NewMoney(1, "KWD"), `{"amount":"0.001","currency":"KWD"}`},
+		{NewMoney(500, "JPY"), `{"amount":"500","currency":"JPY"}`},
+		// beyond float64's exact integers
+		{NewMoney(99999999999999999, "INR"), `{"amount":"999999999999999.99","currency":"INR"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			b, err := json.Marshal(tt.m)
+			if err != nil || string(b) != tt.want {
+				t.Fatalf("Marshal = %s, %v; want %s", b, err, tt.want)
+			}
+			var got Money
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatalf("Unmarshal(%s): %v", b, err)
+			}
+			if got != tt.m {
+				t.Errorf("round trip = %+v, want %+v", got, tt.m)
+			}
+		})
+	}
+}
+
+func TestMoneyUnmarshalInvalid(t *testing.T) {
+	for _, in := range []string{
+		`null`,
+		`""`,
+		`{"currency":"INR"}`,
+		`{"amount":null,"currency":"INR"}`,
+		`{"amount":"1.234","currency":"INR"}`,
+		`{"amount":"10","currency":"XYZ"}`,
+		`{"amount":"ten","currency":"INR"}`,
+	} {
+		var m Money
+		if err := json.Unmarshal([]byte(in), &m); !errors.Is(err, ErrInvalidMoney) {
+			t.Errorf("Unmarshal(%s) error = %v, want ErrInvalidMoney", in, err)
+		}
+	}
+	// a malformed amount without a currency waits for Resolve
+	var m Money
+	if err := json.Unmarshal([]byte(`"ten"`), &m); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if _, err := m.Resolve("INR"); !errors.Is(err, ErrInvalidMoney) {
+		t.Errorf("Resolve of a malformed amount: err = %v", err)
+	}
+}
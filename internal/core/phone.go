@@ -0,0 +1,100 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidPhone is returned for phone numbers that cannot be put in E.164 form.
+var ErrInvalidPhone = errors.New("invalid phone number")
+
+type phoneCountry struct {
+	code     string // calling code without +
+	national int    // digits in a national number without trunk prefix, 0 when it varies
+}
+
+// phoneCountries covers the countries patients have come from so far, keyed by ISO 3166 alpha-2 code.
+var phoneCountries = map[string]phoneCountry{
+	"IN": {"91", 10},
+	"US": {"1", 10},
+	"CA": {"1", 10},
+	"GB": {"44", 10},
+	"IE": {"353", 9},
+	"AE": {"971", 9},
+	"SA": {"966", 9},
+	"QA": {"974", 8},
+	"KW": {"965", 8},
+	"OM": {"968", 8},
+	"BH": {"973", 8},
+	"SG": {"65", 8},
+	"MY": {"60", 0},
+	"AU": {"61", 9},
+	"NZ": {"64", 0},
+	"NP": {"977", 10},
+	"LK": {"94", 9},
+	"BD": {"880", 10},
+	"PK": {"92", 10},
+	"DE": {"49", 0},
+	"FR": {"33", 9},
+}
+
+// IsPhoneCountry reports whether NormalizePhone knows the country code.
+func IsPhoneCountry(country string) bool {
+	_, ok := phoneCountries[strings.ToUpper(country)]
+	return ok
+}
+
+// NormalizePhone converts a phone number as typed into E.164 ("+919845012345").
+// Spaces, dashes, dots and parentheses are ignored. Numbers starting with + or 00
+// are international; anything else is a national number of country, whose trunk 0
+// is dropped and calling code prepended, unless the digits already start with the
+// calling code at full international length. An empty input stays empty.
+func NormalizePhone(raw, country string) (string, error) {
+	s := strings.TrimSpace(raw)
+	if s == "" {
+		return "", nil
+	}
+	intl := strings.HasPrefix(s, "+")
+	s = strings.TrimPrefix(s, "+")
+
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", fmt.Errorf("%w: %q", ErrInvalidPhone, raw)
+		}
+	}
+	digits := b.String()
+	if !intl && strings.HasPrefix(digits, "00") {
+		intl = true
+		digits = digits[2:]
+	}
+
+	if !intl {
+		cc, ok := phoneCountries[strings.ToUpper(country)]
+		if !ok {
+			return "", fmt.Errorf("unknown phone country %q", country)
+		}
+		if !(cc.national > 0 && len(digits) == len(cc.code)+cc.national && strings.HasPrefix(digits, cc.code)) {
+			national := strings.TrimLeft(digits, "0")
+			if cc.national > 0 && len(national) != cc.national {
+				return "", fmt.Errorf("%w: %q", ErrInvalidPhone, raw)
+			}
+			digits = cc.code + national
+		}
+	}
+	// E.164 allows at most 15 digits; nothing real is shorter than 8 with its calling code
+	if len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPhone, raw)
+	}
+	return "+" + digits, nil
+}
+
+// CleanName trims a name and collapses runs of whitespace to single spaces.
+func CleanName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
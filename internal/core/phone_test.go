@@ -0,0 +1,88 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizePhoneCountries(t *testing.T) {
+	// a valid and an invalid national number for every supported country
+	tests := []struct {
+		country, valid, want, invalid string
+	}{
+		{"IN", "98450 12345", "+919845012345", "98450 1234"},
+		{"US", "(415) 555-2671", "+14155552671", "555-2671"},
+		{"CA", "604 555 0199", "+16045550199", "604 555 019"},
+		{"GB", "020 7946 0958", "+442079460958", "020 7946 095"},
+		{"IE", "087 123 4567", "+353871234567", "087 123 456"},
+		{"AE", "050 123 4567", "+971501234567", "050 123 45"},
+		{"SA", "050 123 4567", "+966501234567", "0501234"},
+		{"QA", "3312 3456", "+97433123456", "3312 345"},
+		{"KW", "5001 2345", "+96550012345", "500 12345 6"},
+		{"OM", "9212 3456", "+96892123456", "9212345"},
+		{"BH", "3600 1234", "+97336001234", "3600 12"},
+		{"SG", "8123 4567", "+6581234567", "812 3456"},
+		{"MY", "012-345 6789", "+60123456789", "012"},
+		{"AU", "0412 345 678", "+61412345678", "0412 345 67"},
+		{"NZ", "021 123 4567", "+64211234567", "021"},
+		{"NP", "984-1234567", "+9779841234567", "984123456"},
+		{"LK", "071 234 5678", "+94712345678", "071 234 567"},
+		{"BD", "01712-345678", "+8801712345678", "01712-34567"},
+		{"PK", "0300 1234567", "+923001234567", "0300 123456"},
+		{"DE", "030 123456", "+4930123456", "030 1"},
+		{"FR", "06 12 34 56 78", "+33612345678", "06 12 34 56 7"},
+	}
+	covered := map[string]bool{}
+	for _, tt := range tests {
+		covered[tt.country] = true
+		t.Run(tt.country, func(t *testing.T) {
+			got, err := NormalizePhone(tt.valid, tt.country)
+			if err != nil || got != tt.want {
+				t.Errorf("NormalizePhone(%q) = %q, %v; want %q", tt.valid, got, err, tt.want)
+			}
+			if got, err := NormalizePhone(tt.invalid, tt.country); !errors.Is(err, ErrInvalidPhone) {
+				t.Errorf("NormalizePhone(%q) = %q, %v; want ErrInvalidPhone", tt.invalid, got, err)
+			}
+		})
+	}
+	for country := range phoneCountries {
+		if !covered[country] {
+			t.Errorf("no test numbers for %s", country)
+		}
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		name, raw, country, want string
+		err                      error
+	}{
+		{"empty stays empty", "  ", "IN", "", nil},
+		{"international with +", "+44 20 7946 0958", "IN", "+442079460958", nil},
+		{"international with 00", "0044 20 7946 0958", "IN", "+442079460958", nil},
+		{"national with calling code", "919845012345", "IN", "+919845012345", nil},
+		{"country is case insensitive", "9845012345", "in", "+919845012345", nil},
+		{"letters", "98450 CALL", "IN", "", ErrInvalidPhone},
+		{"longer than E.164", "+1234567890123456", "IN", "", ErrInvalidPhone},
+		{"too short", "+9112345", "IN", "", ErrInvalidPhone},
+		{"calling code cannot start with 0", "+0123456789", "IN", "", ErrInvalidPhone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizePhone(tt.raw, tt.country)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("NormalizePhone(%q, %q) = %q, %v; want %q, %v", tt.raw, tt.country, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestNormalizePhoneUnknownCountry(t *testing.T) {
+	if _, err := NormalizePhone("9845012345", "XX"); err == nil || errors.Is(err, ErrInvalidPhone) {
+		t.Errorf("unknown country: err = %v, want a country error", err)
+	}
+	// international numbers do not need the country
+	if got, err := NormalizePhone("+919845012345", "XX"); err != nil || got != "+919845012345" {
+		t.Errorf("international number with unknown country = %q, %v", got, err)
+	}
+}
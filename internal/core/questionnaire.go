@@ -0,0 +1,237 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Scoring methods. "sum" adds the answers, scaling up from the answered items when some
+// are missing. "percent" gives the answers as a percentage of the range the answered
+// items allow: ODI, NDI and DASH all score this way.
+const (
+	ScoreSum     = "sum"
+	ScorePercent = "percent"
+)
+
+const (
+	maxQuestionnaireItems = 100
+	maxQuestionnaireBands = 20
+	maxItemIDLength       = 32
+	maxItemTextBytes      = 1000
+)
+
+// QuestionnaireOption is one answer to an item and the value it scores.
+type QuestionnaireOption struct {
+	Value float64 `json:"value"`
+	Label string  `json:"label"`
+}
+
+// QuestionnaireItem is one question. Items without Options use the questionnaire's.
+type QuestionnaireItem struct {
+	ID      string                `json:"id"`
+	Text    string                `json:"text"`
+	Options []QuestionnaireOption `json:"options,omitempty"`
+}
+
+// ScoreBand labels the scores from Min up to the next band's Min.
+type ScoreBand struct {
+	Min   float64 `json:"min"`
+	Label string  `json:"label"`
+}
+
+// QuestionnaireScoring says how answers become a score. With more than MaxMissing
+// items unanswered no score is given.
+type QuestionnaireScoring struct {
+	Method         string      `json:"method"`
+	MaxMissing     int         `json:"max_missing"`
+	HigherIsBetter bool        `json:"higher_is_better"`
+	Bands          []ScoreBand `json:"bands"`
+}
+
+// Questionnaire is an outcome instrument such as ODI. Definitions are data: the built-in
+// ones and those an owner adds are stored alike.
+type Questionnaire struct {
+	Code        string                `json:"code"`
+	Name        string                `json:"name"`
+	Description string                `json:"description"`
+	Options     []QuestionnaireOption `json:"options,omitempty"`
+	Items       []QuestionnaireItem   `json:"items,omitempty"`
+	Scoring     QuestionnaireScoring  `json:"scoring"`
+	BuiltIn     bool                  `json:"built_in"`
+	Version     int                   `json:"version"`
+}
+
+// QuestionnaireResponse is one administration of a questionnaire to a patient. Answers
+// maps item ids to the value of the chosen option; Score is nil when too many items
+// were left unanswered. Name and DefinitionVersion record the definition it was scored with.
+type QuestionnaireResponse struct {
+	ID                string             `json:"id"`
+	PatientID         string             `json:"patient_id"`
+	Code              string             `json:"code"`
+	Name              string             `json:"name"`
+	DefinitionVersion int                `json:"definition_version"`
+	Answers           map[string]float64 `json:"answers"`
+	Score             *float64           `json:"score"`
+	Band              string             `json:"band"`
+	Answered          int                `json:"answered"`
+	TakenAt           JSONTime           `json:"taken_at"`
+	Notes             string             `json:"notes"`
+	CreatedTime       JSONTime           `json:"created_time"`
+	Version           int                `json:"version"`
+}
+
+// QuestionnaireHistory is a patient's responses to one questionnaire, oldest first.
+// Change is the latest score minus the first one.
+type QuestionnaireHistory struct {
+	Code           string                  `json:"code"`
+	Name           string                  `json:"name"`
+	HigherIsBetter bool                    `json:"higher_is_better"`
+	Responses      []QuestionnaireResponse `json:"responses"`
+	Change         *float64                `json:"change"`
+}
+
+// ItemOptions returns the options of an item, falling back to the questionnaire's.
+func (q Questionnaire) ItemOptions(item QuestionnaireItem) []QuestionnaireOption {
+	if len(item.Options) > 0 {
+		return item.Options
+	}
+	return q.Options
+}
+
+// Validate checks that a definition can be scored.
+func (q Questionnaire) Validate() error {
+	switch {
+	case q.Name == "" || utf8.RuneCountInString(q.Name) > 255:
+		return fmt.Errorf("name must be 1 to 255 characters")
+	case len(q.Description) > 4000:
+		return fmt.Errorf("description is longer than 4000 bytes")
+	case len(q.Items) == 0 || len(q.Items) > maxQuestionnaireItems:
+		return fmt.Errorf("a questionnaire has 1 to %d items", maxQuestionnaireItems)
+	}
+	if err := validateOptions(q.Options); len(q.Options) > 0 && err != nil {
+		return fmt.Errorf("options: %v", err)
+	}
+	seen := map[string]bool{}
+	for i, item := range q.Items {
+		switch {
+		case item.ID == "" || len(item.ID) > maxItemIDLength:
+			return fmt.Errorf("item %d: id must be 1 to %d characters", i+1, maxItemIDLength)
+		case seen[item.ID]:
+			return fmt.Errorf("item %d: id %q is used twice", i+1, item.ID)
+		case item.Text == "" || len(item.Text) > maxItemTextBytes:
+			return fmt.Errorf("item %s: text must be 1 to %d bytes", item.ID, maxItemTextBytes)
+		}
+		seen[item.ID] = true
+		if err := validateOptions(q.ItemOptions(item)); err != nil {
+			return fmt.Errorf("item %s: %v", item.ID, err)
+		}
+	}
+
+	s := q.Scoring
+	switch {
+	case s.Method != ScoreSum && s.Method != ScorePercent:
+		return fmt.Errorf("scoring method must be %s or %s", ScoreSum, ScorePercent)
+	case s.MaxMissing < 0 || s.MaxMissing >= len(q.Items):
+		return fmt.Errorf("max_missing must be 0 to %d", len(q.Items)-1)
+	case len(s.Bands) > maxQuestionnaireBands:
+		return fmt.Errorf("at most %d bands", maxQuestionnaireBands)
+	}
+	for i, b := range s.Bands {
+		if b.Label == "" {
+			return fmt.Errorf("band %d has no label", i+1)
+		}
+		if i > 0 && b.Min <= s.Bands[i-1].Min {
+			return fmt.Errorf("bands must be in increasing order of min")
+		}
+	}
+	return nil
+}
+
+func validateOptions(options []QuestionnaireOption) error {
+	if len(options) < 2 {
+		return fmt.Errorf("needs at least 2 options")
+	}
+	values := map[float64]bool{}
+	for _, o := range options {
+		if o.Label == "" {
+			return fmt.Errorf("option %v has no label", o.Value)
+		}
+		if math.IsNaN(o.Value) || math.IsInf(o.Value, 0) || values[o.Value] {
+			return fmt.Errorf("option values must be distinct numbers")
+		}
+		values[o.Value] = true
+	}
+	return nil
+}
+
+// Score checks answers against the definition and scores them, rounded to one decimal.
+// It returns a nil score, and no band, when more items are missing than allowed.
+func (q Questionnaire) Score(answers map[string]float64) (score *float64, band string, answered int, err error) {
+	items := map[string]QuestionnaireItem{}
+	for _, item := range q.Items {
+		items[item.ID] = item
+	}
+	ids := make([]string, 0, len(answers))
+	for id := range answers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var total, low, high float64
+	for _, id := range ids {
+		item, ok := items[id]
+		if !ok {
+			return nil, "", 0, fmt.Errorf("%s has no item %q", q.Code, id)
+		}
+		options := q.ItemOptions(item)
+		v := answers[id]
+		valid := false
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, o := range options {
+			valid = valid || o.Value == v
+			lo, hi = math.Min(lo, o.Value), math.Max(hi, o.Value)
+		}
+		if !valid {
+			return nil, "", 0, fmt.Errorf("item %s: %v is not one of its options", id, v)
+		}
+		total += v
+		low += lo
+		high += hi
+	}
+	answered = len(ids)
+	if answered == 0 || len(q.Items)-answered > q.Scoring.MaxMissing {
+		return nil, "", answered, nil
+	}
+
+	var s float64
+	switch q.Scoring.Method {
+	case ScoreSum:
+		s = total * float64(len(q.Items)) / float64(answered)
+	case ScorePercent:
+		s = (total - low) / (high - low) * 100
+	}
+	s = math.Round(s*10) / 10
+	for _, b := range q.Scoring.Bands {
+		if s >= b.Min {
+			band = b.Label
+		}
+	}
+	return &s, band, answered, nil
+}
+
+// NormalizeQuestionnaireCode upper-cases a code; codes are letters, digits, - and _.
+func NormalizeQuestionnaireCode(code string) (string, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" || len(code) > maxItemIDLength {
+		return code, false
+	}
+	for _, r := range code {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return code, false
+		}
+	}
+	return code, true
+}
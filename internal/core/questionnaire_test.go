@@ -0,0 +1,226 @@
+package core
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// builtIn reads one of the built-in definitions the repo embeds.
+func builtIn(t *testing.T, name string) Questionnaire {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("..", "repo", "questionnaires", name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var q Questionnaire
+	if err := json.Unmarshal(b, &q); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return q
+}
+
+// answerAll answers the first n items of q with values, cycling through them.
+func answerAll(q Questionnaire, n int, values ...float64) map[string]float64 {
+	answers := map[string]float64{}
+	for i, item := range q.Items[:n] {
+		answers[item.ID] = values[i%len(values)]
+	}
+	return answers
+}
+
+func scoreString(s *float64) string {
+	if s == nil {
+		return "none"
+	}
+	return strconv.FormatFloat(*s, 'f', -1, 64)
+}
+
+func TestBuiltInQuestionnairesValidate(t *testing.T) {
+	for _, name := range []string{"odi", "ndi", "lefs", "dash"} {
+		if err := builtIn(t, name).Validate(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestQuestionnaireScore(t *testing.T) {
+	odi := builtIn(t, "odi")
+	lefs := builtIn(t, "lefs")
+	tests := []struct {
+		name     string
+		q        Questionnaire
+		answers  map[string]float64
+		score    float64 // NaN when no score is given
+		band     string
+		answered int
+	}{
+		// ODI: ten items scored 0-5, as a percentage of the answered items' range
+		{"ODI none", odi, answerAll(odi, 10, 0), 0, "Minimal disability", 10},
+		{"ODI full", odi, answerAll(odi, 10, 0, 1, 2, 3, 4, 5, 4, 3, 2, 1), 50, "Severe disability", 10},
+		{"ODI worst", odi, answerAll(odi, 10, 5), 100, "Bed-bound or exaggerating", 10},
+		{"ODI one missing", odi, answerAll(odi, 9, 2), 40, "Moderate disability", 9},
+		{"ODI one missing rounds", odi, answerAll(odi, 9, 1, 1, 1, 1, 1, 1, 1, 1, 0), 17.8, "Minimal disability", 9},
+		{"ODI two missing", odi, answerAll(odi, 8, 2), math.NaN(), "", 8},
+		{"ODI nothing answered", odi, map[string]float64{}, math.NaN(), "", 0},
+
+		// LEFS: twenty items scored 0-4, summed and scaled up over missing items
+		{"LEFS full", lefs, answerAll(lefs, 20, 4), 80, "Minimal limitation", 20},
+		{"LEFS mixed", lefs, answerAll(lefs, 20, 1, 2), 30, "Severe limitation", 20},
+		{"LEFS four missing scales", lefs, answerAll(lefs, 16, 3), 60, "Minimal limitation", 16},
+		{"LEFS scaled and rounded", lefs, answerAll(lefs, 17, 2, 1, 1), 27.1, "Severe limitation", 17},
+		{"LEFS five missing", lefs, answerAll(lefs, 15, 4), math.NaN(), "", 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, band, answered, err := tt.q.Score(tt.answers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case math.IsNaN(tt.score) && score != nil:
+				t.Errorf("score = %v, want none", *score)
+			case !math.IsNaN(tt.score) && (score == nil || *score != tt.score):
+				t.Errorf("score = %s, want %v", scoreString(score), tt.score)
+			}
+			if band != tt.band || answered != tt.answered {
+				t.Errorf("band, answered = %q, %d; want %q, %d", band, answered, tt.band, tt.answered)
+			}
+		})
+	}
+}
+
+func TestQuestionnaireScoreBandEdges(t *testing.T) {
+	// one item scored 0-30, so the score is the answer
+	var options []QuestionnaireOption
+	for v := 0; v <= 30; v++ {
+		options = append(options, QuestionnaireOption{Value: float64(v), Label: "x"})
+	}
+	q := Questionnaire{
+		Code:    "EDGE",
+		Name:    "Edges",
+		Options: options,
+		Items:   []QuestionnaireItem{{ID: "a", Text: "A"}},
+		Scoring: QuestionnaireScoring{Method: ScoreSum, Bands: []ScoreBand{
+			{Min: 5, Label: "low"}, {Min: 10, Label: "mid"}, {Min: 20, Label: "high"},
+		}},
+	}
+	tests := []struct {
+		answer float64
+		band   string
+	}{
+		{0, ""}, // below the first band
+		{4, ""},
+		{5, "low"},
+		{9, "low"},
+		{10, "mid"},
+		{19, "mid"},
+		{20, "high"},
+		{30, "high"},
+	}
+	for _, tt := range tests {
+		_, band, _, err := q.Score(map[string]float64{"a": tt.answer})
+		if err != nil || band != tt.band {
+			t.Errorf("score %v: band %q, %v; want %q", tt.answer, band, err, tt.band)
+		}
+	}
+
+	// the band follows the rounded score
+	q = Questionnaire{
+		Code: "ROUND",
+		Name: "Rounding",
+		Options: []QuestionnaireOption{
+			{Value: 0, Label: "none"}, {Value: 2094, Label: "a"}, {Value: 2096, Label: "b"}, {Value: 10000, Label: "all"},
+		},
+		Items: []QuestionnaireItem{{ID: "a", Text: "A"}},
+		Scoring: QuestionnaireScoring{Method: ScorePercent, Bands: []ScoreBand{
+			{Min: 0, Label: "low"}, {Min: 21, Label: "high"},
+		}},
+	}
+	for _, tt := range []struct {
+		answer, score float64
+		band          string
+	}{
+		{2094, 20.9, "low"},
+		{2096, 21, "high"},
+	} {
+		score, band, _, err := q.Score(map[string]float64{"a": tt.answer})
+		if err != nil || score == nil || *score != tt.score || band != tt.band {
+			t.Errorf("answer %v: %s %q, %v; want %v %q", tt.answer, scoreString(score), band, err, tt.score, tt.band)
+		}
+	}
+}
+
+func TestQuestionnaireScoreRejects(t *testing.T) {
+	odi := builtIn(t, "odi")
+	for name, answers := range map[string]map[string]float64{
+		"unknown item":    {"pain_intensity": 1, "nope": 1},
+		"not an option":   {"pain_intensity": 6},
+		"between options": {"pain_intensity": 2.5},
+		"negative answer": {"pain_intensity": -1},
+	} {
+		if _, _, _, err := odi.Score(answers); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestQuestionnaireValidate(t *testing.T) {
+	valid := func() Questionnaire {
+		return Questionnaire{
+			Code:    "TEST",
+			Name:    "Test",
+			Options: []QuestionnaireOption{{Value: 0, Label: "No"}, {Value: 1, Label: "Yes"}},
+			Items:   []QuestionnaireItem{{ID: "a", Text: "A"}, {ID: "b", Text: "B"}},
+			Scoring: QuestionnaireScoring{Method: ScoreSum, MaxMissing: 1,
+				Bands: []ScoreBand{{Min: 0, Label: "low"}, {Min: 1, Label: "high"}}},
+		}
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("valid definition: %v", err)
+	}
+	tests := []struct {
+		name   string
+		change func(q *Questionnaire)
+	}{
+		{"no name", func(q *Questionnaire) { q.Name = "" }},
+		{"no items", func(q *Questionnaire) { q.Items = nil }},
+		{"item without id", func(q *Questionnaire) { q.Items[0].ID = "" }},
+		{"item id twice", func(q *Questionnaire) { q.Items[1].ID = "a" }},
+		{"item without text", func(q *Questionnaire) { q.Items[1].Text = "" }},
+		{"one option", func(q *Questionnaire) { q.Options = q.Options[:1] }},
+		{"option values repeat", func(q *Questionnaire) { q.Options[1].Value = 0 }},
+		{"option value NaN", func(q *Questionnaire) { q.Options[1].Value = math.NaN() }},
+		{"option without label", func(q *Questionnaire) { q.Options[0].Label = "" }},
+		{"item options invalid", func(q *Questionnaire) {
+			q.Items[0].Options = []QuestionnaireOption{{Value: 1, Label: "only"}}
+		}},
+		{"unknown method", func(q *Questionnaire) { q.Scoring.Method = "mean" }},
+		{"max_missing negative", func(q *Questionnaire) { q.Scoring.MaxMissing = -1 }},
+		{"max_missing every item", func(q *Questionnaire) { q.Scoring.MaxMissing = 2 }},
+		{"band without label", func(q *Questionnaire) { q.Scoring.Bands[1].Label = "" }},
+		{"bands out of order", func(q *Questionnaire) { q.Scoring.Bands[1].Min = 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := valid()
+			tt.change(&q)
+			if err := q.Validate(); err == nil {
+				t.Error("Validate accepted it")
+			}
+		})
+	}
+
+	// items may bring their own options instead of the questionnaire's
+	q := valid()
+	q.Options = nil
+	for i := range q.Items {
+		q.Items[i].Options = []QuestionnaireOption{{Value: 0, Label: "No"}, {Value: 2, Label: "Yes"}}
+	}
+	if err := q.Validate(); err != nil {
+		t.Errorf("per-item options: %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The legacy sheet packed an exercise table into the rehab cell: rows end with
+// RehabRowSep and cells within a row are separated by RehabColumnSep.
+const (
+	RehabColumnSep = "Č"
+	RehabRowSep    = "Ɍ"
+)
+
+// rehabColumns is the column order assumed when a table has no header row.
+var rehabColumns = []string{"exercise", "sets", "reps", "hold", "frequency", "progression"}
+
+// ParseLegacyRehab reads a legacy rehab table into prescriptions, in table order.
+// A first row naming the columns (Exercise, Sets, Reps, Hold, Frequency, ...) is used
+// to map them; otherwise they are taken in rehabColumns order. Cells that do not fit
+// their column, and columns it does not know, are kept in Progression so nothing is
+// lost; so is a row without an exercise, on the exercise above it (or, before the first
+// exercise, the one below). Text without separators is not a table and yields nil.
+func ParseLegacyRehab(s string) []ExercisePrescription {
+	if !strings.Contains(s, RehabColumnSep) && !strings.Contains(s, RehabRowSep) {
+		return nil
+	}
+	var rows [][]string
+	for _, line := range strings.Split(s, RehabRowSep) {
+		cells := strings.Split(line, RehabColumnSep)
+		empty := true
+		for i := range cells {
+			cells[i] = strings.TrimSpace(cells[i])
+			empty = empty && cells[i] == ""
+		}
+		if !empty {
+			rows = append(rows, cells)
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	columns, labels := rehabColumns, []string(nil)
+	if header, ok := rehabHeader(rows[0]); ok {
+		columns, labels, rows = header, rows[0], rows[1:]
+	}
+
+	var out []ExercisePrescription
+	var orphans []string // rows without an exercise seen before the first exercise
+	for _, cells := range rows {
+		e := ExercisePrescription{Position: len(out) + 1}
+		var extra []string
+		keep := func(label, v string) {
+			extra = append(extra, label+": "+v)
+		}
+		for i, v := range cells {
+			if v == "" {
+				continue
+			}
+			column, label := rehabCell(i, columns, labels)
+			switch column {
+			case "exercise":
+				e.Exercise = v
+			case "sets", "reps":
+				n, ok := parseCount(v)
+				if !ok {
+					keep(label, v)
+				} else if column == "sets" {
+					e.Sets = n
+				} else {
+					e.Reps = n
+				}
+			case "hold":
+				if n, ok := parseHold(v); ok {
+					e.HoldSeconds = n
+				} else {
+					keep(label, v)
+				}
+			case "frequency":
+				e.Frequency = v
+			case "progression":
+				extra = append(extra, v)
+			case "start", "stop":
+				t, ok := parseRehabDate(v)
+				switch {
+				case !ok:
+					keep(label, v)
+				case column == "start":
+					e.StartDate = NewJSONTime(t)
+				default:
+					e.StopDate = NewJSONTime(t)
+				}
+			default:
+				keep(label, v)
+			}
+		}
+		if e.Exercise == "" {
+			// a row without an exercise continues the one above it, or the first one below
+			line := rehabRowText(cells, columns, labels)
+			if len(out) == 0 {
+				orphans = append(orphans, line)
+			} else {
+				last := &out[len(out)-1]
+				last.Progression = strings.TrimPrefix(last.Progression+"\n"+line, "\n")
+			}
+			continue
+		}
+		if len(out) == 0 {
+			extra = append(orphans, extra...)
+		}
+		e.Progression = strings.Join(extra, "\n")
+		out = append(out, e)
+	}
+	return out
+}
+
+// rehabCell returns the column of cell i and the label to keep it under when it does
+// not fit that column: the header's own text, the column name, or "Note".
+func rehabCell(i int, columns, labels []string) (column, label string) {
+	label = "Note"
+	if i < len(columns) {
+		column = columns[i]
+		label = column
+	}
+	if i < len(labels) && labels[i] != "" {
+		label = labels[i]
+	}
+	return column, label
+}
+
+// rehabRowText keeps a row that has no exercise as one line of labelled cells.
+func rehabRowText(cells, columns, labels []string) string {
+	var parts []string
+	for i, v := range cells {
+		if v == "" {
+			continue
+		}
+		if column, label := rehabCell(i, columns, labels); column != "progression" {
+			v = label + ": " + v
+		}
+		parts = append(parts, v)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// rehabHeader maps a header row to column names. A row is a header when it names the
+// exercise column or at least two known columns and holds no numbers.
+func rehabHeader(cells []string) ([]string, bool) {
+	columns := make([]string, len(cells))
+	known := 0
+	for i, v := range cells {
+		if strings.IndexFunc(v, unicode.IsDigit) >= 0 {
+			return nil, false
+		}
+		columns[i] = rehabHeaderColumn(v)
+		if columns[i] != "" {
+			known++
+		}
+	}
+	if columns[0] != "exercise" && known < 2 {
+		return nil, false
+	}
+	return columns, true
+}
+
+func rehabHeaderColumn(label string) string {
+	l := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, label)
+	switch {
+	case l == "":
+		return ""
+	case strings.HasPrefix(l, "exercise") || l == "name":
+		return "exercise"
+	case l == "set" || l == "sets":
+		return "sets"
+	case strings.HasPrefix(l, "rep"):
+		return "reps"
+	case strings.HasPrefix(l, "hold"):
+		return "hold"
+	case strings.HasPrefix(l, "freq"):
+		return "frequency"
+	case strings.HasPrefix(l, "progress") || l == "notes" || l == "note" || l == "remarks":
+		return "progression"
+	case strings.HasPrefix(l, "start"):
+		return "start"
+	case strings.HasPrefix(l, "stop") || strings.HasPrefix(l, "end"):
+		return "stop"
+	}
+	return ""
+}
+
+// parseCount reads "3", "3 sets", "10x" or "x10".
+func parseCount(s string) (int, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.Trim(s, "x ")
+	for _, unit := range []string{"sets", "set", "reps", "rep", "times"} {
+		s = strings.TrimSpace(strings.TrimSuffix(s, unit))
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// parseHold reads a hold time in seconds: "10", "10s", "10 sec" or "1 min".
+func parseHold(s string) (int, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, false
+	}
+	switch strings.TrimSpace(s[i:]) {
+	case "", "s", "sec", "secs", "second", "seconds":
+		return n, true
+	case "m", "min", "mins", "minute", "minutes":
+		return n * 60, true
+	}
+	return 0, false
+}
+
+func parseRehabDate(s string) (time.Time, bool) {
+	for _, layout := range []string{"02/01/2006", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rehabTable builds a legacy rehab cell from rows of cells.
+func rehabTable(rows ...[]string) string {
+	var b strings.Builder
+	for _, cells := range rows {
+		b.WriteString(strings.Join(cells, RehabColumnSep))
+		b.WriteString(RehabRowSep)
+	}
+	return b.String()
+}
+
+func rehabDate(y int, m time.Month, d int) JSONTime {
+	return NewJSONTime(time.Date(y, m, d, 0, 0, 0, 0, time.UTC))
+}
+
+func TestParseLegacyRehab(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []ExercisePrescription
+	}{
+		{"not a table", "Bridges 3x10 daily", nil},
+		{"only empty rows", rehabTable([]string{" ", ""}, []string{""}), nil},
+		{
+			"header row",
+			rehabTable(
+				[]string{"Exercise", "Sets", "Reps", "Hold", "Frequency"},
+				[]string{"Bridge", "3", "10", "5 sec", "Daily"},
+				[]string{"Clamshell", "2 sets", "x15", "", "Alternate days"},
+			),
+			[]ExercisePrescription{
+				{Position: 1, Exercise: "Bridge", Sets: 3, Reps: 10, HoldSeconds: 5, Frequency: "Daily"},
+				{Position: 2, Exercise: "Clamshell", Sets: 2, Reps: 15, Frequency: "Alternate days"},
+			},
+		},
+		{
+			"header in another order",
+			rehabTable(
+				[]string{"Reps", "Name", "Remarks"},
+				[]string{"12", "Heel slide", "add a towel"},
+			),
+			[]ExercisePrescription{{Position: 1, Exercise: "Heel slide", Reps: 12, Progression: "add a towel"}},
+		},
+		{
+			"no header",
+			rehabTable(
+				[]string{"Bridge", "3", "10", "10s", "Twice a day", "add a band next week"},
+				[]string{"Plank", "1", "", "1 min"},
+			),
+			[]ExercisePrescription{
+				{Position: 1, Exercise: "Bridge", Sets: 3, Reps: 10, HoldSeconds: 10, Frequency: "Twice a day",
+					Progression: "add a band next week"},
+				{Position: 2, Exercise: "Plank", Sets: 1, HoldSeconds: 60},
+			},
+		},
+		{
+			"hold units",
+			rehabTable(
+				[]string{"Exercise", "Hold"},
+				[]string{"Stretch A", "30 seconds"},
+				[]string{"Stretch B", "2 mins"},
+				[]string{"Stretch C", "20"},
+				[]string{"Stretch D", "till it hurts"},
+			),
+			[]ExercisePrescription{
+				{Position: 1, Exercise: "Stretch A", HoldSeconds: 30},
+				{Position: 2, Exercise: "Stretch B", HoldSeconds: 120},
+				{Position: 3, Exercise: "Stretch C", HoldSeconds: 20},
+				{Position: 4, Exercise: "Stretch D", Progression: "Hold: till it hurts"},
+			},
+		},
+		{
+			"dates",
+			rehabTable(
+				[]string{"Exercise", "Start date", "End date"},
+				[]string{"Squat", "02/01/2025", "2025-02-15"},
+				[]string{"Lunge", "next week", ""},
+			),
+			[]ExercisePrescription{
+				{Position: 1, Exercise: "Squat", StartDate: rehabDate(2025, time.January, 2),
+					StopDate: rehabDate(2025, time.February, 15)},
+				{Position: 2, Exercise: "Lunge", Progression: "Start date: next week"},
+			},
+		},
+		{
+			"unknown columns",
+			rehabTable(
+				[]string{"Exercise", "Side", "Sets"},
+				[]string{"Straight leg raise", "Left", "3", "bring a strap"},
+			),
+			[]ExercisePrescription{
+				{Position: 1, Exercise: "Straight leg raise", Sets: 3, Progression: "Side: Left\nNote: bring a strap"},
+			},
+		},
+		{
+			"cells that do not fit",
+			rehabTable([]string{"Wall sit", "many", "3 reps"}),
+			[]ExercisePrescription{{Position: 1, Exercise: "Wall sit", Reps: 3, Progression: "sets: many"}},
+		},
+		{
+			"row without an exercise continues the one above",
+			rehabTable(
+				[]string{"Exercise", "Sets", "Notes"},
+				[]string{"Bridge", "3", "slow"},
+				[]string{"", "", "hold at the top"},
+				[]string{"", "2", ""},
+			),
+			[]ExercisePrescription{
+				{Position: 1, Exercise: "Bridge", Sets: 3, Progression: "slow\nhold at the top\nSets: 2"},
+			},
+		},
+		{
+			"row without an exercise before the first one",
+			rehabTable(
+				[]string{"", "", "", "", "", "warm up first"},
+				[]string{"Bridge", "3", "10"},
+			),
+			[]ExercisePrescription{{Position: 1, Exercise: "Bridge", Sets: 3, Reps: 10, Progression: "warm up first"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseLegacyRehab(tt.in)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLegacyRehab(%q)\n got %+v\nwant %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+// Package export writes patients and payments as XLSX workbooks or CSV files laid out
+// like the sheets tools/seed_from_sheet.go imports, so an export can be imported again.
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/xuri/excelize/v2"
+
+	"phsio_track_backend/internal/core"
+)
+
+// Export formats.
+const (
+	FormatXLSX = "xlsx"
+	FormatCSV  = "csv"
+)
+
+// Sheet names, the importer's defaults.
+const (
+	PatientsSheet = "details"
+	PaymentsSheet = "payment"
+)
+
+// sheetTime is the importer's format for created_time and updated_time.
+const sheetTime = "02/01/2006 15:04:05"
+
+// ContentType is the MIME type of an export format.
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+}
+
+// Table is one sheet of an export: a header row and the data rows below it.
+type Table struct {
+	Name   string
+	Header []string
+	Rows   [][]interface{}
+}
+
+// number is a decimal kept exact in CSV and written as a number cell in XLSX.
+type number string
+
+// Patients lays out patients in the 17 details columns of the legacy sheet, in its
+// order (see code.gs), followed by the last payment date.
+func Patients(patients []core.Patient) Table {
+	t := Table{
+		Name: PatientsSheet,
+		Header: []string{
+			"id", "full_name", "phone_number", "Age", "Gender", "chief_complaint", "present_history",
+			"medical_history", "observation", "palpation", "examination", "rehab", "diagnosis",
+			"created_time", "updated_time", "last_paid_amount", "status",
+			"last_paid_date",
+		},
+	}
+	for _, p := range patients {
+		var age interface{} = ""
+		if p.Age > 0 {
+			age = p.Age
+		}
+		var lastPaid interface{} = ""
+		if p.LastPaidAmount != nil {
+			lastPaid = number(p.LastPaidAmount.String())
+		}
+		t.Rows = append(t.Rows, []interface{}{
+			p.ID, p.FullName, p.PhoneNumber, age, p.Gender, p.ChiefComplaint, p.PresentHistory,
+			p.MedicalHistory, p.Observation, p.Palpation, p.Examination, p.Rehab, p.Diagnosis,
+			formatTime(p.CreatedTime, sheetTime), formatTime(p.UpdatedTime, sheetTime), lastPaid, p.Status,
+			formatTime(p.LastPaidDate, "2006-01-02"),
+		})
+	}
+	return t
+}
+
+// Payments lays out payments in the importer's five payment columns, followed by the
+// currency and patient name for the reader.
+func Payments(payments []core.ExportedPayment) Table {
+	t := Table{
+		Name:   PaymentsSheet,
+		Header: []string{"patient_id", "id", "amount", "mode", "date", "currency", "patient_name"},
+	}
+	for _, p := range payments {
+		t.Rows = append(t.Rows, []interface{}{
+			p.PatientID, p.ID, number(p.Amount.String()), p.Mode, formatTime(p.Date, "2006-01-02"),
+			p.Amount.Currency, p.PatientName,
+		})
+	}
+	return t
+}
+
+// WriteXLSX writes the tables as the sheets of one workbook.
+func WriteXLSX(w io.Writer, tables ...Table) error {
+	f := excelize.NewFile()
+	defer f.Close()
+	for i, t := range tables {
+		if i == 0 {
+			if err := f.SetSheetName(f.GetSheetName(0), t.Name); err != nil {
+				return err
+			}
+		} else if _, err := f.NewSheet(t.Name); err != nil {
+			return err
+		}
+		sw, err := f.NewStreamWriter(t.Name)
+		if err != nil {
+			return err
+		}
+		header := make([]interface{}, len(t.Header))
+		for j, h := range t.Header {
+			header[j] = h
+		}
+		if err := sw.SetRow("A1", header); err != nil {
+			return err
+		}
+		for j, row := range t.Rows {
+			cells := make([]interface{}, len(row))
+			for k, v := range row {
+				cells[k] = v
+				if n, ok := v.(number); ok {
+					if x, err := strconv.ParseFloat(string(n), 64); err == nil {
+						cells[k] = x
+					} else {
+						cells[k] = string(n)
+					}
+				}
+			}
+			cell, err := excelize.CoordinatesToCellName(1, j+2)
+			if err != nil {
+				return err
+			}
+			if err := sw.SetRow(cell, cells); err != nil {
+				return err
+			}
+		}
+		if err := sw.Flush(); err != nil {
+			return err
+		}
+	}
+	return f.Write(w)
+}
+
+// WriteCSV writes a table as CSV with a UTF-8 byte order mark, which spreadsheet programs
+// need to read names outside ASCII. The importer skips it.
+func WriteCSV(w io.Writer, t Table) error {
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.Header); err != nil {
+		return err
+	}
+	record := make([]string, len(t.Header))
+	for _, row := range t.Rows {
+		record = record[:0]
+		for _, v := range row {
+			switch v := v.(type) {
+			case string:
+				record = append(record, v)
+			case number:
+				record = append(record, string(v))
+			case int:
+				record = append(record, strconv.Itoa(v))
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatTime(t core.JSONTime, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(layout)
+}
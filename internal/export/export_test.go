@@ -0,0 +1,59 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"phsio_track_backend/internal/core"
+)
+
+// legacyColumns are the details columns of the legacy sheet, as code.gs writes them.
+var legacyColumns = []string{
+	"id", "full_name", "phone_number", "Age", "Gender", "chief_complaint", "present_history", "medical_history",
+	"observation", "palpation", "examination", "rehab", "diagnosis", "created_time", "updated_time",
+	"last_paid_amount", "status",
+}
+
+func TestPatientsLegacyLayout(t *testing.T) {
+	paid := core.NewMoney(150050, "INR")
+	created := time.Date(2025, 4, 1, 9, 30, 0, 0, time.UTC)
+	table := Patients([]core.Patient{
+		{
+			ID: "p1", FullName: "Asha Rao", Age: 42, Status: core.PatientActive,
+			CreatedTime: core.NewJSONTime(created), UpdatedTime: core.NewJSONTime(created),
+			LastPaidAmount: &paid, LastPaidDate: core.NewJSONTime(created),
+		},
+		{ID: "p2", FullName: "Ravi Kumar", Status: core.PatientEnquiry},
+	})
+
+	if got := strings.Join(table.Header[:len(legacyColumns)], ","); got != strings.Join(legacyColumns, ",") {
+		t.Fatalf("header = %s\nwant the legacy columns %s", got, strings.Join(legacyColumns, ","))
+	}
+	if extra := table.Header[len(legacyColumns):]; len(extra) != 1 || extra[0] != "last_paid_date" {
+		t.Errorf("columns after status = %v", extra)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, table); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(buf.String(), "\ufeff"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("%d records, want a header and two rows", len(records))
+	}
+	for i, want := range [][]string{
+		{"p1", "Asha Rao", "", "42", "", "", "", "", "", "", "", "", "", "01/04/2025 09:30:00", "01/04/2025 09:30:00",
+			"1500.50", "ACTIVE", "2025-04-01"},
+		{"p2", "Ravi Kumar", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "ENQUIRY", ""},
+	} {
+		if got := records[i+1]; strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("row %d = %q\nwant %q", i+1, got, want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"phsio_track_backend/internal/core"
+	"phsio_track_backend/internal/repo"
+)
+
+type AppointmentHandler struct {
+	repo repo.AppointmentStore
+}
+
+func NewAppointmentHandler(repo repo.AppointmentStore) *AppointmentHandler {
+	return &AppointmentHandler{repo: repo}
+}
+
+// Create books an appointment; a clash with the therapist's other bookings is a 409.
+func (h *AppointmentHandler) Create(c *gin.Context) {
+	var req core.Appointment
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": payloadError(err)})
+		return
+	}
+	owner := c.GetString("user")
+	if err := h.repo.Create(c, owner, &req); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, req.Version)
+	c.JSON(http.StatusCreated, req)
+}
+
+// List returns appointments starting in [from, to), optionally filtered by therapist,
+// patient_id and a comma-separated status list.
+func (h *AppointmentHandler) List(c *gin.Context) {
+	var q core.AppointmentQuery
+	var err error
+	if q.From, err = queryTime(c, "from", false); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if q.To, err = queryTime(c, "to", true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	q.Therapist = c.Query("therapist")
+	q.PatientID = c.Query("patient_id")
+	for _, s := range splitList(c.Query("status")) {
+		s = strings.ToUpper(s)
+		if !contains(core.AppointmentStatuses, s) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown status " + s})
+			return
+		}
+		q.Statuses = append(q.Statuses, s)
+	}
+	owner := c.GetString("user")
+	items, err := h.repo.List(c, owner, q)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// Calendar returns the day or week (view=day|week, default week) containing date,
+// which defaults to today, optionally for one therapist.
+func (h *AppointmentHandler) Calendar(c *gin.Context) {
+	day, err := queryTime(c, "date", false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if day.IsZero() {
+		now := time.Now()
+		day = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	owner := c.GetString("user")
+	cal, err := h.repo.Calendar(c, owner, c.DefaultQuery("view", "week"), day, c.Query("therapist"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, cal)
+}
+
+func (h *AppointmentHandler) GetByID(c *gin.Context) {
+	owner := c.GetString("user")
+	item, err := h.repo.GetByID(c, owner, c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, item.Version)
+	c.JSON(http.StatusOK, item)
+}
+
+// Update reschedules an appointment or records its outcome, honouring If-Match like
+// PatientHandler.Update.
+func (h *AppointmentHandler) Update(c *gin.Context) {
+	id := c.Param("id")
+	ifMatch, ok := readIfMatch(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match"})
+		return
+	}
+	var req core.AppointmentUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": payloadError(err)})
+		return
+	}
+	owner := c.GetString("user")
+	updated, err := h.repo.Update(c, owner, id, &req, ifMatch)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, updated.Version)
+	c.JSON(http.StatusOK, updated)
+}
+
+func (h *AppointmentHandler) Delete(c *gin.Context) {
+	owner := c.GetString("user")
+	if err := h.repo.Delete(c, owner, c.Param("id")); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// CreateSeries books a recurring series such as Mon/Wed/Fri for 4 weeks.
+func (h *AppointmentHandler) CreateSeries(c *gin.Context) {
+	var req core.AppointmentSeries
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": payloadError(err)})
+		return
+	}
+	owner := c.GetString("user")
+	result, err := h.repo.CreateSeries(c, owner, req)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, result)
+}
+
+// CancelSeries cancels the upcoming booked appointments of a series.
+func (h *AppointmentHandler) CancelSeries(c *gin.Context) {
+	owner := c.GetString("user")
+	n, err := h.repo.CancelSeries(c, owner, c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cancelled": n})
+}
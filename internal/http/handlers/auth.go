@@ -14,13 +14,13 @@ import (
 )
 
 type AuthHandler struct {
-	userRepo  *repo.UserRepo
+	userRepo  repo.UserStore
 	jwtSecret string
 	issuer    string
 	expiry    time.Duration
 }
 
-func NewAuthHandler(userRepo *repo.UserRepo, jwtSecret, issuer string, expiry time.Duration) *AuthHandler {
+func NewAuthHandler(userRepo repo.UserStore, jwtSecret, issuer string, expiry time.Duration) *AuthHandler {
 	return &AuthHandler{
 		userRepo:  userRepo,
 		jwtSecret: jwtSecret,
@@ -125,7 +125,7 @@ func (h *AuthHandler) RegisterUser(c *gin.Context) {
 }
 
 // SeedUser is a helper to create the first user if needed.
-func SeedUser(userRepo *repo.UserRepo, username, password string) error {
+func SeedUser(userRepo repo.UserStore, username, password string) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"phsio_track_backend/internal/core"
+	"phsio_track_backend/internal/repo"
+	"phsio_track_backend/internal/report"
+)
+
+type DischargeHandler struct {
+	repo repo.DischargeStore
+}
+
+func NewDischargeHandler(repo repo.DischargeStore) *DischargeHandler {
+	return &DischargeHandler{repo: repo}
+}
+
+// Discharge closes the patient's case with its outcome, final measurements and home advice.
+func (h *DischargeHandler) Discharge(c *gin.Context) {
+	var req core.Discharge
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	req.PatientID = c.Param("id")
+	owner := c.GetString("user")
+	if err := h.repo.Discharge(c, owner, &req); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, req)
+}
+
+func (h *DischargeHandler) List(c *gin.Context) {
+	owner := c.GetString("user")
+	items, err := h.repo.List(c, owner, c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *DischargeHandler) GetByID(c *gin.Context) {
+	owner := c.GetString("user")
+	item, err := h.repo.GetByID(c, owner, c.Param("id"), c.Param("discharge_id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, item)
+}
+
+// Summary downloads the discharge summary; format=pdf (the default) or html.
+func (h *DischargeHandler) Summary(c *gin.Context) {
+	format := c.DefaultQuery("format", report.FormatPDF)
+	if format != report.FormatPDF && format != report.FormatHTML {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be pdf or html"})
+		return
+	}
+	owner := c.GetString("user")
+	summary, err := h.repo.Summary(c, owner, c.Param("id"), c.Param("discharge_id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	var buf bytes.Buffer
+	if err := report.DischargeSummary(&buf, summary, format); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	name := "discharge-summary-" + summary.Discharge.DischargedOn.Format("2006-01-02") + "." + format
+	c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+	c.Data(http.StatusOK, report.ContentType(format), buf.Bytes())
+}
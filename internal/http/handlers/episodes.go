@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"phsio_track_backend/internal/core"
+	"phsio_track_backend/internal/repo"
+)
+
+type EpisodeHandler struct {
+	repo repo.EpisodeStore
+}
+
+func NewEpisodeHandler(repo repo.EpisodeStore) *EpisodeHandler {
+	return &EpisodeHandler{repo: repo}
+}
+
+// List returns the patient's episodes of care, the current one first.
+func (h *EpisodeHandler) List(c *gin.Context) {
+	owner := c.GetString("user")
+	items, err := h.repo.List(c, owner, c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// Open starts a new episode for a returning patient, closing the one open until now.
+func (h *EpisodeHandler) Open(c *gin.Context) {
+	var req core.Episode
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	req.PatientID = c.Param("id")
+	owner := c.GetString("user")
+	if err := h.repo.Open(c, owner, &req); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, req.Version)
+	c.JSON(http.StatusCreated, req)
+}
+
+// GetByID returns an episode with its payments.
+func (h *EpisodeHandler) GetByID(c *gin.Context) {
+	owner := c.GetString("user")
+	item, err := h.repo.GetByID(c, owner, c.Param("id"), c.Param("episode_id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, item.Version)
+	c.JSON(http.StatusOK, item)
+}
+
+// Update edits, closes or reopens an episode, honouring If-Match like PatientHandler.Update.
+func (h *EpisodeHandler) Update(c *gin.Context) {
+	ifMatch, ok := readIfMatch(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match"})
+		return
+	}
+	var req core.EpisodeUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	owner := c.GetString("user")
+	updated, err := h.repo.Update(c, owner, c.Param("id"), c.Param("episode_id"), &req, ifMatch)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, updated.Version)
+	c.JSON(http.StatusOK, updated)
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"phsio_track_backend/internal/core"
+	"phsio_track_backend/internal/repo"
+)
+
+// errorStatus maps repository errors to HTTP status codes.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, repo.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repo.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, repo.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, repo.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, repo.ErrStale):
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// payloadError is the message for a request body that failed to bind. Bad amounts
+// say what was wrong with them; anything else is just an invalid payload.
+func payloadError(err error) string {
+	if errors.Is(err, core.ErrInvalidMoney) {
+		return err.Error()
+	}
+	return "invalid payload"
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"phsio_track_backend/internal/core"
+)
+
+// setETag exposes a row version as a strong ETag, e.g. "3".
+func setETag(c *gin.Context, version int) {
+	c.Header("ETag", `"`+strconv.Itoa(version)+`"`)
+}
+
+// readIfMatch reads the versions a client accepts from If-Match, e.g. "3", "4" or *.
+// An absent header is unconditional; ok=false means the header cannot match any version,
+// as when it only lists weak tags such as W/"3".
+func readIfMatch(c *gin.Context) (m core.VersionMatch, ok bool) {
+	h := strings.TrimSpace(c.GetHeader("If-Match"))
+	if h == "" {
+		return m, true
+	}
+	for _, tag := range strings.Split(h, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return core.VersionMatch{Any: true}, true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			// If-Match uses the strong comparison: a weak tag never matches
+			continue
+		}
+		if n, err := strconv.Atoi(strings.Trim(tag, `"`)); err == nil && n > 0 {
+			m.Versions = append(m.Versions, n)
+		}
+	}
+	return m, len(m.Versions) > 0
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"phsio_track_backend/internal/core"
+	"phsio_track_backend/internal/repo"
+)
+
+type ExerciseLibraryHandler struct {
+	repo repo.ExerciseLibraryStore
+}
+
+func NewExerciseLibraryHandler(repo repo.ExerciseLibraryStore) *ExerciseLibraryHandler {
+	return &ExerciseLibraryHandler{repo: repo}
+}
+
+// Search lists the library for the exercise picker: q matches name, tags, body region
+// and description; body_region, tag and limit narrow it down.
+func (h *ExerciseLibraryHandler) Search(c *gin.Context) {
+	q := core.LibraryQuery{
+		Query:      c.Query("q"),
+		BodyRegion: c.Query("body_region"),
+		Tag:        c.Query("tag"),
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+		q.Limit = n
+	}
+	owner := c.GetString("user")
+	items, err := h.repo.Search(c, owner, q)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *ExerciseLibraryHandler) Create(c *gin.Context) {
+	var req core.LibraryExercise
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	owner := c.GetString("user")
+	if err := h.repo.Create(c, owner, &req); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, req.Version)
+	c.JSON(http.StatusCreated, req)
+}
+
+func (h *ExerciseLibraryHandler) GetByID(c *gin.Context) {
+	owner := c.GetString("user")
+	item, err := h.repo.GetByID(c, owner, c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, item.Version)
+	c.JSON(http.StatusOK, item)
+}
+
+// Update patches a library entry, honouring If-Match like PatientHandler.Update.
+func (h *ExerciseLibraryHandler) Update(c *gin.Context) {
+	ifMatch, ok := readIfMatch(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match"})
+		return
+	}
+	var req core.LibraryExerciseUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	owner := c.GetString("user")
+	updated, err := h.repo.Update(c, owner, c.Param("id"), &req, ifMatch)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, updated.Version)
+	c.JSON(http.StatusOK, updated)
+}
+
+func (h *ExerciseLibraryHandler) Delete(c *gin.Context) {
+	owner := c.GetString("user")
+	if err := h.repo.Delete(c, owner, c.Param("id")); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
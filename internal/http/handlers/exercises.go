@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"phsio_track_backend/internal/core"
+	"phsio_track_backend/internal/repo"
+)
+
+type ExerciseHandler struct {
+	repo repo.ExerciseStore
+}
+
+func NewExerciseHandler(repo repo.ExerciseStore) *ExerciseHandler {
+	return &ExerciseHandler{repo: repo}
+}
+
+// List returns the patient's exercise programme; active_on keeps the exercises running that day.
+func (h *ExerciseHandler) List(c *gin.Context) {
+	activeOn, err := queryTime(c, "active_on", false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	owner := c.GetString("user")
+	items, err := h.repo.List(c, owner, c.Param("id"), activeOn)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *ExerciseHandler) Create(c *gin.Context) {
+	var req core.ExercisePrescription
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	req.PatientID = c.Param("id")
+	owner := c.GetString("user")
+	if err := h.repo.Create(c, owner, &req); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, req.Version)
+	c.JSON(http.StatusCreated, req)
+}
+
+// Replace overwrites the whole programme with the list sent, in that order.
+func (h *ExerciseHandler) Replace(c *gin.Context) {
+	var req []core.ExercisePrescription
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	owner := c.GetString("user")
+	items, err := h.repo.Replace(c, owner, c.Param("id"), req)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *ExerciseHandler) GetByID(c *gin.Context) {
+	owner := c.GetString("user")
+	item, err := h.repo.GetByID(c, owner, c.Param("id"), c.Param("exercise_id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, item.Version)
+	c.JSON(http.StatusOK, item)
+}
+
+// Update patches one exercise, honouring If-Match like PatientHandler.Update.
+func (h *ExerciseHandler) Update(c *gin.Context) {
+	ifMatch, ok := readIfMatch(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match"})
+		return
+	}
+	var req core.ExercisePrescriptionUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	owner := c.GetString("user")
+	updated, err := h.repo.Update(c, owner, c.Param("id"), c.Param("exercise_id"), &req, ifMatch)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, updated.Version)
+	c.JSON(http.StatusOK, updated)
+}
+
+func (h *ExerciseHandler) Delete(c *gin.Context) {
+	owner := c.GetString("user")
+	if err := h.repo.Delete(c, owner, c.Param("id"), c.Param("exercise_id")); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
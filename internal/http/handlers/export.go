@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"phsio_track_backend/internal/core"
+	"phsio_track_backend/internal/export"
+	"phsio_track_backend/internal/repo"
+)
+
+type ExportHandler struct {
+	repo repo.ExportStore
+}
+
+func NewExportHandler(repo repo.ExportStore) *ExportHandler {
+	return &ExportHandler{repo: repo}
+}
+
+// Workbook downloads patients and payments as one XLSX workbook, a sheet each.
+func (h *ExportHandler) Workbook(c *gin.Context) {
+	q, ok := exportQuery(c)
+	if !ok {
+		return
+	}
+	owner := c.GetString("user")
+	patients, err := h.repo.Patients(c, owner, q)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	payments, err := h.repo.Payments(c, owner, q)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	var buf bytes.Buffer
+	if err := export.WriteXLSX(&buf, export.Patients(patients), export.Payments(payments)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sendExport(c, "export", export.FormatXLSX, buf.Bytes())
+}
+
+// PatientsCSV downloads the patients created in from..to as CSV.
+func (h *ExportHandler) PatientsCSV(c *gin.Context) {
+	q, ok := exportQuery(c)
+	if !ok {
+		return
+	}
+	owner := c.GetString("user")
+	items, err := h.repo.Patients(c, owner, q)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	var buf bytes.Buffer
+	if err := export.WriteCSV(&buf, export.Patients(items)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sendExport(c, "patients", export.FormatCSV, buf.Bytes())
+}
+
+// PaymentsCSV downloads the payments made in from..to as CSV.
+func (h *ExportHandler) PaymentsCSV(c *gin.Context) {
+	q, ok := exportQuery(c)
+	if !ok {
+		return
+	}
+	owner := c.GetString("user")
+	items, err := h.repo.Payments(c, owner, q)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	var buf bytes.Buffer
+	if err := export.WriteCSV(&buf, export.Payments(items)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sendExport(c, "payments", export.FormatCSV, buf.Bytes())
+}
+
+// exportQuery reads from and to; a date-only to includes that whole day.
+func exportQuery(c *gin.Context) (core.ExportQuery, bool) {
+	var q core.ExportQuery
+	var err error
+	if q.From, err = queryTime(c, "from", false); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return q, false
+	}
+	if q.To, err = queryTime(c, "to", true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return q, false
+	}
+	return q, true
+}
+
+func sendExport(c *gin.Context, name, format string, data []byte) {
+	name += "-" + time.Now().Format("2006-01-02") + "." + format
+	c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+	c.Data(http.StatusOK, export.ContentType(format), data)
+}
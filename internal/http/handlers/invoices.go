@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"phsio_track_backend/internal/core"
+	"phsio_track_backend/internal/repo"
+)
+
+type InvoiceHandler struct {
+	repo repo.InvoiceStore
+}
+
+func NewInvoiceHandler(repo repo.InvoiceStore) *InvoiceHandler {
+	return &InvoiceHandler{repo: repo}
+}
+
+// Create adds an invoice; it is ISSUED unless the body asks for a DRAFT.
+func (h *InvoiceHandler) Create(c *gin.Context) {
+	var req core.Invoice
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": payloadError(err)})
+		return
+	}
+	owner := c.GetString("user")
+	if err := h.repo.Create(c, owner, &req); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, req.Version)
+	c.JSON(http.StatusCreated, req)
+}
+
+// List returns invoices, optionally filtered by patient_id and status.
+func (h *InvoiceHandler) List(c *gin.Context) {
+	owner := c.GetString("user")
+	items, err := h.repo.List(c, owner, c.Query("patient_id"), c.Query("status"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *InvoiceHandler) GetByID(c *gin.Context) {
+	owner := c.GetString("user")
+	item, err := h.repo.GetByID(c, owner, c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, item.Version)
+	c.JSON(http.StatusOK, item)
+}
+
+// Update patches an invoice, honouring If-Match like PatientHandler.Update.
+func (h *InvoiceHandler) Update(c *gin.Context) {
+	id := c.Param("id")
+	ifMatch, ok := readIfMatch(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match"})
+		return
+	}
+	var req core.InvoiceUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": payloadError(err)})
+		return
+	}
+	owner := c.GetString("user")
+	updated, err := h.repo.Update(c, owner, id, &req, ifMatch)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, updated.Version)
+	c.JSON(http.StatusOK, updated)
+}
+
+// Delete removes a draft invoice.
+func (h *InvoiceHandler) Delete(c *gin.Context) {
+	owner := c.GetString("user")
+	if err := h.repo.Delete(c, owner, c.Param("id")); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// Fees lists the price list.
+func (h *InvoiceHandler) Fees(c *gin.Context) {
+	owner := c.GetString("user")
+	items, err := h.repo.Fees(c, owner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// PutFee creates or replaces the fee named in the path.
+func (h *InvoiceHandler) PutFee(c *gin.Context) {
+	var req core.Fee
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": payloadError(err)})
+		return
+	}
+	req.Code = c.Param("code")
+	owner := c.GetString("user")
+	if err := h.repo.PutFee(c, owner, &req); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+func (h *InvoiceHandler) DeleteFee(c *gin.Context) {
+	owner := c.GetString("user")
+	if err := h.repo.DeleteFee(c, owner, c.Param("code")); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
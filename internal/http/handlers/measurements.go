@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"phsio_track_backend/internal/core"
+	"phsio_track_backend/internal/repo"
+)
+
+type MeasurementHandler struct {
+	repo repo.MeasurementStore
+}
+
+func NewMeasurementHandler(repo repo.MeasurementStore) *MeasurementHandler {
+	return &MeasurementHandler{repo: repo}
+}
+
+func (h *MeasurementHandler) Create(c *gin.Context) {
+	var req core.Measurement
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	req.PatientID = c.Param("id")
+	owner := c.GetString("user")
+	if err := h.repo.Create(c, owner, &req); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, req.Version)
+	c.JSON(http.StatusCreated, req)
+}
+
+// List returns the patient's readings, oldest first, filtered like Series.
+func (h *MeasurementHandler) List(c *gin.Context) {
+	q, ok := measurementQuery(c)
+	if !ok {
+		return
+	}
+	owner := c.GetString("user")
+	items, err := h.repo.List(c, owner, c.Param("id"), q)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// Series returns one chartable series per measure, optionally narrowed by kind, joint,
+// side, movement, site, name, appointment_id and from/to.
+func (h *MeasurementHandler) Series(c *gin.Context) {
+	q, ok := measurementQuery(c)
+	if !ok {
+		return
+	}
+	owner := c.GetString("user")
+	items, err := h.repo.Series(c, owner, c.Param("id"), q)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// measurementQuery reads the measurement filters, answering 400 itself when one is invalid.
+func measurementQuery(c *gin.Context) (core.MeasurementQuery, bool) {
+	q := core.MeasurementQuery{
+		Kind:          strings.ToUpper(c.Query("kind")),
+		Joint:         c.Query("joint"),
+		Side:          strings.ToUpper(c.Query("side")),
+		Movement:      c.Query("movement"),
+		Site:          c.Query("site"),
+		Name:          c.Query("name"),
+		AppointmentID: c.Query("appointment_id"),
+	}
+	if q.Kind != "" && !contains(core.MeasurementKinds, q.Kind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown kind " + q.Kind})
+		return q, false
+	}
+	var err error
+	if q.From, err = queryTime(c, "from", false); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return q, false
+	}
+	if q.To, err = queryTime(c, "to", true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return q, false
+	}
+	return q, true
+}
+
+func (h *MeasurementHandler) GetByID(c *gin.Context) {
+	owner := c.GetString("user")
+	item, err := h.repo.GetByID(c, owner, c.Param("id"), c.Param("measurement_id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, item.Version)
+	c.JSON(http.StatusOK, item)
+}
+
+// Update corrects a reading, honouring If-Match like PatientHandler.Update.
+func (h *MeasurementHandler) Update(c *gin.Context) {
+	ifMatch, ok := readIfMatch(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match"})
+		return
+	}
+	var req core.MeasurementUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	owner := c.GetString("user")
+	updated, err := h.repo.Update(c, owner, c.Param("id"), c.Param("measurement_id"), &req, ifMatch)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, updated.Version)
+	c.JSON(http.StatusOK, updated)
+}
+
+func (h *MeasurementHandler) Delete(c *gin.Context) {
+	owner := c.GetString("user")
+	if err := h.repo.Delete(c, owner, c.Param("id"), c.Param("measurement_id")); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"phsio_track_backend/internal/core"
+)
+
+// parsePatientQuery reads the GET /patients filter, sort and paging parameters.
+func parsePatientQuery(c *gin.Context) (core.PatientQuery, error) {
+	pq := core.PatientQuery{
+		Statuses:        splitList(c.Query("status")),
+		Genders:         splitList(c.Query("gender")),
+		Diagnosis:       c.Query("diagnosis"),
+		IncludeArchived: c.Query("include_archived") == "true",
+		Sort:            c.DefaultQuery("sort", "created_time"),
+		Cursor:          c.Query("cursor"),
+	}
+
+	for i, s := range pq.Statuses {
+		status, ok := core.NormalizePatientStatus(s)
+		if !ok {
+			return pq, fmt.Errorf("status must be one of %s", strings.Join(core.PatientStatuses, ", "))
+		}
+		pq.Statuses[i] = status
+	}
+	if !contains(core.PatientSortKeys, pq.Sort) {
+		return pq, fmt.Errorf("sort must be one of %s", strings.Join(core.PatientSortKeys, ", "))
+	}
+	// timestamps default to newest first, names and ages to ascending
+	switch c.Query("order") {
+	case "":
+		pq.Desc = pq.Sort == "created_time" || pq.Sort == "updated_time"
+	case "asc":
+	case "desc":
+		pq.Desc = true
+	default:
+		return pq, fmt.Errorf("order must be asc or desc")
+	}
+
+	var err error
+	if pq.Limit, err = queryInt(c, "limit"); err != nil {
+		return pq, err
+	}
+	for name, dst := range map[string]**int{"min_age": &pq.MinAge, "max_age": &pq.MaxAge} {
+		if v := c.Query(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return pq, fmt.Errorf("%s must be a number", name)
+			}
+			*dst = &n
+		}
+	}
+	for name, dst := range map[string]*time.Time{
+		"created_from": &pq.CreatedFrom, "created_to": &pq.CreatedTo,
+		"updated_from": &pq.UpdatedFrom, "updated_to": &pq.UpdatedTo,
+	} {
+		if *dst, err = queryTime(c, name, strings.HasSuffix(name, "_to")); err != nil {
+			return pq, err
+		}
+	}
+	return pq, nil
+}
+
+// summaryFields reads the fields parameter, checked against core.PatientSummaryFields.
+func summaryFields(c *gin.Context, defaults []string) ([]string, error) {
+	fields := splitList(c.Query("fields"))
+	if len(fields) == 0 {
+		return defaults, nil
+	}
+	for _, f := range fields {
+		if !contains(core.PatientSummaryFields, f) {
+			return nil, fmt.Errorf("unknown field %s", f)
+		}
+	}
+	return fields, nil
+}
+
+// splitList splits a comma separated query value, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func queryInt(c *gin.Context, name string) (int, error) {
+	v := c.Query(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative number", name)
+	}
+	return n, nil
+}
+
+// queryTime parses a date or timestamp parameter in any layout core.JSONTime accepts.
+// A date-only upper bound is moved to the following midnight so the whole day is included.
+func queryTime(c *gin.Context, name string, upper bool) (time.Time, error) {
+	v := c.Query(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	var jt core.JSONTime
+	if err := jt.UnmarshalJSON([]byte(strconv.Quote(v))); err != nil {
+		return time.Time{}, fmt.Errorf("%s: %v", name, err)
+	}
+	if upper && len(v) == len("2006-01-02") {
+		return jt.Time.AddDate(0, 0, 1), nil
+	}
+	return jt.Time, nil
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -10,13 +11,20 @@ import (
 )
 
 type PatientHandler struct {
-	repo *repo.PatientRepo
+	repo repo.PatientStore
 }
 
-func NewPatientHandler(repo *repo.PatientRepo) *PatientHandler {
+func NewPatientHandler(repo repo.PatientStore) *PatientHandler {
 	return &PatientHandler{repo: repo}
 }
 
+type createPatientResponse struct {
+	core.Patient
+	PossibleDuplicates []gin.H `json:"possible_duplicates,omitempty"`
+}
+
+// Create adds a patient. The patient is always created; existing patients with the
+// same phone number or name are listed in possible_duplicates as a warning.
 func (h *PatientHandler) Create(c *gin.Context) {
 	var req core.Patient
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -25,19 +33,130 @@ func (h *PatientHandler) Create(c *gin.Context) {
 	}
 	owner := c.GetString("user")
 	if err := h.repo.Create(c, owner, &req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusCreated, req)
+	resp := createPatientResponse{Patient: req}
+	matches, err := h.repo.FindDuplicates(c, owner, req)
+	if err != nil {
+		log.Printf("duplicate check for patient %s: %v", req.ID, err)
+	}
+	for _, m := range matches {
+		dup := m.Patient.Project([]string{"id", "full_name", "phone_number", "status"})
+		dup["matched"] = m.Matched
+		resp.PossibleDuplicates = append(resp.PossibleDuplicates, dup)
+	}
+	setETag(c, req.Version)
+	c.JSON(http.StatusCreated, resp)
 }
 
+// List returns one page of active patients as {items, total, next_cursor}; pass
+// include_archived=true to include archived ones. See parsePatientQuery for filters.
+// view=summary returns only the fields named in fields (see core.PatientSummaryFields).
 func (h *PatientHandler) List(c *gin.Context) {
+	pq, err := parsePatientQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	owner := c.GetString("user")
-	items, err := h.repo.List(c, owner)
+	switch c.Query("view") {
+	case "", "full":
+	case "summary":
+		h.listSummary(c, owner, pq)
+		return
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "view must be full or summary"})
+		return
+	}
+	page, err := h.repo.List(c, owner, pq)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *PatientHandler) listSummary(c *gin.Context, owner string, pq core.PatientQuery) {
+	fields, err := summaryFields(c, core.DefaultPatientSummaryFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := h.repo.ListSummary(c, owner, pq)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	items := make([]map[string]interface{}, len(page.Items))
+	for i, s := range page.Items {
+		items[i] = s.Project(fields)
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items, "total": page.Total, "next_cursor": page.NextCursor})
+}
+
+// Search ranks the owner's patients against q over name, phone, chief complaint and
+// diagnosis. Each hit carries the summary fields (selectable like view=summary),
+// its score and the fields that matched.
+func (h *PatientHandler) Search(c *gin.Context) {
+	h.search(c, false, core.DefaultPatientSummaryFields)
+}
+
+// Autocomplete matches q as name prefixes for the patient picker.
+func (h *PatientHandler) Autocomplete(c *gin.Context) {
+	h.search(c, true, []string{"id", "full_name", "phone_number"})
+}
+
+func (h *PatientHandler) search(c *gin.Context, nameOnly bool, defaultFields []string) {
+	fields, err := summaryFields(c, defaultFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, err := queryInt(c, "limit")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	owner := c.GetString("user")
+	hits, err := h.repo.Search(c, owner, core.PatientSearch{
+		Query:           c.Query("q"),
+		NameOnly:        nameOnly,
+		IncludeArchived: c.Query("include_archived") == "true",
+		Limit:           limit,
+	})
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	items := make([]gin.H, len(hits))
+	for i, hit := range hits {
+		items[i] = gin.H{"patient": hit.Patient.Project(fields), "score": hit.Score, "matched": hit.Matched}
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// Duplicates lists clusters of active patients sharing a phone number or name.
+func (h *PatientHandler) Duplicates(c *gin.Context) {
+	fields, err := summaryFields(c, core.DefaultPatientSummaryFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	owner := c.GetString("user")
+	clusters, err := h.repo.DuplicateClusters(c, owner)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	items := make([]gin.H, len(clusters))
+	for i, cl := range clusters {
+		patients := make([]map[string]interface{}, len(cl.Patients))
+		for j, p := range cl.Patients {
+			patients[j] = p.Project(fields)
+		}
+		items[i] = gin.H{"matched": cl.Matched, "patients": patients}
+	}
 	c.JSON(http.StatusOK, items)
 }
 
@@ -55,25 +174,159 @@ func (h *PatientHandler) GetByID(c *gin.Context) {
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
+	setETag(c, item.Version)
 	c.JSON(http.StatusOK, item)
 }
 
+// Update patches a patient. Send the ETag from GetByID as If-Match to reject
+// the change with 412 when someone else has edited the patient in between.
 func (h *PatientHandler) Update(c *gin.Context) {
 	id := c.Param("id")
+	ifMatch, ok := readIfMatch(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match"})
+		return
+	}
 	var req core.PatientUpdate
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
 		return
 	}
 	owner := c.GetString("user")
-	updated, err := h.repo.Update(c, owner, id, &req)
+	updated, err := h.repo.Update(c, owner, id, &req, ifMatch)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if err == repo.ErrNotFound {
-			status = http.StatusNotFound
-		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
+	setETag(c, updated.Version)
 	c.JSON(http.StatusOK, updated)
 }
+
+// Trash lists archived patients.
+func (h *PatientHandler) Trash(c *gin.Context) {
+	owner := c.GetString("user")
+	items, err := h.repo.ListArchived(c, owner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// Archive soft-deletes a patient.
+func (h *PatientHandler) Archive(c *gin.Context) {
+	id := c.Param("id")
+	owner := c.GetString("user")
+	item, err := h.repo.Archive(c, owner, id)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, item.Version)
+	c.JSON(http.StatusOK, item)
+}
+
+// Restore un-archives a patient.
+func (h *PatientHandler) Restore(c *gin.Context) {
+	id := c.Param("id")
+	owner := c.GetString("user")
+	item, err := h.repo.Restore(c, owner, id)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, item.Version)
+	c.JSON(http.StatusOK, item)
+}
+
+// Purge permanently deletes an archived patient and everything recorded for them.
+func (h *PatientHandler) Purge(c *gin.Context) {
+	id := c.Param("id")
+	owner := c.GetString("user")
+	if err := h.repo.Purge(c, owner, id); err != nil {
+		status := errorStatus(err)
+		msg := err.Error()
+		if status == http.StatusConflict {
+			msg = "patient must be archived before it can be purged"
+		}
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// Merge folds the patient named by source_id into this one and archives the source.
+// If-Match, when sent, must carry this patient's ETag.
+func (h *PatientHandler) Merge(c *gin.Context) {
+	id := c.Param("id")
+	ifMatch, ok := readIfMatch(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match"})
+		return
+	}
+	var req core.PatientMerge
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	owner := c.GetString("user")
+	result, err := h.repo.Merge(c, owner, id, req, ifMatch)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, result.Patient.Version)
+	c.JSON(http.StatusOK, result)
+}
+
+// History lists the field-level revisions of a patient, newest first.
+func (h *PatientHandler) History(c *gin.Context) {
+	id := c.Param("id")
+	owner := c.GetString("user")
+	items, err := h.repo.History(c, owner, id)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// StatusHistory lists the patient's status changes with their reasons, newest first.
+func (h *PatientHandler) StatusHistory(c *gin.Context) {
+	id := c.Param("id")
+	owner := c.GetString("user")
+	items, err := h.repo.StatusHistory(c, owner, id)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+type revertRequest struct {
+	RevisionID string `json:"revision_id" binding:"required"`
+	Field      string `json:"field" binding:"required"`
+}
+
+// Revert restores one field to the value it had before the given revision.
+func (h *PatientHandler) Revert(c *gin.Context) {
+	id := c.Param("id")
+	ifMatch, ok := readIfMatch(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match"})
+		return
+	}
+	var req revertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	owner := c.GetString("user")
+	item, err := h.repo.RevertField(c, owner, id, req.RevisionID, req.Field, ifMatch)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, item.Version)
+	c.JSON(http.StatusOK, item)
+}
@@ -10,27 +10,40 @@ import (
 )
 
 type PaymentHandler struct {
-	repo *repo.PaymentRepo
+	repo repo.PaymentStore
 }
 
-func NewPaymentHandler(repo *repo.PaymentRepo) *PaymentHandler {
+func NewPaymentHandler(repo repo.PaymentStore) *PaymentHandler {
 	return &PaymentHandler{repo: repo}
 }
 
 func (h *PaymentHandler) Create(c *gin.Context) {
 	var req core.Payment
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": payloadError(err)})
 		return
 	}
 	owner := c.GetString("user")
 	if err := h.repo.Create(c, owner, &req); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
+	setETag(c, req.Version)
 	c.JSON(http.StatusCreated, req)
 }
 
+func (h *PaymentHandler) GetByID(c *gin.Context) {
+	id := c.Param("id")
+	owner := c.GetString("user")
+	item, err := h.repo.GetByID(c, owner, id)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, item.Version)
+	c.JSON(http.StatusOK, item)
+}
+
 func (h *PaymentHandler) List(c *gin.Context) {
 	patientID := c.Query("patient_id")
 	owner := c.GetString("user")
@@ -42,23 +55,26 @@ func (h *PaymentHandler) List(c *gin.Context) {
 	c.JSON(http.StatusOK, items)
 }
 
+// Update patches a payment, honouring If-Match like PatientHandler.Update.
 func (h *PaymentHandler) Update(c *gin.Context) {
 	id := c.Param("id")
+	ifMatch, ok := readIfMatch(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match"})
+		return
+	}
 	var req core.PaymentUpdate
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": payloadError(err)})
 		return
 	}
 	owner := c.GetString("user")
-	updated, err := h.repo.Update(c, owner, id, &req)
+	updated, err := h.repo.Update(c, owner, id, &req, ifMatch)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if err == repo.ErrNotFound {
-			status = http.StatusNotFound
-		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
+	setETag(c, updated.Version)
 	c.JSON(http.StatusOK, updated)
 }
 
@@ -66,12 +82,19 @@ func (h *PaymentHandler) Delete(c *gin.Context) {
 	id := c.Param("id")
 	owner := c.GetString("user")
 	if err := h.repo.Delete(c, owner, id); err != nil {
-		status := http.StatusInternalServerError
-		if err == repo.ErrNotFound {
-			status = http.StatusNotFound
-		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
+
+// Balance shows what a patient has been billed, has paid and still owes.
+func (h *PaymentHandler) Balance(c *gin.Context) {
+	owner := c.GetString("user")
+	balance, err := h.repo.Balance(c, owner, c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, balance)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"phsio_track_backend/internal/core"
+	"phsio_track_backend/internal/repo"
+)
+
+type QuestionnaireHandler struct {
+	repo repo.QuestionnaireStore
+}
+
+func NewQuestionnaireHandler(repo repo.QuestionnaireStore) *QuestionnaireHandler {
+	return &QuestionnaireHandler{repo: repo}
+}
+
+// List returns the questionnaires the owner can administer, without their items and options.
+func (h *QuestionnaireHandler) List(c *gin.Context) {
+	owner := c.GetString("user")
+	items, err := h.repo.List(c, owner)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *QuestionnaireHandler) Get(c *gin.Context) {
+	owner := c.GetString("user")
+	item, err := h.repo.Get(c, owner, c.Param("code"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, item.Version)
+	c.JSON(http.StatusOK, item)
+}
+
+// Put creates or replaces the owner's questionnaire named in the path, honouring If-Match.
+func (h *QuestionnaireHandler) Put(c *gin.Context) {
+	ifMatch, ok := readIfMatch(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match"})
+		return
+	}
+	var req core.Questionnaire
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	req.Code = c.Param("code")
+	owner := c.GetString("user")
+	if err := h.repo.Put(c, owner, &req, ifMatch); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, req.Version)
+	c.JSON(http.StatusOK, req)
+}
+
+func (h *QuestionnaireHandler) Delete(c *gin.Context) {
+	owner := c.GetString("user")
+	if err := h.repo.Delete(c, owner, c.Param("code")); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// Submit records and scores a completed questionnaire for the patient.
+func (h *QuestionnaireHandler) Submit(c *gin.Context) {
+	var req core.QuestionnaireResponse
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	req.PatientID = c.Param("id")
+	owner := c.GetString("user")
+	if err := h.repo.Submit(c, owner, &req); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, req)
+}
+
+// History returns the patient's scores per questionnaire, oldest first; code narrows it to one.
+func (h *QuestionnaireHandler) History(c *gin.Context) {
+	owner := c.GetString("user")
+	items, err := h.repo.History(c, owner, c.Param("id"), c.Query("code"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *QuestionnaireHandler) GetResponse(c *gin.Context) {
+	owner := c.GetString("user")
+	item, err := h.repo.GetResponse(c, owner, c.Param("id"), c.Param("response_id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, item)
+}
+
+func (h *QuestionnaireHandler) DeleteResponse(c *gin.Context) {
+	owner := c.GetString("user")
+	if err := h.repo.DeleteResponse(c, owner, c.Param("id"), c.Param("response_id")); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"phsio_track_backend/internal/core"
+	"phsio_track_backend/internal/repo"
+	"phsio_track_backend/internal/report"
+)
+
+type ReceiptHandler struct {
+	repo repo.ReceiptStore
+}
+
+func NewReceiptHandler(repo repo.ReceiptStore) *ReceiptHandler {
+	return &ReceiptHandler{repo: repo}
+}
+
+func (h *ReceiptHandler) Clinic(c *gin.Context) {
+	owner := c.GetString("user")
+	item, err := h.repo.Clinic(c, owner)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, item)
+}
+
+// PutClinic saves the clinic profile printed on receipts; the logo is sent base64 encoded.
+func (h *ReceiptHandler) PutClinic(c *gin.Context) {
+	var req core.ClinicProfile
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": payloadError(err)})
+		return
+	}
+	if len(req.Logo) > 0 {
+		if err := report.CheckLogo(req.Logo); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "logo cannot be printed: " + err.Error()})
+			return
+		}
+	}
+	owner := c.GetString("user")
+	if err := h.repo.PutClinic(c, owner, &req); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// Receipt downloads the PDF receipt of a payment, numbering it the first time.
+func (h *ReceiptHandler) Receipt(c *gin.Context) {
+	owner := c.GetString("user")
+	receipt, err := h.repo.Issue(c, owner, c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	var buf bytes.Buffer
+	if err := report.Receipt(&buf, receipt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	name := "receipt-" + report.ReceiptNumber(receipt.Number) + ".pdf"
+	c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+	c.Data(http.StatusOK, report.ContentType(report.FormatPDF), buf.Bytes())
+}
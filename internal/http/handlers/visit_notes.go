@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"phsio_track_backend/internal/core"
+	"phsio_track_backend/internal/repo"
+)
+
+type VisitNoteHandler struct {
+	repo repo.VisitNoteStore
+}
+
+func NewVisitNoteHandler(repo repo.VisitNoteStore) *VisitNoteHandler {
+	return &VisitNoteHandler{repo: repo}
+}
+
+func (h *VisitNoteHandler) Create(c *gin.Context) {
+	var req core.VisitNote
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	req.PatientID = c.Param("id")
+	owner := c.GetString("user")
+	if err := h.repo.Create(c, owner, &req); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, req.Version)
+	c.JSON(http.StatusCreated, req)
+}
+
+// List returns the patient's visit notes, oldest first, optionally between from and to.
+func (h *VisitNoteHandler) List(c *gin.Context) {
+	from, err := queryTime(c, "from", false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to, err := queryTime(c, "to", true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	owner := c.GetString("user")
+	items, err := h.repo.List(c, owner, c.Param("id"), from, to)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func (h *VisitNoteHandler) GetByID(c *gin.Context) {
+	owner := c.GetString("user")
+	item, err := h.repo.GetByID(c, owner, c.Param("id"), c.Param("note_id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, item.Version)
+	c.JSON(http.StatusOK, item)
+}
+
+// Update edits a visit note, honouring If-Match like PatientHandler.Update.
+func (h *VisitNoteHandler) Update(c *gin.Context) {
+	ifMatch, ok := readIfMatch(c)
+	if !ok {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match"})
+		return
+	}
+	var req core.VisitNoteUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	owner := c.GetString("user")
+	updated, err := h.repo.Update(c, owner, c.Param("id"), c.Param("note_id"), &req, ifMatch)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setETag(c, updated.Version)
+	c.JSON(http.StatusOK, updated)
+}
+
+func (h *VisitNoteHandler) Delete(c *gin.Context) {
+	owner := c.GetString("user")
+	if err := h.repo.Delete(c, owner, c.Param("id"), c.Param("note_id")); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// Timeline merges the patient's visit notes, payments and measurements for the detail
+// screen. kind (comma separated) picks entry kinds, from/to a period and order=asc|desc
+// the direction (newest first by default).
+func (h *VisitNoteHandler) Timeline(c *gin.Context) {
+	var q core.TimelineQuery
+	for _, k := range splitList(strings.ToUpper(c.Query("kind"))) {
+		if !contains(core.TimelineKinds, k) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown kind " + k})
+			return
+		}
+		q.Kinds = append(q.Kinds, k)
+	}
+	switch c.DefaultQuery("order", "desc") {
+	case "desc":
+		q.Descending = true
+	case "asc":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return
+	}
+	var err error
+	if q.From, err = queryTime(c, "from", false); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if q.To, err = queryTime(c, "to", true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	owner := c.GetString("user")
+	items, err := h.repo.Timeline(c, owner, c.Param("id"), q)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
@@ -0,0 +1,517 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"phsio_track_backend/internal/core"
+)
+
+// Appointment times are clinic wall-clock times: they are stored exactly as sent, without
+// converting zones, so "now" is compared as the server's wall clock too.
+
+const (
+	maxAppointmentLength = 12 * time.Hour
+	maxSeriesWeeks       = 52
+	maxSeriesSlots       = 200
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"SUN": time.Sunday, "MON": time.Monday, "TUE": time.Tuesday, "WED": time.Wednesday,
+	"THU": time.Thursday, "FRI": time.Friday, "SAT": time.Saturday,
+}
+
+// AppointmentRepo stores appointments scoped to an owner. A therapist cannot hold
+// two BOOKED or ATTENDED appointments that overlap.
+type AppointmentRepo struct {
+	db *DB
+}
+
+func NewAppointmentRepo(db *DB) *AppointmentRepo {
+	return &AppointmentRepo{db: db}
+}
+
+// Create books an appointment for one of the owner's patients. The therapist defaults
+// to the owner and the status to BOOKED; an overlapping booking yields ErrConflict.
+func (r *AppointmentRepo) Create(ctx context.Context, owner string, a *core.Appointment) error {
+	a.Therapist = strings.TrimSpace(a.Therapist)
+	if a.Therapist == "" {
+		a.Therapist = owner
+	}
+	a.Status = strings.ToUpper(strings.TrimSpace(a.Status))
+	if a.Status == "" {
+		a.Status = core.AppointmentBooked
+	}
+	a.Notes = strings.TrimSpace(a.Notes)
+	if err := validateAppointment(*a); err != nil {
+		return err
+	}
+	if a.ID == "" {
+		a.ID = uuid.NewString()
+	}
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		if err := assertPatientOwner(ctx, tx, owner, a.PatientID); err != nil {
+			return err
+		}
+		if err := checkAppointmentSlot(ctx, tx, owner, *a); err != nil {
+			return err
+		}
+		if err := insertAppointment(ctx, tx, owner, *a); err != nil {
+			return err
+		}
+		var err error
+		*a, err = getAppointmentByID(ctx, tx, owner, a.ID)
+		return err
+	})
+}
+
+// CreateSeries books a recurring series in one transaction. A slot that clashes with
+// an existing appointment fails the whole series unless s.SkipConflicts is set.
+func (r *AppointmentRepo) CreateSeries(ctx context.Context, owner string, s core.AppointmentSeries) (core.AppointmentSeriesResult, error) {
+	result := core.AppointmentSeriesResult{SeriesID: uuid.NewString(), Appointments: []core.Appointment{}, Skipped: []core.JSONTime{}}
+	template := core.Appointment{
+		PatientID: s.PatientID,
+		Therapist: strings.TrimSpace(s.Therapist),
+		Start:     s.Start,
+		End:       s.End,
+		Status:    core.AppointmentBooked,
+		SeriesID:  result.SeriesID,
+		Notes:     strings.TrimSpace(s.Notes),
+	}
+	if template.Therapist == "" {
+		template.Therapist = owner
+	}
+	if err := validateAppointment(template); err != nil {
+		return result, err
+	}
+	starts, err := seriesStarts(s)
+	if err != nil {
+		return result, err
+	}
+	length := s.End.Sub(s.Start.Time)
+
+	err = withTx(ctx, r.db, func(tx *Tx) error {
+		if err := assertPatientOwner(ctx, tx, owner, s.PatientID); err != nil {
+			return err
+		}
+		for _, start := range starts {
+			a := template
+			a.ID = uuid.NewString()
+			a.Start = core.NewJSONTime(start)
+			a.End = core.NewJSONTime(start.Add(length))
+			if err := checkAppointmentSlot(ctx, tx, owner, a); err != nil {
+				if s.SkipConflicts && errors.Is(err, ErrConflict) {
+					result.Skipped = append(result.Skipped, a.Start)
+					continue
+				}
+				return err
+			}
+			if err := insertAppointment(ctx, tx, owner, a); err != nil {
+				return err
+			}
+			created, err := getAppointmentByID(ctx, tx, owner, a.ID)
+			if err != nil {
+				return err
+			}
+			result.Appointments = append(result.Appointments, created)
+		}
+		if len(result.Appointments) == 0 {
+			return fmt.Errorf("%w: every slot of the series clashes with another appointment", ErrConflict)
+		}
+		return nil
+	})
+	if err != nil {
+		return core.AppointmentSeriesResult{}, err
+	}
+	return result, nil
+}
+
+// CancelSeries cancels the series' BOOKED appointments that have not started yet and
+// returns how many it cancelled. Past appointments keep their status.
+func (r *AppointmentRepo) CancelSeries(ctx context.Context, owner, seriesID string) (int, error) {
+	var n int
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		var count int
+		err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM appointments WHERE series_id=:1 AND owner_username=:2
+		`, seriesID, owner).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrNotFound
+		}
+		res, err := tx.ExecContext(ctx, `
+			UPDATE appointments
+			   SET status = :1,
+			       updated_time = :2,
+			       version = version + 1
+			 WHERE series_id = :3 AND owner_username = :4 AND status = :5 AND start_time >= :6
+		`, core.AppointmentCancelled, time.Now(), seriesID, owner, core.AppointmentBooked, wallClockNow())
+		if err != nil {
+			return err
+		}
+		affected, _ := res.RowsAffected()
+		n = int(affected)
+		return nil
+	})
+	return n, err
+}
+
+// List returns the owner's appointments matching q, earliest first.
+func (r *AppointmentRepo) List(ctx context.Context, owner string, q core.AppointmentQuery) ([]core.Appointment, error) {
+	query := `SELECT ` + appointmentColumns + ` FROM appointments WHERE owner_username=:1`
+	args := []interface{}{owner}
+	bind := func(v interface{}) string {
+		args = append(args, v)
+		return ":" + strconv.Itoa(len(args))
+	}
+	if !q.From.IsZero() {
+		query += ` AND start_time >= ` + bind(q.From)
+	}
+	if !q.To.IsZero() {
+		query += ` AND start_time < ` + bind(q.To)
+	}
+	if q.Therapist != "" {
+		query += ` AND therapist = ` + bind(q.Therapist)
+	}
+	if q.PatientID != "" {
+		query += ` AND patient_id = ` + bind(q.PatientID)
+	}
+	if len(q.Statuses) > 0 {
+		binds := make([]string, len(q.Statuses))
+		for i, s := range q.Statuses {
+			binds[i] = bind(strings.ToUpper(s))
+		}
+		query += ` AND status IN (` + strings.Join(binds, ",") + `)`
+	}
+	rows, err := r.db.QueryContext(ctx, query+` ORDER BY start_time, therapist, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []core.Appointment{}
+	for rows.Next() {
+		a, err := scanAppointment(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, a)
+	}
+	return items, rows.Err()
+}
+
+// Calendar returns the appointments of the day, or of the Monday-to-Sunday week, that
+// contains day, grouped by day. An empty therapist shows every therapist.
+func (r *AppointmentRepo) Calendar(ctx context.Context, owner, view string, day time.Time, therapist string) (core.Calendar, error) {
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	days := 1
+	switch view {
+	case "day":
+	case "week":
+		// time.Weekday counts from Sunday; weeks here start on Monday
+		from = from.AddDate(0, 0, -((int(from.Weekday()) + 6) % 7))
+		days = 7
+	default:
+		return core.Calendar{}, fmt.Errorf("%w: view must be day or week", ErrInvalid)
+	}
+	to := from.AddDate(0, 0, days)
+	items, err := r.List(ctx, owner, core.AppointmentQuery{From: from, To: to, Therapist: therapist})
+	if err != nil {
+		return core.Calendar{}, err
+	}
+
+	cal := core.Calendar{View: view, From: core.NewJSONTime(from), To: core.NewJSONTime(to)}
+	for i := 0; i < days; i++ {
+		cal.Days = append(cal.Days, core.CalendarDay{Date: from.AddDate(0, 0, i).Format("2006-01-02"), Appointments: []core.Appointment{}})
+	}
+	for _, a := range items {
+		i := int(a.Start.Sub(from) / (24 * time.Hour))
+		if i >= 0 && i < days {
+			cal.Days[i].Appointments = append(cal.Days[i].Appointments, a)
+		}
+	}
+	return cal, nil
+}
+
+func (r *AppointmentRepo) GetByID(ctx context.Context, owner, id string) (core.Appointment, error) {
+	return getAppointmentByID(ctx, r.db, owner, id)
+}
+
+// Update reschedules or changes the status of an appointment. Moving it, or bringing a
+// cancelled or missed one back, checks the therapist's time again. A conditional ifMatch
+// must match.
+func (r *AppointmentRepo) Update(ctx context.Context, owner, id string, upd *core.AppointmentUpdate, ifMatch core.VersionMatch) (core.Appointment, error) {
+	var updated core.Appointment
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		current, err := getAppointmentByID(ctx, tx, owner, id)
+		if err != nil {
+			return err
+		}
+		if !ifMatch.Matches(current.Version) {
+			return ErrStale
+		}
+
+		next := current
+		if upd.Therapist != nil {
+			next.Therapist = strings.TrimSpace(*upd.Therapist)
+		}
+		if upd.Start != nil {
+			next.Start = *upd.Start
+		}
+		if upd.End != nil {
+			next.End = *upd.End
+		}
+		if upd.Status != nil {
+			next.Status = strings.ToUpper(strings.TrimSpace(*upd.Status))
+		}
+		if upd.Notes != nil {
+			next.Notes = strings.TrimSpace(*upd.Notes)
+		}
+		if next == current {
+			updated = current
+			return nil
+		}
+		if err := validateAppointment(next); err != nil {
+			return err
+		}
+		moved := !next.Start.Equal(current.Start.Time) || !next.End.Equal(current.End.Time) || next.Therapist != current.Therapist
+		if moved || !holdsSlot(current.Status) {
+			if err := checkAppointmentSlot(ctx, tx, owner, next); err != nil {
+				return err
+			}
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			UPDATE appointments
+			   SET therapist = :1,
+			       start_time = :2,
+			       end_time = :3,
+			       status = :4,
+			       notes = :5,
+			       updated_time = :6,
+			       version = version + 1
+			 WHERE id = :7 AND owner_username = :8 AND version = :9
+		`, next.Therapist, next.Start, next.End, next.Status, nullableText(next.Notes), time.Now(), id, owner, current.Version)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return ErrStale
+		}
+		updated, err = getAppointmentByID(ctx, tx, owner, id)
+		return err
+	})
+	if err != nil {
+		return core.Appointment{}, err
+	}
+	return updated, nil
+}
+
+// Delete removes an appointment entered by mistake; cancel it to keep it on record.
+// Measurements and visit notes taken at it stay with the patient.
+func (r *AppointmentRepo) Delete(ctx context.Context, owner, id string) error {
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM appointments WHERE id=:1 AND owner_username=:2`, id, owner)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return ErrNotFound
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE measurements SET appointment_id = NULL WHERE appointment_id = :1 AND owner_username = :2
+		`, id, owner); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE visit_notes SET appointment_id = NULL WHERE appointment_id = :1 AND owner_username = :2
+		`, id, owner)
+		return err
+	})
+}
+
+func validateAppointment(a core.Appointment) error {
+	switch {
+	case !containsString(core.AppointmentStatuses, a.Status):
+		return fmt.Errorf("%w: status must be one of %s", ErrInvalid, strings.Join(core.AppointmentStatuses, ", "))
+	case a.Start.IsZero() || a.End.IsZero():
+		return fmt.Errorf("%w: start and end are required", ErrInvalid)
+	case !a.End.After(a.Start.Time):
+		return fmt.Errorf("%w: end must be after start", ErrInvalid)
+	case a.End.Sub(a.Start.Time) > maxAppointmentLength:
+		return fmt.Errorf("%w: an appointment lasts at most %v", ErrInvalid, maxAppointmentLength)
+	case a.Therapist == "" || utf8.RuneCountInString(a.Therapist) > maxNameLength:
+		return fmt.Errorf("%w: therapist must be 1 to %d characters", ErrInvalid, maxNameLength)
+	case len(a.Notes) > maxTextBytes:
+		return fmt.Errorf("%w: notes are longer than %d bytes", ErrInvalid, maxTextBytes)
+	}
+	return nil
+}
+
+// seriesStarts lists the start time of every session of a series.
+func seriesStarts(s core.AppointmentSeries) ([]time.Time, error) {
+	first := time.Date(s.Start.Year(), s.Start.Month(), s.Start.Day(), 0, 0, 0, 0, s.Start.Location())
+	clock := s.Start.Sub(first)
+
+	days := map[time.Weekday]bool{}
+	for _, d := range s.Weekdays {
+		name := strings.ToUpper(strings.TrimSpace(d))
+		if len(name) > 3 {
+			name = name[:3]
+		}
+		wd, ok := weekdayNames[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown weekday %q", ErrInvalid, d)
+		}
+		days[wd] = true
+	}
+	if len(days) == 0 {
+		days[first.Weekday()] = true
+	}
+
+	var end time.Time // exclusive
+	switch {
+	case s.Weeks != 0 && !s.Until.IsZero():
+		return nil, fmt.Errorf("%w: give weeks or until, not both", ErrInvalid)
+	case s.Weeks != 0:
+		if s.Weeks < 0 || s.Weeks > maxSeriesWeeks {
+			return nil, fmt.Errorf("%w: weeks must be 1 to %d", ErrInvalid, maxSeriesWeeks)
+		}
+		end = first.AddDate(0, 0, 7*s.Weeks)
+	case !s.Until.IsZero():
+		end = time.Date(s.Until.Year(), s.Until.Month(), s.Until.Day(), 0, 0, 0, 0, first.Location()).AddDate(0, 0, 1)
+		if end.After(first.AddDate(0, 0, 7*maxSeriesWeeks)) {
+			return nil, fmt.Errorf("%w: a series runs for at most %d weeks", ErrInvalid, maxSeriesWeeks)
+		}
+	default:
+		return nil, fmt.Errorf("%w: weeks or until is required", ErrInvalid)
+	}
+
+	var starts []time.Time
+	for d := first; d.Before(end); d = d.AddDate(0, 0, 1) {
+		if days[d.Weekday()] {
+			starts = append(starts, d.Add(clock))
+		}
+	}
+	switch {
+	case len(starts) == 0:
+		return nil, fmt.Errorf("%w: the series has no sessions", ErrInvalid)
+	case len(starts) > maxSeriesSlots:
+		return nil, fmt.Errorf("%w: a series has at most %d sessions", ErrInvalid, maxSeriesSlots)
+	}
+	return starts, nil
+}
+
+func holdsSlot(status string) bool {
+	return status == core.AppointmentBooked || status == core.AppointmentAttended
+}
+
+// checkAppointmentSlot returns ErrConflict when a would overlap another appointment
+// holding the same therapist's time. Appointments that do not hold a slot always fit.
+// The owner's appointment lock is held from the check until tx ends, so two bookings
+// cannot both find the same slot free.
+func checkAppointmentSlot(ctx context.Context, tx *Tx, owner string, a core.Appointment) error {
+	if !holdsSlot(a.Status) {
+		return nil
+	}
+	if err := lockAppointments(ctx, tx, owner); err != nil {
+		return err
+	}
+	query := `
+		SELECT ` + appointmentColumns + `
+		FROM appointments
+		WHERE owner_username=:1 AND therapist=:2 AND status IN (:3,:4) AND start_time < :5 AND end_time > :6`
+	args := []interface{}{owner, a.Therapist, core.AppointmentBooked, core.AppointmentAttended, a.End, a.Start}
+	if a.ID != "" {
+		query += ` AND id <> :7`
+		args = append(args, a.ID)
+	}
+	rows, err := tx.QueryContext(ctx, query+` ORDER BY start_time`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return rows.Err()
+	}
+	other, err := scanAppointment(rows)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s already has appointment %s from %s to %s", ErrConflict, a.Therapist, other.ID,
+		other.Start.Format("2006-01-02 15:04"), other.End.Format("15:04"))
+}
+
+// lockAppointments creates the owner's appointment lock row if needed and locks it
+// until tx ends.
+func lockAppointments(ctx context.Context, tx *Tx, owner string) error {
+	q := `
+		INSERT INTO appointment_locks (owner_username) VALUES (:1)
+		ON CONFLICT (owner_username) DO NOTHING
+	`
+	if tx.dialect == DialectOracle {
+		q = `
+		MERGE INTO appointment_locks t
+		USING (SELECT :1 AS owner_username FROM dual) s
+		ON (t.owner_username = s.owner_username)
+		WHEN NOT MATCHED THEN INSERT (owner_username) VALUES (s.owner_username)
+	`
+	}
+	if _, err := tx.ExecContext(ctx, q, owner); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE appointment_locks SET owner_username = owner_username WHERE owner_username = :1
+	`, owner)
+	return err
+}
+
+func insertAppointment(ctx context.Context, q querier, owner string, a core.Appointment) error {
+	now := time.Now()
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO appointments (id, patient_id, owner_username, therapist, start_time, end_time, status,
+		                          series_id, notes, created_time, updated_time, version)
+		VALUES (:1,:2,:3,:4,:5,:6,:7,:8,:9,:10,:11,1)
+	`, a.ID, a.PatientID, owner, a.Therapist, a.Start, a.End, a.Status, nullableText(a.SeriesID), nullableText(a.Notes), now, now)
+	return err
+}
+
+// wallClockNow is the current time on the server's clock, read as a zone-less
+// wall-clock time like the appointment times.
+func wallClockNow() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), 0, time.UTC)
+}
+
+// appointmentColumns is the select list read by scanAppointment.
+const appointmentColumns = `id, patient_id, therapist, start_time, end_time, status, series_id, notes,
+		       created_time, updated_time, version`
+
+func scanAppointment(row rowScanner) (core.Appointment, error) {
+	var a core.Appointment
+	var series, notes sql.NullString
+	err := row.Scan(&a.ID, &a.PatientID, &a.Therapist, &a.Start, &a.End, &a.Status, &series, &notes,
+		&a.CreatedTime, &a.UpdatedTime, &a.Version)
+	a.SeriesID = nullStringToString(series)
+	a.Notes = nullStringToString(notes)
+	return a, err
+}
+
+func getAppointmentByID(ctx context.Context, q querier, owner, id string) (core.Appointment, error) {
+	a, err := scanAppointment(q.QueryRowContext(ctx, `
+		SELECT `+appointmentColumns+` FROM appointments WHERE id=:1 AND owner_username=:2
+	`, id, owner))
+	if err == sql.ErrNoRows {
+		return a, ErrNotFound
+	}
+	return a, err
+}
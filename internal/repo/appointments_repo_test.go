@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"phsio_track_backend/internal/core"
+)
+
+func TestAppointmentSlotConflicts(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	r := NewAppointmentRepo(db)
+	patient := newTestPatient(t, db, "Asha Rao")
+	at := func(hour, minute int) core.JSONTime {
+		return core.NewJSONTime(time.Date(2025, 4, 7, hour, minute, 0, 0, time.UTC))
+	}
+	book := func(therapist string, from, to core.JSONTime) error {
+		a := core.Appointment{PatientID: patient, Therapist: therapist, Start: from, End: to}
+		return r.Create(ctx, "owner", &a)
+	}
+
+	if err := book("dr-a", at(10, 0), at(10, 45)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		therapist string
+		from, to  core.JSONTime
+		err       error
+	}{
+		{"same slot", "dr-a", at(10, 0), at(10, 45), ErrConflict},
+		{"overlapping start", "dr-a", at(10, 30), at(11, 0), ErrConflict},
+		{"inside", "dr-a", at(10, 10), at(10, 20), ErrConflict},
+		{"back to back", "dr-a", at(10, 45), at(11, 30), nil},
+		{"other therapist", "dr-b", at(10, 0), at(10, 45), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := book(tt.therapist, tt.from, tt.to); !errors.Is(err, tt.err) {
+				t.Errorf("Create error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAppointmentConcurrentBookings(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	r := NewAppointmentRepo(db)
+	patient := newTestPatient(t, db, "Asha Rao")
+	start := time.Date(2025, 4, 7, 9, 0, 0, 0, time.UTC)
+
+	const n = 8
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a := core.Appointment{PatientID: patient, Therapist: "dr-a",
+				Start: core.NewJSONTime(start), End: core.NewJSONTime(start.Add(30 * time.Minute))}
+			errs[i] = r.Create(ctx, "owner", &a)
+		}()
+	}
+	wg.Wait()
+
+	booked := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			booked++
+		case !errors.Is(err, ErrConflict):
+			t.Errorf("Create error = %v, want ErrConflict", err)
+		}
+	}
+	if booked != 1 {
+		t.Errorf("%d concurrent bookings of one slot succeeded, want 1", booked)
+	}
+}
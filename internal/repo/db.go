@@ -17,14 +17,31 @@ import (
 )
 
 type DBConfig struct {
+	Driver        string // oracle (default), postgres or sqlite
+	DSN           string // connection string for the postgres driver
+	SQLitePath    string // database file for the sqlite driver
 	User          string
 	Password      string
 	ConnectString string // TNS alias, e.g., sf1qflnhz887u1f0_high
 	TNSAdmin      string // wallet dir
 }
 
+// Open connects to the backend selected by cfg.Driver.
+func Open(ctx context.Context, cfg DBConfig) (*DB, error) {
+	switch Dialect(strings.ToLower(strings.TrimSpace(cfg.Driver))) {
+	case "", DialectOracle:
+		return NewDB(ctx, cfg)
+	case DialectPostgres, "postgresql", "pgx":
+		return NewPostgresDB(ctx, cfg.DSN)
+	case DialectSQLite:
+		return NewSQLiteDB(ctx, cfg.SQLitePath)
+	default:
+		return nil, fmt.Errorf("unsupported db driver %q", cfg.Driver)
+	}
+}
+
 // NewDB connects to Oracle using godror and the wallet/TNS alias.
-func NewDB(ctx context.Context, cfg DBConfig) (*sql.DB, error) {
+func NewDB(ctx context.Context, cfg DBConfig) (*DB, error) {
 	cleanDir := strings.ReplaceAll(cfg.TNSAdmin, `\`, `/`)
 
 	log.Printf("DB config user=%s connect_string=%s tns_admin=%s", cfg.User, cfg.ConnectString, cleanDir)
@@ -56,7 +73,7 @@ func NewDB(ctx context.Context, cfg DBConfig) (*sql.DB, error) {
 		return nil, fmt.Errorf("db ping failed: %w", err)
 	}
 	log.Println("DB ping ok")
-	return db, nil
+	return &DB{DB: db, dialect: DialectOracle}, nil
 }
 
 type tnsEntry struct {
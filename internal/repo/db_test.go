@@ -0,0 +1,45 @@
+package repo
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"phsio_track_backend/internal/core"
+)
+
+func TestMain(m *testing.M) {
+	// the stores log their connections and migrations
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestDB opens a migrated in-memory SQLite database with one user, "owner".
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	ctx := context.Background()
+	db, err := NewSQLiteDB(ctx, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := MigrateUp(ctx, db, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewUserRepo(db).UpsertUser(ctx, core.User{Username: "owner", PasswordHash: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestPatient adds a patient for "owner" and returns its id.
+func newTestPatient(t *testing.T, db *DB, name string) string {
+	t.Helper()
+	p := core.Patient{FullName: name}
+	if err := NewPatientRepo(db, "IN").Create(context.Background(), "owner", &p); err != nil {
+		t.Fatal(err)
+	}
+	return p.ID
+}
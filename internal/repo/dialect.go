@@ -0,0 +1,114 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+)
+
+// Dialect identifies the SQL flavour spoken by the connected database.
+type Dialect string
+
+const (
+	DialectOracle   Dialect = "oracle"
+	DialectSQLite   Dialect = "sqlite"
+	DialectPostgres Dialect = "postgres"
+)
+
+var bindRe = regexp.MustCompile(`:([0-9]+)`)
+
+// rebind rewrites Oracle-style ":n" binds into the dialect's placeholder syntax.
+// Queries in this package are written with ":n" binds so they read the same for every backend.
+func (d Dialect) rebind(q string) string {
+	switch d {
+	case DialectSQLite:
+		return bindRe.ReplaceAllString(q, "?$1")
+	case DialectPostgres:
+		return bindRe.ReplaceAllString(q, "$$$1")
+	default:
+		return q
+	}
+}
+
+// limit returns the clause that caps a query at n rows; append it after ORDER BY.
+func (d Dialect) limit(n int) string {
+	if d == DialectSQLite {
+		return " LIMIT " + strconv.Itoa(n)
+	}
+	return " FETCH FIRST " + strconv.Itoa(n) + " ROWS ONLY"
+}
+
+// DB wraps *sql.DB and remembers which dialect it speaks.
+type DB struct {
+	*sql.DB
+	dialect Dialect
+}
+
+// Dialect returns the SQL dialect of the underlying connection.
+func (db *DB) Dialect() Dialect { return db.dialect }
+
+// ExecContext rebinds q for the dialect and executes it.
+func (db *DB) ExecContext(ctx context.Context, q string, args ...interface{}) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.dialect.rebind(q), args...)
+}
+
+// QueryContext rebinds q for the dialect and runs it.
+func (db *DB) QueryContext(ctx context.Context, q string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, db.dialect.rebind(q), args...)
+}
+
+// QueryRowContext rebinds q for the dialect and runs it.
+func (db *DB) QueryRowContext(ctx context.Context, q string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRowContext(ctx, db.dialect.rebind(q), args...)
+}
+
+// BeginTx starts a transaction that rebinds queries like DB does.
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, dialect: db.dialect}, nil
+}
+
+// Tx wraps *sql.Tx with the dialect of the DB that started it.
+type Tx struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+// ExecContext rebinds q for the dialect and executes it inside the transaction.
+func (tx *Tx) ExecContext(ctx context.Context, q string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, tx.dialect.rebind(q), args...)
+}
+
+// QueryContext rebinds q for the dialect and runs it inside the transaction.
+func (tx *Tx) QueryContext(ctx context.Context, q string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(ctx, tx.dialect.rebind(q), args...)
+}
+
+// QueryRowContext rebinds q for the dialect and runs it inside the transaction.
+func (tx *Tx) QueryRowContext(ctx context.Context, q string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, tx.dialect.rebind(q), args...)
+}
+
+// querier is satisfied by both *DB and *Tx.
+type querier interface {
+	ExecContext(ctx context.Context, q string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, q string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, q string, args ...interface{}) *sql.Row
+}
+
+// withTx runs fn in a transaction, committing on success and rolling back on error.
+func withTx(ctx context.Context, db *DB, fn func(tx *Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,277 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"phsio_track_backend/internal/core"
+)
+
+// maxDischargeMeasurements caps the final readings taken with one discharge.
+const maxDischargeMeasurements = 50
+
+// DischargeRepo records discharges and compiles their summaries.
+type DischargeRepo struct {
+	db       *DB
+	currency string
+}
+
+// NewDischargeRepo returns a DischargeRepo reporting payment totals in currency.
+func NewDischargeRepo(db *DB, currency string) *DischargeRepo {
+	return &DischargeRepo{db: db, currency: currency}
+}
+
+// Discharge closes a patient's case in one transaction: the final measurements are
+// recorded, the open episode is closed on the discharge day and the patient moves to
+// DISCHARGED with the outcome as the reason. A patient who cannot move to DISCHARGED
+// yields ErrConflict.
+func (r *DischargeRepo) Discharge(ctx context.Context, owner string, d *core.Discharge) error {
+	if d.DischargedOn.IsZero() {
+		d.DischargedOn = core.NewJSONTime(time.Now())
+	}
+	d.DischargedOn = core.NewJSONTime(episodeDay(d.DischargedOn.Time))
+	// final readings taken without a time are taken now, or at the end of a past discharge day
+	measuredAt := time.Now()
+	if end := d.DischargedOn.AddDate(0, 0, 1).Add(-time.Second); measuredAt.After(end) {
+		measuredAt = end
+	}
+	d.Outcome = strings.ToUpper(strings.TrimSpace(d.Outcome))
+	d.OutcomeNotes = strings.TrimSpace(d.OutcomeNotes)
+	d.HomeAdvice = strings.TrimSpace(d.HomeAdvice)
+	switch {
+	case !containsString(core.DischargeOutcomes, d.Outcome):
+		return fmt.Errorf("%w: outcome must be one of %s", ErrInvalid, strings.Join(core.DischargeOutcomes, ", "))
+	case len(d.OutcomeNotes) > maxTextBytes:
+		return fmt.Errorf("%w: outcome_notes is longer than %d bytes", ErrInvalid, maxTextBytes)
+	case len(d.HomeAdvice) > maxTextBytes:
+		return fmt.Errorf("%w: home_advice is longer than %d bytes", ErrInvalid, maxTextBytes)
+	case len(d.Measurements) > maxDischargeMeasurements:
+		return fmt.Errorf("%w: at most %d measurements can be taken at discharge", ErrInvalid, maxDischargeMeasurements)
+	}
+	if d.Measurements == nil {
+		d.Measurements = []core.Measurement{}
+	}
+	for i := range d.Measurements {
+		m := &d.Measurements[i]
+		m.PatientID = d.PatientID
+		if m.MeasuredAt.IsZero() {
+			m.MeasuredAt = core.NewJSONTime(measuredAt)
+		}
+		if err := normalizeMeasurement(m); err != nil {
+			return fmt.Errorf("measurement %d: %w", i+1, err)
+		}
+	}
+	d.ID = uuid.NewString()
+
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		patient, err := getPatientByID(ctx, tx, owner, d.PatientID)
+		if err == ErrNotFound {
+			return ErrForbidden
+		}
+		if err != nil {
+			return err
+		}
+		if !core.CanTransitionPatient(patient.Status, core.PatientDischarged) {
+			return fmt.Errorf("%w: cannot discharge a %s patient", ErrConflict, patient.Status)
+		}
+		ids := make([]string, 0, len(d.Measurements))
+		for i := range d.Measurements {
+			if err := insertMeasurement(ctx, tx, owner, &d.Measurements[i]); err != nil {
+				return err
+			}
+			ids = append(ids, d.Measurements[i].ID)
+		}
+
+		episode, err := currentEpisode(ctx, tx, owner, d.PatientID)
+		switch {
+		case err == ErrNotFound:
+		case err != nil:
+			return err
+		default:
+			d.EpisodeID = episode.ID
+			if episode.Status == core.EpisodeOpen {
+				closedOn := d.DischargedOn.Time
+				if closedOn.Before(episode.OpenedOn.Time) {
+					closedOn = episode.OpenedOn.Time
+				}
+				if _, err := tx.ExecContext(ctx, `
+					UPDATE episodes
+					   SET status = :1,
+					       closed_on = :2,
+					       updated_time = :3,
+					       version = version + 1
+					 WHERE id = :4 AND owner_username = :5
+				`, core.EpisodeClosed, closedOn, time.Now(), episode.ID, owner); err != nil {
+					return err
+				}
+			}
+		}
+
+		now := time.Now()
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO discharges (id, patient_id, owner_username, episode_id, discharged_on, outcome, outcome_notes,
+			                        home_advice, measurement_ids, created_time)
+			VALUES (:1,:2,:3,:4,:5,:6,:7,:8,:9,:10)
+		`, d.ID, d.PatientID, owner, nullableText(d.EpisodeID), d.DischargedOn, d.Outcome, nullableText(d.OutcomeNotes),
+			nullableText(d.HomeAdvice), nullableText(strings.Join(ids, "\n")), now)
+		if err != nil {
+			return err
+		}
+		d.CreatedTime = core.NewJSONTime(now)
+
+		status, reason := core.PatientDischarged, d.Outcome
+		if d.OutcomeNotes != "" {
+			reason += ": " + d.OutcomeNotes
+		}
+		reason = truncateText(reason, maxTextBytes)
+		_, err = updatePatient(ctx, tx, owner, d.PatientID, &core.PatientUpdate{Status: &status, StatusReason: &reason}, core.VersionMatch{})
+		return err
+	})
+}
+
+// List returns a patient's discharges, most recent first, without their measurements.
+func (r *DischargeRepo) List(ctx context.Context, owner, patientID string) ([]core.Discharge, error) {
+	if err := assertPatientOwner(ctx, r.db, owner, patientID); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+dischargeColumns+` FROM discharges WHERE patient_id=:1 AND owner_username=:2
+		 ORDER BY discharged_on DESC, created_time DESC
+	`, patientID, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []core.Discharge{}
+	for rows.Next() {
+		d, _, err := scanDischarge(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, d)
+	}
+	return items, rows.Err()
+}
+
+// GetByID returns a discharge with the final measurements still on record.
+func (r *DischargeRepo) GetByID(ctx context.Context, owner, patientID, id string) (core.Discharge, error) {
+	d, ids, err := scanDischarge(r.db.QueryRowContext(ctx, `
+		SELECT `+dischargeColumns+` FROM discharges WHERE id=:1 AND patient_id=:2 AND owner_username=:3
+	`, id, patientID, owner))
+	if err == sql.ErrNoRows {
+		return d, ErrNotFound
+	}
+	if err != nil {
+		return d, err
+	}
+	for _, mid := range ids {
+		m, err := getMeasurementByID(ctx, r.db, owner, patientID, mid)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return d, err
+		}
+		d.Measurements = append(d.Measurements, m)
+	}
+	return d, nil
+}
+
+// Summary compiles the discharge summary from what is on record now: the closed episode's
+// complaint, diagnosis and plan, the home programme, progress of every measure taken
+// during the episode and the patient's payment totals.
+func (r *DischargeRepo) Summary(ctx context.Context, owner, patientID, id string) (core.DischargeSummary, error) {
+	var s core.DischargeSummary
+	d, err := r.GetByID(ctx, owner, patientID, id)
+	if err != nil {
+		return s, err
+	}
+	s.Discharge = d
+	s.Therapist = owner
+	if s.Patient, err = getPatientByID(ctx, r.db, owner, patientID); err != nil {
+		return s, err
+	}
+
+	from := time.Time{}
+	if d.EpisodeID != "" {
+		e, err := getEpisode(ctx, r.db, owner, patientID, d.EpisodeID)
+		switch {
+		case err == ErrNotFound:
+		case err != nil:
+			return s, err
+		default:
+			s.Episode = &e
+			from = e.OpenedOn.Time
+		}
+	}
+	if s.Exercises, err = listExercises(ctx, r.db, owner, patientID, d.DischargedOn.Time); err != nil {
+		return s, err
+	}
+
+	series, err := (&MeasurementRepo{db: r.db}).Series(ctx, owner, patientID, core.MeasurementQuery{
+		From: from,
+		To:   d.DischargedOn.AddDate(0, 0, 1),
+	})
+	if err != nil {
+		return s, err
+	}
+	s.Progress = []core.MeasureProgress{}
+	for _, m := range series {
+		s.Progress = append(s.Progress, core.MeasureProgress{
+			Label: m.Label,
+			Unit:  m.Unit,
+			First: m.Points[0],
+			Last:  m.Points[len(m.Points)-1],
+		})
+	}
+
+	var paid sql.NullInt64
+	if d.EpisodeID != "" {
+		err := r.db.QueryRowContext(ctx, `
+			SELECT SUM(amount_minor) FROM payments WHERE episode_id=:1 AND patient_id=:2 AND owner_username=:3
+		`, d.EpisodeID, patientID, owner).Scan(&paid)
+		if err != nil {
+			return s, err
+		}
+	}
+	s.EpisodePaid = core.NewMoney(paid.Int64, r.currency)
+	s.Balance, err = (&PaymentRepo{db: r.db, currency: r.currency}).Balance(ctx, owner, patientID)
+	return s, err
+}
+
+// dischargeColumns is the select list read by scanDischarge.
+const dischargeColumns = `id, patient_id, episode_id, discharged_on, outcome, outcome_notes, home_advice,
+		       measurement_ids, created_time`
+
+// scanDischarge reads a discharge and the ids of its final measurements.
+func scanDischarge(row rowScanner) (core.Discharge, []string, error) {
+	var d core.Discharge
+	var episode, notes, advice, ids sql.NullString
+	err := row.Scan(&d.ID, &d.PatientID, &episode, &d.DischargedOn, &d.Outcome, &notes, &advice, &ids, &d.CreatedTime)
+	d.EpisodeID = nullStringToString(episode)
+	d.OutcomeNotes = nullStringToString(notes)
+	d.HomeAdvice = nullStringToString(advice)
+	d.Measurements = []core.Measurement{}
+	var measurementIDs []string
+	if ids.Valid && ids.String != "" {
+		measurementIDs = strings.Split(ids.String, "\n")
+	}
+	return d, measurementIDs, err
+}
+
+// truncateText cuts s to at most n bytes without splitting a character.
+func truncateText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,35 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exact", 5, "exact"},
+		{"longer", 4, "long"},
+		{"héllo", 2, "h"},  // é is two bytes; half of it is dropped
+		{"héllo", 3, "hé"}, // both bytes of é fit
+		{"नमस्ते", 4, "न"}, // three-byte characters
+		{"😀x", 3, ""},
+		{"", 0, ""},
+	}
+	for _, tt := range tests {
+		got := truncateText(tt.s, tt.n)
+		if got != tt.want || !utf8.ValidString(got) {
+			t.Errorf("truncateText(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+
+	long := strings.Repeat("é", maxTextBytes)
+	if got := truncateText(long, maxTextBytes); len(got) != maxTextBytes || !utf8.ValidString(got) {
+		t.Errorf("truncated to %d bytes, valid %v", len(got), utf8.ValidString(got))
+	}
+}
@@ -0,0 +1,557 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"phsio_track_backend/internal/core"
+)
+
+// EpisodeRepo stores patients' episodes of care. The patient row keeps a copy of the
+// current episode's clinical fields, so every change to either side is mirrored to the
+// other in the same transaction.
+type EpisodeRepo struct {
+	db *DB
+}
+
+func NewEpisodeRepo(db *DB) *EpisodeRepo {
+	return &EpisodeRepo{db: db}
+}
+
+// List returns a patient's episodes, most recently opened first.
+func (r *EpisodeRepo) List(ctx context.Context, owner, patientID string) ([]core.Episode, error) {
+	if err := assertPatientOwner(ctx, r.db, owner, patientID); err != nil {
+		return nil, err
+	}
+	return listEpisodes(ctx, r.db, owner, patientID)
+}
+
+// Open starts a new episode for a returning patient. The episode open until now is
+// closed on the new one's opening day, and the patient's clinical fields switch to the
+// new episode; their previous values stay with the old one.
+func (r *EpisodeRepo) Open(ctx context.Context, owner string, e *core.Episode) error {
+	if e.OpenedOn.IsZero() {
+		e.OpenedOn = core.NewJSONTime(time.Now())
+	}
+	e.Status = core.EpisodeOpen
+	e.ClosedOn = core.JSONTime{}
+	normalizeEpisode(e)
+	if err := validateEpisode(*e); err != nil {
+		return err
+	}
+	e.ID = uuid.NewString()
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		if err := assertPatientOwner(ctx, tx, owner, e.PatientID); err != nil {
+			return err
+		}
+		open, err := openEpisode(ctx, tx, owner, e.PatientID)
+		switch {
+		case err == ErrNotFound:
+		case err != nil:
+			return err
+		default:
+			closedOn := e.OpenedOn.Time
+			if closedOn.Before(open.OpenedOn.Time) {
+				closedOn = open.OpenedOn.Time
+			}
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE episodes
+				   SET status = :1,
+				       closed_on = :2,
+				       updated_time = :3,
+				       version = version + 1
+				 WHERE id = :4 AND owner_username = :5
+			`, core.EpisodeClosed, closedOn, time.Now(), open.ID, owner); err != nil {
+				return err
+			}
+		}
+		if err := insertEpisode(ctx, tx, owner, *e); err != nil {
+			return err
+		}
+		if err := mirrorCurrentEpisode(ctx, tx, owner, e.PatientID); err != nil {
+			return err
+		}
+		*e, err = getEpisode(ctx, tx, owner, e.PatientID, e.ID)
+		return err
+	})
+}
+
+// GetByID returns an episode with the payments made for it.
+func (r *EpisodeRepo) GetByID(ctx context.Context, owner, patientID, id string) (core.Episode, error) {
+	e, err := getEpisode(ctx, r.db, owner, patientID, id)
+	if err != nil {
+		return e, err
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, patient_id, amount_minor, currency, payment_mode, paid_date, version, episode_id
+		FROM payments
+		WHERE episode_id=:1 AND patient_id=:2 AND owner_username=:3
+		ORDER BY paid_date DESC
+	`, id, patientID, owner)
+	if err != nil {
+		return e, err
+	}
+	for rows.Next() {
+		var p core.Payment
+		var paid sql.NullTime
+		var episode sql.NullString
+		if err := rows.Scan(&p.ID, &p.PatientID, &p.Amount.Minor, &p.Amount.Currency, &p.Mode, &paid, &p.Version, &episode); err != nil {
+			rows.Close()
+			return e, err
+		}
+		if paid.Valid {
+			p.Date = core.NewJSONTime(paid.Time)
+		}
+		p.EpisodeID = nullStringToString(episode)
+		e.Payments = append(e.Payments, p)
+	}
+	rows.Close()
+	return e, rows.Err()
+}
+
+// Update edits an episode, closes it or reopens it. Only one episode can be open; a
+// change to the current episode is copied to the patient. A conditional ifMatch must match.
+func (r *EpisodeRepo) Update(ctx context.Context, owner, patientID, id string, upd *core.EpisodeUpdate, ifMatch core.VersionMatch) (core.Episode, error) {
+	var updated core.Episode
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		current, err := getEpisode(ctx, tx, owner, patientID, id)
+		if err != nil {
+			return err
+		}
+		if !ifMatch.Matches(current.Version) {
+			return ErrStale
+		}
+		next := current
+		for _, f := range []struct {
+			dst *string
+			src *string
+		}{
+			{&next.Title, upd.Title}, {&next.ChiefComplaint, upd.ChiefComplaint},
+			{&next.PresentHistory, upd.PresentHistory}, {&next.Observation, upd.Observation},
+			{&next.Palpation, upd.Palpation}, {&next.Examination, upd.Examination},
+			{&next.Diagnosis, upd.Diagnosis}, {&next.Plan, upd.Plan}, {&next.Status, upd.Status},
+		} {
+			if f.src != nil {
+				*f.dst = *f.src
+			}
+		}
+		if upd.OpenedOn != nil {
+			next.OpenedOn = *upd.OpenedOn
+		}
+		if upd.ClosedOn != nil {
+			next.ClosedOn = *upd.ClosedOn
+		}
+		normalizeEpisode(&next)
+		switch {
+		case next.Status == core.EpisodeOpen && current.Status != core.EpisodeOpen:
+			if open, err := openEpisode(ctx, tx, owner, patientID); err == nil {
+				return fmt.Errorf("%w: close episode %s before reopening this one", ErrConflict, open.ID)
+			} else if err != ErrNotFound {
+				return err
+			}
+			if upd.ClosedOn == nil {
+				next.ClosedOn = core.JSONTime{}
+			}
+		case next.Status == core.EpisodeClosed && next.ClosedOn.IsZero():
+			next.ClosedOn = core.NewJSONTime(episodeDay(time.Now()))
+		}
+		if err := validateEpisode(next); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `
+			UPDATE episodes
+			   SET title = :1,
+			       chief_complaint = :2,
+			       present_history = :3,
+			       observation = :4,
+			       palpation = :5,
+			       examination = :6,
+			       diagnosis = :7,
+			       plan = :8,
+			       status = :9,
+			       opened_on = :10,
+			       closed_on = :11,
+			       updated_time = :12,
+			       version = version + 1
+			 WHERE id = :13 AND owner_username = :14 AND version = :15
+		`, nullableText(next.Title), nullableText(next.ChiefComplaint), nullableText(next.PresentHistory),
+			nullableText(next.Observation), nullableText(next.Palpation), nullableText(next.Examination),
+			nullableText(next.Diagnosis), nullableText(next.Plan), next.Status, next.OpenedOn, next.ClosedOn,
+			time.Now(), id, owner, current.Version)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return ErrStale
+		}
+		if err := mirrorCurrentEpisode(ctx, tx, owner, patientID); err != nil {
+			return err
+		}
+		updated, err = getEpisode(ctx, tx, owner, patientID, id)
+		return err
+	})
+	if err != nil {
+		return core.Episode{}, err
+	}
+	return updated, nil
+}
+
+// mirrorCurrentEpisode copies the current episode's clinical fields to the patient,
+// recording the change as a normal revision. Nothing is written when they already match.
+func mirrorCurrentEpisode(ctx context.Context, q querier, owner, patientID string) error {
+	e, err := currentEpisode(ctx, q, owner, patientID)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	p, err := getPatientByID(ctx, q, owner, patientID)
+	if err != nil {
+		return err
+	}
+	var upd core.PatientUpdate
+	changed := false
+	for _, f := range []struct {
+		dst           **string
+		from, patient string
+	}{
+		{&upd.ChiefComplaint, e.ChiefComplaint, p.ChiefComplaint},
+		{&upd.PresentHistory, e.PresentHistory, p.PresentHistory},
+		{&upd.Observation, e.Observation, p.Observation},
+		{&upd.Palpation, e.Palpation, p.Palpation},
+		{&upd.Examination, e.Examination, p.Examination},
+		{&upd.Diagnosis, e.Diagnosis, p.Diagnosis},
+		{&upd.Rehab, e.Plan, p.Rehab},
+	} {
+		if f.from != f.patient {
+			value := f.from
+			*f.dst = &value
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	_, err = updatePatient(ctx, q, owner, patientID, &upd, core.VersionMatch{})
+	return err
+}
+
+// syncEpisodeFromPatient copies the clinical fields changed on a patient to their current
+// episode, starting a first episode for a patient without one.
+func syncEpisodeFromPatient(ctx context.Context, q querier, owner string, before, after core.Patient) error {
+	if episodeFieldsOf(before) == episodeFieldsOf(after) {
+		return nil
+	}
+	e, err := currentEpisode(ctx, q, owner, after.ID)
+	if err == ErrNotFound {
+		return insertEpisode(ctx, q, owner, firstEpisode(after))
+	}
+	if err != nil {
+		return err
+	}
+	next := e
+	next.ChiefComplaint, next.PresentHistory = after.ChiefComplaint, after.PresentHistory
+	next.Observation, next.Palpation, next.Examination = after.Observation, after.Palpation, after.Examination
+	next.Diagnosis, next.Plan = after.Diagnosis, after.Rehab
+	if episodeFields(next) == episodeFields(e) {
+		return nil
+	}
+	_, err = q.ExecContext(ctx, `
+		UPDATE episodes
+		   SET chief_complaint = :1,
+		       present_history = :2,
+		       observation = :3,
+		       palpation = :4,
+		       examination = :5,
+		       diagnosis = :6,
+		       plan = :7,
+		       updated_time = :8,
+		       version = version + 1
+		 WHERE id = :9 AND owner_username = :10
+	`, nullableText(next.ChiefComplaint), nullableText(next.PresentHistory), nullableText(next.Observation),
+		nullableText(next.Palpation), nullableText(next.Examination), nullableText(next.Diagnosis),
+		nullableText(next.Plan), time.Now(), e.ID, owner)
+	return err
+}
+
+// episodeFields are the clinical fields an episode shares with its patient, in order.
+func episodeFields(e core.Episode) [7]string {
+	return [7]string{e.ChiefComplaint, e.PresentHistory, e.Observation, e.Palpation, e.Examination, e.Diagnosis, e.Plan}
+}
+
+func episodeFieldsOf(p core.Patient) [7]string {
+	return [7]string{p.ChiefComplaint, p.PresentHistory, p.Observation, p.Palpation, p.Examination, p.Diagnosis, p.Rehab}
+}
+
+// firstEpisode is the episode a patient starts with: their clinical fields, opened the
+// day the patient was created.
+func firstEpisode(p core.Patient) core.Episode {
+	opened := p.CreatedTime.Time
+	if opened.IsZero() {
+		opened = time.Now()
+	}
+	return core.Episode{
+		ID:             uuid.NewString(),
+		PatientID:      p.ID,
+		ChiefComplaint: p.ChiefComplaint,
+		PresentHistory: p.PresentHistory,
+		Observation:    p.Observation,
+		Palpation:      p.Palpation,
+		Examination:    p.Examination,
+		Diagnosis:      p.Diagnosis,
+		Plan:           p.Rehab,
+		Status:         core.EpisodeOpen,
+		OpenedOn:       core.NewJSONTime(episodeDay(opened)),
+	}
+}
+
+// episodeDay is the calendar day of t, as stored in the DATE columns.
+func episodeDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func insertEpisode(ctx context.Context, q querier, owner string, e core.Episode) error {
+	now := time.Now()
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO episodes (id, patient_id, owner_username, title, chief_complaint, present_history, observation,
+		                      palpation, examination, diagnosis, plan, status, opened_on, closed_on, created_time,
+		                      updated_time, version)
+		VALUES (:1,:2,:3,:4,:5,:6,:7,:8,:9,:10,:11,:12,:13,:14,:15,:16,1)
+	`, e.ID, e.PatientID, owner, nullableText(e.Title), nullableText(e.ChiefComplaint), nullableText(e.PresentHistory),
+		nullableText(e.Observation), nullableText(e.Palpation), nullableText(e.Examination), nullableText(e.Diagnosis),
+		nullableText(e.Plan), e.Status, e.OpenedOn, e.ClosedOn, now, now)
+	return err
+}
+
+func normalizeEpisode(e *core.Episode) {
+	e.Title = strings.TrimSpace(e.Title)
+	e.Status = strings.ToUpper(strings.TrimSpace(e.Status))
+	if !e.OpenedOn.IsZero() {
+		e.OpenedOn = core.NewJSONTime(episodeDay(e.OpenedOn.Time))
+	}
+	if !e.ClosedOn.IsZero() {
+		e.ClosedOn = core.NewJSONTime(episodeDay(e.ClosedOn.Time))
+	}
+}
+
+func validateEpisode(e core.Episode) error {
+	switch {
+	case utf8.RuneCountInString(e.Title) > maxNameLength:
+		return fmt.Errorf("%w: title is longer than %d characters", ErrInvalid, maxNameLength)
+	case e.Status != core.EpisodeOpen && e.Status != core.EpisodeClosed:
+		return fmt.Errorf("%w: status must be %s or %s", ErrInvalid, core.EpisodeOpen, core.EpisodeClosed)
+	case e.OpenedOn.IsZero():
+		return fmt.Errorf("%w: opened_on is required", ErrInvalid)
+	case e.Status == core.EpisodeOpen && !e.ClosedOn.IsZero():
+		return fmt.Errorf("%w: an open episode has no closed_on", ErrInvalid)
+	case !e.ClosedOn.IsZero() && e.ClosedOn.Before(e.OpenedOn.Time):
+		return fmt.Errorf("%w: closed_on is before opened_on", ErrInvalid)
+	}
+	for _, f := range []struct{ name, value string }{
+		{"chief_complaint", e.ChiefComplaint}, {"present_history", e.PresentHistory},
+		{"observation", e.Observation}, {"palpation", e.Palpation}, {"examination", e.Examination},
+		{"diagnosis", e.Diagnosis}, {"plan", e.Plan},
+	} {
+		if len(f.value) > maxTextBytes {
+			return fmt.Errorf("%w: %s is longer than %d bytes", ErrInvalid, f.name, maxTextBytes)
+		}
+	}
+	return nil
+}
+
+// episodeOrder puts the current episode first: the open one, then the latest opened.
+const episodeOrder = ` ORDER BY CASE WHEN status = 'OPEN' THEN 0 ELSE 1 END, opened_on DESC, created_time DESC, id`
+
+func listEpisodes(ctx context.Context, q querier, owner, patientID string) ([]core.Episode, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+episodeColumns+` FROM episodes WHERE patient_id=:1 AND owner_username=:2
+	`+episodeOrder, patientID, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []core.Episode{}
+	for rows.Next() {
+		e, err := scanEpisode(rows)
+		if err != nil {
+			return nil, err
+		}
+		e.Current = len(items) == 0
+		items = append(items, e)
+	}
+	return items, rows.Err()
+}
+
+// currentEpisode returns the episode the patient's clinical fields mirror.
+func currentEpisode(ctx context.Context, q querier, owner, patientID string) (core.Episode, error) {
+	items, err := listEpisodes(ctx, q, owner, patientID)
+	if err != nil {
+		return core.Episode{}, err
+	}
+	if len(items) == 0 {
+		return core.Episode{}, ErrNotFound
+	}
+	return items[0], nil
+}
+
+func openEpisode(ctx context.Context, q querier, owner, patientID string) (core.Episode, error) {
+	e, err := currentEpisode(ctx, q, owner, patientID)
+	if err == nil && e.Status != core.EpisodeOpen {
+		return core.Episode{}, ErrNotFound
+	}
+	return e, err
+}
+
+// episodeOn returns the episode a patient was in on day t: the latest opened by then, or
+// their first one for earlier days. It returns "" for a patient without episodes.
+func episodeOn(ctx context.Context, q querier, owner, patientID string, t time.Time) (string, error) {
+	items, err := listEpisodes(ctx, q, owner, patientID)
+	if err != nil || len(items) == 0 {
+		return "", err
+	}
+	if t.IsZero() {
+		return items[0].ID, nil
+	}
+	day := episodeDay(t)
+	best := core.Episode{}
+	for _, e := range items {
+		if !e.OpenedOn.After(day) && (best.ID == "" || e.OpenedOn.After(best.OpenedOn.Time)) {
+			best = e
+		}
+	}
+	if best.ID == "" {
+		best = items[len(items)-1]
+		for _, e := range items {
+			if e.OpenedOn.Before(best.OpenedOn.Time) {
+				best = e
+			}
+		}
+	}
+	return best.ID, nil
+}
+
+// linkPaymentEpisode files a payment under episodeID, which must be one of the patient's.
+// Without one the payment keeps an episode of its patient, or gets the episode of its date.
+func linkPaymentEpisode(ctx context.Context, q querier, owner, paymentID, patientID, episodeID string, paid time.Time) (string, error) {
+	if episodeID != "" {
+		if _, err := getEpisode(ctx, q, owner, patientID, episodeID); err == ErrNotFound {
+			return "", fmt.Errorf("%w: episode %s is not one of the patient's", ErrInvalid, episodeID)
+		} else if err != nil {
+			return "", err
+		}
+	} else {
+		var linked sql.NullString
+		err := q.QueryRowContext(ctx, `
+			SELECT e.id FROM payments p JOIN episodes e ON e.id = p.episode_id AND e.patient_id = p.patient_id
+			 WHERE p.id = :1 AND p.owner_username = :2
+		`, paymentID, owner).Scan(&linked)
+		if err != nil && err != sql.ErrNoRows {
+			return "", err
+		}
+		if linked.Valid {
+			return linked.String, nil
+		}
+		if episodeID, err = episodeOn(ctx, q, owner, patientID, paid); err != nil {
+			return "", err
+		}
+	}
+	_, err := q.ExecContext(ctx, `
+		UPDATE payments SET episode_id = :1 WHERE id = :2 AND owner_username = :3
+	`, nullableText(episodeID), paymentID, owner)
+	return episodeID, err
+}
+
+// episodeColumns is the select list read by scanEpisode.
+const episodeColumns = `id, patient_id, title, chief_complaint, present_history, observation, palpation, examination,
+		       diagnosis, plan, status, opened_on, closed_on, created_time, updated_time, version`
+
+func scanEpisode(row rowScanner) (core.Episode, error) {
+	var e core.Episode
+	var title, complaint, history, observation, palpation, examination, diagnosis, plan sql.NullString
+	err := row.Scan(&e.ID, &e.PatientID, &title, &complaint, &history, &observation, &palpation, &examination,
+		&diagnosis, &plan, &e.Status, &e.OpenedOn, &e.ClosedOn, &e.CreatedTime, &e.UpdatedTime, &e.Version)
+	e.Title = nullStringToString(title)
+	e.ChiefComplaint = nullStringToString(complaint)
+	e.PresentHistory = nullStringToString(history)
+	e.Observation = nullStringToString(observation)
+	e.Palpation = nullStringToString(palpation)
+	e.Examination = nullStringToString(examination)
+	e.Diagnosis = nullStringToString(diagnosis)
+	e.Plan = nullStringToString(plan)
+	return e, err
+}
+
+// getEpisode reads one of a patient's episodes, flagging whether it is the current one.
+func getEpisode(ctx context.Context, q querier, owner, patientID, id string) (core.Episode, error) {
+	items, err := listEpisodes(ctx, q, owner, patientID)
+	if err != nil {
+		return core.Episode{}, err
+	}
+	for _, e := range items {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return core.Episode{}, ErrNotFound
+}
+
+// backfillEpisodes turns each patient's clinical fields into their first, open episode
+// and files their payments under it. It lists its own columns as they were in migration
+// 16, so later changes to the tables cannot break it.
+func backfillEpisodes(ctx context.Context, tx *Tx) error {
+	type legacy struct {
+		owner   string
+		patient core.Patient
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, owner_username, chief_complaint, present_history, observation, palpation, examination,
+		       diagnosis, rehab, created_time
+		FROM patients
+		WHERE owner_username IS NOT NULL
+	`)
+	if err != nil {
+		return err
+	}
+	var found []legacy
+	for rows.Next() {
+		var p legacy
+		var complaint, history, observation, palpation, examination, diagnosis, rehab sql.NullString
+		if err := rows.Scan(&p.patient.ID, &p.owner, &complaint, &history, &observation, &palpation, &examination,
+			&diagnosis, &rehab, &p.patient.CreatedTime); err != nil {
+			rows.Close()
+			return err
+		}
+		p.patient.ChiefComplaint = nullStringToString(complaint)
+		p.patient.PresentHistory = nullStringToString(history)
+		p.patient.Observation = nullStringToString(observation)
+		p.patient.Palpation = nullStringToString(palpation)
+		p.patient.Examination = nullStringToString(examination)
+		p.patient.Diagnosis = nullStringToString(diagnosis)
+		p.patient.Rehab = nullStringToString(rehab)
+		found = append(found, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range found {
+		e := firstEpisode(p.patient)
+		if err := insertEpisode(ctx, tx, p.owner, e); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE payments SET episode_id = :1 WHERE patient_id = :2 AND owner_username = :3
+		`, e.ID, p.patient.ID, p.owner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,4 +3,9 @@ package repo
 import "errors"
 
 var ErrNotFound = errors.New("not found")
-var ErrForbidden = errors.New("forbidden")
\ No newline at end of file
+var ErrForbidden = errors.New("forbidden")
+var ErrConflict = errors.New("conflict")
+var ErrInvalid = errors.New("invalid input")
+
+// ErrStale means the caller's If-Match version no longer matches the stored row.
+var ErrStale = errors.New("record was modified by someone else")
@@ -0,0 +1,561 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"phsio_track_backend/internal/core"
+)
+
+const (
+	maxLibraryTags      = 20
+	maxTagLength        = 50
+	maxAttachments      = 10
+	defaultLibraryLimit = 20
+	maxLibraryLimit     = 200
+)
+
+// ExerciseLibraryRepo stores each owner's catalog of exercises. Names are unique per
+// owner, ignoring case and spacing.
+type ExerciseLibraryRepo struct {
+	db *DB
+}
+
+func NewExerciseLibraryRepo(db *DB) *ExerciseLibraryRepo {
+	return &ExerciseLibraryRepo{db: db}
+}
+
+// Search returns the owner's library entries matching q, best match first (by name
+// without a query). A catalog holds hundreds of entries at most, so the words are
+// matched here rather than through an index.
+func (r *ExerciseLibraryRepo) Search(ctx context.Context, owner string, q core.LibraryQuery) ([]core.LibraryExercise, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultLibraryLimit
+	}
+	if limit > maxLibraryLimit {
+		limit = maxLibraryLimit
+	}
+	query := `SELECT ` + libraryColumns + ` FROM library_exercises WHERE owner_username=:1`
+	args := []interface{}{owner}
+	bind := func(v interface{}) string {
+		args = append(args, v)
+		return ":" + strconv.Itoa(len(args))
+	}
+	if region := strings.TrimSpace(q.BodyRegion); region != "" {
+		query += ` AND LOWER(body_region) = ` + bind(strings.ToLower(region))
+	}
+	if tag := normalizeTag(q.Tag); tag != "" {
+		query += ` AND tags LIKE ` + bind("%,"+tag+",%")
+	}
+	rows, err := r.db.QueryContext(ctx, query+` ORDER BY name_key`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type hit struct {
+		entry core.LibraryExercise
+		score int
+	}
+	words := uniqueStrings(searchWords(q.Query))
+	var hits []hit
+	for rows.Next() {
+		e, err := scanLibraryExercise(rows)
+		if err != nil {
+			return nil, err
+		}
+		if score, ok := libraryScore(e, words); ok {
+			hits = append(hits, hit{e, score})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].score > hits[j].score })
+
+	items := []core.LibraryExercise{}
+	for _, h := range hits {
+		if len(items) == limit {
+			break
+		}
+		items = append(items, h.entry)
+	}
+	return items, nil
+}
+
+// libraryScore ranks an entry for the query words: each must start a word of the name
+// (3, or 4 for the whole word), a tag or the body region (2) or the description (1).
+func libraryScore(e core.LibraryExercise, words []string) (int, bool) {
+	fields := []struct {
+		words  []string
+		weight int
+	}{
+		{searchWords(e.Name), 3},
+		{searchWords(strings.Join(e.Tags, " ") + " " + e.BodyRegion), 2},
+		{searchWords(e.Description), 1},
+	}
+	score := 0
+	for _, w := range words {
+		best := 0
+		for _, f := range fields {
+			for _, fw := range f.words {
+				s := 0
+				switch {
+				case fw == w:
+					s = f.weight + 1
+				case strings.HasPrefix(fw, w):
+					s = f.weight
+				}
+				if s > best {
+					best = s
+				}
+			}
+		}
+		if best == 0 {
+			return 0, false
+		}
+		score += best
+	}
+	return score, true
+}
+
+func (r *ExerciseLibraryRepo) Create(ctx context.Context, owner string, e *core.LibraryExercise) error {
+	normalizeLibraryExercise(e)
+	if err := validateLibraryExercise(*e); err != nil {
+		return err
+	}
+	e.ID = uuid.NewString()
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		if err := assertLibraryNameFree(ctx, tx, owner, e.Name, ""); err != nil {
+			return err
+		}
+		if err := insertLibraryExercise(ctx, tx, owner, *e); err != nil {
+			return err
+		}
+		var err error
+		*e, err = getLibraryExercise(ctx, tx, owner, e.ID)
+		return err
+	})
+}
+
+func (r *ExerciseLibraryRepo) GetByID(ctx context.Context, owner, id string) (core.LibraryExercise, error) {
+	return getLibraryExercise(ctx, r.db, owner, id)
+}
+
+// Update patches a library entry. Prescriptions already made from it keep their dosage.
+// A conditional ifMatch must match.
+func (r *ExerciseLibraryRepo) Update(ctx context.Context, owner, id string, upd *core.LibraryExerciseUpdate, ifMatch core.VersionMatch) (core.LibraryExercise, error) {
+	var updated core.LibraryExercise
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		current, err := getLibraryExercise(ctx, tx, owner, id)
+		if err != nil {
+			return err
+		}
+		if !ifMatch.Matches(current.Version) {
+			return ErrStale
+		}
+		next := current
+		if upd.Name != nil {
+			next.Name = *upd.Name
+		}
+		if upd.BodyRegion != nil {
+			next.BodyRegion = *upd.BodyRegion
+		}
+		if upd.Description != nil {
+			next.Description = *upd.Description
+		}
+		if upd.Sets != nil {
+			next.Sets = *upd.Sets
+		}
+		if upd.Reps != nil {
+			next.Reps = *upd.Reps
+		}
+		if upd.HoldSeconds != nil {
+			next.HoldSeconds = *upd.HoldSeconds
+		}
+		if upd.Frequency != nil {
+			next.Frequency = *upd.Frequency
+		}
+		if upd.Tags != nil {
+			next.Tags = *upd.Tags
+		}
+		if upd.Attachments != nil {
+			next.Attachments = *upd.Attachments
+		}
+		normalizeLibraryExercise(&next)
+		if err := validateLibraryExercise(next); err != nil {
+			return err
+		}
+		if libraryNameKey(next.Name) != libraryNameKey(current.Name) {
+			if err := assertLibraryNameFree(ctx, tx, owner, next.Name, id); err != nil {
+				return err
+			}
+		}
+		res, err := tx.ExecContext(ctx, `
+			UPDATE library_exercises
+			   SET name = :1,
+			       name_key = :2,
+			       body_region = :3,
+			       description = :4,
+			       sets = :5,
+			       reps = :6,
+			       hold_seconds = :7,
+			       frequency = :8,
+			       tags = :9,
+			       attachments = :10,
+			       updated_time = :11,
+			       version = version + 1
+			 WHERE id = :12 AND owner_username = :13 AND version = :14
+		`, next.Name, libraryNameKey(next.Name), nullableText(next.BodyRegion), nullableText(next.Description),
+			nullableCount(next.Sets), nullableCount(next.Reps), nullableCount(next.HoldSeconds), nullableText(next.Frequency),
+			nullableText(joinTags(next.Tags)), nullableText(strings.Join(next.Attachments, "\n")), time.Now(),
+			id, owner, current.Version)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return ErrStale
+		}
+		updated, err = getLibraryExercise(ctx, tx, owner, id)
+		return err
+	})
+	if err != nil {
+		return core.LibraryExercise{}, err
+	}
+	return updated, nil
+}
+
+// Delete removes a library entry; prescriptions made from it keep their copy of it.
+func (r *ExerciseLibraryRepo) Delete(ctx context.Context, owner, id string) error {
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM library_exercises WHERE id=:1 AND owner_username=:2`, id, owner)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return ErrNotFound
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE exercise_prescriptions SET library_id = NULL WHERE library_id = :1 AND owner_username = :2
+		`, id, owner)
+		return err
+	})
+}
+
+// SeedFromRehab adds the exercises of every legacy rehab table that are not in their
+// owner's library yet, and links matching prescriptions to them. It returns how many
+// entries it added.
+func (r *ExerciseLibraryRepo) SeedFromRehab(ctx context.Context) (int, error) {
+	var n int
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		var err error
+		n, err = seedExerciseLibrary(ctx, tx)
+		return err
+	})
+	return n, err
+}
+
+// applyLibraryDefaults fills what a prescription picked from the library leaves out
+// with the entry's name and dosage.
+func applyLibraryDefaults(ctx context.Context, q querier, owner string, e *core.ExercisePrescription) error {
+	if e.LibraryID == "" {
+		return nil
+	}
+	l, err := getLibraryExercise(ctx, q, owner, e.LibraryID)
+	if err == ErrNotFound {
+		return fmt.Errorf("%w: unknown library exercise %q", ErrInvalid, e.LibraryID)
+	}
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(e.Exercise) == "" {
+		e.Exercise = l.Name
+	}
+	if e.Sets == 0 {
+		e.Sets = l.Sets
+	}
+	if e.Reps == 0 {
+		e.Reps = l.Reps
+	}
+	if e.HoldSeconds == 0 {
+		e.HoldSeconds = l.HoldSeconds
+	}
+	if strings.TrimSpace(e.Frequency) == "" {
+		e.Frequency = l.Frequency
+	}
+	return nil
+}
+
+// libraryNameKey is the form names are compared in: lower case, single spaces.
+func libraryNameKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(tag, ",", " ")), " "))
+}
+
+// joinTags stores tags as ",a,b," so a single tag can be found with LIKE '%,tag,%'.
+func joinTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return "," + strings.Join(tags, ",") + ","
+}
+
+func splitTags(s string) []string {
+	tags := []string{}
+	for _, t := range strings.Split(strings.Trim(s, ","), ",") {
+		if t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+func normalizeLibraryExercise(e *core.LibraryExercise) {
+	e.Name = strings.Join(strings.Fields(e.Name), " ")
+	e.BodyRegion = strings.TrimSpace(e.BodyRegion)
+	e.Description = strings.TrimSpace(e.Description)
+	e.Frequency = strings.TrimSpace(e.Frequency)
+	var tags []string
+	for _, t := range e.Tags {
+		if t = normalizeTag(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	e.Tags = uniqueStrings(tags)
+	var attachments []string
+	for _, a := range e.Attachments {
+		if a = strings.TrimSpace(a); a != "" {
+			attachments = append(attachments, a)
+		}
+	}
+	e.Attachments = uniqueStrings(attachments)
+}
+
+func validateLibraryExercise(e core.LibraryExercise) error {
+	switch {
+	case e.Name == "" || utf8.RuneCountInString(e.Name) > maxNameLength:
+		return fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalid, maxNameLength)
+	case utf8.RuneCountInString(e.BodyRegion) > maxNameLength:
+		return fmt.Errorf("%w: body_region is longer than %d characters", ErrInvalid, maxNameLength)
+	case len(e.Description) > maxTextBytes:
+		return fmt.Errorf("%w: description is longer than %d bytes", ErrInvalid, maxTextBytes)
+	case e.Sets < 0 || e.Sets > maxExerciseCount || e.Reps < 0 || e.Reps > maxExerciseCount:
+		return fmt.Errorf("%w: sets and reps must be 0 to %d", ErrInvalid, maxExerciseCount)
+	case e.HoldSeconds < 0 || e.HoldSeconds > maxHoldSeconds:
+		return fmt.Errorf("%w: hold_seconds must be 0 to %d", ErrInvalid, maxHoldSeconds)
+	case utf8.RuneCountInString(e.Frequency) > maxNameLength:
+		return fmt.Errorf("%w: frequency is longer than %d characters", ErrInvalid, maxNameLength)
+	case len(e.Tags) > maxLibraryTags:
+		return fmt.Errorf("%w: at most %d tags", ErrInvalid, maxLibraryTags)
+	case len(e.Attachments) > maxAttachments:
+		return fmt.Errorf("%w: at most %d attachments", ErrInvalid, maxAttachments)
+	case len(joinTags(e.Tags)) > maxTextBytes || len(strings.Join(e.Attachments, "\n")) > maxTextBytes:
+		return fmt.Errorf("%w: tags and attachments are limited to %d bytes each", ErrInvalid, maxTextBytes)
+	}
+	for _, t := range e.Tags {
+		if utf8.RuneCountInString(t) > maxTagLength {
+			return fmt.Errorf("%w: tag %q is longer than %d characters", ErrInvalid, t, maxTagLength)
+		}
+	}
+	for _, a := range e.Attachments {
+		if strings.ContainsAny(a, "\r\n") {
+			return fmt.Errorf("%w: attachment references must be single lines", ErrInvalid)
+		}
+	}
+	return nil
+}
+
+func assertLibraryNameFree(ctx context.Context, q querier, owner, name, exceptID string) error {
+	var id string
+	err := q.QueryRowContext(ctx, `
+		SELECT id FROM library_exercises WHERE owner_username=:1 AND name_key=:2
+	`, owner, libraryNameKey(name)).Scan(&id)
+	switch {
+	case err == sql.ErrNoRows || id == exceptID:
+		return nil
+	case err != nil:
+		return err
+	}
+	return fmt.Errorf("%w: the library already has %q (%s)", ErrConflict, name, id)
+}
+
+func insertLibraryExercise(ctx context.Context, q querier, owner string, e core.LibraryExercise) error {
+	now := time.Now()
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO library_exercises (id, owner_username, name, name_key, body_region, description, sets, reps,
+		                               hold_seconds, frequency, tags, attachments, created_time, updated_time, version)
+		VALUES (:1,:2,:3,:4,:5,:6,:7,:8,:9,:10,:11,:12,:13,:14,1)
+	`, e.ID, owner, e.Name, libraryNameKey(e.Name), nullableText(e.BodyRegion), nullableText(e.Description),
+		nullableCount(e.Sets), nullableCount(e.Reps), nullableCount(e.HoldSeconds), nullableText(e.Frequency),
+		nullableText(joinTags(e.Tags)), nullableText(strings.Join(e.Attachments, "\n")), now, now)
+	return err
+}
+
+// libraryColumns is the select list read by scanLibraryExercise.
+const libraryColumns = `id, name, body_region, description, sets, reps, hold_seconds, frequency, tags, attachments,
+		       created_time, updated_time, version`
+
+func scanLibraryExercise(row rowScanner) (core.LibraryExercise, error) {
+	var e core.LibraryExercise
+	var region, description, frequency, tags, attachments sql.NullString
+	var sets, reps, hold sql.NullInt64
+	err := row.Scan(&e.ID, &e.Name, &region, &description, &sets, &reps, &hold, &frequency, &tags, &attachments,
+		&e.CreatedTime, &e.UpdatedTime, &e.Version)
+	e.BodyRegion = nullStringToString(region)
+	e.Description = nullStringToString(description)
+	e.Sets = nullIntToInt(sets)
+	e.Reps = nullIntToInt(reps)
+	e.HoldSeconds = nullIntToInt(hold)
+	e.Frequency = nullStringToString(frequency)
+	e.Tags = splitTags(tags.String)
+	e.Attachments = []string{}
+	if attachments.Valid {
+		e.Attachments = strings.Split(attachments.String, "\n")
+	}
+	return e, err
+}
+
+func getLibraryExercise(ctx context.Context, q querier, owner, id string) (core.LibraryExercise, error) {
+	e, err := scanLibraryExercise(q.QueryRowContext(ctx, `
+		SELECT `+libraryColumns+` FROM library_exercises WHERE id=:1 AND owner_username=:2
+	`, id, owner))
+	if err == sql.ErrNoRows {
+		return e, ErrNotFound
+	}
+	return e, err
+}
+
+// seedExerciseLibrary adds each owner's distinct legacy rehab exercises to their
+// library, taking the first dosage seen for each, then links unlinked prescriptions
+// with the same name. It runs as migration 12's backfill, so it lists its columns as
+// they were then.
+func seedExerciseLibrary(ctx context.Context, q querier) (int, error) {
+	type key struct{ owner, name string }
+
+	existing := map[key]string{}
+	rows, err := q.QueryContext(ctx, `SELECT id, owner_username, name_key FROM library_exercises`)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var id string
+		var k key
+		if err := rows.Scan(&id, &k.owner, &k.name); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		existing[k] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	rows, err = q.QueryContext(ctx, `
+		SELECT owner_username, rehab FROM patients
+		WHERE rehab IS NOT NULL AND owner_username IS NOT NULL
+		ORDER BY created_time, id
+	`)
+	if err != nil {
+		return 0, err
+	}
+	found := map[key]*core.LibraryExercise{}
+	var order []key
+	for rows.Next() {
+		var owner, rehab string
+		if err := rows.Scan(&owner, &rehab); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		for _, p := range core.ParseLegacyRehab(rehab) {
+			k := key{owner, libraryNameKey(p.Exercise)}
+			if _, ok := existing[k]; ok {
+				continue
+			}
+			e := found[k]
+			if e == nil {
+				e = &core.LibraryExercise{Name: p.Exercise}
+				found[k] = e
+				order = append(order, k)
+			}
+			if e.Sets == 0 && e.Reps == 0 {
+				e.Sets, e.Reps = p.Sets, p.Reps
+			}
+			if e.HoldSeconds == 0 {
+				e.HoldSeconds = p.HoldSeconds
+			}
+			if e.Frequency == "" {
+				e.Frequency = p.Frequency
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	added := 0
+	now := time.Now()
+	for _, k := range order {
+		e := found[k]
+		normalizeLibraryExercise(e)
+		if validateLibraryExercise(*e) != nil {
+			continue
+		}
+		id := uuid.NewString()
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO library_exercises (id, owner_username, name, name_key, sets, reps, hold_seconds, frequency,
+			                               created_time, updated_time, version)
+			VALUES (:1,:2,:3,:4,:5,:6,:7,:8,:9,:10,1)
+		`, id, k.owner, e.Name, k.name, nullableCount(e.Sets), nullableCount(e.Reps), nullableCount(e.HoldSeconds),
+			nullableText(e.Frequency), now, now)
+		if err != nil {
+			return 0, err
+		}
+		existing[k] = id
+		added++
+	}
+
+	type link struct{ id, libraryID string }
+	var links []link
+	rows, err = q.QueryContext(ctx, `
+		SELECT id, owner_username, exercise FROM exercise_prescriptions WHERE library_id IS NULL
+	`)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var id string
+		var k key
+		if err := rows.Scan(&id, &k.owner, &k.name); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		k.name = libraryNameKey(k.name)
+		if libraryID, ok := existing[k]; ok {
+			links = append(links, link{id, libraryID})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, l := range links {
+		if _, err := q.ExecContext(ctx, `UPDATE exercise_prescriptions SET library_id = :1 WHERE id = :2`, l.libraryID, l.id); err != nil {
+			return 0, err
+		}
+	}
+	return added, nil
+}
@@ -0,0 +1,359 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"phsio_track_backend/internal/core"
+)
+
+const (
+	maxExercisesPerPatient = 100
+	maxExerciseCount       = 1000
+	maxHoldSeconds         = 3600
+)
+
+// ExerciseRepo stores patients' exercise prescriptions, scoped to the owner of the patient.
+type ExerciseRepo struct {
+	db *DB
+}
+
+func NewExerciseRepo(db *DB) *ExerciseRepo {
+	return &ExerciseRepo{db: db}
+}
+
+// List returns a patient's prescriptions in programme order. A non-zero activeOn keeps
+// only those running on that day.
+func (r *ExerciseRepo) List(ctx context.Context, owner, patientID string, activeOn time.Time) ([]core.ExercisePrescription, error) {
+	if err := assertPatientOwner(ctx, r.db, owner, patientID); err != nil {
+		return nil, err
+	}
+	return listExercises(ctx, r.db, owner, patientID, activeOn)
+}
+
+// Create adds a prescription at the end of the patient's programme unless it names a
+// position. One picked from the library gets the entry's name and dosage where left out.
+func (r *ExerciseRepo) Create(ctx context.Context, owner string, e *core.ExercisePrescription) error {
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		if err := assertPatientOwner(ctx, tx, owner, e.PatientID); err != nil {
+			return err
+		}
+		if err := applyLibraryDefaults(ctx, tx, owner, e); err != nil {
+			return err
+		}
+		normalizeExercise(e)
+		if err := validateExercise(*e); err != nil {
+			return err
+		}
+		var count, last int
+		err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*), COALESCE(MAX(position), 0) FROM exercise_prescriptions WHERE patient_id=:1 AND owner_username=:2
+		`, e.PatientID, owner).Scan(&count, &last)
+		if err != nil {
+			return err
+		}
+		if count >= maxExercisesPerPatient {
+			return fmt.Errorf("%w: a patient has at most %d exercises", ErrInvalid, maxExercisesPerPatient)
+		}
+		if e.Position == 0 {
+			e.Position = last + 1
+		}
+		e.ID = uuid.NewString()
+		if err := insertExercise(ctx, tx, owner, *e); err != nil {
+			return err
+		}
+		*e, err = getExerciseByID(ctx, tx, owner, e.PatientID, e.ID)
+		return err
+	})
+}
+
+// Replace swaps the patient's whole programme for items, numbered in the order given.
+func (r *ExerciseRepo) Replace(ctx context.Context, owner, patientID string, items []core.ExercisePrescription) ([]core.ExercisePrescription, error) {
+	if len(items) > maxExercisesPerPatient {
+		return nil, fmt.Errorf("%w: a patient has at most %d exercises", ErrInvalid, maxExercisesPerPatient)
+	}
+	var out []core.ExercisePrescription
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		if err := assertPatientOwner(ctx, tx, owner, patientID); err != nil {
+			return err
+		}
+		for i := range items {
+			if err := applyLibraryDefaults(ctx, tx, owner, &items[i]); err != nil {
+				return fmt.Errorf("%w (exercise %d)", err, i+1)
+			}
+			normalizeExercise(&items[i])
+			if err := validateExercise(items[i]); err != nil {
+				return fmt.Errorf("%w (exercise %d)", err, i+1)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM exercise_prescriptions WHERE patient_id=:1 AND owner_username=:2
+		`, patientID, owner); err != nil {
+			return err
+		}
+		for i, e := range items {
+			e.ID = uuid.NewString()
+			e.PatientID = patientID
+			e.Position = i + 1
+			if err := insertExercise(ctx, tx, owner, e); err != nil {
+				return err
+			}
+		}
+		var err error
+		out, err = listExercises(ctx, tx, owner, patientID, time.Time{})
+		return err
+	})
+	return out, err
+}
+
+func (r *ExerciseRepo) GetByID(ctx context.Context, owner, patientID, id string) (core.ExercisePrescription, error) {
+	return getExerciseByID(ctx, r.db, owner, patientID, id)
+}
+
+// Update patches a prescription. A conditional ifMatch must match.
+func (r *ExerciseRepo) Update(ctx context.Context, owner, patientID, id string, upd *core.ExercisePrescriptionUpdate, ifMatch core.VersionMatch) (core.ExercisePrescription, error) {
+	var updated core.ExercisePrescription
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		current, err := getExerciseByID(ctx, tx, owner, patientID, id)
+		if err != nil {
+			return err
+		}
+		if !ifMatch.Matches(current.Version) {
+			return ErrStale
+		}
+		next := current
+		if upd.Position != nil {
+			next.Position = *upd.Position
+		}
+		if upd.Exercise != nil {
+			next.Exercise = *upd.Exercise
+		}
+		if upd.Sets != nil {
+			next.Sets = *upd.Sets
+		}
+		if upd.Reps != nil {
+			next.Reps = *upd.Reps
+		}
+		if upd.HoldSeconds != nil {
+			next.HoldSeconds = *upd.HoldSeconds
+		}
+		if upd.Frequency != nil {
+			next.Frequency = *upd.Frequency
+		}
+		if upd.Progression != nil {
+			next.Progression = *upd.Progression
+		}
+		if upd.StartDate != nil {
+			next.StartDate = *upd.StartDate
+		}
+		if upd.StopDate != nil {
+			next.StopDate = *upd.StopDate
+		}
+		normalizeExercise(&next)
+		if next == current {
+			updated = current
+			return nil
+		}
+		if err := validateExercise(next); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `
+			UPDATE exercise_prescriptions
+			   SET position = :1,
+			       exercise = :2,
+			       sets = :3,
+			       reps = :4,
+			       hold_seconds = :5,
+			       frequency = :6,
+			       progression = :7,
+			       start_date = :8,
+			       stop_date = :9,
+			       updated_time = :10,
+			       version = version + 1
+			 WHERE id = :11 AND owner_username = :12 AND version = :13
+		`, next.Position, next.Exercise, nullableCount(next.Sets), nullableCount(next.Reps), nullableCount(next.HoldSeconds),
+			nullableText(next.Frequency), nullableText(next.Progression), next.StartDate, next.StopDate, time.Now(),
+			id, owner, current.Version)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return ErrStale
+		}
+		updated, err = getExerciseByID(ctx, tx, owner, patientID, id)
+		return err
+	})
+	if err != nil {
+		return core.ExercisePrescription{}, err
+	}
+	return updated, nil
+}
+
+func (r *ExerciseRepo) Delete(ctx context.Context, owner, patientID, id string) error {
+	res, err := r.db.ExecContext(ctx, `
+		DELETE FROM exercise_prescriptions WHERE id=:1 AND patient_id=:2 AND owner_username=:3
+	`, id, patientID, owner)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func normalizeExercise(e *core.ExercisePrescription) {
+	e.Exercise = strings.TrimSpace(e.Exercise)
+	e.Frequency = strings.TrimSpace(e.Frequency)
+	e.Progression = strings.TrimSpace(e.Progression)
+}
+
+func validateExercise(e core.ExercisePrescription) error {
+	switch {
+	case e.Exercise == "" || utf8.RuneCountInString(e.Exercise) > maxNameLength:
+		return fmt.Errorf("%w: exercise must be 1 to %d characters", ErrInvalid, maxNameLength)
+	case e.Position < 0:
+		return fmt.Errorf("%w: position cannot be negative", ErrInvalid)
+	case e.Sets < 0 || e.Sets > maxExerciseCount || e.Reps < 0 || e.Reps > maxExerciseCount:
+		return fmt.Errorf("%w: sets and reps must be 0 to %d", ErrInvalid, maxExerciseCount)
+	case e.HoldSeconds < 0 || e.HoldSeconds > maxHoldSeconds:
+		return fmt.Errorf("%w: hold_seconds must be 0 to %d", ErrInvalid, maxHoldSeconds)
+	case utf8.RuneCountInString(e.Frequency) > maxNameLength:
+		return fmt.Errorf("%w: frequency is longer than %d characters", ErrInvalid, maxNameLength)
+	case len(e.Progression) > maxTextBytes:
+		return fmt.Errorf("%w: progression is longer than %d bytes", ErrInvalid, maxTextBytes)
+	case !e.StartDate.IsZero() && !e.StopDate.IsZero() && e.StopDate.Before(e.StartDate.Time):
+		return fmt.Errorf("%w: stop_date is before start_date", ErrInvalid)
+	}
+	return nil
+}
+
+// nullableCount stores an unprescribed (zero) count as NULL.
+func nullableCount(n int) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+func insertExercise(ctx context.Context, q querier, owner string, e core.ExercisePrescription) error {
+	now := time.Now()
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO exercise_prescriptions (id, patient_id, owner_username, library_id, position, exercise, sets, reps,
+		                                    hold_seconds, frequency, progression, start_date, stop_date, created_time,
+		                                    updated_time, version)
+		VALUES (:1,:2,:3,:4,:5,:6,:7,:8,:9,:10,:11,:12,:13,:14,:15,1)
+	`, e.ID, e.PatientID, owner, nullableText(e.LibraryID), e.Position, e.Exercise, nullableCount(e.Sets),
+		nullableCount(e.Reps), nullableCount(e.HoldSeconds), nullableText(e.Frequency), nullableText(e.Progression),
+		e.StartDate, e.StopDate, now, now)
+	return err
+}
+
+// exerciseColumns is the select list read by scanExercise.
+const exerciseColumns = `id, patient_id, library_id, position, exercise, sets, reps, hold_seconds, frequency, progression,
+		       start_date, stop_date, created_time, updated_time, version`
+
+func scanExercise(row rowScanner) (core.ExercisePrescription, error) {
+	var e core.ExercisePrescription
+	var sets, reps, hold sql.NullInt64
+	var libraryID, frequency, progression sql.NullString
+	err := row.Scan(&e.ID, &e.PatientID, &libraryID, &e.Position, &e.Exercise, &sets, &reps, &hold, &frequency, &progression,
+		&e.StartDate, &e.StopDate, &e.CreatedTime, &e.UpdatedTime, &e.Version)
+	e.LibraryID = nullStringToString(libraryID)
+	e.Sets = nullIntToInt(sets)
+	e.Reps = nullIntToInt(reps)
+	e.HoldSeconds = nullIntToInt(hold)
+	e.Frequency = nullStringToString(frequency)
+	e.Progression = nullStringToString(progression)
+	return e, err
+}
+
+func getExerciseByID(ctx context.Context, q querier, owner, patientID, id string) (core.ExercisePrescription, error) {
+	e, err := scanExercise(q.QueryRowContext(ctx, `
+		SELECT `+exerciseColumns+` FROM exercise_prescriptions WHERE id=:1 AND patient_id=:2 AND owner_username=:3
+	`, id, patientID, owner))
+	if err == sql.ErrNoRows {
+		return e, ErrNotFound
+	}
+	return e, err
+}
+
+func listExercises(ctx context.Context, q querier, owner, patientID string, activeOn time.Time) ([]core.ExercisePrescription, error) {
+	query := `SELECT ` + exerciseColumns + ` FROM exercise_prescriptions WHERE patient_id=:1 AND owner_username=:2`
+	args := []interface{}{patientID, owner}
+	if !activeOn.IsZero() {
+		query += ` AND (start_date IS NULL OR start_date <= :3) AND (stop_date IS NULL OR stop_date >= :4)`
+		args = append(args, activeOn, activeOn)
+	}
+	rows, err := q.QueryContext(ctx, query+` ORDER BY position, created_time, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []core.ExercisePrescription{}
+	for rows.Next() {
+		e, err := scanExercise(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, e)
+	}
+	return items, rows.Err()
+}
+
+// backfillExercisesFromRehab turns the legacy exercise tables in patients.rehab into
+// prescriptions. Rows that do not validate are left out; the rehab text itself is kept.
+// It lists its own columns as they were in migration 11, so later changes to the table
+// cannot break it.
+func backfillExercisesFromRehab(ctx context.Context, tx *Tx) error {
+	type legacy struct{ id, owner, rehab string }
+	rows, err := tx.QueryContext(ctx, `SELECT id, owner_username, rehab FROM patients WHERE rehab IS NOT NULL AND owner_username IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	var found []legacy
+	for rows.Next() {
+		var p legacy
+		if err := rows.Scan(&p.id, &p.owner, &p.rehab); err != nil {
+			rows.Close()
+			return err
+		}
+		found = append(found, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, p := range found {
+		items := core.ParseLegacyRehab(p.rehab)
+		if len(items) > maxExercisesPerPatient {
+			items = items[:maxExercisesPerPatient]
+		}
+		for _, e := range items {
+			normalizeExercise(&e)
+			if validateExercise(e) != nil {
+				continue
+			}
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO exercise_prescriptions (id, patient_id, owner_username, position, exercise, sets, reps,
+				                                    hold_seconds, frequency, progression, start_date, stop_date,
+				                                    created_time, updated_time, version)
+				VALUES (:1,:2,:3,:4,:5,:6,:7,:8,:9,:10,:11,:12,:13,:14,1)
+			`, uuid.NewString(), p.id, p.owner, e.Position, e.Exercise, nullableCount(e.Sets), nullableCount(e.Reps),
+				nullableCount(e.HoldSeconds), nullableText(e.Frequency), nullableText(e.Progression), e.StartDate,
+				e.StopDate, now, now)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
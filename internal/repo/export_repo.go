@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"phsio_track_backend/internal/core"
+)
+
+// ExportRepo reads an owner's patients and payments for export.
+type ExportRepo struct {
+	db *DB
+}
+
+func NewExportRepo(db *DB) *ExportRepo {
+	return &ExportRepo{db: db}
+}
+
+// Patients lists the patients created in the query's range, oldest first. Patients in
+// the trash are included so that an export is a complete backup.
+func (r *ExportRepo) Patients(ctx context.Context, owner string, q core.ExportQuery) ([]core.Patient, error) {
+	query := `SELECT ` + patientColumns + ` FROM patients WHERE owner_username=:1`
+	args := []interface{}{owner}
+	bind := func(v interface{}) string {
+		args = append(args, v)
+		return ":" + strconv.Itoa(len(args))
+	}
+	if !q.From.IsZero() {
+		query += ` AND created_time >= ` + bind(q.From)
+	}
+	if !q.To.IsZero() {
+		query += ` AND created_time < ` + bind(q.To)
+	}
+	rows, err := r.db.QueryContext(ctx, query+` ORDER BY created_time, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []core.Patient{}
+	for rows.Next() {
+		p, err := scanPatient(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, p)
+	}
+	return items, rows.Err()
+}
+
+// Payments lists the payments made in the query's range with their patients' names,
+// oldest first. Payments without a date are only listed when the range is open.
+func (r *ExportRepo) Payments(ctx context.Context, owner string, q core.ExportQuery) ([]core.ExportedPayment, error) {
+	query := `
+		SELECT p.id, p.patient_id, p.amount_minor, p.currency, p.payment_mode, p.paid_date, p.version, p.episode_id,
+		       pt.full_name
+		FROM payments p
+		JOIN patients pt ON pt.id = p.patient_id
+		WHERE p.owner_username=:1`
+	args := []interface{}{owner}
+	bind := func(v interface{}) string {
+		args = append(args, v)
+		return ":" + strconv.Itoa(len(args))
+	}
+	if !q.From.IsZero() {
+		query += ` AND p.paid_date >= ` + bind(q.From)
+	}
+	if !q.To.IsZero() {
+		query += ` AND p.paid_date < ` + bind(q.To)
+	}
+	rows, err := r.db.QueryContext(ctx, query+` ORDER BY p.paid_date, p.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []core.ExportedPayment{}
+	for rows.Next() {
+		var p core.ExportedPayment
+		var paid sql.NullTime
+		var episode sql.NullString
+		if err := rows.Scan(&p.ID, &p.PatientID, &p.Amount.Minor, &p.Amount.Currency, &p.Mode, &paid, &p.Version,
+			&episode, &p.PatientName); err != nil {
+			return nil, err
+		}
+		if paid.Valid {
+			p.Date = core.NewJSONTime(paid.Time)
+		}
+		p.EpisodeID = nullStringToString(episode)
+		items = append(items, p)
+	}
+	return items, rows.Err()
+}
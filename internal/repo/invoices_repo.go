@@ -0,0 +1,524 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"phsio_track_backend/internal/core"
+)
+
+const (
+	maxInvoiceLines  = 100
+	maxLineQuantity  = 1000
+	maxFeeCodeLength = 32
+	maxNameLength    = 255
+)
+
+// InvoiceRepo stores invoices and the fee list they are priced from.
+type InvoiceRepo struct {
+	db       *DB
+	currency string
+}
+
+// NewInvoiceRepo returns an InvoiceRepo billing in currency.
+func NewInvoiceRepo(db *DB, currency string) *InvoiceRepo {
+	return &InvoiceRepo{db: db, currency: currency}
+}
+
+// Create adds an invoice for inv.PatientID. It is ISSUED unless inv.Status asks for
+// a DRAFT; an issued invoice is settled straight away from any credit the patient has.
+func (r *InvoiceRepo) Create(ctx context.Context, owner string, inv *core.Invoice) error {
+	status := strings.ToUpper(strings.TrimSpace(inv.Status))
+	if status == "" {
+		status = core.InvoiceIssued
+	}
+	if status != core.InvoiceDraft && status != core.InvoiceIssued {
+		return fmt.Errorf("%w: a new invoice is DRAFT or ISSUED", ErrInvalid)
+	}
+	inv.Notes = strings.TrimSpace(inv.Notes)
+	if len(inv.Notes) > maxTextBytes {
+		return fmt.Errorf("%w: notes are longer than %d bytes", ErrInvalid, maxTextBytes)
+	}
+	if inv.ID == "" {
+		inv.ID = uuid.NewString()
+	}
+	now := time.Now()
+	issued := inv.IssuedDate
+	if issued.IsZero() {
+		issued = core.NewJSONTime(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))
+	}
+
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		if err := assertPatientOwner(ctx, tx, owner, inv.PatientID); err != nil {
+			return err
+		}
+		lines, total, err := priceLines(ctx, tx, owner, r.currency, inv.Lines)
+		if err != nil {
+			return err
+		}
+		if status == core.InvoiceIssued && len(lines) == 0 {
+			return fmt.Errorf("%w: an issued invoice needs at least one line", ErrInvalid)
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO invoices (id, patient_id, owner_username, status, issued_date, due_date, notes,
+			                      total_minor, currency, created_time, updated_time, version)
+			VALUES (:1,:2,:3,:4,:5,:6,:7,:8,:9,:10,:11,1)
+		`, inv.ID, inv.PatientID, owner, status, issued, inv.DueDate, nullableText(inv.Notes),
+			total, r.currency, now, now)
+		if err != nil {
+			return err
+		}
+		if err := insertLines(ctx, tx, inv.ID, lines); err != nil {
+			return err
+		}
+		if status == core.InvoiceIssued {
+			if err := allocatePayments(ctx, tx, owner, inv.PatientID); err != nil {
+				return err
+			}
+		}
+		*inv, err = getInvoiceByID(ctx, tx, owner, inv.ID)
+		return err
+	})
+}
+
+// List returns the owner's invoices, newest first, optionally only one patient's
+// (patientID) or only those in status. Lines are not loaded.
+func (r *InvoiceRepo) List(ctx context.Context, owner, patientID, status string) ([]core.Invoice, error) {
+	status = strings.ToUpper(strings.TrimSpace(status))
+	if status != "" && !isInvoiceStatus(status) {
+		return nil, fmt.Errorf("%w: unknown invoice status %q", ErrInvalid, status)
+	}
+	items, err := listInvoices(ctx, r.db, owner, patientID, status)
+	if err != nil {
+		return nil, err
+	}
+	// newest first for display; listInvoices keeps ledger order
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	return items, nil
+}
+
+func (r *InvoiceRepo) GetByID(ctx context.Context, owner, id string) (core.Invoice, error) {
+	return getInvoiceByID(ctx, r.db, owner, id)
+}
+
+// Update changes an invoice. Lines can only be replaced while it is a DRAFT; a draft
+// can be ISSUED and any invoice can be VOIDed, which releases the payments allocated
+// to it to settle the patient's other invoices. A VOID invoice cannot be changed.
+func (r *InvoiceRepo) Update(ctx context.Context, owner, id string, upd *core.InvoiceUpdate, ifMatch core.VersionMatch) (core.Invoice, error) {
+	var updated core.Invoice
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		current, err := getInvoiceByID(ctx, tx, owner, id)
+		if err != nil {
+			return err
+		}
+		if !ifMatch.Matches(current.Version) {
+			return ErrStale
+		}
+		if current.Status == core.InvoiceVoid {
+			return fmt.Errorf("%w: invoice is void", ErrConflict)
+		}
+
+		status := current.Status
+		if upd.Status != nil {
+			status = strings.ToUpper(strings.TrimSpace(*upd.Status))
+			switch {
+			case status == current.Status:
+			case status == core.InvoiceVoid:
+			case status == core.InvoiceIssued && current.Status == core.InvoiceDraft:
+			case !isInvoiceStatus(status):
+				return fmt.Errorf("%w: unknown invoice status %q", ErrInvalid, status)
+			case status == core.InvoicePartlyPaid || status == core.InvoicePaid:
+				return fmt.Errorf("%w: %s follows from payments and cannot be set", ErrInvalid, status)
+			default:
+				return fmt.Errorf("%w: cannot move a %s invoice to %s", ErrConflict, current.Status, status)
+			}
+		}
+
+		sets := []string{}
+		args := []interface{}{}
+		add := func(expr string, val interface{}) {
+			sets = append(sets, expr+"=:"+strconv.Itoa(len(args)+1))
+			args = append(args, val)
+		}
+		if upd.Lines != nil {
+			if current.Status != core.InvoiceDraft {
+				return fmt.Errorf("%w: only a draft invoice's lines can be changed", ErrConflict)
+			}
+			lines, total, err := priceLines(ctx, tx, owner, r.currency, *upd.Lines)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM invoice_lines WHERE invoice_id=:1`, id); err != nil {
+				return err
+			}
+			if err := insertLines(ctx, tx, id, lines); err != nil {
+				return err
+			}
+			current.Lines = lines
+			add("total_minor", total)
+		}
+		if status == core.InvoiceIssued && current.Status == core.InvoiceDraft && len(current.Lines) == 0 {
+			return fmt.Errorf("%w: an issued invoice needs at least one line", ErrInvalid)
+		}
+		if status != current.Status {
+			add("status", status)
+		}
+		if upd.IssuedDate != nil {
+			if upd.IssuedDate.IsZero() {
+				return fmt.Errorf("%w: issued_date cannot be empty", ErrInvalid)
+			}
+			add("issued_date", *upd.IssuedDate)
+		}
+		if upd.DueDate != nil {
+			add("due_date", *upd.DueDate)
+		}
+		if upd.Notes != nil {
+			notes := strings.TrimSpace(*upd.Notes)
+			if len(notes) > maxTextBytes {
+				return fmt.Errorf("%w: notes are longer than %d bytes", ErrInvalid, maxTextBytes)
+			}
+			add("notes", nullableText(notes))
+		}
+		if len(sets) == 0 {
+			updated = current
+			return nil
+		}
+
+		add("updated_time", time.Now())
+		n := len(args)
+		args = append(args, id, owner, current.Version)
+		res, err := tx.ExecContext(ctx, "UPDATE invoices SET "+strings.Join(sets, ", ")+", version=version+1"+
+			" WHERE id=:"+strconv.Itoa(n+1)+" AND owner_username=:"+strconv.Itoa(n+2)+" AND version=:"+strconv.Itoa(n+3), args...)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return ErrStale
+		}
+		if status == core.InvoiceVoid && current.Status != core.InvoiceVoid {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM payment_allocations WHERE invoice_id=:1`, id); err != nil {
+				return err
+			}
+		}
+		if err := allocatePayments(ctx, tx, owner, current.PatientID); err != nil {
+			return err
+		}
+		updated, err = getInvoiceByID(ctx, tx, owner, id)
+		return err
+	})
+	if err != nil {
+		return core.Invoice{}, err
+	}
+	return updated, nil
+}
+
+// Delete removes a draft invoice. Issued invoices are voided instead, so they stay on record.
+func (r *InvoiceRepo) Delete(ctx context.Context, owner, id string) error {
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		inv, err := getInvoiceByID(ctx, tx, owner, id)
+		if err != nil {
+			return err
+		}
+		if inv.Status != core.InvoiceDraft {
+			return fmt.Errorf("%w: only draft invoices can be deleted; void it instead", ErrConflict)
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM invoices WHERE id=:1 AND owner_username=:2`, id, owner)
+		return err
+	})
+}
+
+// Fees returns the owner's price list ordered by code.
+func (r *InvoiceRepo) Fees(ctx context.Context, owner string) ([]core.Fee, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT code, name, unit, amount_minor, currency
+		FROM fees
+		WHERE owner_username=:1
+		ORDER BY code
+	`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []core.Fee{}
+	for rows.Next() {
+		var f core.Fee
+		if err := rows.Scan(&f.Code, &f.Name, &f.Unit, &f.Amount.Minor, &f.Amount.Currency); err != nil {
+			return nil, err
+		}
+		items = append(items, f)
+	}
+	return items, rows.Err()
+}
+
+// PutFee creates or replaces the fee with f.Code. Invoices already written keep their prices.
+func (r *InvoiceRepo) PutFee(ctx context.Context, owner string, f *core.Fee) error {
+	f.Code = feeCode(f.Code)
+	f.Name = strings.TrimSpace(f.Name)
+	f.Unit = strings.ToUpper(strings.TrimSpace(f.Unit))
+	if f.Unit == "" {
+		f.Unit = core.FeePerSession
+	}
+	switch {
+	case f.Code == "" || len(f.Code) > maxFeeCodeLength:
+		return fmt.Errorf("%w: fee code must be 1 to %d characters", ErrInvalid, maxFeeCodeLength)
+	case f.Name == "" || utf8.RuneCountInString(f.Name) > maxNameLength:
+		return fmt.Errorf("%w: fee name must be 1 to %d characters", ErrInvalid, maxNameLength)
+	case f.Unit != core.FeePerSession && f.Unit != core.FeePerService:
+		return fmt.Errorf("%w: fee unit must be %s or %s", ErrInvalid, core.FeePerSession, core.FeePerService)
+	}
+	amount, err := checkPrice(f.Amount, r.currency)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if amount.Minor < 0 {
+		return fmt.Errorf("%w: fee amount cannot be negative", ErrInvalid)
+	}
+	f.Amount = amount
+
+	q := `
+		INSERT INTO fees (owner_username, code, name, unit, amount_minor, currency)
+		VALUES (:1,:2,:3,:4,:5,:6)
+		ON CONFLICT (owner_username, code) DO UPDATE SET name = excluded.name,
+		                                                 unit = excluded.unit,
+		                                                 amount_minor = excluded.amount_minor,
+		                                                 currency = excluded.currency
+	`
+	if r.db.Dialect() == DialectOracle {
+		q = `
+		MERGE INTO fees t
+		USING (SELECT :1 AS owner_username,
+		              :2 AS code,
+		              :3 AS name,
+		              :4 AS unit,
+		              :5 AS amount_minor,
+		              :6 AS currency
+		       FROM dual) s
+		ON (t.owner_username = s.owner_username AND t.code = s.code)
+		WHEN MATCHED THEN
+		  UPDATE SET t.name = s.name,
+		             t.unit = s.unit,
+		             t.amount_minor = s.amount_minor,
+		             t.currency = s.currency
+		WHEN NOT MATCHED THEN
+		  INSERT (owner_username, code, name, unit, amount_minor, currency)
+		  VALUES (s.owner_username, s.code, s.name, s.unit, s.amount_minor, s.currency)
+	`
+	}
+	_, err = r.db.ExecContext(ctx, q, owner, f.Code, f.Name, f.Unit, f.Amount.Minor, f.Amount.Currency)
+	return err
+}
+
+// DeleteFee removes a fee from the price list; invoice lines that used it keep its code.
+func (r *InvoiceRepo) DeleteFee(ctx context.Context, owner, code string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM fees WHERE owner_username=:1 AND code=:2`, owner, feeCode(code))
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func feeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func isInvoiceStatus(s string) bool {
+	switch s {
+	case core.InvoiceDraft, core.InvoiceIssued, core.InvoicePartlyPaid, core.InvoicePaid, core.InvoiceVoid:
+		return true
+	}
+	return false
+}
+
+// priceLines validates invoice lines and fills in prices and descriptions from the
+// fee list. It returns the priced lines and their total in minor units.
+func priceLines(ctx context.Context, q querier, owner, currency string, in []core.InvoiceLine) ([]core.InvoiceLine, int64, error) {
+	if len(in) > maxInvoiceLines {
+		return nil, 0, fmt.Errorf("%w: an invoice has at most %d lines", ErrInvalid, maxInvoiceLines)
+	}
+	lines := make([]core.InvoiceLine, 0, len(in))
+	var total int64
+	for i, l := range in {
+		l.FeeCode = feeCode(l.FeeCode)
+		l.Description = strings.TrimSpace(l.Description)
+		var price *core.Money
+		if l.FeeCode != "" {
+			var name string
+			var fee core.Money
+			err := q.QueryRowContext(ctx, `
+				SELECT name, amount_minor, currency FROM fees WHERE owner_username=:1 AND code=:2
+			`, owner, l.FeeCode).Scan(&name, &fee.Minor, &fee.Currency)
+			if err == sql.ErrNoRows {
+				return nil, 0, fmt.Errorf("%w: line %d: unknown fee %q", ErrInvalid, i+1, l.FeeCode)
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			if l.Description == "" {
+				l.Description = name
+			}
+			price = &fee
+		}
+		if l.UnitPrice != nil {
+			price = l.UnitPrice
+		}
+		if price == nil {
+			return nil, 0, fmt.Errorf("%w: line %d needs a unit_price or a fee_code", ErrInvalid, i+1)
+		}
+		unit, err := checkPrice(*price, currency)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: line %d: %v", ErrInvalid, i+1, err)
+		}
+		if l.Quantity == 0 {
+			l.Quantity = 1
+		}
+		switch {
+		case l.Description == "" || utf8.RuneCountInString(l.Description) > maxNameLength:
+			return nil, 0, fmt.Errorf("%w: line %d: description must be 1 to %d characters", ErrInvalid, i+1, maxNameLength)
+		case l.Quantity < 0 || l.Quantity > maxLineQuantity:
+			return nil, 0, fmt.Errorf("%w: line %d: quantity must be 1 to %d", ErrInvalid, i+1, maxLineQuantity)
+		case unit.Minor < 0:
+			return nil, 0, fmt.Errorf("%w: line %d: unit_price cannot be negative", ErrInvalid, i+1)
+		}
+		l.UnitPrice = &unit
+		l.Amount = core.NewMoney(unit.Minor*int64(l.Quantity), currency)
+		total += l.Amount.Minor
+		lines = append(lines, l)
+	}
+	return lines, total, nil
+}
+
+func insertLines(ctx context.Context, q querier, invoiceID string, lines []core.InvoiceLine) error {
+	for i, l := range lines {
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO invoice_lines (invoice_id, line_no, fee_code, description, quantity,
+			                           unit_price_minor, amount_minor, service_date)
+			VALUES (:1,:2,:3,:4,:5,:6,:7,:8)
+		`, invoiceID, i+1, nullableText(l.FeeCode), l.Description, l.Quantity, l.UnitPrice.Minor, l.Amount.Minor, l.ServiceDate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invoiceColumns is the select list read by scanInvoice.
+const invoiceColumns = `id, patient_id, status, issued_date, due_date, notes, total_minor, currency,
+		       created_time, updated_time, version,
+		       (SELECT COALESCE(SUM(a.amount_minor), 0) FROM payment_allocations a WHERE a.invoice_id = invoices.id) AS paid_minor`
+
+func scanInvoice(row rowScanner) (core.Invoice, error) {
+	var inv core.Invoice
+	var notes sql.NullString
+	var paid int64
+	if err := row.Scan(
+		&inv.ID, &inv.PatientID, &inv.Status, &inv.IssuedDate, &inv.DueDate, &notes, &inv.Total.Minor, &inv.Total.Currency,
+		&inv.CreatedTime, &inv.UpdatedTime, &inv.Version, &paid,
+	); err != nil {
+		return inv, err
+	}
+	inv.Notes = nullStringToString(notes)
+	inv.Paid = core.NewMoney(paid, inv.Total.Currency)
+	due := int64(0)
+	if inv.Status != core.InvoiceDraft && inv.Status != core.InvoiceVoid && paid < inv.Total.Minor {
+		due = inv.Total.Minor - paid
+	}
+	inv.Due = core.NewMoney(due, inv.Total.Currency)
+	return inv, nil
+}
+
+// listInvoices returns the owner's invoices, optionally one patient's or one status,
+// oldest first.
+func listInvoices(ctx context.Context, q querier, owner, patientID, status string) ([]core.Invoice, error) {
+	query := `SELECT ` + invoiceColumns + ` FROM invoices WHERE owner_username=:1`
+	args := []interface{}{owner}
+	if patientID != "" {
+		args = append(args, patientID)
+		query += ` AND patient_id=:` + strconv.Itoa(len(args))
+	}
+	if status != "" {
+		args = append(args, status)
+		query += ` AND status=:` + strconv.Itoa(len(args))
+	}
+	rows, err := q.QueryContext(ctx, query+` ORDER BY issued_date, created_time, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []core.Invoice{}
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, inv)
+	}
+	return items, rows.Err()
+}
+
+// getInvoiceByID loads an invoice with its lines and the payments allocated to it.
+func getInvoiceByID(ctx context.Context, q querier, owner, id string) (core.Invoice, error) {
+	inv, err := scanInvoice(q.QueryRowContext(ctx, `
+		SELECT `+invoiceColumns+` FROM invoices WHERE id=:1 AND owner_username=:2
+	`, id, owner))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return inv, ErrNotFound
+		}
+		return inv, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT fee_code, description, quantity, unit_price_minor, amount_minor, service_date
+		FROM invoice_lines
+		WHERE invoice_id=:1
+		ORDER BY line_no
+	`, id)
+	if err != nil {
+		return inv, err
+	}
+	for rows.Next() {
+		var l core.InvoiceLine
+		var code sql.NullString
+		unit := core.Money{Currency: inv.Total.Currency}
+		l.Amount.Currency = inv.Total.Currency
+		if err := rows.Scan(&code, &l.Description, &l.Quantity, &unit.Minor, &l.Amount.Minor, &l.ServiceDate); err != nil {
+			rows.Close()
+			return inv, err
+		}
+		l.FeeCode = nullStringToString(code)
+		l.UnitPrice = &unit
+		inv.Lines = append(inv.Lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return inv, err
+	}
+
+	rows, err = q.QueryContext(ctx, `
+		SELECT payment_id, amount_minor FROM payment_allocations WHERE invoice_id=:1 ORDER BY payment_id
+	`, id)
+	if err != nil {
+		return inv, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a := core.Allocation{InvoiceID: id, Amount: core.Money{Currency: inv.Total.Currency}}
+		if err := rows.Scan(&a.PaymentID, &a.Amount.Minor); err != nil {
+			return inv, err
+		}
+		inv.Allocations = append(inv.Allocations, a)
+	}
+	return inv, rows.Err()
+}
@@ -0,0 +1,318 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"phsio_track_backend/internal/core"
+)
+
+// A patient's payments settle their invoices through payment_allocations. Allocations
+// a payment was created with are kept; whatever a payment has left over is applied to
+// the patient's oldest unpaid invoices, and the invoice statuses follow what they have
+// been paid. Anything still unallocated is credit.
+
+// resolveAmount checks an amount against the deployment's currency and its precision.
+// Amounts that move money, payments and their allocations, must be positive.
+func resolveAmount(m core.Money, currency string) (core.Money, error) {
+	m, err := checkAmount(m, currency)
+	if err != nil {
+		return m, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return m, nil
+}
+
+func checkAmount(m core.Money, currency string) (core.Money, error) {
+	if m == (core.Money{}) {
+		// nothing was sent, as opposed to an explicit zero
+		return m, fmt.Errorf("amount is required")
+	}
+	m, err := checkPrice(m, currency)
+	if err != nil {
+		return m, err
+	}
+	if m.Minor <= 0 {
+		return m, fmt.Errorf("amount must be positive, got %s", m)
+	}
+	return m, nil
+}
+
+// checkPrice is checkAmount for fees and invoice lines, which may be free.
+func checkPrice(m core.Money, currency string) (core.Money, error) {
+	m, err := m.Resolve(currency)
+	if err != nil {
+		return m, err
+	}
+	if m.Currency != currency {
+		return m, fmt.Errorf("amounts are taken in %s, not %s", currency, m.Currency)
+	}
+	return m, nil
+}
+
+type ledgerInvoice struct {
+	id     string
+	status string
+	total  int64
+	paid   int64
+}
+
+// owedInvoices returns the patient's issued invoices, oldest first, with what has
+// been allocated to each.
+func owedInvoices(ctx context.Context, q querier, owner, patientID string) ([]ledgerInvoice, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT i.id, i.status, i.total_minor,
+		       (SELECT COALESCE(SUM(a.amount_minor), 0) FROM payment_allocations a WHERE a.invoice_id = i.id)
+		FROM invoices i
+		WHERE i.patient_id=:1 AND i.owner_username=:2 AND i.status IN (:3,:4,:5)
+		ORDER BY i.issued_date, i.created_time, i.id
+	`, patientID, owner, core.InvoiceIssued, core.InvoicePartlyPaid, core.InvoicePaid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ledgerInvoice
+	for rows.Next() {
+		var inv ledgerInvoice
+		if err := rows.Scan(&inv.id, &inv.status, &inv.total, &inv.paid); err != nil {
+			return nil, err
+		}
+		items = append(items, inv)
+	}
+	return items, rows.Err()
+}
+
+// allocatePayments applies the unallocated part of each of the patient's payments,
+// oldest payment first, to their oldest unpaid invoices and then brings every invoice's
+// status in line with what it has been paid.
+func allocatePayments(ctx context.Context, q querier, owner, patientID string) error {
+	invoices, err := owedInvoices(ctx, q, owner, patientID)
+	if err != nil {
+		return err
+	}
+
+	type unallocated struct {
+		id   string
+		left int64
+	}
+	var payments []unallocated
+	rows, err := q.QueryContext(ctx, `
+		SELECT p.id,
+		       p.amount_minor - (SELECT COALESCE(SUM(a.amount_minor), 0) FROM payment_allocations a WHERE a.payment_id = p.id)
+		FROM payments p
+		WHERE p.patient_id=:1 AND p.owner_username=:2
+		ORDER BY p.paid_date NULLS FIRST, p.id
+	`, patientID, owner)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var p unallocated
+		if err := rows.Scan(&p.id, &p.left); err != nil {
+			rows.Close()
+			return err
+		}
+		if p.left > 0 {
+			payments = append(payments, p)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	existing := map[[2]string]bool{}
+	rows, err = q.QueryContext(ctx, `
+		SELECT a.payment_id, a.invoice_id
+		FROM payment_allocations a
+		JOIN invoices i ON i.id = a.invoice_id
+		WHERE i.patient_id=:1 AND i.owner_username=:2
+	`, patientID, owner)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var k [2]string
+		if err := rows.Scan(&k[0], &k[1]); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[k] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	next := 0
+	for _, p := range payments {
+		for p.left > 0 && next < len(invoices) {
+			inv := &invoices[next]
+			due := inv.total - inv.paid
+			if due <= 0 {
+				next++
+				continue
+			}
+			amount := min(due, p.left)
+			if err := addAllocation(ctx, q, owner, p.id, inv.id, amount, existing[[2]string{p.id, inv.id}]); err != nil {
+				return err
+			}
+			existing[[2]string{p.id, inv.id}] = true
+			inv.paid += amount
+			p.left -= amount
+		}
+	}
+
+	for _, inv := range invoices {
+		status := invoiceStatus(inv.total, inv.paid)
+		if status == inv.status {
+			continue
+		}
+		if _, err := q.ExecContext(ctx, `UPDATE invoices SET status=:1 WHERE id=:2 AND owner_username=:3`, status, inv.id, owner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invoiceStatus is the status of an issued invoice that has been paid paid of total.
+func invoiceStatus(total, paid int64) string {
+	switch {
+	case paid >= total:
+		return core.InvoicePaid
+	case paid > 0:
+		return core.InvoicePartlyPaid
+	default:
+		return core.InvoiceIssued
+	}
+}
+
+func addAllocation(ctx context.Context, q querier, owner, paymentID, invoiceID string, amount int64, exists bool) error {
+	if exists {
+		_, err := q.ExecContext(ctx, `
+			UPDATE payment_allocations
+			   SET amount_minor = amount_minor + :1
+			 WHERE payment_id = :2 AND invoice_id = :3
+		`, amount, paymentID, invoiceID)
+		return err
+	}
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO payment_allocations (payment_id, invoice_id, owner_username, amount_minor)
+		VALUES (:1,:2,:3,:4)
+	`, paymentID, invoiceID, owner, amount)
+	return err
+}
+
+// allocateExplicit records the allocations a payment was created with. Each must
+// name an issued invoice of the payment's patient and owe no more than it is due,
+// and together they cannot exceed the payment.
+func allocateExplicit(ctx context.Context, q querier, owner, currency string, p *core.Payment) error {
+	if len(p.Allocations) == 0 {
+		return nil
+	}
+	invoices, err := owedInvoices(ctx, q, owner, p.PatientID)
+	if err != nil {
+		return err
+	}
+	byID := map[string]*ledgerInvoice{}
+	for i := range invoices {
+		byID[invoices[i].id] = &invoices[i]
+	}
+	left := p.Amount.Minor
+	seen := map[string]bool{}
+	for i, a := range p.Allocations {
+		amount, err := checkAmount(a.Amount, currency)
+		if err != nil {
+			return fmt.Errorf("%w: allocation %d: %v", ErrInvalid, i+1, err)
+		}
+		inv := byID[a.InvoiceID]
+		switch {
+		case inv == nil:
+			return fmt.Errorf("%w: allocation %d: invoice %q is not an issued invoice of this patient", ErrInvalid, i+1, a.InvoiceID)
+		case seen[a.InvoiceID]:
+			return fmt.Errorf("%w: allocation %d: invoice %q is listed twice", ErrInvalid, i+1, a.InvoiceID)
+		case amount.Minor > inv.total-inv.paid:
+			return fmt.Errorf("%w: allocation %d: invoice %q only has %s due", ErrInvalid, i+1, a.InvoiceID,
+				core.NewMoney(inv.total-inv.paid, currency))
+		case amount.Minor > left:
+			return fmt.Errorf("%w: allocations add up to more than the payment", ErrInvalid)
+		}
+		if err := addAllocation(ctx, q, owner, p.ID, inv.id, amount.Minor, false); err != nil {
+			return err
+		}
+		seen[a.InvoiceID] = true
+		inv.paid += amount.Minor
+		left -= amount.Minor
+	}
+	return nil
+}
+
+// paymentAllocations returns the allocations of the given payments, keyed by payment id.
+// An empty paymentID selects every payment of patientID, or of the owner when that is empty too.
+func paymentAllocations(ctx context.Context, q querier, owner, patientID, paymentID string) (map[string][]core.Allocation, error) {
+	query := `
+		SELECT a.payment_id, a.invoice_id, a.amount_minor, p.currency
+		FROM payment_allocations a
+		JOIN payments p ON p.id = a.payment_id
+		WHERE a.owner_username=:1`
+	args := []interface{}{owner}
+	switch {
+	case paymentID != "":
+		query += ` AND a.payment_id=:2`
+		args = append(args, paymentID)
+	case patientID != "":
+		query += ` AND p.patient_id=:2`
+		args = append(args, patientID)
+	}
+	rows, err := q.QueryContext(ctx, query+` ORDER BY a.payment_id, a.invoice_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string][]core.Allocation{}
+	for rows.Next() {
+		var a core.Allocation
+		if err := rows.Scan(&a.PaymentID, &a.InvoiceID, &a.Amount.Minor, &a.Amount.Currency); err != nil {
+			return nil, err
+		}
+		out[a.PaymentID] = append(out[a.PaymentID], a)
+	}
+	return out, rows.Err()
+}
+
+// Balance returns what the patient has been billed, has paid and still owes.
+func (r *PaymentRepo) Balance(ctx context.Context, owner, patientID string) (core.PatientBalance, error) {
+	if _, err := getPatientByID(ctx, r.db, owner, patientID); err != nil {
+		return core.PatientBalance{}, err
+	}
+	var paid sql.NullInt64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT SUM(amount_minor) FROM payments WHERE patient_id=:1 AND owner_username=:2
+	`, patientID, owner).Scan(&paid)
+	if err != nil {
+		return core.PatientBalance{}, err
+	}
+	invoices, err := listInvoices(ctx, r.db, owner, patientID, "")
+	if err != nil {
+		return core.PatientBalance{}, err
+	}
+
+	var billed, due int64
+	b := core.PatientBalance{PatientID: patientID, OpenInvoices: []core.Invoice{}}
+	for _, inv := range invoices {
+		if inv.Status == core.InvoiceDraft || inv.Status == core.InvoiceVoid {
+			continue
+		}
+		billed += inv.Total.Minor
+		due += inv.Due.Minor
+		if inv.Due.Minor > 0 {
+			b.OpenInvoices = append(b.OpenInvoices, inv)
+		}
+	}
+	b.Billed = core.NewMoney(billed, r.currency)
+	b.Paid = core.NewMoney(paid.Int64, r.currency)
+	b.Due = core.NewMoney(due, r.currency)
+	// allocations only ever go to issued invoices, so what was allocated is billed - due
+	b.Credit = core.NewMoney(paid.Int64-(billed-due), r.currency)
+	return b, nil
+}
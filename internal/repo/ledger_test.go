@@ -0,0 +1,279 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"phsio_track_backend/internal/core"
+)
+
+func day(d int) core.JSONTime {
+	return core.NewJSONTime(time.Date(2025, 4, d, 0, 0, 0, 0, time.UTC))
+}
+
+func inr(minor int64) *core.Money {
+	m := core.NewMoney(minor, "INR")
+	return &m
+}
+
+func issueInvoice(t *testing.T, r *InvoiceRepo, patientID string, issued int, total int64) core.Invoice {
+	t.Helper()
+	inv := core.Invoice{
+		PatientID:  patientID,
+		IssuedDate: day(issued),
+		Lines:      []core.InvoiceLine{{Description: "Session", Quantity: 1, UnitPrice: inr(total)}},
+	}
+	if err := r.Create(context.Background(), "owner", &inv); err != nil {
+		t.Fatal(err)
+	}
+	return inv
+}
+
+func pay(t *testing.T, r *PaymentRepo, patientID string, paid int, amount int64, allocations ...core.Allocation) core.Payment {
+	t.Helper()
+	p := core.Payment{PatientID: patientID, Amount: *inr(amount), Mode: "CASH", Date: day(paid), Allocations: allocations}
+	if err := r.Create(context.Background(), "owner", &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// invoiceState is an invoice's status and what it has been paid, in minor units.
+type invoiceState struct {
+	status string
+	paid   int64
+}
+
+func checkInvoices(t *testing.T, r *InvoiceRepo, want map[string]invoiceState) {
+	t.Helper()
+	for id, w := range want {
+		inv, err := r.GetByID(context.Background(), "owner", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inv.Status != w.status || inv.Paid.Minor != w.paid {
+			t.Errorf("invoice %s: %s paid %d, want %s paid %d", id, inv.Status, inv.Paid.Minor, w.status, w.paid)
+		}
+	}
+}
+
+func TestAllocatePayments(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	invoices := NewInvoiceRepo(db, "INR")
+	payments := NewPaymentRepo(db, "INR")
+	patient := newTestPatient(t, db, "Asha Rao")
+
+	// issued out of order; the older one is settled first
+	second := issueInvoice(t, invoices, patient, 10, 1000)
+	first := issueInvoice(t, invoices, patient, 5, 1000)
+
+	p1 := pay(t, payments, patient, 12, 1500)
+	checkInvoices(t, invoices, map[string]invoiceState{
+		first.ID:  {core.InvoicePaid, 1000},
+		second.ID: {core.InvoicePartlyPaid, 500},
+	})
+
+	// overpayment is held as credit and settles the next invoice when it is issued
+	pay(t, payments, patient, 13, 700)
+	third := issueInvoice(t, invoices, patient, 14, 400)
+	checkInvoices(t, invoices, map[string]invoiceState{
+		second.ID: {core.InvoicePaid, 1000},
+		third.ID:  {core.InvoicePartlyPaid, 200},
+	})
+
+	// running the allocation again changes nothing
+	if err := allocatePayments(ctx, db, "owner", patient); err != nil {
+		t.Fatal(err)
+	}
+	checkInvoices(t, invoices, map[string]invoiceState{
+		first.ID:  {core.InvoicePaid, 1000},
+		second.ID: {core.InvoicePaid, 1000},
+		third.ID:  {core.InvoicePartlyPaid, 200},
+	})
+
+	// deleting a payment reopens what it paid; other payments keep their allocations
+	if err := payments.Delete(ctx, "owner", p1.ID); err != nil {
+		t.Fatal(err)
+	}
+	checkInvoices(t, invoices, map[string]invoiceState{
+		first.ID:  {core.InvoiceIssued, 0},
+		second.ID: {core.InvoicePartlyPaid, 500},
+		third.ID:  {core.InvoicePartlyPaid, 200},
+	})
+
+	// new money goes to the oldest invoice again
+	pay(t, payments, patient, 15, 1200)
+	checkInvoices(t, invoices, map[string]invoiceState{
+		first.ID:  {core.InvoicePaid, 1000},
+		second.ID: {core.InvoicePartlyPaid, 700},
+		third.ID:  {core.InvoicePartlyPaid, 200},
+	})
+}
+
+func TestAllocatePaymentsExplicit(t *testing.T) {
+	db := newTestDB(t)
+	invoices := NewInvoiceRepo(db, "INR")
+	payments := NewPaymentRepo(db, "INR")
+	patient := newTestPatient(t, db, "Asha Rao")
+
+	first := issueInvoice(t, invoices, patient, 5, 1000)
+	second := issueInvoice(t, invoices, patient, 10, 1000)
+
+	// the named invoice is paid first; the rest goes to the oldest
+	p := pay(t, payments, patient, 12, 1300, core.Allocation{InvoiceID: second.ID, Amount: *inr(1000)})
+	checkInvoices(t, invoices, map[string]invoiceState{
+		first.ID:  {core.InvoicePartlyPaid, 300},
+		second.ID: {core.InvoicePaid, 1000},
+	})
+	if len(p.Allocations) != 2 {
+		t.Errorf("allocations = %+v, want two", p.Allocations)
+	}
+
+	tests := []struct {
+		name       string
+		allocation core.Allocation
+	}{
+		{"more than is due", core.Allocation{InvoiceID: first.ID, Amount: *inr(800)}},
+		{"more than the payment", core.Allocation{InvoiceID: first.ID, Amount: *inr(600)}},
+		{"zero", core.Allocation{InvoiceID: first.ID, Amount: *inr(0)}},
+		{"unknown invoice", core.Allocation{InvoiceID: "nope", Amount: *inr(100)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := core.Payment{PatientID: patient, Amount: *inr(500), Mode: "CASH", Date: day(13),
+				Allocations: []core.Allocation{tt.allocation}}
+			if err := payments.Create(context.Background(), "owner", &p); !errors.Is(err, ErrInvalid) {
+				t.Errorf("Create error = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestPaymentAmountMustBePositive(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	payments := NewPaymentRepo(db, "INR")
+	patient := newTestPatient(t, db, "Asha Rao")
+
+	for _, amount := range []int64{0, -500} {
+		p := core.Payment{PatientID: patient, Amount: *inr(amount), Mode: "CASH", Date: day(1)}
+		if err := payments.Create(ctx, "owner", &p); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Create(%d) error = %v, want ErrInvalid", amount, err)
+		}
+		if err := payments.Upsert(ctx, "owner", &p); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Upsert(%d) error = %v, want ErrInvalid", amount, err)
+		}
+	}
+
+	missing := core.Payment{PatientID: patient, Mode: "CASH", Date: day(1)}
+	if err := payments.Create(ctx, "owner", &missing); !errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), "amount is required") {
+		t.Errorf("Create without an amount: error = %v, want amount is required", err)
+	}
+
+	p := pay(t, payments, patient, 1, 500)
+	upd := core.PaymentUpdate{Amount: inr(0)}
+	if _, err := payments.Update(ctx, "owner", p.ID, &upd, core.VersionMatch{}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Update to zero: error = %v, want ErrInvalid", err)
+	}
+	usd := core.NewMoney(500, "USD")
+	upd = core.PaymentUpdate{Amount: &usd}
+	if _, err := payments.Update(ctx, "owner", p.ID, &upd, core.VersionMatch{}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Update in USD: error = %v, want ErrInvalid", err)
+	}
+}
+
+func TestPaymentUpsertKeepsOwners(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	payments := NewPaymentRepo(db, "INR")
+	patient := newTestPatient(t, db, "Asha Rao")
+	p := pay(t, payments, patient, 1, 500)
+
+	if err := NewUserRepo(db).UpsertUser(ctx, core.User{Username: "other", PasswordHash: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	theirs := core.Patient{FullName: "Ravi Kumar"}
+	if err := NewPatientRepo(db, "IN").Create(ctx, "other", &theirs); err != nil {
+		t.Fatal(err)
+	}
+	clash := core.Payment{ID: p.ID, PatientID: theirs.ID, Amount: *inr(900), Mode: "UPI", Date: day(2)}
+	if err := payments.Upsert(ctx, "other", &clash); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Upsert of another owner's id: error = %v, want ErrConflict", err)
+	}
+	got, err := payments.GetByID(ctx, "owner", p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Amount.Minor != 500 || got.PatientID != patient {
+		t.Errorf("payment after the clash = %d for %s, want 500 for %s", got.Amount.Minor, got.PatientID, patient)
+	}
+
+	// the owner's own upsert still updates in place
+	mine := core.Payment{ID: p.ID, PatientID: patient, Amount: *inr(700), Mode: "CASH", Date: day(1)}
+	if err := payments.Upsert(ctx, "owner", &mine); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := payments.GetByID(ctx, "owner", p.ID); got.Amount.Minor != 700 {
+		t.Errorf("amount after upsert = %d, want 700", got.Amount.Minor)
+	}
+}
+
+func TestBalance(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	invoices := NewInvoiceRepo(db, "INR")
+	payments := NewPaymentRepo(db, "INR")
+	patient := newTestPatient(t, db, "Asha Rao")
+
+	check := func(billed, paid, due, credit int64, open int) {
+		t.Helper()
+		b, err := payments.Balance(ctx, "owner", patient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Billed.Minor != billed || b.Paid.Minor != paid || b.Due.Minor != due || b.Credit.Minor != credit ||
+			len(b.OpenInvoices) != open {
+			t.Errorf("balance = billed %d paid %d due %d credit %d open %d; want %d %d %d %d %d",
+				b.Billed.Minor, b.Paid.Minor, b.Due.Minor, b.Credit.Minor, len(b.OpenInvoices),
+				billed, paid, due, credit, open)
+		}
+		if b.Billed.Currency != "INR" || b.Credit.Currency != "INR" {
+			t.Errorf("balance currency = %s/%s, want INR", b.Billed.Currency, b.Credit.Currency)
+		}
+	}
+
+	check(0, 0, 0, 0, 0)
+
+	// paying ahead is credit
+	pay(t, payments, patient, 1, 300)
+	check(0, 300, 0, 300, 0)
+
+	first := issueInvoice(t, invoices, patient, 2, 1000)
+	check(1000, 300, 700, 0, 1)
+
+	// drafts and void invoices are not billed
+	draft := core.Invoice{PatientID: patient, Status: core.InvoiceDraft,
+		Lines: []core.InvoiceLine{{Description: "Session", Quantity: 1, UnitPrice: inr(900)}}}
+	if err := invoices.Create(ctx, "owner", &draft); err != nil {
+		t.Fatal(err)
+	}
+	second := issueInvoice(t, invoices, patient, 3, 500)
+	check(1500, 300, 1200, 0, 2)
+	void := core.InvoiceVoid
+	if _, err := invoices.Update(ctx, "owner", second.ID, &core.InvoiceUpdate{Status: &void}, core.VersionMatch{}); err != nil {
+		t.Fatal(err)
+	}
+	check(1000, 300, 700, 0, 1)
+
+	pay(t, payments, patient, 4, 900)
+	check(1000, 1200, 0, 200, 0)
+	checkInvoices(t, invoices, map[string]invoiceState{first.ID: {core.InvoicePaid, 1000}})
+
+	if _, err := payments.Balance(ctx, "someone-else", patient); err == nil {
+		t.Error("Balance of another owner's patient succeeded")
+	}
+}
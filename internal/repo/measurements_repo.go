@@ -0,0 +1,403 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"phsio_track_backend/internal/core"
+)
+
+// measurementUnits is the fixed unit of each kind; CUSTOM measures bring their own.
+var measurementUnits = map[string]string{
+	core.MeasureVAS:   "cm",
+	core.MeasureNRS:   "",
+	core.MeasureROM:   "deg",
+	core.MeasureMMT:   "grade",
+	core.MeasureGirth: "cm",
+}
+
+// MeasurementRepo stores patients' outcome measurements, scoped to the owner of the patient.
+type MeasurementRepo struct {
+	db *DB
+}
+
+func NewMeasurementRepo(db *DB) *MeasurementRepo {
+	return &MeasurementRepo{db: db}
+}
+
+// Create records a reading. MeasuredAt defaults to now; an AppointmentID must be one of
+// the patient's appointments.
+func (r *MeasurementRepo) Create(ctx context.Context, owner string, m *core.Measurement) error {
+	if m.MeasuredAt.IsZero() {
+		m.MeasuredAt = core.NewJSONTime(time.Now())
+	}
+	if err := normalizeMeasurement(m); err != nil {
+		return err
+	}
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		if err := assertPatientOwner(ctx, tx, owner, m.PatientID); err != nil {
+			return err
+		}
+		return insertMeasurement(ctx, tx, owner, m)
+	})
+}
+
+// insertMeasurement stores a normalized reading of a patient the owner has and reads it back.
+func insertMeasurement(ctx context.Context, q querier, owner string, m *core.Measurement) error {
+	if err := assertPatientAppointment(ctx, q, owner, m.PatientID, m.AppointmentID); err != nil {
+		return err
+	}
+	m.ID = uuid.NewString()
+	now := time.Now()
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO measurements (id, patient_id, owner_username, appointment_id, kind, joint, side, movement, site,
+		                          name, value, grade, unit, measured_at, notes, created_time, updated_time, version)
+		VALUES (:1,:2,:3,:4,:5,:6,:7,:8,:9,:10,:11,:12,:13,:14,:15,:16,:17,1)
+	`, m.ID, m.PatientID, owner, nullableText(m.AppointmentID), m.Kind, nullableText(m.Joint), nullableText(m.Side),
+		nullableText(m.Movement), nullableText(m.Site), nullableText(m.Name), m.Value, nullableText(m.Grade),
+		nullableText(m.Unit), m.MeasuredAt, nullableText(m.Notes), now, now)
+	if err != nil {
+		return err
+	}
+	*m, err = getMeasurementByID(ctx, q, owner, m.PatientID, m.ID)
+	return err
+}
+
+// List returns a patient's readings matching q, oldest first.
+func (r *MeasurementRepo) List(ctx context.Context, owner, patientID string, q core.MeasurementQuery) ([]core.Measurement, error) {
+	if err := assertPatientOwner(ctx, r.db, owner, patientID); err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + measurementColumns + ` FROM measurements WHERE patient_id=:1 AND owner_username=:2`
+	args := []interface{}{patientID, owner}
+	bind := func(v interface{}) string {
+		args = append(args, v)
+		return ":" + strconv.Itoa(len(args))
+	}
+	for _, f := range []struct{ column, value string }{
+		{"kind", strings.ToUpper(q.Kind)},
+		{"joint", measurementKey(q.Joint)},
+		{"side", strings.ToUpper(q.Side)},
+		{"movement", measurementKey(q.Movement)},
+		{"site", measurementKey(q.Site)},
+		{"name", measurementKey(q.Name)},
+		{"appointment_id", q.AppointmentID},
+	} {
+		if f.value != "" {
+			query += ` AND ` + f.column + ` = ` + bind(f.value)
+		}
+	}
+	if !q.From.IsZero() {
+		query += ` AND measured_at >= ` + bind(q.From)
+	}
+	if !q.To.IsZero() {
+		query += ` AND measured_at < ` + bind(q.To)
+	}
+	rows, err := r.db.QueryContext(ctx, query+` ORDER BY measured_at, created_time, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []core.Measurement{}
+	for rows.Next() {
+		m, err := scanMeasurement(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, m)
+	}
+	return items, rows.Err()
+}
+
+// Series groups the readings matching q into one series per measure for charting,
+// in the order each measure was first taken.
+func (r *MeasurementRepo) Series(ctx context.Context, owner, patientID string, q core.MeasurementQuery) ([]core.MeasurementSeries, error) {
+	items, err := r.List(ctx, owner, patientID, q)
+	if err != nil {
+		return nil, err
+	}
+	type measure struct{ kind, joint, side, movement, site, name, unit string }
+	index := map[measure]int{}
+	out := []core.MeasurementSeries{}
+	for _, m := range items {
+		k := measure{m.Kind, m.Joint, m.Side, m.Movement, m.Site, m.Name, m.Unit}
+		i, ok := index[k]
+		if !ok {
+			i = len(out)
+			index[k] = i
+			out = append(out, core.MeasurementSeries{
+				Kind: m.Kind, Joint: m.Joint, Side: m.Side, Movement: m.Movement, Site: m.Site, Name: m.Name,
+				Unit: m.Unit, Label: measurementLabel(m),
+			})
+		}
+		out[i].Points = append(out[i].Points, core.MeasurementPoint{
+			ID: m.ID, MeasuredAt: m.MeasuredAt, Value: m.Value, Grade: m.Grade, AppointmentID: m.AppointmentID,
+		})
+	}
+	return out, nil
+}
+
+func (r *MeasurementRepo) GetByID(ctx context.Context, owner, patientID, id string) (core.Measurement, error) {
+	return getMeasurementByID(ctx, r.db, owner, patientID, id)
+}
+
+// Update corrects a reading's value, time, notes or appointment. A conditional ifMatch must match.
+func (r *MeasurementRepo) Update(ctx context.Context, owner, patientID, id string, upd *core.MeasurementUpdate, ifMatch core.VersionMatch) (core.Measurement, error) {
+	var updated core.Measurement
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		current, err := getMeasurementByID(ctx, tx, owner, patientID, id)
+		if err != nil {
+			return err
+		}
+		if !ifMatch.Matches(current.Version) {
+			return ErrStale
+		}
+		next := current
+		if upd.AppointmentID != nil {
+			next.AppointmentID = strings.TrimSpace(*upd.AppointmentID)
+		}
+		if upd.Value != nil {
+			next.Value = *upd.Value
+			// a new value replaces the grade it was read from
+			next.Grade = ""
+		}
+		if upd.Grade != nil {
+			next.Grade = *upd.Grade
+		}
+		if upd.Unit != nil {
+			next.Unit = *upd.Unit
+		}
+		if upd.MeasuredAt != nil {
+			next.MeasuredAt = *upd.MeasuredAt
+		}
+		if upd.Notes != nil {
+			next.Notes = *upd.Notes
+		}
+		if err := normalizeMeasurement(&next); err != nil {
+			return err
+		}
+		if next == current {
+			updated = current
+			return nil
+		}
+		if next.AppointmentID != current.AppointmentID {
+			if err := assertPatientAppointment(ctx, tx, owner, patientID, next.AppointmentID); err != nil {
+				return err
+			}
+		}
+		res, err := tx.ExecContext(ctx, `
+			UPDATE measurements
+			   SET appointment_id = :1,
+			       value = :2,
+			       grade = :3,
+			       unit = :4,
+			       measured_at = :5,
+			       notes = :6,
+			       updated_time = :7,
+			       version = version + 1
+			 WHERE id = :8 AND owner_username = :9 AND version = :10
+		`, nullableText(next.AppointmentID), next.Value, nullableText(next.Grade), nullableText(next.Unit),
+			next.MeasuredAt, nullableText(next.Notes), time.Now(), id, owner, current.Version)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return ErrStale
+		}
+		updated, err = getMeasurementByID(ctx, tx, owner, patientID, id)
+		return err
+	})
+	if err != nil {
+		return core.Measurement{}, err
+	}
+	return updated, nil
+}
+
+func (r *MeasurementRepo) Delete(ctx context.Context, owner, patientID, id string) error {
+	res, err := r.db.ExecContext(ctx, `
+		DELETE FROM measurements WHERE id=:1 AND patient_id=:2 AND owner_username=:3
+	`, id, patientID, owner)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// normalizeMeasurement cleans up m and checks it against the rules of its kind. Joints,
+// movements, sites and names are lower-cased so readings of one measure form one series.
+func normalizeMeasurement(m *core.Measurement) error {
+	m.Kind = strings.ToUpper(strings.TrimSpace(m.Kind))
+	m.Side = strings.ToUpper(strings.TrimSpace(m.Side))
+	m.Joint = measurementKey(m.Joint)
+	m.Movement = measurementKey(m.Movement)
+	m.Site = measurementKey(m.Site)
+	m.Name = measurementKey(m.Name)
+	m.Grade = strings.TrimSpace(m.Grade)
+	m.Unit = strings.TrimSpace(m.Unit)
+	m.Notes = strings.TrimSpace(m.Notes)
+
+	if !containsString(core.MeasurementKinds, m.Kind) {
+		return fmt.Errorf("%w: kind must be one of %s", ErrInvalid, strings.Join(core.MeasurementKinds, ", "))
+	}
+	if m.Kind != core.MeasureCustom {
+		m.Unit = measurementUnits[m.Kind]
+	}
+	if m.Kind != core.MeasureMMT && m.Grade != "" {
+		return fmt.Errorf("%w: only MMT readings have a grade", ErrInvalid)
+	}
+	switch m.Side {
+	case "", core.SideLeft, core.SideRight, core.SideBilateral:
+	default:
+		return fmt.Errorf("%w: side must be LEFT, RIGHT or BILATERAL", ErrInvalid)
+	}
+	for _, f := range []struct{ name, value string }{
+		{"joint", m.Joint}, {"movement", m.Movement}, {"site", m.Site}, {"name", m.Name}, {"unit", m.Unit},
+	} {
+		if utf8.RuneCountInString(f.value) > maxNameLength {
+			return fmt.Errorf("%w: %s is longer than %d characters", ErrInvalid, f.name, maxNameLength)
+		}
+	}
+	if len(m.Notes) > maxTextBytes {
+		return fmt.Errorf("%w: notes are longer than %d bytes", ErrInvalid, maxTextBytes)
+	}
+	if math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+		return fmt.Errorf("%w: value must be a number", ErrInvalid)
+	}
+
+	switch m.Kind {
+	case core.MeasureVAS, core.MeasureNRS:
+		if m.Joint != "" || m.Movement != "" || m.Side != "" {
+			return fmt.Errorf("%w: pain scores have no joint, movement or side", ErrInvalid)
+		}
+		if m.Value < 0 || m.Value > 10 {
+			return fmt.Errorf("%w: %s must be 0 to 10", ErrInvalid, m.Kind)
+		}
+		if m.Kind == core.MeasureNRS && m.Value != math.Trunc(m.Value) {
+			return fmt.Errorf("%w: NRS is a whole number", ErrInvalid)
+		}
+	case core.MeasureROM:
+		if m.Joint == "" || m.Movement == "" {
+			return fmt.Errorf("%w: ROM needs joint and movement", ErrInvalid)
+		}
+		if m.Value < -180 || m.Value > 360 {
+			return fmt.Errorf("%w: ROM must be -180 to 360 degrees", ErrInvalid)
+		}
+	case core.MeasureMMT:
+		if m.Joint == "" || m.Movement == "" {
+			return fmt.Errorf("%w: MMT needs joint and movement", ErrInvalid)
+		}
+		if m.Grade != "" {
+			v, ok := mmtValue(m.Grade)
+			if !ok {
+				return fmt.Errorf("%w: MMT grade must be 0 to 5, optionally with + or -", ErrInvalid)
+			}
+			m.Value = v
+		} else {
+			if m.Value < 0 || m.Value > 5 || m.Value != math.Trunc(m.Value) {
+				return fmt.Errorf("%w: MMT value must be a whole grade 0 to 5; send grade for 4+ or 4-", ErrInvalid)
+			}
+			m.Grade = strconv.Itoa(int(m.Value))
+		}
+	case core.MeasureGirth:
+		if m.Site == "" {
+			return fmt.Errorf("%w: girth needs site", ErrInvalid)
+		}
+		if m.Value <= 0 || m.Value > 300 {
+			return fmt.Errorf("%w: girth must be above 0 and at most 300 cm", ErrInvalid)
+		}
+	case core.MeasureCustom:
+		if m.Name == "" {
+			return fmt.Errorf("%w: custom measurements need a name", ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// mmtValue charts an MMT grade: "4" is 4, "4+" is 4.33 and "4-" is 3.67.
+func mmtValue(grade string) (float64, bool) {
+	base, sign := grade, 0.0
+	switch {
+	case strings.HasSuffix(grade, "+"):
+		base, sign = strings.TrimSuffix(grade, "+"), 1
+	case strings.HasSuffix(grade, "-"):
+		base, sign = strings.TrimSuffix(grade, "-"), -1
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(base))
+	if err != nil || n < 0 || n > 5 || (n == 5 && sign > 0) || (n == 0 && sign < 0) {
+		return 0, false
+	}
+	return math.Round((float64(n)+sign/3)*100) / 100, true
+}
+
+func measurementKey(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// measurementLabel names a measure for a chart legend, e.g. "ROM knee flexion (LEFT)".
+func measurementLabel(m core.Measurement) string {
+	parts := []string{m.Kind}
+	for _, p := range []string{m.Joint, m.Movement, m.Site, m.Name} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	label := strings.Join(parts, " ")
+	if m.Side != "" {
+		label += " (" + m.Side + ")"
+	}
+	return label
+}
+
+// assertPatientAppointment checks that an appointment a record points at belongs to its patient.
+func assertPatientAppointment(ctx context.Context, q querier, owner, patientID, appointmentID string) error {
+	if appointmentID == "" {
+		return nil
+	}
+	a, err := getAppointmentByID(ctx, q, owner, appointmentID)
+	if err == ErrNotFound || (err == nil && a.PatientID != patientID) {
+		return fmt.Errorf("%w: appointment %s is not one of the patient's", ErrInvalid, appointmentID)
+	}
+	return err
+}
+
+// measurementColumns is the select list read by scanMeasurement.
+const measurementColumns = `id, patient_id, appointment_id, kind, joint, side, movement, site, name, value, grade, unit,
+		       measured_at, notes, created_time, updated_time, version`
+
+func scanMeasurement(row rowScanner) (core.Measurement, error) {
+	var m core.Measurement
+	var appointment, joint, side, movement, site, name, grade, unit, notes sql.NullString
+	err := row.Scan(&m.ID, &m.PatientID, &appointment, &m.Kind, &joint, &side, &movement, &site, &name, &m.Value,
+		&grade, &unit, &m.MeasuredAt, &notes, &m.CreatedTime, &m.UpdatedTime, &m.Version)
+	m.AppointmentID = nullStringToString(appointment)
+	m.Joint = nullStringToString(joint)
+	m.Side = nullStringToString(side)
+	m.Movement = nullStringToString(movement)
+	m.Site = nullStringToString(site)
+	m.Name = nullStringToString(name)
+	m.Grade = nullStringToString(grade)
+	m.Unit = nullStringToString(unit)
+	m.Notes = nullStringToString(notes)
+	return m, err
+}
+
+func getMeasurementByID(ctx context.Context, q querier, owner, patientID, id string) (core.Measurement, error) {
+	m, err := scanMeasurement(q.QueryRowContext(ctx, `
+		SELECT `+measurementColumns+` FROM measurements WHERE id=:1 AND patient_id=:2 AND owner_username=:3
+	`, id, patientID, owner))
+	if err == sql.ErrNoRows {
+		return m, ErrNotFound
+	}
+	return m, err
+}
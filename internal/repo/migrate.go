@@ -0,0 +1,209 @@
+package repo
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Migration is one numbered, reversible schema change with SQL per dialect.
+// Backfill, when set, runs after the up statements in the same transaction for
+// data changes SQL alone cannot express; it is not part of the checksum.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       map[Dialect][]string
+	Down     map[Dialect][]string
+	Backfill func(ctx context.Context, tx *Tx) error
+}
+
+// Checksum fingerprints the migration's up statements for a dialect.
+func (m Migration) Checksum(d Dialect) string {
+	sum := sha256.Sum256([]byte(strings.Join(m.Up[d], "\n;\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// MigrationState reports whether a migration is applied and still matches its recorded checksum.
+type MigrationState struct {
+	Version     int       `json:"version"`
+	Name        string    `json:"name"`
+	Applied     bool      `json:"applied"`
+	AppliedTime time.Time `json:"applied_time,omitempty"`
+	Checksum    string    `json:"checksum"`
+	Modified    bool      `json:"modified"`
+}
+
+var schemaMigrationsDDL = map[Dialect]string{
+	DialectOracle: `BEGIN
+	   EXECUTE IMMEDIATE 'CREATE TABLE schema_migrations (
+	     version NUMBER PRIMARY KEY,
+	     name VARCHAR2(255) NOT NULL,
+	     checksum VARCHAR2(64) NOT NULL,
+	     applied_time TIMESTAMP NOT NULL
+	   )';
+	 EXCEPTION
+	   WHEN OTHERS THEN
+	     IF SQLCODE != -955 THEN RAISE; END IF;
+	 END;`,
+	DialectPostgres: `CREATE TABLE IF NOT EXISTS schema_migrations (
+	   version INTEGER PRIMARY KEY,
+	   name VARCHAR(255) NOT NULL,
+	   checksum VARCHAR(64) NOT NULL,
+	   applied_time TIMESTAMP NOT NULL
+	 )`,
+	DialectSQLite: `CREATE TABLE IF NOT EXISTS schema_migrations (
+	   version INTEGER PRIMARY KEY,
+	   name TEXT NOT NULL,
+	   checksum TEXT NOT NULL,
+	   applied_time TIMESTAMP NOT NULL
+	 )`,
+}
+
+type appliedMigration struct {
+	checksum string
+	applied  time.Time
+}
+
+// MigrationStatus lists every known migration and whether it has been applied.
+func MigrationStatus(ctx context.Context, db *DB) ([]MigrationState, error) {
+	applied, err := loadApplied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var out []MigrationState
+	for _, m := range sortedMigrations() {
+		st := MigrationState{Version: m.Version, Name: m.Name, Checksum: m.Checksum(db.Dialect())}
+		if a, ok := applied[m.Version]; ok {
+			st.Applied = true
+			st.AppliedTime = a.applied
+			st.Modified = a.checksum != st.Checksum
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// MigrateUp applies pending migrations up to and including target (0 means latest).
+// It refuses to run if an applied migration was edited after the fact.
+func MigrateUp(ctx context.Context, db *DB, target int) ([]Migration, error) {
+	applied, err := loadApplied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, m := range sortedMigrations() {
+		if target > 0 && m.Version > target {
+			break
+		}
+		if a, ok := applied[m.Version]; ok {
+			if a.checksum != m.Checksum(db.Dialect()) {
+				return done, fmt.Errorf("migration %d (%s) was modified after it was applied", m.Version, m.Name)
+			}
+			continue
+		}
+		if err := runMigration(ctx, db, m, true); err != nil {
+			return done, err
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts applied migrations newest first until only versions <= target remain.
+func MigrateDown(ctx context.Context, db *DB, target int) ([]Migration, error) {
+	applied, err := loadApplied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	ms := sortedMigrations()
+	var done []Migration
+	for i := len(ms) - 1; i >= 0; i-- {
+		m := ms[i]
+		if m.Version <= target {
+			break
+		}
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := runMigration(ctx, db, m, false); err != nil {
+			return done, err
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+func runMigration(ctx context.Context, db *DB, m Migration, up bool) error {
+	stmts, direction := m.Up[db.Dialect()], "up"
+	if !up {
+		stmts, direction = m.Down[db.Dialect()], "down"
+	}
+	if stmts == nil {
+		return fmt.Errorf("migration %d (%s) has no %s statements for %s", m.Version, m.Name, direction, db.Dialect())
+	}
+
+	// Oracle commits implicitly around DDL, so a transaction only buys atomicity elsewhere.
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migration %d (%s) %s statement %d: %w", m.Version, m.Name, direction, i+1, err)
+		}
+	}
+	if up && m.Backfill != nil {
+		if err := m.Backfill(ctx, tx); err != nil {
+			return fmt.Errorf("migration %d (%s) backfill: %w", m.Version, m.Name, err)
+		}
+	}
+	if up {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO schema_migrations (version, name, checksum, applied_time)
+			VALUES (:1,:2,:3,:4)
+		`, m.Version, m.Name, m.Checksum(db.Dialect()), time.Now())
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version=:1`, m.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("record migration %d: %w", m.Version, err)
+	}
+	return tx.Commit()
+}
+
+func loadApplied(ctx context.Context, db *DB) (map[int]appliedMigration, error) {
+	if _, err := db.ExecContext(ctx, schemaMigrationsDDL[db.Dialect()]); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	rows, err := db.QueryContext(ctx, `SELECT version, checksum, applied_time FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		var at sql.NullTime
+		if err := rows.Scan(&version, &a.checksum, &at); err != nil {
+			return nil, err
+		}
+		a.applied = at.Time
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+func sortedMigrations() []Migration {
+	ms := append([]Migration(nil), migrations...)
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	return ms
+}
@@ -0,0 +1,1576 @@
+package repo
+
+import "context"
+
+// migrations lists every schema change in version order. Applied migrations
+// are checksummed, so never edit one that has shipped; append a new version instead.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: map[Dialect][]string{
+			// Existing Oracle deployments predate the migration table, so each
+			// statement tolerates only "already exists" errors and re-raises the rest.
+			DialectOracle: {
+				`BEGIN
+				   EXECUTE IMMEDIATE 'CREATE TABLE users (
+				     id VARCHAR2(36) PRIMARY KEY,
+				     username VARCHAR2(255) UNIQUE NOT NULL,
+				     password_hash VARCHAR2(255) NOT NULL,
+				     created_time TIMESTAMP DEFAULT SYSTIMESTAMP NOT NULL
+				   )';
+				 EXCEPTION
+				   WHEN OTHERS THEN
+				     IF SQLCODE != -955 THEN RAISE; END IF; -- ORA-00955 name already used
+				 END;`,
+				`BEGIN
+				   EXECUTE IMMEDIATE 'CREATE TABLE patients (
+				     id VARCHAR2(36) PRIMARY KEY,
+				     full_name VARCHAR2(255) NOT NULL,
+				     phone_number VARCHAR2(64),
+				     age NUMBER,
+				     gender VARCHAR2(50),
+				     chief_complaint VARCHAR2(4000),
+				     present_history VARCHAR2(4000),
+				     medical_history VARCHAR2(4000),
+				     observation VARCHAR2(4000),
+				     palpation VARCHAR2(4000),
+				     examination VARCHAR2(4000),
+				     rehab VARCHAR2(4000),
+				     diagnosis VARCHAR2(4000),
+				     created_time TIMESTAMP DEFAULT SYSTIMESTAMP NOT NULL,
+				     updated_time TIMESTAMP DEFAULT SYSTIMESTAMP NOT NULL,
+				     last_paid_amount NUMBER,
+				     status VARCHAR2(100)
+				   )';
+				 EXCEPTION
+				   WHEN OTHERS THEN
+				     IF SQLCODE != -955 THEN RAISE; END IF;
+				 END;`,
+				`BEGIN
+				   EXECUTE IMMEDIATE 'CREATE TABLE payments (
+				     id VARCHAR2(36) PRIMARY KEY,
+				     patient_id VARCHAR2(36) NOT NULL,
+				     amount NUMBER NOT NULL,
+				     payment_mode VARCHAR2(100),
+				     paid_date DATE,
+				     CONSTRAINT fk_payment_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				   )';
+				 EXCEPTION
+				   WHEN OTHERS THEN
+				     IF SQLCODE != -955 THEN RAISE; END IF;
+				 END;`,
+				`BEGIN EXECUTE IMMEDIATE 'ALTER TABLE patients ADD (owner_username VARCHAR2(255))';
+				 EXCEPTION WHEN OTHERS THEN IF SQLCODE != -1430 THEN RAISE; END IF; END;`, // ORA-01430 column already exists
+				`BEGIN EXECUTE IMMEDIATE 'ALTER TABLE payments ADD (owner_username VARCHAR2(255))';
+				 EXCEPTION WHEN OTHERS THEN IF SQLCODE != -1430 THEN RAISE; END IF; END;`,
+				`BEGIN EXECUTE IMMEDIATE 'CREATE INDEX idx_patients_phone ON patients(phone_number)';
+				 EXCEPTION WHEN OTHERS THEN IF SQLCODE NOT IN (-955, -1408) THEN RAISE; END IF; END;`,
+				`BEGIN EXECUTE IMMEDIATE 'CREATE INDEX idx_payments_patient ON payments(patient_id)';
+				 EXCEPTION WHEN OTHERS THEN IF SQLCODE NOT IN (-955, -1408) THEN RAISE; END IF; END;`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE IF NOT EXISTS users (
+				   id VARCHAR(36) PRIMARY KEY,
+				   username VARCHAR(255) UNIQUE NOT NULL,
+				   password_hash VARCHAR(255) NOT NULL,
+				   created_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+				 )`,
+				`CREATE TABLE IF NOT EXISTS patients (
+				   id VARCHAR(36) PRIMARY KEY,
+				   full_name VARCHAR(255) NOT NULL,
+				   phone_number VARCHAR(64),
+				   age INTEGER,
+				   gender VARCHAR(50),
+				   chief_complaint TEXT,
+				   present_history TEXT,
+				   medical_history TEXT,
+				   observation TEXT,
+				   palpation TEXT,
+				   examination TEXT,
+				   rehab TEXT,
+				   diagnosis TEXT,
+				   created_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+				   last_paid_amount NUMERIC(12,2),
+				   status VARCHAR(100),
+				   owner_username VARCHAR(255)
+				 )`,
+				`CREATE TABLE IF NOT EXISTS payments (
+				   id VARCHAR(36) PRIMARY KEY,
+				   patient_id VARCHAR(36) NOT NULL,
+				   amount NUMERIC(12,2) NOT NULL,
+				   payment_mode VARCHAR(100),
+				   paid_date DATE,
+				   owner_username VARCHAR(255),
+				   CONSTRAINT fk_payment_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX IF NOT EXISTS idx_patients_phone ON patients(phone_number)`,
+				`CREATE INDEX IF NOT EXISTS idx_payments_patient ON payments(patient_id)`,
+			},
+			DialectSQLite: {
+				`CREATE TABLE IF NOT EXISTS users (
+				   id TEXT PRIMARY KEY,
+				   username TEXT UNIQUE NOT NULL,
+				   password_hash TEXT NOT NULL,
+				   created_time TIMESTAMP NOT NULL
+				 )`,
+				`CREATE TABLE IF NOT EXISTS patients (
+				   id TEXT PRIMARY KEY,
+				   full_name TEXT NOT NULL,
+				   phone_number TEXT,
+				   age INTEGER,
+				   gender TEXT,
+				   chief_complaint TEXT,
+				   present_history TEXT,
+				   medical_history TEXT,
+				   observation TEXT,
+				   palpation TEXT,
+				   examination TEXT,
+				   rehab TEXT,
+				   diagnosis TEXT,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   last_paid_amount REAL,
+				   status TEXT,
+				   owner_username TEXT
+				 )`,
+				`CREATE TABLE IF NOT EXISTS payments (
+				   id TEXT PRIMARY KEY,
+				   patient_id TEXT NOT NULL,
+				   amount REAL NOT NULL,
+				   payment_mode TEXT,
+				   paid_date DATE,
+				   owner_username TEXT,
+				   CONSTRAINT fk_payment_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX IF NOT EXISTS idx_patients_phone ON patients(phone_number)`,
+				`CREATE INDEX IF NOT EXISTS idx_payments_patient ON payments(patient_id)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle: {
+				`DROP TABLE payments`,
+				`DROP TABLE patients`,
+				`DROP TABLE users`,
+			},
+			DialectPostgres: {
+				`DROP TABLE IF EXISTS payments`,
+				`DROP TABLE IF EXISTS patients`,
+				`DROP TABLE IF EXISTS users`,
+			},
+			DialectSQLite: {
+				`DROP TABLE IF EXISTS payments`,
+				`DROP TABLE IF EXISTS patients`,
+				`DROP TABLE IF EXISTS users`,
+			},
+		},
+	},
+	{
+		Version: 2,
+		Name:    "patients_last_paid_date",
+		Up: map[Dialect][]string{
+			DialectOracle:   {`ALTER TABLE patients ADD (last_paid_date DATE)`},
+			DialectPostgres: {`ALTER TABLE patients ADD COLUMN last_paid_date DATE`},
+			DialectSQLite:   {`ALTER TABLE patients ADD COLUMN last_paid_date DATE`},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {`ALTER TABLE patients DROP COLUMN last_paid_date`},
+			DialectPostgres: {`ALTER TABLE patients DROP COLUMN last_paid_date`},
+			DialectSQLite:   {`ALTER TABLE patients DROP COLUMN last_paid_date`},
+		},
+	},
+	{
+		Version: 3,
+		Name:    "patients_archived_time",
+		Up: map[Dialect][]string{
+			DialectOracle:   {`ALTER TABLE patients ADD (archived_time TIMESTAMP)`},
+			DialectPostgres: {`ALTER TABLE patients ADD COLUMN archived_time TIMESTAMP`},
+			DialectSQLite:   {`ALTER TABLE patients ADD COLUMN archived_time TIMESTAMP`},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {`ALTER TABLE patients DROP COLUMN archived_time`},
+			DialectPostgres: {`ALTER TABLE patients DROP COLUMN archived_time`},
+			DialectSQLite:   {`ALTER TABLE patients DROP COLUMN archived_time`},
+		},
+	},
+	{
+		Version: 4,
+		Name:    "patient_revisions",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`CREATE TABLE patient_revisions (
+				   id VARCHAR2(36) PRIMARY KEY,
+				   revision_id VARCHAR2(36) NOT NULL,
+				   patient_id VARCHAR2(36) NOT NULL,
+				   owner_username VARCHAR2(255) NOT NULL,
+				   changed_by VARCHAR2(255),
+				   changed_time TIMESTAMP NOT NULL,
+				   field_name VARCHAR2(64) NOT NULL,
+				   old_value VARCHAR2(4000),
+				   new_value VARCHAR2(4000),
+				   CONSTRAINT fk_revision_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_patient_revisions_patient ON patient_revisions(patient_id, changed_time)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE patient_revisions (
+				   id VARCHAR(36) PRIMARY KEY,
+				   revision_id VARCHAR(36) NOT NULL,
+				   patient_id VARCHAR(36) NOT NULL,
+				   owner_username VARCHAR(255) NOT NULL,
+				   changed_by VARCHAR(255),
+				   changed_time TIMESTAMP NOT NULL,
+				   field_name VARCHAR(64) NOT NULL,
+				   old_value TEXT,
+				   new_value TEXT,
+				   CONSTRAINT fk_revision_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_patient_revisions_patient ON patient_revisions(patient_id, changed_time)`,
+			},
+			DialectSQLite: {
+				`CREATE TABLE patient_revisions (
+				   id TEXT PRIMARY KEY,
+				   revision_id TEXT NOT NULL,
+				   patient_id TEXT NOT NULL,
+				   owner_username TEXT NOT NULL,
+				   changed_by TEXT,
+				   changed_time TIMESTAMP NOT NULL,
+				   field_name TEXT NOT NULL,
+				   old_value TEXT,
+				   new_value TEXT,
+				   CONSTRAINT fk_revision_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_patient_revisions_patient ON patient_revisions(patient_id, changed_time)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {`DROP TABLE patient_revisions`},
+			DialectPostgres: {`DROP TABLE patient_revisions`},
+			DialectSQLite:   {`DROP TABLE patient_revisions`},
+		},
+	},
+	{
+		Version: 5,
+		Name:    "row_versions",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`ALTER TABLE patients ADD (version NUMBER DEFAULT 1 NOT NULL)`,
+				`ALTER TABLE payments ADD (version NUMBER DEFAULT 1 NOT NULL)`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE patients ADD COLUMN version INTEGER DEFAULT 1 NOT NULL`,
+				`ALTER TABLE payments ADD COLUMN version INTEGER DEFAULT 1 NOT NULL`,
+			},
+			DialectSQLite: {
+				`ALTER TABLE patients ADD COLUMN version INTEGER DEFAULT 1 NOT NULL`,
+				`ALTER TABLE payments ADD COLUMN version INTEGER DEFAULT 1 NOT NULL`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle: {
+				`ALTER TABLE patients DROP COLUMN version`,
+				`ALTER TABLE payments DROP COLUMN version`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE patients DROP COLUMN version`,
+				`ALTER TABLE payments DROP COLUMN version`,
+			},
+			DialectSQLite: {
+				`ALTER TABLE patients DROP COLUMN version`,
+				`ALTER TABLE payments DROP COLUMN version`,
+			},
+		},
+	},
+	{
+		Version: 6,
+		Name:    "patients_list_indexes",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`CREATE INDEX idx_patients_owner_created ON patients(owner_username, created_time, id)`,
+				`CREATE INDEX idx_patients_owner_updated ON patients(owner_username, updated_time, id)`,
+			},
+			DialectPostgres: {
+				`CREATE INDEX idx_patients_owner_created ON patients(owner_username, created_time, id)`,
+				`CREATE INDEX idx_patients_owner_updated ON patients(owner_username, updated_time, id)`,
+			},
+			DialectSQLite: {
+				`CREATE INDEX idx_patients_owner_created ON patients(owner_username, created_time, id)`,
+				`CREATE INDEX idx_patients_owner_updated ON patients(owner_username, updated_time, id)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle: {
+				`DROP INDEX idx_patients_owner_created`,
+				`DROP INDEX idx_patients_owner_updated`,
+			},
+			DialectPostgres: {
+				`DROP INDEX idx_patients_owner_created`,
+				`DROP INDEX idx_patients_owner_updated`,
+			},
+			DialectSQLite: {
+				`DROP INDEX idx_patients_owner_created`,
+				`DROP INDEX idx_patients_owner_updated`,
+			},
+		},
+	},
+	{
+		Version: 7,
+		Name:    "patient_search_terms",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`CREATE TABLE patient_search_terms (
+				   patient_id VARCHAR2(36) NOT NULL,
+				   owner_username VARCHAR2(255) NOT NULL,
+				   field_name VARCHAR2(64) NOT NULL,
+				   term VARCHAR2(100) NOT NULL,
+				   CONSTRAINT fk_search_term_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_patient_search_terms ON patient_search_terms(owner_username, term)`,
+				`CREATE INDEX idx_patient_search_patient ON patient_search_terms(patient_id)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE patient_search_terms (
+				   patient_id VARCHAR(36) NOT NULL,
+				   owner_username VARCHAR(255) NOT NULL,
+				   field_name VARCHAR(64) NOT NULL,
+				   term VARCHAR(100) NOT NULL,
+				   CONSTRAINT fk_search_term_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				// pattern ops let LIKE 'prefix%' use the index under any collation
+				`CREATE INDEX idx_patient_search_terms ON patient_search_terms(owner_username, term varchar_pattern_ops)`,
+				`CREATE INDEX idx_patient_search_patient ON patient_search_terms(patient_id)`,
+			},
+			DialectSQLite: {
+				// NOCASE lets SQLite's LIKE optimisation turn prefix matches into index ranges
+				`CREATE TABLE patient_search_terms (
+				   patient_id TEXT NOT NULL,
+				   owner_username TEXT NOT NULL,
+				   field_name TEXT NOT NULL,
+				   term TEXT NOT NULL COLLATE NOCASE,
+				   CONSTRAINT fk_search_term_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_patient_search_terms ON patient_search_terms(owner_username, term)`,
+				`CREATE INDEX idx_patient_search_patient ON patient_search_terms(patient_id)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {`DROP TABLE patient_search_terms`},
+			DialectPostgres: {`DROP TABLE patient_search_terms`},
+			DialectSQLite:   {`DROP TABLE patient_search_terms`},
+		},
+		Backfill: func(ctx context.Context, tx *Tx) error {
+			_, err := rebuildSearchIndex(ctx, tx)
+			return err
+		},
+	},
+	{
+		// Existing amounts were entered in rupees; they become paise in INR.
+		Version: 8,
+		Name:    "money_minor_units",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`ALTER TABLE payments ADD (amount_minor NUMBER(19), currency VARCHAR2(3))`,
+				`UPDATE payments SET amount_minor = ROUND(amount * 100), currency = 'INR'`,
+				`ALTER TABLE payments MODIFY (amount_minor NOT NULL, currency NOT NULL)`,
+				`ALTER TABLE payments DROP COLUMN amount`,
+				`ALTER TABLE patients ADD (last_paid_minor NUMBER(19), last_paid_currency VARCHAR2(3))`,
+				`UPDATE patients SET last_paid_minor = ROUND(last_paid_amount * 100), last_paid_currency = 'INR'
+				  WHERE last_paid_amount IS NOT NULL`,
+				`ALTER TABLE patients DROP COLUMN last_paid_amount`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE payments ADD COLUMN amount_minor BIGINT, ADD COLUMN currency VARCHAR(3)`,
+				`UPDATE payments SET amount_minor = ROUND(amount * 100), currency = 'INR'`,
+				`ALTER TABLE payments ALTER COLUMN amount_minor SET NOT NULL, ALTER COLUMN currency SET NOT NULL`,
+				`ALTER TABLE payments DROP COLUMN amount`,
+				`ALTER TABLE patients ADD COLUMN last_paid_minor BIGINT, ADD COLUMN last_paid_currency VARCHAR(3)`,
+				`UPDATE patients SET last_paid_minor = ROUND(last_paid_amount * 100), last_paid_currency = 'INR'
+				  WHERE last_paid_amount IS NOT NULL`,
+				`ALTER TABLE patients DROP COLUMN last_paid_amount`,
+			},
+			DialectSQLite: {
+				`ALTER TABLE payments ADD COLUMN amount_minor INTEGER NOT NULL DEFAULT 0`,
+				`ALTER TABLE payments ADD COLUMN currency TEXT NOT NULL DEFAULT 'INR'`,
+				`UPDATE payments SET amount_minor = CAST(ROUND(amount * 100) AS INTEGER), currency = 'INR'`,
+				`ALTER TABLE payments DROP COLUMN amount`,
+				`ALTER TABLE patients ADD COLUMN last_paid_minor INTEGER`,
+				`ALTER TABLE patients ADD COLUMN last_paid_currency TEXT`,
+				`UPDATE patients SET last_paid_minor = CAST(ROUND(last_paid_amount * 100) AS INTEGER), last_paid_currency = 'INR'
+				  WHERE last_paid_amount IS NOT NULL`,
+				`ALTER TABLE patients DROP COLUMN last_paid_amount`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle: {
+				`ALTER TABLE payments ADD (amount NUMBER)`,
+				`UPDATE payments SET amount = amount_minor / 100`,
+				`ALTER TABLE payments MODIFY (amount NOT NULL)`,
+				`ALTER TABLE payments DROP (amount_minor, currency)`,
+				`ALTER TABLE patients ADD (last_paid_amount NUMBER)`,
+				`UPDATE patients SET last_paid_amount = last_paid_minor / 100`,
+				`ALTER TABLE patients DROP (last_paid_minor, last_paid_currency)`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE payments ADD COLUMN amount NUMERIC(12,2)`,
+				`UPDATE payments SET amount = amount_minor / 100.0`,
+				`ALTER TABLE payments ALTER COLUMN amount SET NOT NULL`,
+				`ALTER TABLE payments DROP COLUMN amount_minor, DROP COLUMN currency`,
+				`ALTER TABLE patients ADD COLUMN last_paid_amount NUMERIC(12,2)`,
+				`UPDATE patients SET last_paid_amount = last_paid_minor / 100.0`,
+				`ALTER TABLE patients DROP COLUMN last_paid_minor, DROP COLUMN last_paid_currency`,
+			},
+			DialectSQLite: {
+				`ALTER TABLE payments ADD COLUMN amount REAL NOT NULL DEFAULT 0`,
+				`UPDATE payments SET amount = amount_minor / 100.0`,
+				`ALTER TABLE payments DROP COLUMN amount_minor`,
+				`ALTER TABLE payments DROP COLUMN currency`,
+				`ALTER TABLE patients ADD COLUMN last_paid_amount REAL`,
+				`UPDATE patients SET last_paid_amount = last_paid_minor / 100.0`,
+				`ALTER TABLE patients DROP COLUMN last_paid_minor`,
+				`ALTER TABLE patients DROP COLUMN last_paid_currency`,
+			},
+		},
+	},
+	{
+		Version: 9,
+		Name:    "billing",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`CREATE TABLE fees (
+				   owner_username VARCHAR2(255) NOT NULL,
+				   code VARCHAR2(32) NOT NULL,
+				   name VARCHAR2(255) NOT NULL,
+				   unit VARCHAR2(20) NOT NULL,
+				   amount_minor NUMBER(19) NOT NULL,
+				   currency VARCHAR2(3) NOT NULL,
+				   CONSTRAINT pk_fees PRIMARY KEY (owner_username, code)
+				 )`,
+				`CREATE TABLE invoices (
+				   id VARCHAR2(36) PRIMARY KEY,
+				   patient_id VARCHAR2(36) NOT NULL,
+				   owner_username VARCHAR2(255) NOT NULL,
+				   status VARCHAR2(20) NOT NULL,
+				   issued_date DATE NOT NULL,
+				   due_date DATE,
+				   notes VARCHAR2(4000),
+				   total_minor NUMBER(19) NOT NULL,
+				   currency VARCHAR2(3) NOT NULL,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version NUMBER(10) DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_invoice_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_invoices_patient ON invoices(owner_username, patient_id, issued_date)`,
+				`CREATE TABLE invoice_lines (
+				   invoice_id VARCHAR2(36) NOT NULL,
+				   line_no NUMBER(10) NOT NULL,
+				   fee_code VARCHAR2(32),
+				   description VARCHAR2(255) NOT NULL,
+				   quantity NUMBER(10) NOT NULL,
+				   unit_price_minor NUMBER(19) NOT NULL,
+				   amount_minor NUMBER(19) NOT NULL,
+				   service_date DATE,
+				   CONSTRAINT pk_invoice_lines PRIMARY KEY (invoice_id, line_no),
+				   CONSTRAINT fk_line_invoice FOREIGN KEY (invoice_id) REFERENCES invoices(id) ON DELETE CASCADE
+				 )`,
+				`CREATE TABLE payment_allocations (
+				   payment_id VARCHAR2(36) NOT NULL,
+				   invoice_id VARCHAR2(36) NOT NULL,
+				   owner_username VARCHAR2(255) NOT NULL,
+				   amount_minor NUMBER(19) NOT NULL,
+				   CONSTRAINT pk_payment_allocations PRIMARY KEY (payment_id, invoice_id),
+				   CONSTRAINT fk_allocation_payment FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE CASCADE,
+				   CONSTRAINT fk_allocation_invoice FOREIGN KEY (invoice_id) REFERENCES invoices(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_payment_allocations_invoice ON payment_allocations(invoice_id)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE fees (
+				   owner_username VARCHAR(255) NOT NULL,
+				   code VARCHAR(32) NOT NULL,
+				   name VARCHAR(255) NOT NULL,
+				   unit VARCHAR(20) NOT NULL,
+				   amount_minor BIGINT NOT NULL,
+				   currency VARCHAR(3) NOT NULL,
+				   CONSTRAINT pk_fees PRIMARY KEY (owner_username, code)
+				 )`,
+				`CREATE TABLE invoices (
+				   id VARCHAR(36) PRIMARY KEY,
+				   patient_id VARCHAR(36) NOT NULL,
+				   owner_username VARCHAR(255) NOT NULL,
+				   status VARCHAR(20) NOT NULL,
+				   issued_date DATE NOT NULL,
+				   due_date DATE,
+				   notes TEXT,
+				   total_minor BIGINT NOT NULL,
+				   currency VARCHAR(3) NOT NULL,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_invoice_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_invoices_patient ON invoices(owner_username, patient_id, issued_date)`,
+				`CREATE TABLE invoice_lines (
+				   invoice_id VARCHAR(36) NOT NULL,
+				   line_no INTEGER NOT NULL,
+				   fee_code VARCHAR(32),
+				   description VARCHAR(255) NOT NULL,
+				   quantity INTEGER NOT NULL,
+				   unit_price_minor BIGINT NOT NULL,
+				   amount_minor BIGINT NOT NULL,
+				   service_date DATE,
+				   CONSTRAINT pk_invoice_lines PRIMARY KEY (invoice_id, line_no),
+				   CONSTRAINT fk_line_invoice FOREIGN KEY (invoice_id) REFERENCES invoices(id) ON DELETE CASCADE
+				 )`,
+				`CREATE TABLE payment_allocations (
+				   payment_id VARCHAR(36) NOT NULL,
+				   invoice_id VARCHAR(36) NOT NULL,
+				   owner_username VARCHAR(255) NOT NULL,
+				   amount_minor BIGINT NOT NULL,
+				   CONSTRAINT pk_payment_allocations PRIMARY KEY (payment_id, invoice_id),
+				   CONSTRAINT fk_allocation_payment FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE CASCADE,
+				   CONSTRAINT fk_allocation_invoice FOREIGN KEY (invoice_id) REFERENCES invoices(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_payment_allocations_invoice ON payment_allocations(invoice_id)`,
+			},
+			DialectSQLite: {
+				`CREATE TABLE fees (
+				   owner_username TEXT NOT NULL,
+				   code TEXT NOT NULL,
+				   name TEXT NOT NULL,
+				   unit TEXT NOT NULL,
+				   amount_minor INTEGER NOT NULL,
+				   currency TEXT NOT NULL,
+				   CONSTRAINT pk_fees PRIMARY KEY (owner_username, code)
+				 )`,
+				`CREATE TABLE invoices (
+				   id TEXT PRIMARY KEY,
+				   patient_id TEXT NOT NULL,
+				   owner_username TEXT NOT NULL,
+				   status TEXT NOT NULL,
+				   issued_date DATE NOT NULL,
+				   due_date DATE,
+				   notes TEXT,
+				   total_minor INTEGER NOT NULL,
+				   currency TEXT NOT NULL,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_invoice_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_invoices_patient ON invoices(owner_username, patient_id, issued_date)`,
+				`CREATE TABLE invoice_lines (
+				   invoice_id TEXT NOT NULL,
+				   line_no INTEGER NOT NULL,
+				   fee_code TEXT,
+				   description TEXT NOT NULL,
+				   quantity INTEGER NOT NULL,
+				   unit_price_minor INTEGER NOT NULL,
+				   amount_minor INTEGER NOT NULL,
+				   service_date DATE,
+				   CONSTRAINT pk_invoice_lines PRIMARY KEY (invoice_id, line_no),
+				   CONSTRAINT fk_line_invoice FOREIGN KEY (invoice_id) REFERENCES invoices(id) ON DELETE CASCADE
+				 )`,
+				`CREATE TABLE payment_allocations (
+				   payment_id TEXT NOT NULL,
+				   invoice_id TEXT NOT NULL,
+				   owner_username TEXT NOT NULL,
+				   amount_minor INTEGER NOT NULL,
+				   CONSTRAINT pk_payment_allocations PRIMARY KEY (payment_id, invoice_id),
+				   CONSTRAINT fk_allocation_payment FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE CASCADE,
+				   CONSTRAINT fk_allocation_invoice FOREIGN KEY (invoice_id) REFERENCES invoices(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_payment_allocations_invoice ON payment_allocations(invoice_id)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle: {
+				`DROP TABLE payment_allocations`,
+				`DROP TABLE invoice_lines`,
+				`DROP TABLE invoices`,
+				`DROP TABLE fees`,
+			},
+			DialectPostgres: {
+				`DROP TABLE payment_allocations`,
+				`DROP TABLE invoice_lines`,
+				`DROP TABLE invoices`,
+				`DROP TABLE fees`,
+			},
+			DialectSQLite: {
+				`DROP TABLE payment_allocations`,
+				`DROP TABLE invoice_lines`,
+				`DROP TABLE invoices`,
+				`DROP TABLE fees`,
+			},
+		},
+	},
+	{
+		Version: 10,
+		Name:    "appointments",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`CREATE TABLE appointments (
+				   id VARCHAR2(36) PRIMARY KEY,
+				   patient_id VARCHAR2(36) NOT NULL,
+				   owner_username VARCHAR2(255) NOT NULL,
+				   therapist VARCHAR2(255) NOT NULL,
+				   start_time TIMESTAMP NOT NULL,
+				   end_time TIMESTAMP NOT NULL,
+				   status VARCHAR2(20) NOT NULL,
+				   series_id VARCHAR2(36),
+				   notes VARCHAR2(4000),
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version NUMBER(10) DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_appointment_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_appointments_therapist ON appointments(owner_username, therapist, start_time)`,
+				`CREATE INDEX idx_appointments_start ON appointments(owner_username, start_time)`,
+				`CREATE INDEX idx_appointments_patient ON appointments(patient_id, start_time)`,
+				`CREATE INDEX idx_appointments_series ON appointments(series_id)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE appointments (
+				   id VARCHAR(36) PRIMARY KEY,
+				   patient_id VARCHAR(36) NOT NULL,
+				   owner_username VARCHAR(255) NOT NULL,
+				   therapist VARCHAR(255) NOT NULL,
+				   start_time TIMESTAMP NOT NULL,
+				   end_time TIMESTAMP NOT NULL,
+				   status VARCHAR(20) NOT NULL,
+				   series_id VARCHAR(36),
+				   notes TEXT,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_appointment_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_appointments_therapist ON appointments(owner_username, therapist, start_time)`,
+				`CREATE INDEX idx_appointments_start ON appointments(owner_username, start_time)`,
+				`CREATE INDEX idx_appointments_patient ON appointments(patient_id, start_time)`,
+				`CREATE INDEX idx_appointments_series ON appointments(series_id)`,
+			},
+			DialectSQLite: {
+				`CREATE TABLE appointments (
+				   id TEXT PRIMARY KEY,
+				   patient_id TEXT NOT NULL,
+				   owner_username TEXT NOT NULL,
+				   therapist TEXT NOT NULL,
+				   start_time TIMESTAMP NOT NULL,
+				   end_time TIMESTAMP NOT NULL,
+				   status TEXT NOT NULL,
+				   series_id TEXT,
+				   notes TEXT,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_appointment_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_appointments_therapist ON appointments(owner_username, therapist, start_time)`,
+				`CREATE INDEX idx_appointments_start ON appointments(owner_username, start_time)`,
+				`CREATE INDEX idx_appointments_patient ON appointments(patient_id, start_time)`,
+				`CREATE INDEX idx_appointments_series ON appointments(series_id)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {`DROP TABLE appointments`},
+			DialectPostgres: {`DROP TABLE appointments`},
+			DialectSQLite:   {`DROP TABLE appointments`},
+		},
+	},
+	{
+		// Patients whose rehab cell holds a legacy exercise table get it as prescriptions.
+		Version: 11,
+		Name:    "exercise_prescriptions",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`CREATE TABLE exercise_prescriptions (
+				   id VARCHAR2(36) PRIMARY KEY,
+				   patient_id VARCHAR2(36) NOT NULL,
+				   owner_username VARCHAR2(255) NOT NULL,
+				   position NUMBER(10) NOT NULL,
+				   exercise VARCHAR2(255) NOT NULL,
+				   sets NUMBER(10),
+				   reps NUMBER(10),
+				   hold_seconds NUMBER(10),
+				   frequency VARCHAR2(255),
+				   progression VARCHAR2(4000),
+				   start_date DATE,
+				   stop_date DATE,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version NUMBER(10) DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_exercise_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_exercise_prescriptions_patient ON exercise_prescriptions(patient_id, position)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE exercise_prescriptions (
+				   id VARCHAR(36) PRIMARY KEY,
+				   patient_id VARCHAR(36) NOT NULL,
+				   owner_username VARCHAR(255) NOT NULL,
+				   position INTEGER NOT NULL,
+				   exercise VARCHAR(255) NOT NULL,
+				   sets INTEGER,
+				   reps INTEGER,
+				   hold_seconds INTEGER,
+				   frequency VARCHAR(255),
+				   progression TEXT,
+				   start_date DATE,
+				   stop_date DATE,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_exercise_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_exercise_prescriptions_patient ON exercise_prescriptions(patient_id, position)`,
+			},
+			DialectSQLite: {
+				`CREATE TABLE exercise_prescriptions (
+				   id TEXT PRIMARY KEY,
+				   patient_id TEXT NOT NULL,
+				   owner_username TEXT NOT NULL,
+				   position INTEGER NOT NULL,
+				   exercise TEXT NOT NULL,
+				   sets INTEGER,
+				   reps INTEGER,
+				   hold_seconds INTEGER,
+				   frequency TEXT,
+				   progression TEXT,
+				   start_date DATE,
+				   stop_date DATE,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_exercise_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_exercise_prescriptions_patient ON exercise_prescriptions(patient_id, position)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {`DROP TABLE exercise_prescriptions`},
+			DialectPostgres: {`DROP TABLE exercise_prescriptions`},
+			DialectSQLite:   {`DROP TABLE exercise_prescriptions`},
+		},
+		Backfill: backfillExercisesFromRehab,
+	},
+	{
+		// The library starts with the distinct exercises of the legacy rehab tables.
+		Version: 12,
+		Name:    "exercise_library",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`CREATE TABLE library_exercises (
+				   id VARCHAR2(36) PRIMARY KEY,
+				   owner_username VARCHAR2(255) NOT NULL,
+				   name VARCHAR2(255) NOT NULL,
+				   name_key VARCHAR2(255) NOT NULL,
+				   body_region VARCHAR2(255),
+				   description VARCHAR2(4000),
+				   sets NUMBER(10),
+				   reps NUMBER(10),
+				   hold_seconds NUMBER(10),
+				   frequency VARCHAR2(255),
+				   tags VARCHAR2(4000),
+				   attachments VARCHAR2(4000),
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version NUMBER(10) DEFAULT 1 NOT NULL
+				 )`,
+				`CREATE UNIQUE INDEX idx_library_exercises_name ON library_exercises(owner_username, name_key)`,
+				`ALTER TABLE exercise_prescriptions ADD (library_id VARCHAR2(36))`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE library_exercises (
+				   id VARCHAR(36) PRIMARY KEY,
+				   owner_username VARCHAR(255) NOT NULL,
+				   name VARCHAR(255) NOT NULL,
+				   name_key VARCHAR(255) NOT NULL,
+				   body_region VARCHAR(255),
+				   description TEXT,
+				   sets INTEGER,
+				   reps INTEGER,
+				   hold_seconds INTEGER,
+				   frequency VARCHAR(255),
+				   tags TEXT,
+				   attachments TEXT,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL
+				 )`,
+				`CREATE UNIQUE INDEX idx_library_exercises_name ON library_exercises(owner_username, name_key)`,
+				`ALTER TABLE exercise_prescriptions ADD COLUMN library_id VARCHAR(36)`,
+			},
+			DialectSQLite: {
+				`CREATE TABLE library_exercises (
+				   id TEXT PRIMARY KEY,
+				   owner_username TEXT NOT NULL,
+				   name TEXT NOT NULL,
+				   name_key TEXT NOT NULL,
+				   body_region TEXT,
+				   description TEXT,
+				   sets INTEGER,
+				   reps INTEGER,
+				   hold_seconds INTEGER,
+				   frequency TEXT,
+				   tags TEXT,
+				   attachments TEXT,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL
+				 )`,
+				`CREATE UNIQUE INDEX idx_library_exercises_name ON library_exercises(owner_username, name_key)`,
+				`ALTER TABLE exercise_prescriptions ADD COLUMN library_id TEXT`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {`ALTER TABLE exercise_prescriptions DROP COLUMN library_id`, `DROP TABLE library_exercises`},
+			DialectPostgres: {`ALTER TABLE exercise_prescriptions DROP COLUMN library_id`, `DROP TABLE library_exercises`},
+			DialectSQLite:   {`ALTER TABLE exercise_prescriptions DROP COLUMN library_id`, `DROP TABLE library_exercises`},
+		},
+		Backfill: func(ctx context.Context, tx *Tx) error {
+			_, err := seedExerciseLibrary(ctx, tx)
+			return err
+		},
+	},
+	{
+		Version: 13,
+		Name:    "measurements",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`CREATE TABLE measurements (
+				   id VARCHAR2(36) PRIMARY KEY,
+				   patient_id VARCHAR2(36) NOT NULL,
+				   owner_username VARCHAR2(255) NOT NULL,
+				   appointment_id VARCHAR2(36),
+				   kind VARCHAR2(255) NOT NULL,
+				   joint VARCHAR2(255),
+				   side VARCHAR2(255),
+				   movement VARCHAR2(255),
+				   site VARCHAR2(255),
+				   name VARCHAR2(255),
+				   value NUMBER NOT NULL,
+				   grade VARCHAR2(255),
+				   unit VARCHAR2(255),
+				   measured_at TIMESTAMP NOT NULL,
+				   notes VARCHAR2(4000),
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version NUMBER(10) DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_measurement_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_measurements_patient ON measurements(patient_id, kind, measured_at)`,
+				`CREATE INDEX idx_measurements_appointment ON measurements(appointment_id)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE measurements (
+				   id VARCHAR(36) PRIMARY KEY,
+				   patient_id VARCHAR(36) NOT NULL,
+				   owner_username VARCHAR(255) NOT NULL,
+				   appointment_id VARCHAR(36),
+				   kind VARCHAR(255) NOT NULL,
+				   joint VARCHAR(255),
+				   side VARCHAR(255),
+				   movement VARCHAR(255),
+				   site VARCHAR(255),
+				   name VARCHAR(255),
+				   value DOUBLE PRECISION NOT NULL,
+				   grade VARCHAR(255),
+				   unit VARCHAR(255),
+				   measured_at TIMESTAMP NOT NULL,
+				   notes TEXT,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_measurement_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_measurements_patient ON measurements(patient_id, kind, measured_at)`,
+				`CREATE INDEX idx_measurements_appointment ON measurements(appointment_id)`,
+			},
+			DialectSQLite: {
+				`CREATE TABLE measurements (
+				   id TEXT PRIMARY KEY,
+				   patient_id TEXT NOT NULL,
+				   owner_username TEXT NOT NULL,
+				   appointment_id TEXT,
+				   kind TEXT NOT NULL,
+				   joint TEXT,
+				   side TEXT,
+				   movement TEXT,
+				   site TEXT,
+				   name TEXT,
+				   value REAL NOT NULL,
+				   grade TEXT,
+				   unit TEXT,
+				   measured_at TIMESTAMP NOT NULL,
+				   notes TEXT,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_measurement_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_measurements_patient ON measurements(patient_id, kind, measured_at)`,
+				`CREATE INDEX idx_measurements_appointment ON measurements(appointment_id)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {`DROP TABLE measurements`},
+			DialectPostgres: {`DROP TABLE measurements`},
+			DialectSQLite:   {`DROP TABLE measurements`},
+		},
+	},
+	{
+		// Built-in questionnaires are stored under owner "*" and seeded from questionnaires/*.json.
+		Version: 14,
+		Name:    "questionnaires",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`CREATE TABLE questionnaires (
+				   owner_username VARCHAR2(255) NOT NULL,
+				   code VARCHAR2(32) NOT NULL,
+				   name VARCHAR2(255) NOT NULL,
+				   description VARCHAR2(4000),
+				   options VARCHAR2(4000),
+				   method VARCHAR2(32) NOT NULL,
+				   max_missing NUMBER(10) NOT NULL,
+				   higher_is_better NUMBER(10) DEFAULT 0 NOT NULL,
+				   bands VARCHAR2(4000),
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version NUMBER(10) DEFAULT 1 NOT NULL,
+				   PRIMARY KEY (owner_username, code)
+				 )`,
+				`CREATE TABLE questionnaire_items (
+				   owner_username VARCHAR2(255) NOT NULL,
+				   code VARCHAR2(32) NOT NULL,
+				   position NUMBER(10) NOT NULL,
+				   item_id VARCHAR2(32) NOT NULL,
+				   text VARCHAR2(1000) NOT NULL,
+				   options VARCHAR2(4000),
+				   PRIMARY KEY (owner_username, code, position)
+				 )`,
+				`CREATE TABLE questionnaire_responses (
+				   id VARCHAR2(36) PRIMARY KEY,
+				   patient_id VARCHAR2(36) NOT NULL,
+				   owner_username VARCHAR2(255) NOT NULL,
+				   code VARCHAR2(32) NOT NULL,
+				   name VARCHAR2(255) NOT NULL,
+				   definition_version NUMBER(10) NOT NULL,
+				   answers VARCHAR2(4000) NOT NULL,
+				   score NUMBER,
+				   band VARCHAR2(255),
+				   answered NUMBER(10) NOT NULL,
+				   taken_at TIMESTAMP NOT NULL,
+				   notes VARCHAR2(4000),
+				   created_time TIMESTAMP NOT NULL,
+				   version NUMBER(10) DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_questionnaire_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_questionnaire_responses_patient ON questionnaire_responses(patient_id, code, taken_at)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE questionnaires (
+				   owner_username VARCHAR(255) NOT NULL,
+				   code VARCHAR(32) NOT NULL,
+				   name VARCHAR(255) NOT NULL,
+				   description TEXT,
+				   options TEXT,
+				   method VARCHAR(32) NOT NULL,
+				   max_missing INTEGER NOT NULL,
+				   higher_is_better INTEGER DEFAULT 0 NOT NULL,
+				   bands TEXT,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   PRIMARY KEY (owner_username, code)
+				 )`,
+				`CREATE TABLE questionnaire_items (
+				   owner_username VARCHAR(255) NOT NULL,
+				   code VARCHAR(32) NOT NULL,
+				   position INTEGER NOT NULL,
+				   item_id VARCHAR(32) NOT NULL,
+				   text VARCHAR(1000) NOT NULL,
+				   options TEXT,
+				   PRIMARY KEY (owner_username, code, position)
+				 )`,
+				`CREATE TABLE questionnaire_responses (
+				   id VARCHAR(36) PRIMARY KEY,
+				   patient_id VARCHAR(36) NOT NULL,
+				   owner_username VARCHAR(255) NOT NULL,
+				   code VARCHAR(32) NOT NULL,
+				   name VARCHAR(255) NOT NULL,
+				   definition_version INTEGER NOT NULL,
+				   answers TEXT NOT NULL,
+				   score DOUBLE PRECISION,
+				   band VARCHAR(255),
+				   answered INTEGER NOT NULL,
+				   taken_at TIMESTAMP NOT NULL,
+				   notes TEXT,
+				   created_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_questionnaire_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_questionnaire_responses_patient ON questionnaire_responses(patient_id, code, taken_at)`,
+			},
+			DialectSQLite: {
+				`CREATE TABLE questionnaires (
+				   owner_username TEXT NOT NULL,
+				   code TEXT NOT NULL,
+				   name TEXT NOT NULL,
+				   description TEXT,
+				   options TEXT,
+				   method TEXT NOT NULL,
+				   max_missing INTEGER NOT NULL,
+				   higher_is_better INTEGER DEFAULT 0 NOT NULL,
+				   bands TEXT,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   PRIMARY KEY (owner_username, code)
+				 )`,
+				`CREATE TABLE questionnaire_items (
+				   owner_username TEXT NOT NULL,
+				   code TEXT NOT NULL,
+				   position INTEGER NOT NULL,
+				   item_id TEXT NOT NULL,
+				   text TEXT NOT NULL,
+				   options TEXT,
+				   PRIMARY KEY (owner_username, code, position)
+				 )`,
+				`CREATE TABLE questionnaire_responses (
+				   id TEXT PRIMARY KEY,
+				   patient_id TEXT NOT NULL,
+				   owner_username TEXT NOT NULL,
+				   code TEXT NOT NULL,
+				   name TEXT NOT NULL,
+				   definition_version INTEGER NOT NULL,
+				   answers TEXT NOT NULL,
+				   score REAL,
+				   band TEXT,
+				   answered INTEGER NOT NULL,
+				   taken_at TIMESTAMP NOT NULL,
+				   notes TEXT,
+				   created_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_questionnaire_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_questionnaire_responses_patient ON questionnaire_responses(patient_id, code, taken_at)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {`DROP TABLE questionnaire_responses`, `DROP TABLE questionnaire_items`, `DROP TABLE questionnaires`},
+			DialectPostgres: {`DROP TABLE questionnaire_responses`, `DROP TABLE questionnaire_items`, `DROP TABLE questionnaires`},
+			DialectSQLite:   {`DROP TABLE questionnaire_responses`, `DROP TABLE questionnaire_items`, `DROP TABLE questionnaires`},
+		},
+		Backfill: func(ctx context.Context, tx *Tx) error {
+			_, err := seedBuiltInQuestionnaires(ctx, tx)
+			return err
+		},
+	},
+	{
+		Version: 15,
+		Name:    "visit_notes",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`CREATE TABLE visit_notes (
+				   id VARCHAR2(36) PRIMARY KEY,
+				   patient_id VARCHAR2(36) NOT NULL,
+				   owner_username VARCHAR2(255) NOT NULL,
+				   appointment_id VARCHAR2(36),
+				   therapist VARCHAR2(255) NOT NULL,
+				   visited_at TIMESTAMP NOT NULL,
+				   subjective VARCHAR2(4000),
+				   objective VARCHAR2(4000),
+				   assessment VARCHAR2(4000),
+				   plan VARCHAR2(4000),
+				   treatment VARCHAR2(4000),
+				   modalities VARCHAR2(4000),
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version NUMBER(10) DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_visit_note_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_visit_notes_patient ON visit_notes(patient_id, visited_at)`,
+				`CREATE INDEX idx_visit_notes_appointment ON visit_notes(appointment_id)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE visit_notes (
+				   id VARCHAR(36) PRIMARY KEY,
+				   patient_id VARCHAR(36) NOT NULL,
+				   owner_username VARCHAR(255) NOT NULL,
+				   appointment_id VARCHAR(36),
+				   therapist VARCHAR(255) NOT NULL,
+				   visited_at TIMESTAMP NOT NULL,
+				   subjective TEXT,
+				   objective TEXT,
+				   assessment TEXT,
+				   plan TEXT,
+				   treatment TEXT,
+				   modalities TEXT,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_visit_note_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_visit_notes_patient ON visit_notes(patient_id, visited_at)`,
+				`CREATE INDEX idx_visit_notes_appointment ON visit_notes(appointment_id)`,
+			},
+			DialectSQLite: {
+				`CREATE TABLE visit_notes (
+				   id TEXT PRIMARY KEY,
+				   patient_id TEXT NOT NULL,
+				   owner_username TEXT NOT NULL,
+				   appointment_id TEXT,
+				   therapist TEXT NOT NULL,
+				   visited_at TIMESTAMP NOT NULL,
+				   subjective TEXT,
+				   objective TEXT,
+				   assessment TEXT,
+				   plan TEXT,
+				   treatment TEXT,
+				   modalities TEXT,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_visit_note_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_visit_notes_patient ON visit_notes(patient_id, visited_at)`,
+				`CREATE INDEX idx_visit_notes_appointment ON visit_notes(appointment_id)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {`DROP TABLE visit_notes`},
+			DialectPostgres: {`DROP TABLE visit_notes`},
+			DialectSQLite:   {`DROP TABLE visit_notes`},
+		},
+	},
+	{
+		// Each patient's clinical fields become their first episode of care; the patient
+		// row keeps a copy of the current episode's.
+		Version: 16,
+		Name:    "episodes",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`CREATE TABLE episodes (
+				   id VARCHAR2(36) PRIMARY KEY,
+				   patient_id VARCHAR2(36) NOT NULL,
+				   owner_username VARCHAR2(255) NOT NULL,
+				   title VARCHAR2(255),
+				   chief_complaint VARCHAR2(4000),
+				   present_history VARCHAR2(4000),
+				   observation VARCHAR2(4000),
+				   palpation VARCHAR2(4000),
+				   examination VARCHAR2(4000),
+				   diagnosis VARCHAR2(4000),
+				   plan VARCHAR2(4000),
+				   status VARCHAR2(16) NOT NULL,
+				   opened_on DATE NOT NULL,
+				   closed_on DATE,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version NUMBER(10) DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_episode_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_episodes_patient ON episodes(patient_id, opened_on)`,
+				`ALTER TABLE payments ADD (episode_id VARCHAR2(36))`,
+				`CREATE INDEX idx_payments_episode ON payments(episode_id)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE episodes (
+				   id VARCHAR(36) PRIMARY KEY,
+				   patient_id VARCHAR(36) NOT NULL,
+				   owner_username VARCHAR(255) NOT NULL,
+				   title VARCHAR(255),
+				   chief_complaint TEXT,
+				   present_history TEXT,
+				   observation TEXT,
+				   palpation TEXT,
+				   examination TEXT,
+				   diagnosis TEXT,
+				   plan TEXT,
+				   status VARCHAR(16) NOT NULL,
+				   opened_on DATE NOT NULL,
+				   closed_on DATE,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_episode_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_episodes_patient ON episodes(patient_id, opened_on)`,
+				`ALTER TABLE payments ADD COLUMN episode_id VARCHAR(36)`,
+				`CREATE INDEX idx_payments_episode ON payments(episode_id)`,
+			},
+			DialectSQLite: {
+				`CREATE TABLE episodes (
+				   id TEXT PRIMARY KEY,
+				   patient_id TEXT NOT NULL,
+				   owner_username TEXT NOT NULL,
+				   title TEXT,
+				   chief_complaint TEXT,
+				   present_history TEXT,
+				   observation TEXT,
+				   palpation TEXT,
+				   examination TEXT,
+				   diagnosis TEXT,
+				   plan TEXT,
+				   status TEXT NOT NULL,
+				   opened_on DATE NOT NULL,
+				   closed_on DATE,
+				   created_time TIMESTAMP NOT NULL,
+				   updated_time TIMESTAMP NOT NULL,
+				   version INTEGER DEFAULT 1 NOT NULL,
+				   CONSTRAINT fk_episode_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_episodes_patient ON episodes(patient_id, opened_on)`,
+				`ALTER TABLE payments ADD COLUMN episode_id TEXT`,
+				`CREATE INDEX idx_payments_episode ON payments(episode_id)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {`ALTER TABLE payments DROP COLUMN episode_id`, `DROP TABLE episodes`},
+			DialectPostgres: {`ALTER TABLE payments DROP COLUMN episode_id`, `DROP TABLE episodes`},
+			DialectSQLite:   {`DROP INDEX idx_payments_episode`, `ALTER TABLE payments DROP COLUMN episode_id`, `DROP TABLE episodes`},
+		},
+		Backfill: backfillEpisodes,
+	},
+	{
+		// Stored statuses are read into the lifecycle (unknown ones as ACTIVE) and each
+		// patient's status is recorded as of their creation.
+		Version: 17,
+		Name:    "patient_status_changes",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`CREATE TABLE patient_status_changes (
+				   id VARCHAR2(36) PRIMARY KEY,
+				   patient_id VARCHAR2(36) NOT NULL,
+				   owner_username VARCHAR2(255) NOT NULL,
+				   from_status VARCHAR2(255),
+				   to_status VARCHAR2(255) NOT NULL,
+				   reason VARCHAR2(4000),
+				   changed_by VARCHAR2(255),
+				   changed_time TIMESTAMP NOT NULL,
+				   CONSTRAINT fk_status_change_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_status_changes_patient ON patient_status_changes(patient_id, changed_time)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE patient_status_changes (
+				   id VARCHAR(36) PRIMARY KEY,
+				   patient_id VARCHAR(36) NOT NULL,
+				   owner_username VARCHAR(255) NOT NULL,
+				   from_status VARCHAR(255),
+				   to_status VARCHAR(255) NOT NULL,
+				   reason TEXT,
+				   changed_by VARCHAR(255),
+				   changed_time TIMESTAMP NOT NULL,
+				   CONSTRAINT fk_status_change_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_status_changes_patient ON patient_status_changes(patient_id, changed_time)`,
+			},
+			DialectSQLite: {
+				`CREATE TABLE patient_status_changes (
+				   id TEXT PRIMARY KEY,
+				   patient_id TEXT NOT NULL,
+				   owner_username TEXT NOT NULL,
+				   from_status TEXT,
+				   to_status TEXT NOT NULL,
+				   reason TEXT,
+				   changed_by TEXT,
+				   changed_time TIMESTAMP NOT NULL,
+				   CONSTRAINT fk_status_change_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_status_changes_patient ON patient_status_changes(patient_id, changed_time)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {`DROP TABLE patient_status_changes`},
+			DialectPostgres: {`DROP TABLE patient_status_changes`},
+			DialectSQLite:   {`DROP TABLE patient_status_changes`},
+		},
+		Backfill: backfillPatientStatuses,
+	},
+	{
+		Version: 18,
+		Name:    "discharges",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`CREATE TABLE discharges (
+				   id VARCHAR2(36) PRIMARY KEY,
+				   patient_id VARCHAR2(36) NOT NULL,
+				   owner_username VARCHAR2(255) NOT NULL,
+				   episode_id VARCHAR2(36),
+				   discharged_on DATE NOT NULL,
+				   outcome VARCHAR2(255) NOT NULL,
+				   outcome_notes VARCHAR2(4000),
+				   home_advice VARCHAR2(4000),
+				   measurement_ids VARCHAR2(4000),
+				   created_time TIMESTAMP NOT NULL,
+				   CONSTRAINT fk_discharge_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_discharges_patient ON discharges(patient_id, discharged_on)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE discharges (
+				   id VARCHAR(36) PRIMARY KEY,
+				   patient_id VARCHAR(36) NOT NULL,
+				   owner_username VARCHAR(255) NOT NULL,
+				   episode_id VARCHAR(36),
+				   discharged_on DATE NOT NULL,
+				   outcome VARCHAR(255) NOT NULL,
+				   outcome_notes TEXT,
+				   home_advice TEXT,
+				   measurement_ids TEXT,
+				   created_time TIMESTAMP NOT NULL,
+				   CONSTRAINT fk_discharge_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_discharges_patient ON discharges(patient_id, discharged_on)`,
+			},
+			DialectSQLite: {
+				`CREATE TABLE discharges (
+				   id TEXT PRIMARY KEY,
+				   patient_id TEXT NOT NULL,
+				   owner_username TEXT NOT NULL,
+				   episode_id TEXT,
+				   discharged_on DATE NOT NULL,
+				   outcome TEXT NOT NULL,
+				   outcome_notes TEXT,
+				   home_advice TEXT,
+				   measurement_ids TEXT,
+				   created_time TIMESTAMP NOT NULL,
+				   CONSTRAINT fk_discharge_patient FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE
+				 )`,
+				`CREATE INDEX idx_discharges_patient ON discharges(patient_id, discharged_on)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {`DROP TABLE discharges`},
+			DialectPostgres: {`DROP TABLE discharges`},
+			DialectSQLite:   {`DROP TABLE discharges`},
+		},
+	},
+	{
+		Version: 19,
+		Name:    "receipts",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`CREATE TABLE clinic_profiles (
+				   owner_username VARCHAR2(255) PRIMARY KEY,
+				   name VARCHAR2(255) NOT NULL,
+				   address VARCHAR2(4000),
+				   registration_number VARCHAR2(255),
+				   logo BLOB,
+				   updated_time TIMESTAMP NOT NULL
+				 )`,
+				`CREATE TABLE receipt_counters (
+				   owner_username VARCHAR2(255) PRIMARY KEY,
+				   last_number NUMBER(19) NOT NULL
+				 )`,
+				`CREATE TABLE receipts (
+				   owner_username VARCHAR2(255) NOT NULL,
+				   receipt_number NUMBER(19) NOT NULL,
+				   payment_id VARCHAR2(36) NOT NULL,
+				   issued_time TIMESTAMP NOT NULL,
+				   CONSTRAINT pk_receipts PRIMARY KEY (owner_username, receipt_number),
+				   CONSTRAINT uq_receipt_payment UNIQUE (owner_username, payment_id)
+				 )`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE clinic_profiles (
+				   owner_username VARCHAR(255) PRIMARY KEY,
+				   name VARCHAR(255) NOT NULL,
+				   address TEXT,
+				   registration_number VARCHAR(255),
+				   logo BYTEA,
+				   updated_time TIMESTAMP NOT NULL
+				 )`,
+				`CREATE TABLE receipt_counters (
+				   owner_username VARCHAR(255) PRIMARY KEY,
+				   last_number BIGINT NOT NULL
+				 )`,
+				`CREATE TABLE receipts (
+				   owner_username VARCHAR(255) NOT NULL,
+				   receipt_number BIGINT NOT NULL,
+				   payment_id VARCHAR(36) NOT NULL,
+				   issued_time TIMESTAMP NOT NULL,
+				   CONSTRAINT pk_receipts PRIMARY KEY (owner_username, receipt_number),
+				   CONSTRAINT uq_receipt_payment UNIQUE (owner_username, payment_id)
+				 )`,
+			},
+			DialectSQLite: {
+				`CREATE TABLE clinic_profiles (
+				   owner_username TEXT PRIMARY KEY,
+				   name TEXT NOT NULL,
+				   address TEXT,
+				   registration_number TEXT,
+				   logo BLOB,
+				   updated_time TIMESTAMP NOT NULL
+				 )`,
+				`CREATE TABLE receipt_counters (
+				   owner_username TEXT PRIMARY KEY,
+				   last_number INTEGER NOT NULL
+				 )`,
+				`CREATE TABLE receipts (
+				   owner_username TEXT NOT NULL,
+				   receipt_number INTEGER NOT NULL,
+				   payment_id TEXT NOT NULL,
+				   issued_time TIMESTAMP NOT NULL,
+				   CONSTRAINT pk_receipts PRIMARY KEY (owner_username, receipt_number),
+				   CONSTRAINT uq_receipt_payment UNIQUE (owner_username, payment_id)
+				 )`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {`DROP TABLE receipts`, `DROP TABLE receipt_counters`, `DROP TABLE clinic_profiles`},
+			DialectPostgres: {`DROP TABLE receipts`, `DROP TABLE receipt_counters`, `DROP TABLE clinic_profiles`},
+			DialectSQLite:   {`DROP TABLE receipts`, `DROP TABLE receipt_counters`, `DROP TABLE clinic_profiles`},
+		},
+	},
+	{
+		Version: 20,
+		Name:    "appointment_locks",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`CREATE TABLE appointment_locks (
+				   owner_username VARCHAR2(255) PRIMARY KEY
+				 )`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE appointment_locks (
+				   owner_username VARCHAR(255) PRIMARY KEY
+				 )`,
+			},
+			DialectSQLite: {
+				`CREATE TABLE appointment_locks (
+				   owner_username TEXT PRIMARY KEY
+				 )`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {`DROP TABLE appointment_locks`},
+			DialectPostgres: {`DROP TABLE appointment_locks`},
+			DialectSQLite:   {`DROP TABLE appointment_locks`},
+		},
+	},
+	{
+		// ARCHIVED patients and the trash become the same thing; the data is moved by the backfill
+		Version: 21,
+		Name:    "archived_patient_status",
+		Up: map[Dialect][]string{
+			DialectOracle:   {},
+			DialectPostgres: {},
+			DialectSQLite:   {},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle:   {},
+			DialectPostgres: {},
+			DialectSQLite:   {},
+		},
+		Backfill: backfillArchivedPatients,
+	}, {
+		// receipts keep what they printed; receipts issued before take the current values
+		Version: 22,
+		Name:    "receipt_snapshots",
+		Up: map[Dialect][]string{
+			DialectOracle: {
+				`ALTER TABLE receipts ADD (patient_id VARCHAR2(36), amount_minor NUMBER(19), currency VARCHAR2(3),
+				                          payment_mode VARCHAR2(100), paid_date DATE, patient_name VARCHAR2(255),
+				                          clinic_name VARCHAR2(255), clinic_address VARCHAR2(4000),
+				                          clinic_registration_number VARCHAR2(255), clinic_logo BLOB)`,
+				`UPDATE receipts
+				   SET patient_id = (SELECT p.patient_id FROM payments p WHERE p.id = receipts.payment_id),
+				       amount_minor = (SELECT p.amount_minor FROM payments p WHERE p.id = receipts.payment_id),
+				       currency = (SELECT p.currency FROM payments p WHERE p.id = receipts.payment_id),
+				       payment_mode = (SELECT p.payment_mode FROM payments p WHERE p.id = receipts.payment_id),
+				       paid_date = (SELECT p.paid_date FROM payments p WHERE p.id = receipts.payment_id),
+				       patient_name = (SELECT pt.full_name FROM payments p JOIN patients pt ON pt.id = p.patient_id
+				                       WHERE p.id = receipts.payment_id),
+				       clinic_name = (SELECT c.name FROM clinic_profiles c WHERE c.owner_username = receipts.owner_username),
+				       clinic_address = (SELECT c.address FROM clinic_profiles c WHERE c.owner_username = receipts.owner_username),
+				       clinic_registration_number = (SELECT c.registration_number FROM clinic_profiles c
+				                                     WHERE c.owner_username = receipts.owner_username),
+				       clinic_logo = (SELECT c.logo FROM clinic_profiles c WHERE c.owner_username = receipts.owner_username)`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE receipts ADD COLUMN patient_id VARCHAR(36)`,
+				`ALTER TABLE receipts ADD COLUMN amount_minor BIGINT`,
+				`ALTER TABLE receipts ADD COLUMN currency VARCHAR(3)`,
+				`ALTER TABLE receipts ADD COLUMN payment_mode VARCHAR(100)`,
+				`ALTER TABLE receipts ADD COLUMN paid_date DATE`,
+				`ALTER TABLE receipts ADD COLUMN patient_name VARCHAR(255)`,
+				`ALTER TABLE receipts ADD COLUMN clinic_name VARCHAR(255)`,
+				`ALTER TABLE receipts ADD COLUMN clinic_address TEXT`,
+				`ALTER TABLE receipts ADD COLUMN clinic_registration_number VARCHAR(255)`,
+				`ALTER TABLE receipts ADD COLUMN clinic_logo BYTEA`,
+				`UPDATE receipts
+				   SET patient_id = (SELECT p.patient_id FROM payments p WHERE p.id = receipts.payment_id),
+				       amount_minor = (SELECT p.amount_minor FROM payments p WHERE p.id = receipts.payment_id),
+				       currency = (SELECT p.currency FROM payments p WHERE p.id = receipts.payment_id),
+				       payment_mode = (SELECT p.payment_mode FROM payments p WHERE p.id = receipts.payment_id),
+				       paid_date = (SELECT p.paid_date FROM payments p WHERE p.id = receipts.payment_id),
+				       patient_name = (SELECT pt.full_name FROM payments p JOIN patients pt ON pt.id = p.patient_id
+				                       WHERE p.id = receipts.payment_id),
+				       clinic_name = (SELECT c.name FROM clinic_profiles c WHERE c.owner_username = receipts.owner_username),
+				       clinic_address = (SELECT c.address FROM clinic_profiles c WHERE c.owner_username = receipts.owner_username),
+				       clinic_registration_number = (SELECT c.registration_number FROM clinic_profiles c
+				                                     WHERE c.owner_username = receipts.owner_username),
+				       clinic_logo = (SELECT c.logo FROM clinic_profiles c WHERE c.owner_username = receipts.owner_username)`,
+			},
+			DialectSQLite: {
+				`ALTER TABLE receipts ADD COLUMN patient_id TEXT`,
+				`ALTER TABLE receipts ADD COLUMN amount_minor INTEGER`,
+				`ALTER TABLE receipts ADD COLUMN currency TEXT`,
+				`ALTER TABLE receipts ADD COLUMN payment_mode TEXT`,
+				`ALTER TABLE receipts ADD COLUMN paid_date DATE`,
+				`ALTER TABLE receipts ADD COLUMN patient_name TEXT`,
+				`ALTER TABLE receipts ADD COLUMN clinic_name TEXT`,
+				`ALTER TABLE receipts ADD COLUMN clinic_address TEXT`,
+				`ALTER TABLE receipts ADD COLUMN clinic_registration_number TEXT`,
+				`ALTER TABLE receipts ADD COLUMN clinic_logo BLOB`,
+				`UPDATE receipts
+				   SET patient_id = (SELECT p.patient_id FROM payments p WHERE p.id = receipts.payment_id),
+				       amount_minor = (SELECT p.amount_minor FROM payments p WHERE p.id = receipts.payment_id),
+				       currency = (SELECT p.currency FROM payments p WHERE p.id = receipts.payment_id),
+				       payment_mode = (SELECT p.payment_mode FROM payments p WHERE p.id = receipts.payment_id),
+				       paid_date = (SELECT p.paid_date FROM payments p WHERE p.id = receipts.payment_id),
+				       patient_name = (SELECT pt.full_name FROM payments p JOIN patients pt ON pt.id = p.patient_id
+				                       WHERE p.id = receipts.payment_id),
+				       clinic_name = (SELECT c.name FROM clinic_profiles c WHERE c.owner_username = receipts.owner_username),
+				       clinic_address = (SELECT c.address FROM clinic_profiles c WHERE c.owner_username = receipts.owner_username),
+				       clinic_registration_number = (SELECT c.registration_number FROM clinic_profiles c
+				                                     WHERE c.owner_username = receipts.owner_username),
+				       clinic_logo = (SELECT c.logo FROM clinic_profiles c WHERE c.owner_username = receipts.owner_username)`,
+			},
+		},
+		Down: map[Dialect][]string{
+			DialectOracle: {
+				`ALTER TABLE receipts DROP (patient_id, amount_minor, currency, payment_mode, paid_date, patient_name,
+				                           clinic_name, clinic_address, clinic_registration_number, clinic_logo)`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE receipts DROP COLUMN patient_id`,
+				`ALTER TABLE receipts DROP COLUMN amount_minor`,
+				`ALTER TABLE receipts DROP COLUMN currency`,
+				`ALTER TABLE receipts DROP COLUMN payment_mode`,
+				`ALTER TABLE receipts DROP COLUMN paid_date`,
+				`ALTER TABLE receipts DROP COLUMN patient_name`,
+				`ALTER TABLE receipts DROP COLUMN clinic_name`,
+				`ALTER TABLE receipts DROP COLUMN clinic_address`,
+				`ALTER TABLE receipts DROP COLUMN clinic_registration_number`,
+				`ALTER TABLE receipts DROP COLUMN clinic_logo`,
+			},
+			DialectSQLite: {
+				`ALTER TABLE receipts DROP COLUMN patient_id`,
+				`ALTER TABLE receipts DROP COLUMN amount_minor`,
+				`ALTER TABLE receipts DROP COLUMN currency`,
+				`ALTER TABLE receipts DROP COLUMN payment_mode`,
+				`ALTER TABLE receipts DROP COLUMN paid_date`,
+				`ALTER TABLE receipts DROP COLUMN patient_name`,
+				`ALTER TABLE receipts DROP COLUMN clinic_name`,
+				`ALTER TABLE receipts DROP COLUMN clinic_address`,
+				`ALTER TABLE receipts DROP COLUMN clinic_registration_number`,
+				`ALTER TABLE receipts DROP COLUMN clinic_logo`,
+			},
+		},
+	},
+}
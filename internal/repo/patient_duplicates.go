@@ -0,0 +1,203 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"phsio_track_backend/internal/core"
+)
+
+// Two active patients of the same owner are likely duplicates when they share a
+// normalized phone number or a name (ignoring case and spacing).
+
+// FindDuplicates returns the owner's other active patients sharing p's phone number or name.
+func (r *PatientRepo) FindDuplicates(ctx context.Context, owner string, p core.Patient) ([]core.DuplicateMatch, error) {
+	matches := []core.DuplicateMatch{}
+	name := nameKey(p.FullName)
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+patientSummaryColumns+`
+		FROM patients
+		WHERE owner_username=:1 AND id<>:2 AND archived_time IS NULL
+		  AND (phone_number=:3 OR LOWER(full_name)=:4)
+		ORDER BY created_time
+	`, owner, p.ID, nullableText(p.PhoneNumber), nullableText(name))
+	if err != nil {
+		return matches, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := scanPatientSummary(rows)
+		if err != nil {
+			return matches, err
+		}
+		m := core.DuplicateMatch{Patient: s, Matched: []string{}}
+		if p.PhoneNumber != "" && s.PhoneNumber == p.PhoneNumber {
+			m.Matched = append(m.Matched, "phone_number")
+		}
+		if name != "" && nameKey(s.FullName) == name {
+			m.Matched = append(m.Matched, "full_name")
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// DuplicateClusters groups the owner's active patients that are linked, directly or
+// through each other, by a shared phone number or name. Largest clusters come first.
+func (r *PatientRepo) DuplicateClusters(ctx context.Context, owner string) ([]core.DuplicateCluster, error) {
+	clusters := []core.DuplicateCluster{}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+patientSummaryColumns+`
+		FROM patients
+		WHERE owner_username=:1 AND archived_time IS NULL
+		ORDER BY created_time
+	`, owner)
+	if err != nil {
+		return clusters, err
+	}
+	var patients []core.PatientSummary
+	for rows.Next() {
+		s, err := scanPatientSummary(rows)
+		if err != nil {
+			rows.Close()
+			return clusters, err
+		}
+		patients = append(patients, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return clusters, err
+	}
+
+	// union-find over patients, joined through the first patient seen with each key
+	parent := make([]int, len(patients))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	type key struct{ field, value string }
+	first := map[key]int{}
+	count := map[key]int{}
+	for i, p := range patients {
+		keys := []key{}
+		if p.PhoneNumber != "" {
+			keys = append(keys, key{"phone_number", p.PhoneNumber})
+		}
+		if n := nameKey(p.FullName); n != "" {
+			keys = append(keys, key{"full_name", n})
+		}
+		for _, k := range keys {
+			count[k]++
+			if j, ok := first[k]; ok {
+				parent[find(i)] = find(j)
+			} else {
+				first[k] = i
+			}
+		}
+	}
+
+	members := map[int][]int{}
+	for i := range patients {
+		root := find(i)
+		members[root] = append(members[root], i)
+	}
+	matched := map[int]map[string]bool{}
+	for k, n := range count {
+		if n < 2 {
+			continue
+		}
+		root := find(first[k])
+		if matched[root] == nil {
+			matched[root] = map[string]bool{}
+		}
+		matched[root][k.field] = true
+	}
+
+	for root, idx := range members {
+		if len(idx) < 2 {
+			continue
+		}
+		c := core.DuplicateCluster{Matched: []string{}}
+		for _, f := range []string{"phone_number", "full_name"} {
+			if matched[root][f] {
+				c.Matched = append(c.Matched, f)
+			}
+		}
+		for _, i := range idx {
+			c.Patients = append(c.Patients, patients[i])
+		}
+		clusters = append(clusters, c)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i].Patients) != len(clusters[j].Patients) {
+			return len(clusters[i].Patients) > len(clusters[j].Patients)
+		}
+		return nameKey(clusters[i].Patients[0].FullName) < nameKey(clusters[j].Patients[0].FullName)
+	})
+	return clusters, nil
+}
+
+// NormalizePhones rewrites every stored phone number into E.164, recording each
+// change in the patient's history. It returns how many patients changed and the
+// numbers it could not normalize, which are left as they were.
+func (r *PatientRepo) NormalizePhones(ctx context.Context) (int, []string, error) {
+	type row struct{ id, owner, phone string }
+	var all []row
+	rows, err := r.db.QueryContext(ctx, `SELECT id, owner_username, phone_number FROM patients WHERE phone_number IS NOT NULL`)
+	if err != nil {
+		return 0, nil, err
+	}
+	for rows.Next() {
+		var rw row
+		var owner sql.NullString
+		if err := rows.Scan(&rw.id, &owner, &rw.phone); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		rw.owner = nullStringToString(owner)
+		all = append(all, rw)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	changed := 0
+	var invalid []string
+	err = withTx(ctx, r.db, func(tx *Tx) error {
+		for _, rw := range all {
+			phone, err := core.NormalizePhone(rw.phone, r.phoneCountry)
+			if err != nil {
+				invalid = append(invalid, fmt.Sprintf("%s %q", rw.id, rw.phone))
+				continue
+			}
+			if phone == rw.phone {
+				continue
+			}
+			if _, err := updatePatient(ctx, tx, rw.owner, rw.id, &core.PatientUpdate{PhoneNumber: &phone}, core.VersionMatch{}); err != nil {
+				return err
+			}
+			changed++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return changed, invalid, nil
+}
+
+// nameKey is the form names are compared in: lower case, single spaced.
+func nameKey(name string) string {
+	return strings.ToLower(core.CleanName(name))
+}
@@ -0,0 +1,122 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"phsio_track_backend/internal/core"
+)
+
+// recordRevision stores the fields that differ between before and after as one revision.
+// The owner is recorded as the author since accounts are not shared between therapists.
+func recordRevision(ctx context.Context, q querier, owner string, before, after core.Patient) error {
+	revisionID := uuid.NewString()
+	now := time.Now()
+	for _, field := range core.PatientHistoryFields {
+		oldValue, _ := before.FieldValue(field)
+		newValue, _ := after.FieldValue(field)
+		if oldValue == newValue {
+			continue
+		}
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO patient_revisions (
+				id, revision_id, patient_id, owner_username, changed_by, changed_time, field_name, old_value, new_value
+			) VALUES (:1,:2,:3,:4,:5,:6,:7,:8,:9)
+		`, uuid.NewString(), revisionID, after.ID, owner, owner, now, field, nullableText(oldValue), nullableText(newValue))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordEvent stores a revision for something that is not a field edit, such as a
+// merge. field names the event; it cannot be reverted.
+func recordEvent(ctx context.Context, q querier, owner, patientID, field, oldValue, newValue string) error {
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO patient_revisions (
+			id, revision_id, patient_id, owner_username, changed_by, changed_time, field_name, old_value, new_value
+		) VALUES (:1,:2,:3,:4,:5,:6,:7,:8,:9)
+	`, uuid.NewString(), uuid.NewString(), patientID, owner, owner, time.Now(), field, nullableText(oldValue), nullableText(newValue))
+	return err
+}
+
+// History lists a patient's revisions, newest first.
+func (r *PatientRepo) History(ctx context.Context, owner, id string) ([]core.PatientRevision, error) {
+	if _, err := r.GetByID(ctx, owner, id); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT revision_id, changed_by, changed_time, field_name, old_value, new_value
+		FROM patient_revisions
+		WHERE patient_id=:1 AND owner_username=:2
+		ORDER BY changed_time DESC, revision_id, field_name
+	`, id, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []core.PatientRevision{}
+	for rows.Next() {
+		var revisionID, field string
+		var changedBy, oldValue, newValue sql.NullString
+		var changed sql.NullTime
+		if err := rows.Scan(&revisionID, &changedBy, &changed, &field, &oldValue, &newValue); err != nil {
+			return nil, err
+		}
+		if n := len(items); n == 0 || items[n-1].ID != revisionID {
+			items = append(items, core.PatientRevision{
+				ID:          revisionID,
+				PatientID:   id,
+				ChangedBy:   nullStringToString(changedBy),
+				ChangedTime: core.NewJSONTime(changed.Time),
+			})
+		}
+		last := &items[len(items)-1]
+		last.Changes = append(last.Changes, core.FieldChange{
+			Field:    field,
+			OldValue: nullStringToString(oldValue),
+			NewValue: nullStringToString(newValue),
+		})
+	}
+	return items, rows.Err()
+}
+
+// RevertField sets field back to the value it had before the given revision.
+// The revert is itself an update, so it shows up in History as a new revision; like
+// Update it yields ErrStale when ifMatch does not match the current version.
+func (r *PatientRepo) RevertField(ctx context.Context, owner, id, revisionID, field string, ifMatch core.VersionMatch) (core.Patient, error) {
+	var updated core.Patient
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		var oldValue sql.NullString
+		err := tx.QueryRowContext(ctx, `
+			SELECT old_value
+			FROM patient_revisions
+			WHERE revision_id=:1 AND patient_id=:2 AND owner_username=:3 AND field_name=:4
+		`, revisionID, id, owner, field).Scan(&oldValue)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return err
+		}
+		var upd core.PatientUpdate
+		if err := upd.SetField(field, nullStringToString(oldValue)); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		if err := r.cleanUpdate(&upd); err != nil {
+			return err
+		}
+		updated, err = updatePatient(ctx, tx, owner, id, &upd, ifMatch)
+		return err
+	})
+	if err != nil {
+		return core.Patient{}, err
+	}
+	return updated, nil
+}
@@ -0,0 +1,266 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"phsio_track_backend/internal/core"
+)
+
+// maxTextBytes is the size of the clinical text columns on Oracle (VARCHAR2(4000)).
+const maxTextBytes = 4000
+
+// Merge folds m.SourceID into the target patient id in one transaction: the chosen
+// field values are written to the target (recorded as a normal revision), the
+// source's payments, invoices, appointments, exercise prescriptions, measurements,
+// questionnaire responses, visit notes, episodes and discharges move to the target (the
+// exercises after the target's own, the episodes closed), both patients get a merge entry
+// in their history and the source is archived. A conditional ifMatch must match the target's version.
+func (r *PatientRepo) Merge(ctx context.Context, owner, id string, m core.PatientMerge, ifMatch core.VersionMatch) (core.PatientMergeResult, error) {
+	var result core.PatientMergeResult
+	if m.SourceID == "" {
+		return result, fmt.Errorf("%w: source_id is required", ErrInvalid)
+	}
+	if m.SourceID == id {
+		return result, fmt.Errorf("%w: cannot merge a patient into itself", ErrInvalid)
+	}
+	for field, choice := range m.Fields {
+		if !containsString(core.PatientMergeFields, field) {
+			return result, fmt.Errorf("%w: %q cannot be merged", ErrInvalid, field)
+		}
+		switch choice {
+		case "target", "source":
+		case "both":
+			if !containsString(core.PatientMergeTextFields, field) {
+				return result, fmt.Errorf("%w: %q cannot be combined", ErrInvalid, field)
+			}
+		default:
+			return result, fmt.Errorf("%w: %s must be target, source or both", ErrInvalid, field)
+		}
+	}
+
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		target, err := getPatientByID(ctx, tx, owner, id)
+		if err != nil {
+			return err
+		}
+		source, err := getPatientByID(ctx, tx, owner, m.SourceID)
+		if err != nil {
+			return err
+		}
+		if !target.ArchivedTime.IsZero() {
+			return fmt.Errorf("%w: cannot merge into an archived patient", ErrConflict)
+		}
+
+		var upd core.PatientUpdate
+		for _, field := range core.PatientMergeFields {
+			tv, _ := target.FieldValue(field)
+			sv, _ := source.FieldValue(field)
+			value, set := tv, false
+			switch m.Fields[field] {
+			case "":
+				set = (tv == "" || (field == "age" && tv == "0")) && sv != "" && sv != "0"
+				value = sv
+			case "source":
+				set, value = true, sv
+			case "both":
+				set, value = true, combineText(tv, sv)
+				if len(value) > maxTextBytes {
+					return fmt.Errorf("%w: combined %s is longer than %d bytes", ErrInvalid, field, maxTextBytes)
+				}
+			}
+			if set && value != tv {
+				if err := upd.SetField(field, value); err != nil {
+					return fmt.Errorf("%w: %v", ErrInvalid, err)
+				}
+			}
+		}
+		if _, err := updatePatient(ctx, tx, owner, target.ID, &upd, ifMatch); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			UPDATE payments
+			   SET patient_id = :1,
+			       version = version + 1
+			 WHERE patient_id = :2 AND owner_username = :3
+		`, target.ID, source.ID, owner)
+		if err != nil {
+			return err
+		}
+		moved, _ := res.RowsAffected()
+		result.PaymentsMoved = int(moved)
+		res, err = tx.ExecContext(ctx, `
+			UPDATE invoices
+			   SET patient_id = :1
+			 WHERE patient_id = :2 AND owner_username = :3
+		`, target.ID, source.ID, owner)
+		if err != nil {
+			return err
+		}
+		moved, _ = res.RowsAffected()
+		result.InvoicesMoved = int(moved)
+		res, err = tx.ExecContext(ctx, `
+			UPDATE appointments
+			   SET patient_id = :1
+			 WHERE patient_id = :2 AND owner_username = :3
+		`, target.ID, source.ID, owner)
+		if err != nil {
+			return err
+		}
+		moved, _ = res.RowsAffected()
+		result.AppointmentsMoved = int(moved)
+		var lastPosition int
+		err = tx.QueryRowContext(ctx, `
+			SELECT COALESCE(MAX(position), 0) FROM exercise_prescriptions WHERE patient_id = :1
+		`, target.ID).Scan(&lastPosition)
+		if err != nil {
+			return err
+		}
+		res, err = tx.ExecContext(ctx, `
+			UPDATE exercise_prescriptions
+			   SET patient_id = :1,
+			       position = position + :2
+			 WHERE patient_id = :3 AND owner_username = :4
+		`, target.ID, lastPosition, source.ID, owner)
+		if err != nil {
+			return err
+		}
+		moved, _ = res.RowsAffected()
+		result.ExercisesMoved = int(moved)
+		res, err = tx.ExecContext(ctx, `
+			UPDATE measurements
+			   SET patient_id = :1
+			 WHERE patient_id = :2 AND owner_username = :3
+		`, target.ID, source.ID, owner)
+		if err != nil {
+			return err
+		}
+		moved, _ = res.RowsAffected()
+		result.MeasurementsMoved = int(moved)
+		res, err = tx.ExecContext(ctx, `
+			UPDATE questionnaire_responses
+			   SET patient_id = :1
+			 WHERE patient_id = :2 AND owner_username = :3
+		`, target.ID, source.ID, owner)
+		if err != nil {
+			return err
+		}
+		moved, _ = res.RowsAffected()
+		result.QuestionnairesMoved = int(moved)
+		res, err = tx.ExecContext(ctx, `
+			UPDATE visit_notes
+			   SET patient_id = :1
+			 WHERE patient_id = :2 AND owner_username = :3
+		`, target.ID, source.ID, owner)
+		if err != nil {
+			return err
+		}
+		moved, _ = res.RowsAffected()
+		result.VisitNotesMoved = int(moved)
+		// the target's open episode stays the only open one
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE episodes
+			   SET status = :1,
+			       closed_on = :2,
+			       updated_time = :3,
+			       version = version + 1
+			 WHERE patient_id = :4 AND owner_username = :5 AND status = :6
+		`, core.EpisodeClosed, episodeDay(time.Now()), time.Now(), source.ID, owner, core.EpisodeOpen); err != nil {
+			return err
+		}
+		res, err = tx.ExecContext(ctx, `
+			UPDATE episodes
+			   SET patient_id = :1
+			 WHERE patient_id = :2 AND owner_username = :3
+		`, target.ID, source.ID, owner)
+		if err != nil {
+			return err
+		}
+		moved, _ = res.RowsAffected()
+		result.EpisodesMoved = int(moved)
+		res, err = tx.ExecContext(ctx, `
+			UPDATE discharges
+			   SET patient_id = :1
+			 WHERE patient_id = :2 AND owner_username = :3
+		`, target.ID, source.ID, owner)
+		if err != nil {
+			return err
+		}
+		moved, _ = res.RowsAffected()
+		result.DischargesMoved = int(moved)
+		if err := mirrorCurrentEpisode(ctx, tx, owner, target.ID); err != nil {
+			return err
+		}
+		if err := refreshLastPaid(ctx, tx, owner, source.ID); err != nil {
+			return err
+		}
+		if err := refreshLastPaid(ctx, tx, owner, target.ID); err != nil {
+			return err
+		}
+		// credit on either side may now settle the other side's invoices
+		if err := allocatePayments(ctx, tx, owner, target.ID); err != nil {
+			return err
+		}
+
+		if err := recordEvent(ctx, tx, owner, target.ID, "merged_from", "", source.ID); err != nil {
+			return err
+		}
+		if err := recordEvent(ctx, tx, owner, source.ID, "merged_into", "", target.ID); err != nil {
+			return err
+		}
+		if source.Status != core.PatientArchived {
+			if err := archiveMergedPatient(ctx, tx, owner, source, target.ID); err != nil {
+				return err
+			}
+		}
+
+		result.SourceID = source.ID
+		result.Patient, err = getPatientByID(ctx, tx, owner, target.ID)
+		return err
+	})
+	if err != nil {
+		return core.PatientMergeResult{}, err
+	}
+	return result, nil
+}
+
+// archiveMergedPatient archives the source of a merge. A merged record is retired
+// whatever its status, so unlike Archive this does not check the lifecycle.
+func archiveMergedPatient(ctx context.Context, q querier, owner string, source core.Patient, targetID string) error {
+	now := time.Now()
+	_, err := q.ExecContext(ctx, `
+		UPDATE patients
+		   SET status = :1,
+		       archived_time = :2,
+		       updated_time = :3,
+		       version = version + 1
+		 WHERE id = :4 AND owner_username = :5
+	`, core.PatientArchived, now, now, source.ID, owner)
+	if err != nil {
+		return err
+	}
+	return recordStatusChange(ctx, q, owner, source.ID, source.Status, core.PatientArchived, "merged into "+targetID, now)
+}
+
+// combineText joins two versions of a note, dropping empty and identical ones.
+func combineText(target, source string) string {
+	switch {
+	case source == "" || source == target:
+		return target
+	case target == "":
+		return source
+	default:
+		return target + "\n\n" + source
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
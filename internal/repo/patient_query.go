@@ -0,0 +1,297 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"phsio_track_backend/internal/core"
+)
+
+const (
+	defaultPatientPageSize = 50
+	maxPatientPageSize     = 200
+)
+
+// patientSortExprs maps each core.PatientSortKeys entry to the expression it orders by.
+var patientSortExprs = map[string]string{
+	"created_time": "created_time",
+	"updated_time": "updated_time",
+	"full_name":    "full_name",
+	"age":          "COALESCE(age, 0)",
+}
+
+// patientCursor marks the last row of a page. It carries the sort it was issued for
+// so a cursor cannot be replayed against a different ordering.
+type patientCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// patientListing is a PatientQuery compiled to SQL: the WHERE clause (cursor included)
+// with its binds, and the ORDER BY clause fetching one row more than the page size.
+type patientListing struct {
+	sortKey string
+	desc    bool
+	limit   int
+	where   string
+	orderBy string
+	args    []interface{}
+}
+
+// List returns one page of the owner's patients matching pq, plus the total
+// number of matches and a cursor for the following page.
+func (r *PatientRepo) List(ctx context.Context, owner string, pq core.PatientQuery) (core.PatientPage, error) {
+	page := core.PatientPage{Items: []core.Patient{}}
+	l, err := r.prepareListing(ctx, owner, pq, &page.Total)
+	if err != nil {
+		return page, err
+	}
+	items, err := r.list(ctx, `
+		SELECT `+patientColumns+`
+		FROM patients
+		WHERE `+l.where+l.orderBy, l.args...)
+	if err != nil {
+		return page, err
+	}
+	if len(items) > l.limit {
+		items = items[:l.limit]
+		page.NextCursor = encodePatientCursor(l.sortKey, l.desc, items[l.limit-1])
+	}
+	if len(items) > 0 {
+		page.Items = items
+	}
+	return page, nil
+}
+
+// ListSummary pages through the same matches as List but reads only the summary
+// columns, with each patient's total paid summed in the same query.
+func (r *PatientRepo) ListSummary(ctx context.Context, owner string, pq core.PatientQuery) (core.PatientSummaryPage, error) {
+	page := core.PatientSummaryPage{Items: []core.PatientSummary{}}
+	l, err := r.prepareListing(ctx, owner, pq, &page.Total)
+	if err != nil {
+		return page, err
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+patientSummaryColumns+`
+		FROM patients
+		WHERE `+l.where+l.orderBy, l.args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	var last core.Patient
+	for rows.Next() {
+		if len(page.Items) == l.limit {
+			page.NextCursor = encodePatientCursor(l.sortKey, l.desc, last)
+			break
+		}
+		s, err := scanPatientSummary(rows)
+		if err != nil {
+			return page, err
+		}
+		page.Items = append(page.Items, s)
+		last = core.Patient{ID: s.ID, FullName: s.FullName, Age: s.Age, CreatedTime: s.CreatedTime, UpdatedTime: s.UpdatedTime}
+	}
+	return page, rows.Err()
+}
+
+const patientSummaryColumns = `id, full_name, phone_number, age, gender, diagnosis, status, created_time, updated_time,
+		       last_paid_minor, last_paid_currency, last_paid_date, archived_time, version,
+		       (SELECT SUM(pay.amount_minor)
+		          FROM payments pay
+		         WHERE pay.patient_id = patients.id AND pay.owner_username = patients.owner_username) AS total_paid`
+
+func scanPatientSummary(row rowScanner) (core.PatientSummary, error) {
+	var s core.PatientSummary
+	var phone, gender, diagnosis, status sql.NullString
+	var age, lastPaid, totalPaid sql.NullInt64
+	var lastPaidCurrency sql.NullString
+	var created, updated, lastPaidDate, archived sql.NullTime
+	if err := row.Scan(
+		&s.ID, &s.FullName, &phone, &age, &gender, &diagnosis, &status, &created, &updated,
+		&lastPaid, &lastPaidCurrency, &lastPaidDate, &archived, &s.Version, &totalPaid,
+	); err != nil {
+		return s, err
+	}
+	s.PhoneNumber = nullStringToString(phone)
+	s.Age = nullIntToInt(age)
+	s.Gender = nullStringToString(gender)
+	s.Diagnosis = nullStringToString(diagnosis)
+	s.Status = nullStringToString(status)
+	// a deployment takes payments in one currency, so the total is in the last payment's
+	s.LastPaidAmount = nullMoney(lastPaid, lastPaidCurrency)
+	s.TotalPaid = nullMoney(totalPaid, lastPaidCurrency)
+	if created.Valid {
+		s.CreatedTime = core.NewJSONTime(created.Time)
+	}
+	if updated.Valid {
+		s.UpdatedTime = core.NewJSONTime(updated.Time)
+	}
+	if lastPaidDate.Valid {
+		s.LastPaidDate = core.NewJSONTime(lastPaidDate.Time)
+	}
+	if archived.Valid {
+		s.ArchivedTime = core.NewJSONTime(archived.Time)
+	}
+	return s, nil
+}
+
+// prepareListing validates pq, stores the number of matching patients in total and
+// compiles the page query.
+func (r *PatientRepo) prepareListing(ctx context.Context, owner string, pq core.PatientQuery, total *int) (patientListing, error) {
+	l := patientListing{sortKey: pq.Sort, desc: pq.Desc, limit: pq.Limit}
+	if l.sortKey == "" {
+		l.sortKey = "created_time"
+	}
+	expr, ok := patientSortExprs[l.sortKey]
+	if !ok {
+		return l, fmt.Errorf("%w: unknown sort key %q", ErrInvalid, pq.Sort)
+	}
+	if l.limit <= 0 {
+		l.limit = defaultPatientPageSize
+	} else if l.limit > maxPatientPageSize {
+		l.limit = maxPatientPageSize
+	}
+
+	where := []string{}
+	bind := func(v interface{}) string {
+		l.args = append(l.args, v)
+		return ":" + strconv.Itoa(len(l.args))
+	}
+	bindUpper := func(vals []string) string {
+		binds := make([]string, len(vals))
+		for i, v := range vals {
+			binds[i] = bind(strings.ToUpper(strings.TrimSpace(v)))
+		}
+		return strings.Join(binds, ",")
+	}
+
+	where = append(where, "owner_username="+bind(owner))
+	// asking for ARCHIVED patients by status lists them without include_archived
+	includeArchived := pq.IncludeArchived
+	for _, v := range pq.Statuses {
+		if status, _ := core.NormalizePatientStatus(v); status == core.PatientArchived {
+			includeArchived = true
+		}
+	}
+	if !includeArchived {
+		where = append(where, "archived_time IS NULL")
+	}
+	if len(pq.Statuses) > 0 {
+		where = append(where, "UPPER(status) IN ("+bindUpper(pq.Statuses)+")")
+	}
+	if len(pq.Genders) > 0 {
+		where = append(where, "UPPER(gender) IN ("+bindUpper(pq.Genders)+")")
+	}
+	if pq.MinAge != nil {
+		where = append(where, "age >= "+bind(*pq.MinAge))
+	}
+	if pq.MaxAge != nil {
+		where = append(where, "age <= "+bind(*pq.MaxAge))
+	}
+	if !pq.CreatedFrom.IsZero() {
+		where = append(where, "created_time >= "+bind(pq.CreatedFrom))
+	}
+	if !pq.CreatedTo.IsZero() {
+		where = append(where, "created_time < "+bind(pq.CreatedTo))
+	}
+	if !pq.UpdatedFrom.IsZero() {
+		where = append(where, "updated_time >= "+bind(pq.UpdatedFrom))
+	}
+	if !pq.UpdatedTo.IsZero() {
+		where = append(where, "updated_time < "+bind(pq.UpdatedTo))
+	}
+	if d := strings.TrimSpace(pq.Diagnosis); d != "" {
+		where = append(where, `LOWER(diagnosis) LIKE `+bind("%"+escapeLike(strings.ToLower(d))+"%")+` ESCAPE '\'`)
+	}
+
+	l.where = strings.Join(where, " AND ")
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM patients WHERE `+l.where, l.args...).Scan(total); err != nil {
+		return l, err
+	}
+
+	dir, op := "ASC", ">"
+	if l.desc {
+		dir, op = "DESC", "<"
+	}
+	if pq.Cursor != "" {
+		cur, err := decodePatientCursor(pq.Cursor)
+		if err != nil {
+			return l, err
+		}
+		if cur.Sort != l.sortKey || cur.Desc != l.desc {
+			return l, fmt.Errorf("%w: cursor was issued for a different sort", ErrInvalid)
+		}
+		v, err := patientCursorValue(l.sortKey, cur.Value)
+		if err != nil {
+			return l, err
+		}
+		l.where += " AND (" + expr + " " + op + " " + bind(v) +
+			" OR (" + expr + " = " + bind(v) + " AND id " + op + " " + bind(cur.ID) + "))"
+	}
+	// fetch one extra row to learn whether another page follows
+	l.orderBy = " ORDER BY " + expr + " " + dir + ", id " + dir + r.db.Dialect().limit(l.limit+1)
+	return l, nil
+}
+
+func encodePatientCursor(sortKey string, desc bool, last core.Patient) string {
+	cur := patientCursor{Sort: sortKey, Desc: desc, ID: last.ID}
+	switch sortKey {
+	case "created_time":
+		cur.Value = last.CreatedTime.Time.Format(time.RFC3339Nano)
+	case "updated_time":
+		cur.Value = last.UpdatedTime.Time.Format(time.RFC3339Nano)
+	case "full_name":
+		cur.Value = last.FullName
+	case "age":
+		cur.Value = strconv.Itoa(last.Age)
+	}
+	b, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePatientCursor(s string) (patientCursor, error) {
+	var cur patientCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &cur)
+	}
+	if err != nil || cur.ID == "" {
+		return cur, fmt.Errorf("%w: malformed cursor", ErrInvalid)
+	}
+	return cur, nil
+}
+
+// patientCursorValue turns a cursor's sort value back into the type its column compares against.
+func patientCursorValue(sortKey, v string) (interface{}, error) {
+	switch sortKey {
+	case "created_time", "updated_time":
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed cursor", ErrInvalid)
+		}
+		return t, nil
+	case "age":
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed cursor", ErrInvalid)
+		}
+		return n, nil
+	default:
+		return v, nil
+	}
+}
+
+// escapeLike escapes LIKE wildcards so s matches literally under ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
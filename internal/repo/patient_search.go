@@ -0,0 +1,305 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"phsio_track_backend/internal/core"
+)
+
+// Search is backed by patient_search_terms, a plain table of lowercased words per
+// patient field kept in step with every patient write. Prefix matches on it are
+// ordinary B-tree range scans, so the same strategy works on Oracle, Postgres and
+// SQLite without Oracle Text or extensions.
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchWords     = 8
+	// terms are cut so that even 4-byte runes fit term's 100 bytes
+	maxTermRunes = 25
+	// phone numbers are indexed by every suffix of at least this many digits,
+	// so a prefix match on the terms finds any part of the number
+	minPhoneSuffix = 4
+)
+
+// searchWeights ranks a match by the field it was found in. An exact word match counts double.
+var searchWeights = map[string]int{
+	"full_name":       10,
+	"phone_number":    8,
+	"diagnosis":       4,
+	"chief_complaint": 2,
+}
+
+// Search returns the owner's patients matching every word of s.Query as a prefix
+// of some word in the searched fields, best matches first.
+func (r *PatientRepo) Search(ctx context.Context, owner string, s core.PatientSearch) ([]core.PatientSearchHit, error) {
+	hits := []core.PatientSearchHit{}
+	words := uniqueStrings(searchWords(s.Query))
+	if len(words) > maxSearchWords {
+		words = words[:maxSearchWords]
+	}
+	if len(words) == 0 {
+		return hits, nil
+	}
+	limit := s.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	} else if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	args := []interface{}{owner}
+	likes := make([]string, len(words))
+	for i, w := range words {
+		args = append(args, w+"%")
+		likes[i] = "t.term LIKE :" + strconv.Itoa(len(args))
+	}
+	q := `
+		SELECT t.patient_id, t.field_name, t.term, p.full_name
+		FROM patient_search_terms t
+		JOIN patients p ON p.id = t.patient_id
+		WHERE t.owner_username=:1 AND (` + strings.Join(likes, " OR ") + `)`
+	if s.NameOnly {
+		q += ` AND t.field_name = 'full_name'`
+	}
+	if !s.IncludeArchived {
+		q += ` AND p.archived_time IS NULL`
+	}
+
+	type candidate struct {
+		id     string
+		name   string
+		best   []int
+		fields map[string]bool
+		score  int
+	}
+	byID := map[string]*candidate{}
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return hits, err
+	}
+	for rows.Next() {
+		var id, field, term, name string
+		if err := rows.Scan(&id, &field, &term, &name); err != nil {
+			rows.Close()
+			return hits, err
+		}
+		c := byID[id]
+		if c == nil {
+			c = &candidate{id: id, name: strings.ToLower(name), best: make([]int, len(words)), fields: map[string]bool{}}
+			byID[id] = c
+		}
+		for i, w := range words {
+			if !strings.HasPrefix(term, w) {
+				continue
+			}
+			weight := searchWeights[field]
+			if term == w {
+				weight *= 2
+			}
+			if weight > c.best[i] {
+				c.best[i] = weight
+			}
+			c.fields[field] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return hits, err
+	}
+
+	var ranked []*candidate
+	for _, c := range byID {
+		matchedAll := true
+		for _, b := range c.best {
+			matchedAll = matchedAll && b > 0
+			c.score += b
+		}
+		if matchedAll {
+			ranked = append(ranked, c)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		if ranked[i].name != ranked[j].name {
+			return ranked[i].name < ranked[j].name
+		}
+		return ranked[i].id < ranked[j].id
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	if len(ranked) == 0 {
+		return hits, nil
+	}
+
+	args = []interface{}{owner}
+	binds := make([]string, len(ranked))
+	for i, c := range ranked {
+		args = append(args, c.id)
+		binds[i] = ":" + strconv.Itoa(len(args))
+	}
+	rows, err = r.db.QueryContext(ctx, `
+		SELECT `+patientSummaryColumns+`
+		FROM patients
+		WHERE owner_username=:1 AND id IN (`+strings.Join(binds, ",")+`)
+	`, args...)
+	if err != nil {
+		return hits, err
+	}
+	defer rows.Close()
+	summaries := map[string]core.PatientSummary{}
+	for rows.Next() {
+		p, err := scanPatientSummary(rows)
+		if err != nil {
+			return hits, err
+		}
+		summaries[p.ID] = p
+	}
+	if err := rows.Err(); err != nil {
+		return hits, err
+	}
+
+	for _, c := range ranked {
+		p, ok := summaries[c.id]
+		if !ok {
+			continue
+		}
+		hit := core.PatientSearchHit{Patient: p, Score: c.score, Matched: []string{}}
+		for _, f := range core.PatientSearchFields {
+			if c.fields[f] {
+				hit.Matched = append(hit.Matched, f)
+			}
+		}
+		hits = append(hits, hit)
+	}
+	return hits, nil
+}
+
+// RebuildSearchIndex re-derives patient_search_terms for every patient and returns
+// how many patients were indexed.
+func (r *PatientRepo) RebuildSearchIndex(ctx context.Context) (int, error) {
+	var n int
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		var err error
+		n, err = rebuildSearchIndex(ctx, tx)
+		return err
+	})
+	return n, err
+}
+
+// rebuildSearchIndex reads only the indexed columns: it runs as migration 7's
+// backfill, before later migrations have added the rest of patientColumns.
+func rebuildSearchIndex(ctx context.Context, q querier) (int, error) {
+	rows, err := q.QueryContext(ctx, `SELECT id, owner_username, full_name, phone_number, chief_complaint, diagnosis FROM patients`)
+	if err != nil {
+		return 0, err
+	}
+	var patients []core.Patient
+	for rows.Next() {
+		var p core.Patient
+		var owner, phone, chief, diagnosis sql.NullString
+		if err := rows.Scan(&p.ID, &owner, &p.FullName, &phone, &chief, &diagnosis); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		p.OwnerUsername = nullStringToString(owner)
+		p.PhoneNumber = nullStringToString(phone)
+		p.ChiefComplaint = nullStringToString(chief)
+		p.Diagnosis = nullStringToString(diagnosis)
+		patients = append(patients, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if _, err := q.ExecContext(ctx, `DELETE FROM patient_search_terms`); err != nil {
+		return 0, err
+	}
+	for _, p := range patients {
+		if err := indexPatient(ctx, q, p.OwnerUsername, p); err != nil {
+			return 0, err
+		}
+	}
+	return len(patients), nil
+}
+
+// indexPatient replaces the patient's search terms with those of p.
+func indexPatient(ctx context.Context, q querier, owner string, p core.Patient) error {
+	if _, err := q.ExecContext(ctx, `DELETE FROM patient_search_terms WHERE patient_id=:1`, p.ID); err != nil {
+		return err
+	}
+	for _, field := range core.PatientSearchFields {
+		value, _ := p.FieldValue(field)
+		for _, term := range searchTerms(field, value) {
+			_, err := q.ExecContext(ctx, `
+				INSERT INTO patient_search_terms (patient_id, owner_username, field_name, term)
+				VALUES (:1,:2,:3,:4)
+			`, p.ID, owner, field, term)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// searchTerms splits a field value into the terms stored for it.
+func searchTerms(field, value string) []string {
+	if field != "phone_number" {
+		return uniqueStrings(searchWords(value))
+	}
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, value)
+	if len(digits) > maxTermRunes {
+		digits = digits[:maxTermRunes]
+	}
+	if len(digits) <= minPhoneSuffix {
+		if digits == "" {
+			return nil
+		}
+		return []string{digits}
+	}
+	var terms []string
+	for i := 0; i <= len(digits)-minPhoneSuffix; i++ {
+		terms = append(terms, digits[i:])
+	}
+	return terms
+}
+
+// searchWords lowercases s and splits it into runs of letters and digits.
+func searchWords(s string) []string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		if rs := []rune(w); len(rs) > maxTermRunes {
+			words[i] = string(rs[:maxTermRunes])
+		}
+	}
+	return words
+}
+
+func uniqueStrings(in []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range in {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
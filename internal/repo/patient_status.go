@@ -0,0 +1,167 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"phsio_track_backend/internal/core"
+)
+
+// checkPatientTransition returns ErrInvalid for an unknown status and ErrConflict for a
+// move the lifecycle does not allow.
+func checkPatientTransition(from, to string) error {
+	if _, ok := core.NormalizePatientStatus(to); !ok || to == "" {
+		return fmt.Errorf("%w: status must be one of %s", ErrInvalid, strings.Join(core.PatientStatuses, ", "))
+	}
+	if from == to || core.CanTransitionPatient(from, to) {
+		return nil
+	}
+	allowed := core.PatientTransitions(from)
+	if len(allowed) == 0 {
+		return fmt.Errorf("%w: cannot move patient from %s to %s", ErrConflict, from, to)
+	}
+	return fmt.Errorf("%w: cannot move patient from %s to %s, only to %s", ErrConflict, from, to, strings.Join(allowed, ", "))
+}
+
+// recordStatusChange stores a patient's move between statuses.
+func recordStatusChange(ctx context.Context, q querier, owner, patientID, from, to, reason string, at time.Time) error {
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO patient_status_changes (id, patient_id, owner_username, from_status, to_status, reason, changed_by, changed_time)
+		VALUES (:1,:2,:3,:4,:5,:6,:7,:8)
+	`, uuid.NewString(), patientID, owner, nullableText(from), to, nullableText(reason), owner, at)
+	return err
+}
+
+// StatusHistory lists a patient's status changes, newest first.
+func (r *PatientRepo) StatusHistory(ctx context.Context, owner, id string) ([]core.PatientStatusChange, error) {
+	if _, err := r.GetByID(ctx, owner, id); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT from_status, to_status, reason, changed_by, changed_time
+		FROM patient_status_changes
+		WHERE patient_id=:1 AND owner_username=:2
+		ORDER BY changed_time DESC, id
+	`, id, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []core.PatientStatusChange{}
+	for rows.Next() {
+		var c core.PatientStatusChange
+		var from, reason, changedBy sql.NullString
+		if err := rows.Scan(&from, &c.To, &reason, &changedBy, &c.ChangedTime); err != nil {
+			return nil, err
+		}
+		c.From = nullStringToString(from)
+		c.Reason = nullStringToString(reason)
+		c.ChangedBy = nullStringToString(changedBy)
+		items = append(items, c)
+	}
+	return items, rows.Err()
+}
+
+// backfillPatientStatuses rewrites stored statuses into the lifecycle, reading unknown
+// ones as ACTIVE, and records each patient's status as of their creation. It lists its
+// own columns as they were in migration 17, so later changes to the tables cannot break it.
+func backfillPatientStatuses(ctx context.Context, tx *Tx) error {
+	type legacy struct {
+		id, owner, status string
+		created           time.Time
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, owner_username, status, created_time FROM patients WHERE owner_username IS NOT NULL
+	`)
+	if err != nil {
+		return err
+	}
+	var found []legacy
+	for rows.Next() {
+		var p legacy
+		var status sql.NullString
+		var created sql.NullTime
+		if err := rows.Scan(&p.id, &p.owner, &status, &created); err != nil {
+			rows.Close()
+			return err
+		}
+		p.status = nullStringToString(status)
+		p.created = created.Time
+		found = append(found, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range found {
+		status, ok := core.NormalizePatientStatus(p.status)
+		if !ok {
+			status = core.PatientActive
+		}
+		if status != p.status {
+			if _, err := tx.ExecContext(ctx, `UPDATE patients SET status = :1 WHERE id = :2`, status, p.id); err != nil {
+				return err
+			}
+		}
+		created := p.created
+		if created.IsZero() {
+			created = time.Now()
+		}
+		if err := recordStatusChange(ctx, tx, p.owner, p.id, "", status, "", created); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillArchivedPatients makes the trash and the ARCHIVED status one: patients in the
+// trash become ARCHIVED, with the move recorded as of when they were trashed, and
+// ARCHIVED patients not in the trash are put there. Like backfillPatientStatuses it
+// lists its own columns.
+func backfillArchivedPatients(ctx context.Context, tx *Tx) error {
+	type trashed struct {
+		id, owner, status string
+		archived          time.Time
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, owner_username, status, archived_time
+		FROM patients
+		WHERE archived_time IS NOT NULL AND status <> :1 AND owner_username IS NOT NULL
+	`, core.PatientArchived)
+	if err != nil {
+		return err
+	}
+	var found []trashed
+	for rows.Next() {
+		var p trashed
+		if err := rows.Scan(&p.id, &p.owner, &p.status, &p.archived); err != nil {
+			rows.Close()
+			return err
+		}
+		found = append(found, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range found {
+		if _, err := tx.ExecContext(ctx, `UPDATE patients SET status = :1 WHERE id = :2`, core.PatientArchived, p.id); err != nil {
+			return err
+		}
+		if err := recordStatusChange(ctx, tx, p.owner, p.id, p.status, core.PatientArchived, "", p.archived); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE patients SET archived_time = updated_time WHERE status = :1 AND archived_time IS NULL
+	`, core.PatientArchived)
+	return err
+}
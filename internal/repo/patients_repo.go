@@ -3,6 +3,7 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -13,17 +14,26 @@ import (
 )
 
 type PatientRepo struct {
-	db *sql.DB
+	db           *DB
+	phoneCountry string
 }
 
-func NewPatientRepo(db *sql.DB) *PatientRepo {
-	return &PatientRepo{db: db}
+// NewPatientRepo returns a PatientRepo that reads phone numbers without a
+// country code as numbers of phoneCountry (ISO 3166 alpha-2, e.g. "IN").
+func NewPatientRepo(db *DB, phoneCountry string) *PatientRepo {
+	return &PatientRepo{db: db, phoneCountry: phoneCountry}
 }
 
 func (r *PatientRepo) Create(ctx context.Context, owner string, p *core.Patient) error {
 	if p.ID == "" {
 		p.ID = uuid.NewString()
 	}
+	p.FullName = core.CleanName(p.FullName)
+	phone, err := core.NormalizePhone(p.PhoneNumber, r.phoneCountry)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	p.PhoneNumber = phone
 
 	created := p.CreatedTime.Time
 	if created.IsZero() {
@@ -33,20 +43,54 @@ func (r *PatientRepo) Create(ctx context.Context, owner string, p *core.Patient)
 	if updated.IsZero() {
 		updated = created
 	}
-	p.Status = "ACTIVE"
+	// a patient can start out as an enquiry or in any later status (e.g. when imported)
+	status, ok := core.NormalizePatientStatus(p.Status)
+	switch {
+	case status == "":
+		status = core.PatientActive
+	case !ok:
+		return fmt.Errorf("%w: status must be one of %s", ErrInvalid, strings.Join(core.PatientStatuses, ", "))
+	}
+	p.Status = status
+	// a patient created ARCHIVED starts out of the list, as if archived on creation
+	var archived interface{}
+	p.ArchivedTime = core.JSONTime{}
+	if status == core.PatientArchived {
+		archived = created
+		p.ArchivedTime = core.NewJSONTime(created)
+	}
+	// last paid values are derived from payments, never taken from the request
+	p.LastPaidAmount = nil
+	p.LastPaidDate = core.JSONTime{}
+	p.Version = 1
 
-	_, err := r.db.ExecContext(ctx, `
-		INSERT INTO patients (
-			id, full_name, phone_number, age, gender, chief_complaint, present_history,
-			medical_history, observation, palpation, examination, rehab, diagnosis, created_time, updated_time, last_paid_amount, status, owner_username
-		) VALUES (
-			:1,:2,:3,:4,:5,:6,:7,:8,:9,:10,:11,:12,:13,:14,:15,:16,:17,:18
+	err = withTx(ctx, r.db, func(tx *Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO patients (
+				id, full_name, phone_number, age, gender, chief_complaint, present_history,
+				medical_history, observation, palpation, examination, rehab, diagnosis, created_time, updated_time, status, owner_username,
+				archived_time
+			) VALUES (
+				:1,:2,:3,:4,:5,:6,:7,:8,:9,:10,:11,:12,:13,:14,:15,:16,:17,:18
+			)
+		`,
+			p.ID, p.FullName, p.PhoneNumber, p.Age, p.Gender, p.ChiefComplaint, p.PresentHistory,
+			p.MedicalHistory, p.Observation, p.Palpation, p.Examination, p.Rehab, p.Diagnosis, created, updated,
+			p.Status, owner, archived,
 		)
-	`,
-		p.ID, p.FullName, p.PhoneNumber, p.Age, p.Gender, p.ChiefComplaint, p.PresentHistory,
-		p.MedicalHistory, p.Observation, p.Palpation, p.Examination, p.Rehab, p.Diagnosis, created, updated,
-		p.LastPaidAmount, p.Status, owner,
-	)
+		if err != nil {
+			return err
+		}
+		if err := indexPatient(ctx, tx, owner, *p); err != nil {
+			return err
+		}
+		if err := recordStatusChange(ctx, tx, owner, p.ID, "", p.Status, "", created); err != nil {
+			return err
+		}
+		first := *p
+		first.CreatedTime = core.NewJSONTime(created)
+		return insertEpisode(ctx, tx, owner, firstEpisode(first))
+	})
 	if err != nil {
 		return err
 	}
@@ -55,103 +99,95 @@ func (r *PatientRepo) Create(ctx context.Context, owner string, p *core.Patient)
 	return nil
 }
 
-func (r *PatientRepo) List(ctx context.Context, owner string) ([]core.Patient, error) {
-	var items []core.Patient
-	rows, err := r.db.QueryContext(ctx, `
-		SELECT id, full_name, phone_number, age, gender, chief_complaint, present_history,
-		       medical_history, observation, palpation, examination, rehab, diagnosis, created_time, updated_time, last_paid_amount, status, owner_username
+// ListArchived returns the owner's archived patients (the trash), most recently archived first.
+func (r *PatientRepo) ListArchived(ctx context.Context, owner string) ([]core.Patient, error) {
+	return r.list(ctx, `
+		SELECT `+patientColumns+`
 		FROM patients
-		WHERE owner_username=:1
-		ORDER BY created_time DESC
+		WHERE owner_username=:1 AND archived_time IS NOT NULL
+		ORDER BY archived_time DESC
 	`, owner)
+}
+
+func (r *PatientRepo) list(ctx context.Context, q string, args ...interface{}) ([]core.Patient, error) {
+	var items []core.Patient
+	rows, err := r.db.QueryContext(ctx, q, args...)
 	if err != nil {
 		return items, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var p core.Patient
-		var phone, gender, chief, present, medical, observation, palpation, examination, rehab, diagnosis, status, ownerName sql.NullString
-		var age sql.NullInt64
-		var lastPaid sql.NullFloat64
-		var created, updated sql.NullTime
-		if err := rows.Scan(
-			&p.ID, &p.FullName, &phone, &age, &gender, &chief, &present,
-			&medical, &observation, &palpation, &examination, &rehab, &diagnosis, &created, &updated, &lastPaid, &status, &ownerName,
-		); err != nil {
+		p, err := scanPatient(rows)
+		if err != nil {
 			return make([]core.Patient, 0), err
 		}
-		p.PhoneNumber = nullStringToString(phone)
-		p.Age = nullIntToInt(age)
-		p.Gender = nullStringToString(gender)
-		p.ChiefComplaint = nullStringToString(chief)
-		p.PresentHistory = nullStringToString(present)
-		p.MedicalHistory = nullStringToString(medical)
-		p.Observation = nullStringToString(observation)
-		p.Palpation = nullStringToString(palpation)
-		p.Examination = nullStringToString(examination)
-		p.Rehab = nullStringToString(rehab)
-		p.Diagnosis = nullStringToString(diagnosis)
-		p.LastPaidAmount = nullFloatToFloat(lastPaid)
-		p.Status = nullStringToString(status)
-		p.OwnerUsername = nullStringToString(ownerName)
-		if created.Valid {
-			p.CreatedTime = core.NewJSONTime(created.Time)
-		}
-		if updated.Valid {
-			p.UpdatedTime = core.NewJSONTime(updated.Time)
-		}
 		items = append(items, p)
 	}
 	return items, rows.Err()
 }
 
 func (r *PatientRepo) GetByID(ctx context.Context, owner, id string) (core.Patient, error) {
-	var p core.Patient
-	var phone, gender, chief, present, medical, observation, palpation, examination, rehab, diagnosis, status, ownerName sql.NullString
-	var age sql.NullInt64
-	var lastPaid sql.NullFloat64
-	var created, updated sql.NullTime
-	err := r.db.QueryRowContext(ctx, `
-		SELECT id, full_name, phone_number, age, gender, chief_complaint, present_history,
-		       medical_history, observation, palpation, examination, rehab, diagnosis, created_time, updated_time, last_paid_amount, status, owner_username
+	return getPatientByID(ctx, r.db, owner, id)
+}
+
+func getPatientByID(ctx context.Context, q querier, owner, id string) (core.Patient, error) {
+	p, err := scanPatient(q.QueryRowContext(ctx, `
+		SELECT `+patientColumns+`
 		FROM patients
 		WHERE id=:1 AND owner_username=:2
-	`, id, owner).Scan(
-		&p.ID, &p.FullName, &phone, &age, &gender, &chief, &present,
-		&medical, &observation, &palpation, &examination, &rehab, &diagnosis,
-		&created, &updated, &lastPaid, &status, &ownerName,
-	)
+	`, id, owner))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return p, ErrNotFound
 		}
 		return p, err
 	}
-	p.PhoneNumber = nullStringToString(phone)
-	p.Age = nullIntToInt(age)
-	p.Gender = nullStringToString(gender)
-	p.ChiefComplaint = nullStringToString(chief)
-	p.PresentHistory = nullStringToString(present)
-	p.MedicalHistory = nullStringToString(medical)
-	p.Observation = nullStringToString(observation)
-	p.Palpation = nullStringToString(palpation)
-	p.Examination = nullStringToString(examination)
-	p.Rehab = nullStringToString(rehab)
-	p.Diagnosis = nullStringToString(diagnosis)
-	p.LastPaidAmount = nullFloatToFloat(lastPaid)
-	p.Status = nullStringToString(status)
-	p.OwnerUsername = nullStringToString(ownerName)
-	if created.Valid {
-		p.CreatedTime = core.NewJSONTime(created.Time)
+	return p, nil
+}
+
+// Update applies upd and records every changed field as one revision.
+// A conditional ifMatch must match the stored version; a mismatch yields ErrStale.
+func (r *PatientRepo) Update(ctx context.Context, owner, id string, upd *core.PatientUpdate, ifMatch core.VersionMatch) (core.Patient, error) {
+	if err := r.cleanUpdate(upd); err != nil {
+		return core.Patient{}, err
 	}
-	if updated.Valid {
-		p.UpdatedTime = core.NewJSONTime(updated.Time)
+	var updated core.Patient
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		var err error
+		updated, err = updatePatient(ctx, tx, owner, id, upd, ifMatch)
+		return err
+	})
+	if err != nil {
+		return core.Patient{}, err
 	}
-	return p, nil
+	return updated, nil
+}
+
+// cleanUpdate tidies the name and normalizes the phone number and status of upd the same way Create does.
+func (r *PatientRepo) cleanUpdate(upd *core.PatientUpdate) error {
+	if upd.FullName != nil {
+		name := core.CleanName(*upd.FullName)
+		upd.FullName = &name
+	}
+	if upd.PhoneNumber != nil {
+		phone, err := core.NormalizePhone(*upd.PhoneNumber, r.phoneCountry)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		upd.PhoneNumber = &phone
+	}
+	if upd.Status != nil {
+		status, _ := core.NormalizePatientStatus(*upd.Status)
+		upd.Status = &status
+	}
+	if upd.StatusReason != nil && len(*upd.StatusReason) > maxTextBytes {
+		return fmt.Errorf("%w: status_reason is longer than %d bytes", ErrInvalid, maxTextBytes)
+	}
+	return nil
 }
 
-func (r *PatientRepo) Update(ctx context.Context, owner, id string, upd *core.PatientUpdate) (core.Patient, error) {
+func updatePatient(ctx context.Context, q querier, owner, id string, upd *core.PatientUpdate, ifMatch core.VersionMatch) (core.Patient, error) {
 	sets := []string{}
 	args := []interface{}{}
 
@@ -198,20 +234,37 @@ func (r *PatientRepo) Update(ctx context.Context, owner, id string, upd *core.Pa
 	if upd.Diagnosis != nil {
 		add(true, "diagnosis=:%d", *upd.Diagnosis)
 	}
-	if upd.LastPaidAmount != nil {
-		add(true, "last_paid_amount=:%d", *upd.LastPaidAmount)
-	}
 	if upd.Status != nil {
 		add(true, "status=:%d", *upd.Status)
 	}
 
+	before, err := getPatientByID(ctx, q, owner, id)
+	if err != nil {
+		return core.Patient{}, err
+	}
+	if !ifMatch.Matches(before.Version) {
+		return core.Patient{}, ErrStale
+	}
+	if upd.Status != nil {
+		if err := checkPatientTransition(before.Status, *upd.Status); err != nil {
+			return core.Patient{}, err
+		}
+		// archived patients are the ones out of the list, so archived_time follows the status
+		switch {
+		case *upd.Status == before.Status:
+		case *upd.Status == core.PatientArchived:
+			add(true, "archived_time=:%d", time.Now())
+		case before.Status == core.PatientArchived:
+			add(true, "archived_time=:%d", nil)
+		}
+	}
 	if len(sets) == 0 {
 		// nothing to update
-		return r.GetByID(ctx, owner, id)
+		return before, nil
 	}
 
 	// add updated_time
-	sets = append(sets, "updated_time=SYSTIMESTAMP")
+	add(true, "updated_time=:%d", time.Now())
 
 	// build query with parameter indexes
 	for i := range sets {
@@ -219,18 +272,141 @@ func (r *PatientRepo) Update(ctx context.Context, owner, id string, upd *core.Pa
 	}
 	args = append(args, id)
 	args = append(args, owner)
-	idPos := len(args) - 1
-	ownerPos := len(args)
-	q := "UPDATE patients SET " + strings.Join(sets, ", ") + " WHERE id=:" + strconv.Itoa(idPos) + " AND owner_username=:" + strconv.Itoa(ownerPos)
-	res, err := r.db.ExecContext(ctx, q, args...)
+	args = append(args, before.Version)
+	idPos := len(args) - 2
+	ownerPos := len(args) - 1
+	versionPos := len(args)
+	stmt := "UPDATE patients SET " + strings.Join(sets, ", ") + ", version=version+1" +
+		" WHERE id=:" + strconv.Itoa(idPos) + " AND owner_username=:" + strconv.Itoa(ownerPos) + " AND version=:" + strconv.Itoa(versionPos)
+	res, err := q.ExecContext(ctx, stmt, args...)
 	if err != nil {
 		return core.Patient{}, err
 	}
 	affected, _ := res.RowsAffected()
 	if affected == 0 {
-		return core.Patient{}, ErrNotFound
+		// the row existed a moment ago, so someone else changed it first
+		return core.Patient{}, ErrStale
 	}
-	return r.GetByID(ctx, owner, id)
+	after, err := getPatientByID(ctx, q, owner, id)
+	if err != nil {
+		return core.Patient{}, err
+	}
+	if err := recordRevision(ctx, q, owner, before, after); err != nil {
+		return core.Patient{}, err
+	}
+	if before.Status != after.Status {
+		reason := ""
+		if upd.StatusReason != nil {
+			reason = strings.TrimSpace(*upd.StatusReason)
+		}
+		if err := recordStatusChange(ctx, q, owner, id, before.Status, after.Status, reason, time.Now()); err != nil {
+			return core.Patient{}, err
+		}
+	}
+	if err := indexPatient(ctx, q, owner, after); err != nil {
+		return core.Patient{}, err
+	}
+	if err := syncEpisodeFromPatient(ctx, q, owner, before, after); err != nil {
+		return core.Patient{}, err
+	}
+	return after, nil
+}
+
+// Archive moves a patient to ARCHIVED, which takes them out of List but keeps their
+// payments. It is a status change like any other, recorded in StatusHistory; every
+// status can be archived.
+func (r *PatientRepo) Archive(ctx context.Context, owner, id string) (core.Patient, error) {
+	return r.moveArchived(ctx, owner, id, core.PatientArchived)
+}
+
+// Restore brings an archived patient back as ACTIVE.
+func (r *PatientRepo) Restore(ctx context.Context, owner, id string) (core.Patient, error) {
+	return r.moveArchived(ctx, owner, id, core.PatientActive)
+}
+
+// moveArchived moves a patient into or out of ARCHIVED; a patient already on the
+// requested side is returned as is.
+func (r *PatientRepo) moveArchived(ctx context.Context, owner, id, status string) (core.Patient, error) {
+	var p core.Patient
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		var err error
+		if p, err = getPatientByID(ctx, tx, owner, id); err != nil {
+			return err
+		}
+		if (p.Status == core.PatientArchived) == (status == core.PatientArchived) {
+			return nil
+		}
+		p, err = updatePatient(ctx, tx, owner, id, &core.PatientUpdate{Status: &status}, core.VersionMatch{})
+		return err
+	})
+	return p, err
+}
+
+// Purge permanently deletes an archived patient; everything recorded for the patient goes with it via ON DELETE CASCADE.
+// Active patients must be archived first and yield ErrConflict.
+func (r *PatientRepo) Purge(ctx context.Context, owner, id string) error {
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		p, err := getPatientByID(ctx, tx, owner, id)
+		if err != nil {
+			return err
+		}
+		if p.ArchivedTime.IsZero() {
+			return ErrConflict
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM patients WHERE id=:1 AND owner_username=:2`, id, owner)
+		return err
+	})
+}
+
+// patientColumns is the select list read by scanPatient.
+const patientColumns = `id, full_name, phone_number, age, gender, chief_complaint, present_history,
+		       medical_history, observation, palpation, examination, rehab, diagnosis, created_time, updated_time,
+		       last_paid_minor, last_paid_currency, last_paid_date, status, archived_time, version, owner_username`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPatient(row rowScanner) (core.Patient, error) {
+	var p core.Patient
+	var phone, gender, chief, present, medical, observation, palpation, examination, rehab, diagnosis, status, ownerName sql.NullString
+	var age, lastPaid sql.NullInt64
+	var lastPaidCurrency sql.NullString
+	var created, updated, lastPaidDate, archived sql.NullTime
+	if err := row.Scan(
+		&p.ID, &p.FullName, &phone, &age, &gender, &chief, &present,
+		&medical, &observation, &palpation, &examination, &rehab, &diagnosis, &created, &updated,
+		&lastPaid, &lastPaidCurrency, &lastPaidDate, &status, &archived, &p.Version, &ownerName,
+	); err != nil {
+		return p, err
+	}
+	p.PhoneNumber = nullStringToString(phone)
+	p.Age = nullIntToInt(age)
+	p.Gender = nullStringToString(gender)
+	p.ChiefComplaint = nullStringToString(chief)
+	p.PresentHistory = nullStringToString(present)
+	p.MedicalHistory = nullStringToString(medical)
+	p.Observation = nullStringToString(observation)
+	p.Palpation = nullStringToString(palpation)
+	p.Examination = nullStringToString(examination)
+	p.Rehab = nullStringToString(rehab)
+	p.Diagnosis = nullStringToString(diagnosis)
+	p.LastPaidAmount = nullMoney(lastPaid, lastPaidCurrency)
+	p.Status = nullStringToString(status)
+	p.OwnerUsername = nullStringToString(ownerName)
+	if created.Valid {
+		p.CreatedTime = core.NewJSONTime(created.Time)
+	}
+	if updated.Valid {
+		p.UpdatedTime = core.NewJSONTime(updated.Time)
+	}
+	if lastPaidDate.Valid {
+		p.LastPaidDate = core.NewJSONTime(lastPaidDate.Time)
+	}
+	if archived.Valid {
+		p.ArchivedTime = core.NewJSONTime(archived.Time)
+	}
+	return p, nil
 }
 
 func nullableJSON(b []byte) interface{} {
@@ -261,9 +437,10 @@ func nullIntToInt(ni sql.NullInt64) int {
 	return 0
 }
 
-func nullFloatToFloat(nf sql.NullFloat64) float64 {
-	if nf.Valid {
-		return nf.Float64
+func nullMoney(minor sql.NullInt64, currency sql.NullString) *core.Money {
+	if !minor.Valid {
+		return nil
 	}
-	return 0
+	m := core.NewMoney(minor.Int64, currency.String)
+	return &m
 }
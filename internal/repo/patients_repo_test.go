@@ -0,0 +1,219 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"phsio_track_backend/internal/core"
+)
+
+func setStatus(t *testing.T, r *PatientRepo, id, status string) (core.Patient, error) {
+	t.Helper()
+	return r.Update(context.Background(), "owner", id, &core.PatientUpdate{Status: &status}, core.VersionMatch{})
+}
+
+func listedIDs(t *testing.T, r *PatientRepo, q core.PatientQuery) map[string]bool {
+	t.Helper()
+	page, err := r.List(context.Background(), "owner", q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]bool{}
+	for _, p := range page.Items {
+		ids[p.ID] = true
+	}
+	return ids
+}
+
+func TestPatientArchiveFromAnyStatus(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	r := NewPatientRepo(db, "IN")
+	id := newTestPatient(t, db, "Asha Rao")
+
+	p, err := r.Archive(ctx, "owner", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != core.PatientArchived || p.ArchivedTime.IsZero() {
+		t.Errorf("archived patient: status %s, archived_time %v", p.Status, p.ArchivedTime)
+	}
+	if listedIDs(t, r, core.PatientQuery{})[id] {
+		t.Error("archived patient is still listed")
+	}
+	if !listedIDs(t, r, core.PatientQuery{Statuses: []string{"archived"}})[id] {
+		t.Error("archived patient is not listed by status")
+	}
+	trash, err := r.ListArchived(ctx, "owner")
+	if err != nil || len(trash) != 1 || trash[0].ID != id {
+		t.Errorf("ListArchived = %d patients, %v", len(trash), err)
+	}
+
+	// archiving again changes nothing
+	again, err := r.Archive(ctx, "owner", id)
+	if err != nil || again.Version != p.Version {
+		t.Errorf("archiving twice: version %d, %v; want %d", again.Version, err, p.Version)
+	}
+
+	p, err = r.Restore(ctx, "owner", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != core.PatientActive || !p.ArchivedTime.IsZero() {
+		t.Errorf("restored patient: status %s, archived_time %v", p.Status, p.ArchivedTime)
+	}
+	if !listedIDs(t, r, core.PatientQuery{})[id] {
+		t.Error("restored patient is not listed")
+	}
+
+	history, err := r.StatusHistory(ctx, "owner", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var moves []string
+	for i := len(history) - 1; i >= 0; i-- {
+		moves = append(moves, history[i].From+">"+history[i].To)
+	}
+	if got := strings.Join(moves, " "); got != ">ACTIVE ACTIVE>ARCHIVED ARCHIVED>ACTIVE" {
+		t.Errorf("status history = %s", got)
+	}
+
+	for _, status := range []string{core.PatientOnHold, core.PatientDischarged} {
+		other := newTestPatient(t, db, "Ravi Kumar")
+		if _, err := setStatus(t, r, other, status); err != nil {
+			t.Fatal(err)
+		}
+		if p, err := r.Archive(ctx, "owner", other); err != nil || p.Status != core.PatientArchived {
+			t.Errorf("archiving a %s patient: status %s, %v", status, p.Status, err)
+		}
+	}
+}
+
+func TestPatientStatusArchivesAndRestores(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	r := NewPatientRepo(db, "IN")
+
+	enquiry := core.Patient{FullName: "Ravi Kumar", Status: "enquiry"}
+	if err := r.Create(ctx, "owner", &enquiry); err != nil {
+		t.Fatal(err)
+	}
+	p, err := setStatus(t, r, enquiry.ID, core.PatientArchived)
+	if err != nil || p.ArchivedTime.IsZero() {
+		t.Fatalf("PATCH to ARCHIVED: archived_time %v, %v", p.ArchivedTime, err)
+	}
+	p, err = setStatus(t, r, enquiry.ID, core.PatientActive)
+	if err != nil || !p.ArchivedTime.IsZero() {
+		t.Fatalf("PATCH out of ARCHIVED: archived_time %v, %v", p.ArchivedTime, err)
+	}
+
+	created := core.Patient{FullName: "Meera Iyer", Status: core.PatientArchived}
+	if err := r.Create(ctx, "owner", &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ArchivedTime.IsZero() || listedIDs(t, r, core.PatientQuery{})[created.ID] {
+		t.Error("a patient created ARCHIVED is not archived")
+	}
+
+	// a merge archives its source whatever the status
+	source := newTestPatient(t, db, "Ravi K")
+	if _, err := r.Merge(ctx, "owner", enquiry.ID, core.PatientMerge{SourceID: source}, core.VersionMatch{}); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := r.GetByID(ctx, "owner", source)
+	if err != nil || merged.Status != core.PatientArchived || merged.ArchivedTime.IsZero() {
+		t.Errorf("merged source: status %s, archived_time %v, %v", merged.Status, merged.ArchivedTime, err)
+	}
+}
+
+func TestCheckPatientTransitionMessages(t *testing.T) {
+	tests := []struct {
+		from, to, want string
+	}{
+		{core.PatientActive, core.PatientEnquiry, "cannot move patient from ACTIVE to ENQUIRY, only to ON_HOLD, DISCHARGED, ARCHIVED"},
+		{core.PatientArchived, core.PatientOnHold, "cannot move patient from ARCHIVED to ON_HOLD, only to ACTIVE"},
+	}
+	for _, tt := range tests {
+		err := checkPatientTransition(tt.from, tt.to)
+		if !errors.Is(err, ErrConflict) || !strings.HasSuffix(err.Error(), tt.want) {
+			t.Errorf("%s to %s: %v, want %q", tt.from, tt.to, err, tt.want)
+		}
+	}
+	if err := checkPatientTransition(core.PatientActive, "GONE"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("unknown status: %v, want ErrInvalid", err)
+	}
+}
+
+func TestBackfillArchivedPatients(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	r := NewPatientRepo(db, "IN")
+	trashed := newTestPatient(t, db, "Asha Rao")
+	closed := newTestPatient(t, db, "Ravi Kumar")
+
+	// the two meanings of archived before migration 21
+	if _, err := db.ExecContext(ctx, `UPDATE patients SET archived_time = updated_time WHERE id = :1`, trashed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `UPDATE patients SET status = :1 WHERE id = :2`, core.PatientArchived, closed); err != nil {
+		t.Fatal(err)
+	}
+	err := withTx(ctx, db, func(tx *Tx) error { return backfillArchivedPatients(ctx, tx) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{trashed, closed} {
+		p, err := r.GetByID(ctx, "owner", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Status != core.PatientArchived || p.ArchivedTime.IsZero() {
+			t.Errorf("%s: status %s, archived_time %v", p.FullName, p.Status, p.ArchivedTime)
+		}
+	}
+	// the move is recorded as of the archiving, which here is also the creation time
+	history, err := r.StatusHistory(ctx, "owner", trashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := false
+	for _, c := range history {
+		recorded = recorded || c.From == core.PatientActive && c.To == core.PatientArchived
+	}
+	if len(history) != 2 || !recorded {
+		t.Errorf("trashed patient's status history = %+v", history)
+	}
+}
+
+func TestPatientRevertHonoursIfMatch(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	r := NewPatientRepo(db, "IN")
+	id := newTestPatient(t, db, "Asha Rao")
+
+	name := "Asha R."
+	p, err := r.Update(ctx, "owner", id, &core.PatientUpdate{FullName: &name}, core.VersionMatch{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := r.History(ctx, "owner", id)
+	if err != nil || len(history) == 0 {
+		t.Fatalf("History = %d revisions, %v", len(history), err)
+	}
+	rev := history[0].ID
+
+	stale := core.VersionMatch{Versions: []int{p.Version - 1}}
+	if _, err := r.RevertField(ctx, "owner", id, rev, "full_name", stale); !errors.Is(err, ErrStale) {
+		t.Fatalf("revert with a stale If-Match: %v, want ErrStale", err)
+	}
+	reverted, err := r.RevertField(ctx, "owner", id, rev, "full_name", core.VersionMatch{Versions: []int{p.Version}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted.FullName != "Asha Rao" || reverted.Version != p.Version+1 {
+		t.Errorf("reverted = %q version %d, want %q version %d", reverted.FullName, reverted.Version, "Asha Rao", p.Version+1)
+	}
+}
@@ -3,6 +3,7 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -12,69 +13,171 @@ import (
 )
 
 type PaymentRepo struct {
-	db *sql.DB
+	db       *DB
+	currency string
 }
 
-func NewPaymentRepo(db *sql.DB) *PaymentRepo {
-	return &PaymentRepo{db: db}
+// NewPaymentRepo returns a PaymentRepo taking payments in currency; amounts given
+// without one are read in it.
+func NewPaymentRepo(db *DB, currency string) *PaymentRepo {
+	return &PaymentRepo{db: db, currency: currency}
 }
 
+// Create records a payment, applies its allocations and settles the patient's
+// oldest open invoices with whatever is left of it.
 func (r *PaymentRepo) Create(ctx context.Context, owner string, p *core.Payment) error {
-	if err := r.assertPatientOwner(ctx, owner, p.PatientID); err != nil {
+	amount, err := resolveAmount(p.Amount, r.currency)
+	if err != nil {
 		return err
 	}
+	p.Amount = amount
 	p.Mode = strings.ToUpper(strings.TrimSpace(p.Mode))
 	if p.ID == "" {
 		p.ID = uuid.NewString()
 	}
-	_, err := r.db.ExecContext(ctx, `
-		INSERT INTO payments (id, patient_id, amount, payment_mode, paid_date, owner_username)
-		VALUES (:1,:2,:3,:4,:5,:6)
-	`, p.ID, p.PatientID, p.Amount, p.Mode, p.Date, owner)
-	if err != nil {
-		return err
-	}
-	if err := r.setLastPaid(ctx, owner, p.PatientID, p.Amount); err != nil {
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		if err := assertPatientOwner(ctx, tx, owner, p.PatientID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO payments (id, patient_id, amount_minor, currency, payment_mode, paid_date, owner_username)
+			VALUES (:1,:2,:3,:4,:5,:6,:7)
+		`, p.ID, p.PatientID, p.Amount.Minor, p.Amount.Currency, p.Mode, p.Date, owner)
+		if err != nil {
+			return err
+		}
+		p.Version = 1
+		if p.EpisodeID, err = linkPaymentEpisode(ctx, tx, owner, p.ID, p.PatientID, p.EpisodeID, p.Date.Time); err != nil {
+			return err
+		}
+		if err := allocateExplicit(ctx, tx, owner, r.currency, p); err != nil {
+			return err
+		}
+		if err := refreshLastPaid(ctx, tx, owner, p.PatientID); err != nil {
+			return err
+		}
+		if err := allocatePayments(ctx, tx, owner, p.PatientID); err != nil {
+			return err
+		}
+		allocations, err := paymentAllocations(ctx, tx, owner, "", p.ID)
+		p.Allocations = allocations[p.ID]
 		return err
-	}
-	return nil
+	})
 }
 
-// Upsert inserts or updates a payment keyed by id.
+// Upsert inserts or updates a payment keyed by id. A payment whose amount or patient
+// changes is allocated afresh; allocations sent with it are ignored. A receipted payment
+// can only be upserted unchanged, and an id already used by another owner yields ErrConflict.
 func (r *PaymentRepo) Upsert(ctx context.Context, owner string, p *core.Payment) error {
-	if err := r.assertPatientOwner(ctx, owner, p.PatientID); err != nil {
+	amount, err := resolveAmount(p.Amount, r.currency)
+	if err != nil {
 		return err
 	}
+	p.Amount = amount
 	p.Mode = strings.ToUpper(strings.TrimSpace(p.Mode))
 	if p.ID == "" {
 		p.ID = uuid.NewString()
 	}
-	_, err := r.db.ExecContext(ctx, `
+	q := `
+		INSERT INTO payments (id, patient_id, amount_minor, currency, payment_mode, paid_date, owner_username)
+		VALUES (:1,:2,:3,:4,:5,:6,:7)
+		ON CONFLICT (id) DO UPDATE SET amount_minor = excluded.amount_minor,
+		                               currency = excluded.currency,
+		                               payment_mode = excluded.payment_mode,
+		                               paid_date = excluded.paid_date,
+		                               patient_id = excluded.patient_id,
+		                               version = payments.version + 1
+		WHERE payments.owner_username = excluded.owner_username
+	`
+	if r.db.Dialect() == DialectOracle {
+		q = `
 		MERGE INTO payments t
 		USING (SELECT :1 AS id,
 		              :2 AS patient_id,
-		              :3 AS amount,
-		              :4 AS payment_mode,
-		              :5 AS paid_date,
-		              :6 AS owner_username
+		              :3 AS amount_minor,
+		              :4 AS currency,
+		              :5 AS payment_mode,
+		              :6 AS paid_date,
+		              :7 AS owner_username
 		       FROM dual) s
 		ON (t.id = s.id AND t.owner_username = s.owner_username)
 		WHEN MATCHED THEN
-		  UPDATE SET t.amount = s.amount,
+		  UPDATE SET t.amount_minor = s.amount_minor,
+		             t.currency = s.currency,
 		             t.payment_mode = s.payment_mode,
 		             t.paid_date = s.paid_date,
-		             t.patient_id = s.patient_id
+		             t.patient_id = s.patient_id,
+		             t.version = t.version + 1
 		WHEN NOT MATCHED THEN
-		  INSERT (id, patient_id, amount, payment_mode, paid_date, owner_username)
-		  VALUES (s.id, s.patient_id, s.amount, s.payment_mode, s.paid_date, s.owner_username)
-	`, p.ID, p.PatientID, p.Amount, p.Mode, p.Date, owner)
-	if err != nil {
-		return err
+		  INSERT (id, patient_id, amount_minor, currency, payment_mode, paid_date, owner_username)
+		  VALUES (s.id, s.patient_id, s.amount_minor, s.currency, s.payment_mode, s.paid_date, s.owner_username)
+	`
 	}
-	if err := r.setLastPaid(ctx, owner, p.PatientID, p.Amount); err != nil {
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		if err := assertPatientOwner(ctx, tx, owner, p.PatientID); err != nil {
+			return err
+		}
+		// an upsert may move the payment to another patient; both need recomputing
+		previous, err := getPaymentByID(ctx, tx, owner, p.ID)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if err == ErrNotFound {
+			if err := assertPaymentIDFree(ctx, tx, p.ID); err != nil {
+				return err
+			}
+		}
+		if previous.PatientID != "" && (previous.PatientID != p.PatientID || previous.Amount != p.Amount ||
+			previous.Mode != p.Mode || previous.Date.Format("2006-01-02") != p.Date.Format("2006-01-02")) {
+			if err := lockPayment(ctx, tx, owner, p.ID); err != nil {
+				return err
+			}
+			if err := assertNoReceipt(ctx, tx, owner, p.ID); err != nil {
+				return err
+			}
+		}
+		if previous.PatientID != "" && (previous.PatientID != p.PatientID || previous.Amount.Minor != p.Amount.Minor) {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM payment_allocations WHERE payment_id=:1`, p.ID); err != nil {
+				return err
+			}
+		}
+		res, err := tx.ExecContext(ctx, q, p.ID, p.PatientID, p.Amount.Minor, p.Amount.Currency, p.Mode, p.Date, owner)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return fmt.Errorf("%w: payment %s belongs to another user", ErrConflict, p.ID)
+		}
+		if p.EpisodeID, err = linkPaymentEpisode(ctx, tx, owner, p.ID, p.PatientID, p.EpisodeID, p.Date.Time); err != nil {
+			return err
+		}
+		if previous.PatientID != "" && previous.PatientID != p.PatientID {
+			if err := refreshLastPaid(ctx, tx, owner, previous.PatientID); err != nil {
+				return err
+			}
+			if err := allocatePayments(ctx, tx, owner, previous.PatientID); err != nil {
+				return err
+			}
+		}
+		if err := refreshLastPaid(ctx, tx, owner, p.PatientID); err != nil {
+			return err
+		}
+		return allocatePayments(ctx, tx, owner, p.PatientID)
+	})
+}
+
+// assertPaymentIDFree returns ErrConflict when id is already taken by another owner's
+// payment, which an upsert must neither overwrite nor trip over as a duplicate key.
+func assertPaymentIDFree(ctx context.Context, q querier, id string) error {
+	var other string
+	err := q.QueryRowContext(ctx, `SELECT owner_username FROM payments WHERE id=:1`, id).Scan(&other)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
-	return nil
+	return fmt.Errorf("%w: payment %s belongs to another user", ErrConflict, id)
 }
 
 func (r *PaymentRepo) List(ctx context.Context, owner, patientID string) ([]core.Payment, error) {
@@ -82,14 +185,14 @@ func (r *PaymentRepo) List(ctx context.Context, owner, patientID string) ([]core
 	var err error
 	if patientID != "" && patientID != "ALL" {
 		rows, err = r.db.QueryContext(ctx, `
-			SELECT id, patient_id, amount, payment_mode, paid_date
+			SELECT id, patient_id, amount_minor, currency, payment_mode, paid_date, version, episode_id
 			FROM payments
 			WHERE patient_id=:1 AND owner_username=:2
 			ORDER BY paid_date DESC
 		`, patientID, owner)
 	} else {
 		rows, err = r.db.QueryContext(ctx, `
-			SELECT id, patient_id, amount, payment_mode, paid_date
+			SELECT id, patient_id, amount_minor, currency, payment_mode, paid_date, version, episode_id
 			FROM payments
 			WHERE owner_username=:1
 			ORDER BY paid_date DESC
@@ -98,54 +201,68 @@ func (r *PaymentRepo) List(ctx context.Context, owner, patientID string) ([]core
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
 	var items []core.Payment
 	for rows.Next() {
 		var p core.Payment
 		var paid sql.NullTime
-		if err := rows.Scan(&p.ID, &p.PatientID, &p.Amount, &p.Mode, &paid); err != nil {
+		var episode sql.NullString
+		if err := rows.Scan(&p.ID, &p.PatientID, &p.Amount.Minor, &p.Amount.Currency, &p.Mode, &paid, &p.Version, &episode); err != nil {
+			rows.Close()
 			return nil, err
 		}
 		if paid.Valid {
 			p.Date = core.NewJSONTime(paid.Time)
 		}
+		p.EpisodeID = nullStringToString(episode)
 		items = append(items, p)
 	}
-	return items, rows.Err()
-}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-func (r *PaymentRepo) Update(ctx context.Context, owner, id string, upd *core.PaymentUpdate) (core.Payment, error) {
-	// Ensure payment belongs to a patient owned by requester
-	current, err := r.GetByID(ctx, owner, id)
+	if patientID == "ALL" {
+		patientID = ""
+	}
+	allocations, err := paymentAllocations(ctx, r.db, owner, patientID, "")
 	if err != nil {
-		if err == ErrNotFound {
-			return core.Payment{}, ErrForbidden
-		}
-		return core.Payment{}, err
+		return nil, err
 	}
-	if err := r.assertPatientOwner(ctx, owner, current.PatientID); err != nil {
-		return core.Payment{}, err
+	for i := range items {
+		items[i].Allocations = allocations[items[i].ID]
 	}
+	return items, nil
+}
 
+// Update applies upd to a payment. A conditional ifMatch must match the stored version;
+// a mismatch yields ErrStale. A new amount is allocated afresh to the oldest open invoices.
+// Once a receipt has been issued, changing the amount, mode or date yields ErrConflict.
+func (r *PaymentRepo) Update(ctx context.Context, owner, id string, upd *core.PaymentUpdate, ifMatch core.VersionMatch) (core.Payment, error) {
 	// Build update set
 	type field struct {
 		name string
 		val  interface{}
 	}
 	fields := []field{}
+	var amount core.Money
+	var mode string
 	if upd.Amount != nil {
-		fields = append(fields, field{name: "amount", val: *upd.Amount})
+		var err error
+		if amount, err = resolveAmount(*upd.Amount, r.currency); err != nil {
+			return core.Payment{}, err
+		}
+		fields = append(fields, field{name: "amount_minor", val: amount.Minor}, field{name: "currency", val: amount.Currency})
 	}
 	if upd.Mode != nil {
-		mode := strings.ToUpper(strings.TrimSpace(*upd.Mode))
+		mode = strings.ToUpper(strings.TrimSpace(*upd.Mode))
 		fields = append(fields, field{name: "payment_mode", val: mode})
 	}
 	if upd.Date != nil {
 		fields = append(fields, field{name: "paid_date", val: upd.Date.Time})
 	}
-	if len(fields) == 0 {
-		return r.GetByID(ctx, owner, id)
+	if upd.EpisodeID != nil {
+		fields = append(fields, field{name: "episode_id", val: nullableText(strings.TrimSpace(*upd.EpisodeID))})
 	}
 
 	args := []interface{}{}
@@ -158,57 +275,173 @@ func (r *PaymentRepo) Update(ctx context.Context, owner, id string, upd *core.Pa
 		args = append(args, f.val)
 	}
 	args = append(args, id, owner)
+	n := len(args)
+	q := "UPDATE payments SET " + setClauses + ", version=version+1" +
+		" WHERE id=:" + strconv.Itoa(n-1) + " AND owner_username=:" + strconv.Itoa(n) + " AND version=:" + strconv.Itoa(n+1)
 
-	q := "UPDATE payments SET " + setClauses + " WHERE id=:" + strconv.Itoa(len(args)-1) + " AND owner_username=:" + strconv.Itoa(len(args))
-	res, err := r.db.ExecContext(ctx, q, args...)
-	if err != nil {
-		return core.Payment{}, err
-	}
-	if rows, _ := res.RowsAffected(); rows == 0 {
-		return core.Payment{}, ErrNotFound
-	}
-	updatedPayment, err := r.GetByID(ctx, owner, id)
+	var updated core.Payment
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		// Ensure payment belongs to a patient owned by requester
+		current, err := getPaymentByID(ctx, tx, owner, id)
+		if err != nil {
+			if err == ErrNotFound {
+				return ErrForbidden
+			}
+			return err
+		}
+		if err := assertPatientOwner(ctx, tx, owner, current.PatientID); err != nil {
+			return err
+		}
+		if !ifMatch.Matches(current.Version) {
+			return ErrStale
+		}
+		if len(fields) == 0 {
+			updated = current
+			return nil
+		}
+		// what a receipt prints is fixed once one is issued; the episode is not on it
+		if upd.Amount != nil && amount != current.Amount || upd.Mode != nil && mode != current.Mode ||
+			upd.Date != nil && upd.Date.Format("2006-01-02") != current.Date.Format("2006-01-02") {
+			if err := lockPayment(ctx, tx, owner, id); err != nil {
+				return err
+			}
+			if err := assertNoReceipt(ctx, tx, owner, id); err != nil {
+				return err
+			}
+		}
+		if upd.EpisodeID != nil && *upd.EpisodeID != "" {
+			if _, err := getEpisode(ctx, tx, owner, current.PatientID, *upd.EpisodeID); err == ErrNotFound {
+				return fmt.Errorf("%w: episode %s is not one of the patient's", ErrInvalid, *upd.EpisodeID)
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if upd.Amount != nil {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM payment_allocations WHERE payment_id=:1`, id); err != nil {
+				return err
+			}
+		}
+		res, err := tx.ExecContext(ctx, q, append(args, current.Version)...)
+		if err != nil {
+			return err
+		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			return ErrStale
+		}
+		if upd.EpisodeID != nil && *upd.EpisodeID == "" {
+			// an empty episode_id files the payment under the episode of its date again
+			paid, err := getPaymentByID(ctx, tx, owner, id)
+			if err != nil {
+				return err
+			}
+			if _, err := linkPaymentEpisode(ctx, tx, owner, id, paid.PatientID, "", paid.Date.Time); err != nil {
+				return err
+			}
+		}
+		if err := refreshLastPaid(ctx, tx, owner, current.PatientID); err != nil {
+			return err
+		}
+		if err := allocatePayments(ctx, tx, owner, current.PatientID); err != nil {
+			return err
+		}
+		updated, err = getPaymentByID(ctx, tx, owner, id)
+		return err
+	})
 	if err != nil {
 		return core.Payment{}, err
 	}
-	if err := r.setLastPaid(ctx, owner, updatedPayment.PatientID, updatedPayment.Amount); err != nil {
-		return core.Payment{}, err
-	}
-	return updatedPayment, nil
+	return updated, nil
 }
 
+// Delete removes a payment; a payment with a receipt cannot be deleted (ErrConflict).
 func (r *PaymentRepo) Delete(ctx context.Context, owner, id string) error {
-	// Ensure the payment belongs to a patient owned by requester
-	p, err := r.GetByID(ctx, owner, id)
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		// Ensure the payment belongs to a patient owned by requester
+		p, err := getPaymentByID(ctx, tx, owner, id)
+		if err != nil {
+			return err
+		}
+		if err := assertPatientOwner(ctx, tx, owner, p.PatientID); err != nil {
+			return err
+		}
+		if err := lockPayment(ctx, tx, owner, id); err != nil {
+			return err
+		}
+		if err := assertNoReceipt(ctx, tx, owner, id); err != nil {
+			return err
+		}
+
+		cmd, err := tx.ExecContext(ctx, `DELETE FROM payments WHERE id=:1 AND owner_username=:2`, id, owner)
+		if err != nil {
+			return err
+		}
+		rows, err := cmd.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrNotFound
+		}
+		// its allocations went with it; other credit may now settle the invoices it paid
+		if err := refreshLastPaid(ctx, tx, owner, p.PatientID); err != nil {
+			return err
+		}
+		return allocatePayments(ctx, tx, owner, p.PatientID)
+	})
+}
+
+func (r *PaymentRepo) GetByID(ctx context.Context, owner, id string) (core.Payment, error) {
+	return getPaymentByID(ctx, r.db, owner, id)
+}
+
+// RecomputeLastPaid rebuilds the last paid amount and date for every patient
+// from their payments and returns how many patients were processed.
+func (r *PaymentRepo) RecomputeLastPaid(ctx context.Context) (int, error) {
+	type key struct{ id, owner string }
+	var patients []key
+	rows, err := r.db.QueryContext(ctx, `SELECT id, owner_username FROM patients`)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	if err := r.assertPatientOwner(ctx, owner, p.PatientID); err != nil {
-		return err
+	for rows.Next() {
+		var k key
+		var owner sql.NullString
+		if err := rows.Scan(&k.id, &owner); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		k.owner = nullStringToString(owner)
+		patients = append(patients, k)
 	}
-
-	cmd, err := r.db.ExecContext(ctx, `DELETE FROM payments WHERE id=:1 AND owner_username=:2`, id, owner)
-	if err != nil {
-		return err
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
 	}
-	rows, err := cmd.RowsAffected()
+
+	err = withTx(ctx, r.db, func(tx *Tx) error {
+		for _, k := range patients {
+			if err := refreshLastPaid(ctx, tx, k.owner, k.id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return err
-	}
-	if rows == 0 {
-		return ErrNotFound
+		return 0, err
 	}
-	return nil
+	return len(patients), nil
 }
 
-func (r *PaymentRepo) GetByID(ctx context.Context, owner, id string) (core.Payment, error) {
+func getPaymentByID(ctx context.Context, q querier, owner, id string) (core.Payment, error) {
 	var p core.Payment
 	var paid sql.NullTime
-	err := r.db.QueryRowContext(ctx, `
-		SELECT id, patient_id, amount, payment_mode, paid_date
+	var episode sql.NullString
+	err := q.QueryRowContext(ctx, `
+		SELECT id, patient_id, amount_minor, currency, payment_mode, paid_date, version, episode_id
 		FROM payments
 		WHERE id=:1 AND owner_username=:2
-	`, id, owner).Scan(&p.ID, &p.PatientID, &p.Amount, &p.Mode, &paid)
+	`, id, owner).Scan(&p.ID, &p.PatientID, &p.Amount.Minor, &p.Amount.Currency, &p.Mode, &paid, &p.Version, &episode)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return p, ErrNotFound
@@ -218,13 +451,16 @@ func (r *PaymentRepo) GetByID(ctx context.Context, owner, id string) (core.Payme
 	if paid.Valid {
 		p.Date = core.NewJSONTime(paid.Time)
 	}
-	return p, nil
+	p.EpisodeID = nullStringToString(episode)
+	allocations, err := paymentAllocations(ctx, q, owner, "", id)
+	p.Allocations = allocations[id]
+	return p, err
 }
 
 // assertPatientOwner ensures the patient belongs to the requesting owner.
-func (r *PaymentRepo) assertPatientOwner(ctx context.Context, owner, patientID string) error {
+func assertPatientOwner(ctx context.Context, q querier, owner, patientID string) error {
 	var exists int
-	err := r.db.QueryRowContext(ctx, `
+	err := q.QueryRowContext(ctx, `
 		SELECT 1
 		FROM patients
 		WHERE id=:1 AND owner_username=:2
@@ -238,12 +474,27 @@ func (r *PaymentRepo) assertPatientOwner(ctx context.Context, owner, patientID s
 	return nil
 }
 
-// setLastPaid updates the patient's last_paid_amount.
-func (r *PaymentRepo) setLastPaid(ctx context.Context, owner, patientID string, amount float64) error {
-	_, err := r.db.ExecContext(ctx, `
+// refreshLastPaid sets the patient's last paid amount and date from their most
+// recent payment by paid_date, or clears them when no payments remain.
+func refreshLastPaid(ctx context.Context, q querier, owner, patientID string) error {
+	var amount sql.NullInt64
+	var currency sql.NullString
+	var paid sql.NullTime
+	err := q.QueryRowContext(ctx, `
+		SELECT amount_minor, currency, paid_date
+		FROM payments
+		WHERE patient_id=:1 AND owner_username=:2
+		ORDER BY paid_date DESC NULLS LAST, id DESC
+	`, patientID, owner).Scan(&amount, &currency, &paid)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	_, err = q.ExecContext(ctx, `
 		UPDATE patients
-		   SET last_paid_amount = :1
-		 WHERE id = :2 AND owner_username = :3
-	`, amount, patientID, owner)
+		   SET last_paid_minor = :1,
+		       last_paid_currency = :2,
+		       last_paid_date = :3
+		 WHERE id = :4 AND owner_username = :5
+	`, amount, currency, paid, patientID, owner)
 	return err
 }
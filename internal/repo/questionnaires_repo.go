@@ -0,0 +1,569 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"phsio_track_backend/internal/core"
+)
+
+// builtInOwner is the owner_username of the questionnaires every owner can use.
+const builtInOwner = "*"
+
+// The built-in instruments (ODI, NDI, LEFS, DASH) are definitions like any other; these
+// files are only what migration 14 and `repair questionnaires` load.
+//
+//go:embed questionnaires/*.json
+var builtInQuestionnaireFiles embed.FS
+
+// QuestionnaireRepo stores questionnaire definitions and the patients' scored responses.
+type QuestionnaireRepo struct {
+	db *DB
+}
+
+func NewQuestionnaireRepo(db *DB) *QuestionnaireRepo {
+	return &QuestionnaireRepo{db: db}
+}
+
+// List returns the built-in questionnaires and the owner's own, by code, without their
+// items and options.
+func (r *QuestionnaireRepo) List(ctx context.Context, owner string) ([]core.Questionnaire, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+questionnaireColumns+` FROM questionnaires
+		 WHERE owner_username IN (:1, :2)
+		 ORDER BY code
+	`, owner, builtInOwner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []core.Questionnaire{}
+	index := map[string]int{}
+	for rows.Next() {
+		q, err := scanQuestionnaire(rows)
+		if err != nil {
+			return nil, err
+		}
+		q.Options = nil
+		// an owner's own definition shadows a built-in added later under its code
+		if i, ok := index[q.Code]; ok {
+			if !q.BuiltIn {
+				items[i] = q
+			}
+			continue
+		}
+		index[q.Code] = len(items)
+		items = append(items, q)
+	}
+	return items, rows.Err()
+}
+
+// Get returns a questionnaire with its items, the owner's own before a built-in one.
+func (r *QuestionnaireRepo) Get(ctx context.Context, owner, code string) (core.Questionnaire, error) {
+	return getQuestionnaire(ctx, r.db, owner, code)
+}
+
+// Put creates or replaces one of the owner's questionnaires. Built-in codes cannot be
+// reused; a conditional ifMatch must match the stored definition.
+func (r *QuestionnaireRepo) Put(ctx context.Context, owner string, q *core.Questionnaire, ifMatch core.VersionMatch) error {
+	code, ok := core.NormalizeQuestionnaireCode(q.Code)
+	if !ok {
+		return fmt.Errorf("%w: questionnaire code must be letters, digits, - or _ (at most 32)", ErrInvalid)
+	}
+	q.Code = code
+	q.Name = strings.TrimSpace(q.Name)
+	q.Description = strings.TrimSpace(q.Description)
+	q.Scoring.Method = strings.ToLower(strings.TrimSpace(q.Scoring.Method))
+	for i := range q.Items {
+		q.Items[i].ID = strings.TrimSpace(q.Items[i].ID)
+		q.Items[i].Text = strings.TrimSpace(q.Items[i].Text)
+	}
+	if err := q.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		current, err := getQuestionnaire(ctx, tx, owner, code)
+		switch {
+		case err == ErrNotFound:
+			if ifMatch.Conditional() {
+				return ErrStale
+			}
+			if err := insertQuestionnaire(ctx, tx, owner, *q); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case current.BuiltIn:
+			return fmt.Errorf("%w: %s is a built-in questionnaire; pick another code", ErrConflict, code)
+		default:
+			if !ifMatch.Matches(current.Version) {
+				return ErrStale
+			}
+			if err := updateQuestionnaire(ctx, tx, owner, *q, current.Version); err != nil {
+				return err
+			}
+		}
+		*q, err = getQuestionnaire(ctx, tx, owner, code)
+		return err
+	})
+}
+
+// Delete removes one of the owner's questionnaires. Responses keep their scores.
+func (r *QuestionnaireRepo) Delete(ctx context.Context, owner, code string) error {
+	code, _ = core.NormalizeQuestionnaireCode(code)
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM questionnaire_items WHERE owner_username=:1 AND code=:2
+		`, owner, code); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `DELETE FROM questionnaires WHERE owner_username=:1 AND code=:2`, owner, code)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected > 0 {
+			return nil
+		}
+		if _, err := getQuestionnaire(ctx, tx, builtInOwner, code); err == nil {
+			return fmt.Errorf("%w: built-in questionnaires cannot be deleted", ErrForbidden)
+		}
+		return ErrNotFound
+	})
+}
+
+// Submit scores a patient's answers with the current definition and records them.
+// TakenAt defaults to now.
+func (r *QuestionnaireRepo) Submit(ctx context.Context, owner string, resp *core.QuestionnaireResponse) error {
+	resp.Code, _ = core.NormalizeQuestionnaireCode(resp.Code)
+	resp.Notes = strings.TrimSpace(resp.Notes)
+	if resp.TakenAt.IsZero() {
+		resp.TakenAt = core.NewJSONTime(time.Now())
+	}
+	if len(resp.Notes) > maxTextBytes {
+		return fmt.Errorf("%w: notes are longer than %d bytes", ErrInvalid, maxTextBytes)
+	}
+	resp.ID = uuid.NewString()
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		if err := assertPatientOwner(ctx, tx, owner, resp.PatientID); err != nil {
+			return err
+		}
+		def, err := getQuestionnaire(ctx, tx, owner, resp.Code)
+		if err == ErrNotFound {
+			return fmt.Errorf("%w: unknown questionnaire %q", ErrInvalid, resp.Code)
+		}
+		if err != nil {
+			return err
+		}
+		score, band, answered, err := def.Score(resp.Answers)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		if answered == 0 {
+			return fmt.Errorf("%w: no items answered", ErrInvalid)
+		}
+		answers, err := questionnaireJSON("answers", resp.Answers)
+		if err != nil {
+			return err
+		}
+		var scoreArg interface{}
+		if score != nil {
+			scoreArg = *score
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO questionnaire_responses (id, patient_id, owner_username, code, name, definition_version, answers,
+			                                     score, band, answered, taken_at, notes, created_time, version)
+			VALUES (:1,:2,:3,:4,:5,:6,:7,:8,:9,:10,:11,:12,:13,1)
+		`, resp.ID, resp.PatientID, owner, def.Code, def.Name, def.Version, answers, scoreArg, nullableText(band),
+			answered, resp.TakenAt, nullableText(resp.Notes), time.Now())
+		if err != nil {
+			return err
+		}
+		*resp, err = getQuestionnaireResponse(ctx, tx, owner, resp.PatientID, resp.ID)
+		return err
+	})
+}
+
+// History returns a patient's responses grouped per questionnaire, oldest first, in the
+// order each questionnaire was first taken. A non-empty code keeps only that one.
+func (r *QuestionnaireRepo) History(ctx context.Context, owner, patientID, code string) ([]core.QuestionnaireHistory, error) {
+	if err := assertPatientOwner(ctx, r.db, owner, patientID); err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + responseColumns + ` FROM questionnaire_responses WHERE patient_id=:1 AND owner_username=:2`
+	args := []interface{}{patientID, owner}
+	if code != "" {
+		code, _ = core.NormalizeQuestionnaireCode(code)
+		args = append(args, code)
+		query += ` AND code = :` + strconv.Itoa(len(args))
+	}
+	rows, err := r.db.QueryContext(ctx, query+` ORDER BY taken_at, created_time, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	var responses []core.QuestionnaireResponse
+	for rows.Next() {
+		resp, err := scanQuestionnaireResponse(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		responses = append(responses, resp)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	index := map[string]int{}
+	out := []core.QuestionnaireHistory{}
+	for _, resp := range responses {
+		i, ok := index[resp.Code]
+		if !ok {
+			i = len(out)
+			index[resp.Code] = i
+			h := core.QuestionnaireHistory{Code: resp.Code}
+			def, err := getQuestionnaire(ctx, r.db, owner, resp.Code)
+			switch {
+			case err == nil:
+				h.HigherIsBetter = def.Scoring.HigherIsBetter
+			case err != ErrNotFound:
+				return nil, err
+			}
+			out = append(out, h)
+		}
+		out[i].Name = resp.Name
+		out[i].Responses = append(out[i].Responses, resp)
+	}
+	for i := range out {
+		var first, last *float64
+		for _, resp := range out[i].Responses {
+			if resp.Score != nil {
+				if first == nil {
+					first = resp.Score
+				}
+				last = resp.Score
+			}
+		}
+		if first != nil && last != first {
+			change := *last - *first
+			out[i].Change = &change
+		}
+	}
+	return out, nil
+}
+
+func (r *QuestionnaireRepo) GetResponse(ctx context.Context, owner, patientID, id string) (core.QuestionnaireResponse, error) {
+	return getQuestionnaireResponse(ctx, r.db, owner, patientID, id)
+}
+
+func (r *QuestionnaireRepo) DeleteResponse(ctx context.Context, owner, patientID, id string) error {
+	res, err := r.db.ExecContext(ctx, `
+		DELETE FROM questionnaire_responses WHERE id=:1 AND patient_id=:2 AND owner_username=:3
+	`, id, patientID, owner)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SeedBuiltIns adds the built-in questionnaires that are missing and returns how many
+// were added; ones already stored are left alone.
+func (r *QuestionnaireRepo) SeedBuiltIns(ctx context.Context) (int, error) {
+	var added int
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		var err error
+		added, err = seedBuiltInQuestionnaires(ctx, tx)
+		return err
+	})
+	return added, err
+}
+
+func seedBuiltInQuestionnaires(ctx context.Context, tx *Tx) (int, error) {
+	defs, err := builtInQuestionnaires()
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, q := range defs {
+		var exists int
+		err := tx.QueryRowContext(ctx, `
+			SELECT 1 FROM questionnaires WHERE owner_username=:1 AND code=:2
+		`, builtInOwner, q.Code).Scan(&exists)
+		if err == nil {
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return added, err
+		}
+		if err := insertQuestionnaire(ctx, tx, builtInOwner, q); err != nil {
+			return added, err
+		}
+		added++
+	}
+	return added, nil
+}
+
+// builtInQuestionnaires reads and checks the embedded definitions.
+func builtInQuestionnaires() ([]core.Questionnaire, error) {
+	files, err := builtInQuestionnaireFiles.ReadDir("questionnaires")
+	if err != nil {
+		return nil, err
+	}
+	var defs []core.Questionnaire
+	for _, f := range files {
+		b, err := builtInQuestionnaireFiles.ReadFile(path.Join("questionnaires", f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var q core.Questionnaire
+		if err := json.Unmarshal(b, &q); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+		var ok bool
+		if q.Code, ok = core.NormalizeQuestionnaireCode(q.Code); !ok {
+			return nil, fmt.Errorf("%s: invalid code %q", f.Name(), q.Code)
+		}
+		if err := q.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name(), err)
+		}
+		defs = append(defs, q)
+	}
+	return defs, nil
+}
+
+// questionnaireJSON encodes v for a text column, which holds at most maxTextBytes.
+func questionnaireJSON(name string, v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	if len(b) > maxTextBytes {
+		return "", fmt.Errorf("%w: %s take more than %d bytes", ErrInvalid, name, maxTextBytes)
+	}
+	return string(b), nil
+}
+
+// questionnaireHeader encodes the shared options and bands of q.
+func questionnaireHeader(q core.Questionnaire) (options, bands string, err error) {
+	if len(q.Options) > 0 {
+		if options, err = questionnaireJSON("options", q.Options); err != nil {
+			return "", "", err
+		}
+	}
+	if len(q.Scoring.Bands) > 0 {
+		if bands, err = questionnaireJSON("bands", q.Scoring.Bands); err != nil {
+			return "", "", err
+		}
+	}
+	return options, bands, nil
+}
+
+func insertQuestionnaire(ctx context.Context, tx *Tx, owner string, q core.Questionnaire) error {
+	options, bands, err := questionnaireHeader(q)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO questionnaires (owner_username, code, name, description, options, method, max_missing,
+		                            higher_is_better, bands, created_time, updated_time, version)
+		VALUES (:1,:2,:3,:4,:5,:6,:7,:8,:9,:10,:11,1)
+	`, owner, q.Code, q.Name, nullableText(q.Description), nullableText(options), q.Scoring.Method,
+		q.Scoring.MaxMissing, boolFlag(q.Scoring.HigherIsBetter), nullableText(bands), now, now)
+	if err != nil {
+		return err
+	}
+	return insertQuestionnaireItems(ctx, tx, owner, q)
+}
+
+func updateQuestionnaire(ctx context.Context, tx *Tx, owner string, q core.Questionnaire, version int) error {
+	options, bands, err := questionnaireHeader(q)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE questionnaires
+		   SET name = :1,
+		       description = :2,
+		       options = :3,
+		       method = :4,
+		       max_missing = :5,
+		       higher_is_better = :6,
+		       bands = :7,
+		       updated_time = :8,
+		       version = version + 1
+		 WHERE owner_username = :9 AND code = :10 AND version = :11
+	`, q.Name, nullableText(q.Description), nullableText(options), q.Scoring.Method, q.Scoring.MaxMissing,
+		boolFlag(q.Scoring.HigherIsBetter), nullableText(bands), time.Now(), owner, q.Code, version)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrStale
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM questionnaire_items WHERE owner_username=:1 AND code=:2
+	`, owner, q.Code); err != nil {
+		return err
+	}
+	return insertQuestionnaireItems(ctx, tx, owner, q)
+}
+
+func insertQuestionnaireItems(ctx context.Context, tx *Tx, owner string, q core.Questionnaire) error {
+	for i, item := range q.Items {
+		var options string
+		if len(item.Options) > 0 {
+			var err error
+			if options, err = questionnaireJSON("options of item "+item.ID, item.Options); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO questionnaire_items (owner_username, code, position, item_id, text, options)
+			VALUES (:1,:2,:3,:4,:5,:6)
+		`, owner, q.Code, i+1, item.ID, item.Text, nullableText(options)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func boolFlag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// questionnaireColumns is the select list read by scanQuestionnaire.
+const questionnaireColumns = `owner_username, code, name, description, options, method, max_missing, higher_is_better,
+		       bands, version`
+
+func scanQuestionnaire(row rowScanner) (core.Questionnaire, error) {
+	var q core.Questionnaire
+	var owner string
+	var description, options, bands sql.NullString
+	var higher int
+	err := row.Scan(&owner, &q.Code, &q.Name, &description, &options, &q.Scoring.Method, &q.Scoring.MaxMissing,
+		&higher, &bands, &q.Version)
+	if err != nil {
+		return q, err
+	}
+	q.Description = nullStringToString(description)
+	q.BuiltIn = owner == builtInOwner
+	q.Scoring.HigherIsBetter = higher != 0
+	if options.Valid {
+		if err := json.Unmarshal([]byte(options.String), &q.Options); err != nil {
+			return q, fmt.Errorf("questionnaire %s options: %w", q.Code, err)
+		}
+	}
+	if bands.Valid {
+		if err := json.Unmarshal([]byte(bands.String), &q.Scoring.Bands); err != nil {
+			return q, fmt.Errorf("questionnaire %s bands: %w", q.Code, err)
+		}
+	}
+	return q, nil
+}
+
+// getQuestionnaire reads a definition and its items, preferring the owner's own over a built-in.
+func getQuestionnaire(ctx context.Context, q querier, owner, code string) (core.Questionnaire, error) {
+	code, _ = core.NormalizeQuestionnaireCode(code)
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+questionnaireColumns+` FROM questionnaires WHERE code=:1 AND owner_username IN (:2, :3)
+	`, code, owner, builtInOwner)
+	if err != nil {
+		return core.Questionnaire{}, err
+	}
+	var def core.Questionnaire
+	found := false
+	for rows.Next() {
+		d, err := scanQuestionnaire(rows)
+		if err != nil {
+			rows.Close()
+			return def, err
+		}
+		if !found || !d.BuiltIn {
+			def, found = d, true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return def, err
+	}
+	if !found {
+		return def, ErrNotFound
+	}
+
+	itemOwner := owner
+	if def.BuiltIn {
+		itemOwner = builtInOwner
+	}
+	rows, err = q.QueryContext(ctx, `
+		SELECT item_id, text, options FROM questionnaire_items WHERE owner_username=:1 AND code=:2 ORDER BY position
+	`, itemOwner, code)
+	if err != nil {
+		return def, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item core.QuestionnaireItem
+		var options sql.NullString
+		if err := rows.Scan(&item.ID, &item.Text, &options); err != nil {
+			return def, err
+		}
+		if options.Valid {
+			if err := json.Unmarshal([]byte(options.String), &item.Options); err != nil {
+				return def, fmt.Errorf("questionnaire %s item %s options: %w", code, item.ID, err)
+			}
+		}
+		def.Items = append(def.Items, item)
+	}
+	return def, rows.Err()
+}
+
+// responseColumns is the select list read by scanQuestionnaireResponse.
+const responseColumns = `id, patient_id, code, name, definition_version, answers, score, band, answered, taken_at,
+		       notes, created_time, version`
+
+func scanQuestionnaireResponse(row rowScanner) (core.QuestionnaireResponse, error) {
+	var resp core.QuestionnaireResponse
+	var answers string
+	var score sql.NullFloat64
+	var band, notes sql.NullString
+	err := row.Scan(&resp.ID, &resp.PatientID, &resp.Code, &resp.Name, &resp.DefinitionVersion, &answers, &score,
+		&band, &resp.Answered, &resp.TakenAt, &notes, &resp.CreatedTime, &resp.Version)
+	if err != nil {
+		return resp, err
+	}
+	if score.Valid {
+		resp.Score = &score.Float64
+	}
+	resp.Band = nullStringToString(band)
+	resp.Notes = nullStringToString(notes)
+	if err := json.Unmarshal([]byte(answers), &resp.Answers); err != nil {
+		return resp, fmt.Errorf("questionnaire response %s answers: %w", resp.ID, err)
+	}
+	return resp, nil
+}
+
+func getQuestionnaireResponse(ctx context.Context, q querier, owner, patientID, id string) (core.QuestionnaireResponse, error) {
+	resp, err := scanQuestionnaireResponse(q.QueryRowContext(ctx, `
+		SELECT `+responseColumns+` FROM questionnaire_responses WHERE id=:1 AND patient_id=:2 AND owner_username=:3
+	`, id, patientID, owner))
+	if err == sql.ErrNoRows {
+		return resp, ErrNotFound
+	}
+	return resp, err
+}
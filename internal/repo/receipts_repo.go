@@ -0,0 +1,274 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"phsio_track_backend/internal/core"
+)
+
+// maxLogoBytes caps the clinic logo stored with the profile.
+const maxLogoBytes = 256 << 10
+
+// ReceiptRepo keeps the clinic profile and numbers payment receipts.
+type ReceiptRepo struct {
+	db *DB
+}
+
+func NewReceiptRepo(db *DB) *ReceiptRepo {
+	return &ReceiptRepo{db: db}
+}
+
+// Clinic returns the owner's clinic profile, or ErrNotFound until one is saved.
+func (r *ReceiptRepo) Clinic(ctx context.Context, owner string) (core.ClinicProfile, error) {
+	return getClinic(ctx, r.db, owner)
+}
+
+// PutClinic creates or replaces the owner's clinic profile. Receipts already issued
+// keep printing the profile they were issued with.
+func (r *ReceiptRepo) PutClinic(ctx context.Context, owner string, p *core.ClinicProfile) error {
+	p.Name = strings.TrimSpace(p.Name)
+	p.Address = strings.TrimSpace(p.Address)
+	p.RegistrationNumber = strings.TrimSpace(p.RegistrationNumber)
+	switch {
+	case p.Name == "" || utf8.RuneCountInString(p.Name) > maxNameLength:
+		return fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalid, maxNameLength)
+	case len(p.Address) > maxTextBytes:
+		return fmt.Errorf("%w: address is longer than %d bytes", ErrInvalid, maxTextBytes)
+	case utf8.RuneCountInString(p.RegistrationNumber) > maxNameLength:
+		return fmt.Errorf("%w: registration_number is longer than %d characters", ErrInvalid, maxNameLength)
+	case len(p.Logo) > maxLogoBytes:
+		return fmt.Errorf("%w: logo is larger than %d KiB", ErrInvalid, maxLogoBytes>>10)
+	}
+	if len(p.Logo) == 0 {
+		p.Logo = nil
+	} else if t := http.DetectContentType(p.Logo); t != "image/png" && t != "image/jpeg" {
+		return fmt.Errorf("%w: logo must be a PNG or JPEG image", ErrInvalid)
+	}
+	now := time.Now()
+
+	q := `
+		INSERT INTO clinic_profiles (owner_username, name, address, registration_number, logo, updated_time)
+		VALUES (:1,:2,:3,:4,:5,:6)
+		ON CONFLICT (owner_username) DO UPDATE SET name = excluded.name,
+		                                           address = excluded.address,
+		                                           registration_number = excluded.registration_number,
+		                                           logo = excluded.logo,
+		                                           updated_time = excluded.updated_time
+	`
+	if r.db.Dialect() == DialectOracle {
+		q = `
+		MERGE INTO clinic_profiles t
+		USING (SELECT :1 AS owner_username,
+		              :2 AS name,
+		              :3 AS address,
+		              :4 AS registration_number,
+		              :5 AS logo,
+		              :6 AS updated_time
+		       FROM dual) s
+		ON (t.owner_username = s.owner_username)
+		WHEN MATCHED THEN
+		  UPDATE SET t.name = s.name,
+		             t.address = s.address,
+		             t.registration_number = s.registration_number,
+		             t.logo = s.logo,
+		             t.updated_time = s.updated_time
+		WHEN NOT MATCHED THEN
+		  INSERT (owner_username, name, address, registration_number, logo, updated_time)
+		  VALUES (s.owner_username, s.name, s.address, s.registration_number, s.logo, s.updated_time)
+	`
+	}
+	_, err := r.db.ExecContext(ctx, q, owner, p.Name, nullableText(p.Address), nullableText(p.RegistrationNumber),
+		p.Logo, now)
+	if err != nil {
+		return err
+	}
+	p.UpdatedTime = core.NewJSONTime(now)
+	return nil
+}
+
+// Issue returns the receipt for a payment. The first time a payment's receipt is asked
+// for it takes the owner's next receipt number; the counter is locked for the whole
+// transaction, so numbers run without gaps even when receipts are issued concurrently.
+// The payment, the patient's name and the clinic profile are copied into the receipt
+// then, and every reprint shows that copy. Without a clinic profile no number is taken
+// and ErrConflict is returned.
+func (r *ReceiptRepo) Issue(ctx context.Context, owner, paymentID string) (core.Receipt, error) {
+	var rc core.Receipt
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		var err error
+		if rc, err = getReceipt(ctx, tx, owner, paymentID); err != ErrNotFound {
+			return err
+		}
+		// a payment being receipted cannot change under us (see assertNoReceipt), and
+		// someone else may have receipted it while we waited for the lock
+		if err := lockPayment(ctx, tx, owner, paymentID); err != nil {
+			return err
+		}
+		if rc, err = getReceipt(ctx, tx, owner, paymentID); err != ErrNotFound {
+			return err
+		}
+
+		payment, err := getPaymentByID(ctx, tx, owner, paymentID)
+		if err != nil {
+			return err
+		}
+		patient, err := getPatientByID(ctx, tx, owner, payment.PatientID)
+		if err != nil {
+			return err
+		}
+		clinic, err := getClinic(ctx, tx, owner)
+		if err == ErrNotFound {
+			return fmt.Errorf("%w: set up the clinic profile before issuing receipts", ErrConflict)
+		}
+		if err != nil {
+			return err
+		}
+		rc = core.Receipt{Payment: payment, PatientName: patient.FullName, Clinic: clinic}
+		rc.Payment.Allocations = nil
+
+		if err := lockReceiptCounter(ctx, tx, owner); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE receipt_counters SET last_number = last_number + 1 WHERE owner_username = :1
+		`, owner); err != nil {
+			return err
+		}
+		if err := tx.QueryRowContext(ctx, `
+			SELECT last_number FROM receipt_counters WHERE owner_username = :1
+		`, owner).Scan(&rc.Number); err != nil {
+			return err
+		}
+		issued := time.Now()
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO receipts (owner_username, receipt_number, payment_id, issued_time, patient_id, amount_minor,
+			                      currency, payment_mode, paid_date, patient_name, clinic_name, clinic_address,
+			                      clinic_registration_number, clinic_logo)
+			VALUES (:1,:2,:3,:4,:5,:6,:7,:8,:9,:10,:11,:12,:13,:14)
+		`, owner, rc.Number, paymentID, issued, payment.PatientID, payment.Amount.Minor, payment.Amount.Currency,
+			nullableText(payment.Mode), payment.Date, rc.PatientName, clinic.Name, nullableText(clinic.Address),
+			nullableText(clinic.RegistrationNumber), clinic.Logo); err != nil {
+			return err
+		}
+		rc.IssuedTime = core.NewJSONTime(issued)
+		return nil
+	})
+	return rc, err
+}
+
+// getReceipt reads the receipt issued for a payment as it was printed.
+func getReceipt(ctx context.Context, q querier, owner, paymentID string) (core.Receipt, error) {
+	rc := core.Receipt{Payment: core.Payment{ID: paymentID}}
+	var patientID, currency, mode, patientName, clinicName, address, registration sql.NullString
+	var amount sql.NullInt64
+	var paid sql.NullTime
+	err := q.QueryRowContext(ctx, `
+		SELECT receipt_number, issued_time, patient_id, amount_minor, currency, payment_mode, paid_date,
+		       patient_name, clinic_name, clinic_address, clinic_registration_number, clinic_logo
+		FROM receipts
+		WHERE owner_username=:1 AND payment_id=:2
+	`, owner, paymentID).Scan(&rc.Number, &rc.IssuedTime, &patientID, &amount, &currency, &mode, &paid,
+		&patientName, &clinicName, &address, &registration, &rc.Clinic.Logo)
+	if err == sql.ErrNoRows {
+		return rc, ErrNotFound
+	}
+	if err != nil {
+		return rc, err
+	}
+	if !amount.Valid {
+		// issued before receipts kept a copy, for a payment deleted before they did
+		return rc, fmt.Errorf("%w: receipt %d has nothing to print", ErrConflict, rc.Number)
+	}
+	rc.Payment.PatientID = nullStringToString(patientID)
+	rc.Payment.Amount = core.NewMoney(amount.Int64, nullStringToString(currency))
+	rc.Payment.Mode = nullStringToString(mode)
+	if paid.Valid {
+		rc.Payment.Date = core.NewJSONTime(paid.Time)
+	}
+	rc.PatientName = nullStringToString(patientName)
+	rc.Clinic.Name = nullStringToString(clinicName)
+	rc.Clinic.Address = nullStringToString(address)
+	rc.Clinic.RegistrationNumber = nullStringToString(registration)
+	return rc, nil
+}
+
+// assertNoReceipt returns ErrConflict once a receipt has been issued for the payment:
+// what a receipt printed cannot change afterwards. Callers lock the payment first so
+// that a receipt cannot be issued between the check and their change.
+func assertNoReceipt(ctx context.Context, q querier, owner, paymentID string) error {
+	var number int64
+	err := q.QueryRowContext(ctx, `
+		SELECT receipt_number FROM receipts WHERE owner_username=:1 AND payment_id=:2
+	`, owner, paymentID).Scan(&number)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: receipt %d has been issued for this payment", ErrConflict, number)
+}
+
+// lockPayment locks a payment's row until the transaction ends. A missing payment
+// yields ErrNotFound.
+func lockPayment(ctx context.Context, tx *Tx, owner, id string) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE payments SET version = version WHERE id = :1 AND owner_username = :2
+	`, id, owner)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// lockReceiptCounter creates the owner's receipt counter if needed and locks it until
+// the transaction ends.
+func lockReceiptCounter(ctx context.Context, tx *Tx, owner string) error {
+	q := `
+		INSERT INTO receipt_counters (owner_username, last_number) VALUES (:1, 0)
+		ON CONFLICT (owner_username) DO NOTHING
+	`
+	if tx.dialect == DialectOracle {
+		q = `
+		MERGE INTO receipt_counters t
+		USING (SELECT :1 AS owner_username FROM dual) s
+		ON (t.owner_username = s.owner_username)
+		WHEN NOT MATCHED THEN INSERT (owner_username, last_number) VALUES (s.owner_username, 0)
+	`
+	}
+	if _, err := tx.ExecContext(ctx, q, owner); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE receipt_counters SET last_number = last_number WHERE owner_username = :1
+	`, owner)
+	return err
+}
+
+func getClinic(ctx context.Context, q querier, owner string) (core.ClinicProfile, error) {
+	var p core.ClinicProfile
+	var address, registration sql.NullString
+	err := q.QueryRowContext(ctx, `
+		SELECT name, address, registration_number, logo, updated_time
+		FROM clinic_profiles
+		WHERE owner_username=:1
+	`, owner).Scan(&p.Name, &address, &registration, &p.Logo, &p.UpdatedTime)
+	if err == sql.ErrNoRows {
+		return p, ErrNotFound
+	}
+	if err != nil {
+		return p, err
+	}
+	p.Address = nullStringToString(address)
+	p.RegistrationNumber = nullStringToString(registration)
+	return p, nil
+}
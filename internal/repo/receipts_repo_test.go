@@ -0,0 +1,133 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"phsio_track_backend/internal/core"
+)
+
+func TestReceiptKeepsWhatItPrinted(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	receipts := NewReceiptRepo(db)
+	payments := NewPaymentRepo(db, "INR")
+	patients := NewPatientRepo(db, "IN")
+	patient := newTestPatient(t, db, "Asha Rao")
+	p := pay(t, payments, patient, 3, 150050)
+
+	if _, err := receipts.Issue(ctx, "owner", p.ID); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Issue without a clinic profile: %v, want ErrConflict", err)
+	}
+	clinic := core.ClinicProfile{Name: "Care Physio", Address: "1 MG Road"}
+	if err := receipts.PutClinic(ctx, "owner", &clinic); err != nil {
+		t.Fatal(err)
+	}
+	first, err := receipts.Issue(ctx, "owner", p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Number != 1 || first.PatientName != "Asha Rao" || first.Clinic.Name != "Care Physio" ||
+		first.Payment.Amount != core.NewMoney(150050, "INR") || first.Payment.Mode != "CASH" {
+		t.Fatalf("first receipt = %+v", first)
+	}
+
+	// later changes to the patient and clinic do not reach the receipt
+	name := "Asha R. Menon"
+	if _, err := patients.Update(ctx, "owner", patient, &core.PatientUpdate{FullName: &name}, core.VersionMatch{}); err != nil {
+		t.Fatal(err)
+	}
+	clinic = core.ClinicProfile{Name: "Care Physio & Rehab"}
+	if err := receipts.PutClinic(ctx, "owner", &clinic); err != nil {
+		t.Fatal(err)
+	}
+	again, err := receipts.Issue(ctx, "owner", p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Number != 1 || again.PatientName != "Asha Rao" || again.Clinic.Name != "Care Physio" ||
+		again.Clinic.Address != "1 MG Road" || !again.IssuedTime.Equal(first.IssuedTime.Time) ||
+		!again.Payment.Date.Equal(first.Payment.Date.Time) {
+		t.Errorf("reprint = %+v, want %+v", again, first)
+	}
+
+	// nor can the payment change under it
+	amount, mode, date := core.NewMoney(100, "INR"), "UPI", day(4)
+	for name, upd := range map[string]core.PaymentUpdate{
+		"amount": {Amount: &amount},
+		"mode":   {Mode: &mode},
+		"date":   {Date: &date},
+	} {
+		if _, err := payments.Update(ctx, "owner", p.ID, &upd, core.VersionMatch{}); !errors.Is(err, ErrConflict) {
+			t.Errorf("changing the %s of a receipted payment: %v, want ErrConflict", name, err)
+		}
+	}
+	if err := payments.Delete(ctx, "owner", p.ID); !errors.Is(err, ErrConflict) {
+		t.Errorf("deleting a receipted payment: %v, want ErrConflict", err)
+	}
+	changed := p
+	changed.Amount = core.NewMoney(100, "INR")
+	if err := payments.Upsert(ctx, "owner", &changed); !errors.Is(err, ErrConflict) {
+		t.Errorf("upserting a changed receipted payment: %v, want ErrConflict", err)
+	}
+
+	// what is not on the receipt may still change
+	same, cash := core.NewMoney(150050, "INR"), "cash"
+	if _, err := payments.Update(ctx, "owner", p.ID, &core.PaymentUpdate{Amount: &same, Mode: &cash}, core.VersionMatch{}); err != nil {
+		t.Errorf("resending a receipted payment's values: %v", err)
+	}
+	unchanged := p
+	if err := payments.Upsert(ctx, "owner", &unchanged); err != nil {
+		t.Errorf("upserting a receipted payment unchanged: %v", err)
+	}
+
+	// numbers run on; unreceipted payments change freely
+	other := pay(t, payments, patient, 5, 2000)
+	if _, err := payments.Update(ctx, "owner", other.ID, &core.PaymentUpdate{Amount: &amount}, core.VersionMatch{}); err != nil {
+		t.Fatal(err)
+	}
+	second, err := receipts.Issue(ctx, "owner", other.ID)
+	if err != nil || second.Number != 2 || second.PatientName != "Asha R. Menon" || second.Payment.Amount.Minor != 100 {
+		t.Errorf("second receipt = %+v, %v", second, err)
+	}
+	if _, err := receipts.Issue(ctx, "owner", "no-such-payment"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Issue of a missing payment: %v, want ErrNotFound", err)
+	}
+}
+
+func TestReceiptSnapshotMigration(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	payments := NewPaymentRepo(db, "INR")
+	patient := newTestPatient(t, db, "Asha Rao")
+	p := pay(t, payments, patient, 3, 90000)
+
+	// a receipt issued before migration 22
+	if _, err := MigrateDown(ctx, db, 21); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO clinic_profiles (owner_username, name, address, updated_time) VALUES (:1,:2,:3,:4)
+	`, "owner", "Care Physio", "1 MG Road", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO receipts (owner_username, receipt_number, payment_id, issued_time) VALUES (:1,:2,:3,:4)
+	`, "owner", 7, p.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateUp(ctx, db, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := NewReceiptRepo(db).Issue(ctx, "owner", p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.Number != 7 || rc.PatientName != "Asha Rao" || rc.Clinic.Address != "1 MG Road" ||
+		rc.Payment.Amount != core.NewMoney(90000, "INR") || rc.Payment.Mode != "CASH" || rc.Payment.Date.IsZero() {
+		t.Errorf("receipt after migration = %+v", rc)
+	}
+}
@@ -0,0 +1,32 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// NewSQLiteDB opens an embedded SQLite database at path (":memory:" for a throwaway store).
+// It needs no wallet or server and is meant for local development and CI.
+func NewSQLiteDB(ctx context.Context, path string) (*DB, error) {
+	if path == "" {
+		path = ":memory:"
+	}
+	log.Printf("DB config driver=sqlite path=%s", path)
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; one connection also keeps ":memory:" databases alive and shared.
+	db.SetMaxOpenConns(1)
+
+	if err := db.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("db ping failed: %w", err)
+	}
+	log.Println("DB ping ok")
+	return &DB{DB: db, dialect: DialectSQLite}, nil
+}
@@ -0,0 +1,166 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"phsio_track_backend/internal/core"
+)
+
+// PatientStore persists patients scoped to an owner.
+type PatientStore interface {
+	Create(ctx context.Context, owner string, p *core.Patient) error
+	List(ctx context.Context, owner string, q core.PatientQuery) (core.PatientPage, error)
+	ListSummary(ctx context.Context, owner string, q core.PatientQuery) (core.PatientSummaryPage, error)
+	Search(ctx context.Context, owner string, s core.PatientSearch) ([]core.PatientSearchHit, error)
+	FindDuplicates(ctx context.Context, owner string, p core.Patient) ([]core.DuplicateMatch, error)
+	DuplicateClusters(ctx context.Context, owner string) ([]core.DuplicateCluster, error)
+	ListArchived(ctx context.Context, owner string) ([]core.Patient, error)
+	GetByID(ctx context.Context, owner, id string) (core.Patient, error)
+	Update(ctx context.Context, owner, id string, upd *core.PatientUpdate, ifMatch core.VersionMatch) (core.Patient, error)
+	Archive(ctx context.Context, owner, id string) (core.Patient, error)
+	Restore(ctx context.Context, owner, id string) (core.Patient, error)
+	Purge(ctx context.Context, owner, id string) error
+	Merge(ctx context.Context, owner, id string, m core.PatientMerge, ifMatch core.VersionMatch) (core.PatientMergeResult, error)
+	History(ctx context.Context, owner, id string) ([]core.PatientRevision, error)
+	RevertField(ctx context.Context, owner, id, revisionID, field string, ifMatch core.VersionMatch) (core.Patient, error)
+	StatusHistory(ctx context.Context, owner, id string) ([]core.PatientStatusChange, error)
+}
+
+// PaymentStore persists payments scoped to an owner.
+type PaymentStore interface {
+	Create(ctx context.Context, owner string, p *core.Payment) error
+	Upsert(ctx context.Context, owner string, p *core.Payment) error
+	List(ctx context.Context, owner, patientID string) ([]core.Payment, error)
+	GetByID(ctx context.Context, owner, id string) (core.Payment, error)
+	Update(ctx context.Context, owner, id string, upd *core.PaymentUpdate, ifMatch core.VersionMatch) (core.Payment, error)
+	Delete(ctx context.Context, owner, id string) error
+	Balance(ctx context.Context, owner, patientID string) (core.PatientBalance, error)
+}
+
+// InvoiceStore persists invoices and the fee list scoped to an owner.
+type InvoiceStore interface {
+	Create(ctx context.Context, owner string, inv *core.Invoice) error
+	List(ctx context.Context, owner, patientID, status string) ([]core.Invoice, error)
+	GetByID(ctx context.Context, owner, id string) (core.Invoice, error)
+	Update(ctx context.Context, owner, id string, upd *core.InvoiceUpdate, ifMatch core.VersionMatch) (core.Invoice, error)
+	Delete(ctx context.Context, owner, id string) error
+	Fees(ctx context.Context, owner string) ([]core.Fee, error)
+	PutFee(ctx context.Context, owner string, f *core.Fee) error
+	DeleteFee(ctx context.Context, owner, code string) error
+}
+
+// AppointmentStore persists appointments scoped to an owner.
+type AppointmentStore interface {
+	Create(ctx context.Context, owner string, a *core.Appointment) error
+	CreateSeries(ctx context.Context, owner string, s core.AppointmentSeries) (core.AppointmentSeriesResult, error)
+	CancelSeries(ctx context.Context, owner, seriesID string) (int, error)
+	List(ctx context.Context, owner string, q core.AppointmentQuery) ([]core.Appointment, error)
+	Calendar(ctx context.Context, owner, view string, day time.Time, therapist string) (core.Calendar, error)
+	GetByID(ctx context.Context, owner, id string) (core.Appointment, error)
+	Update(ctx context.Context, owner, id string, upd *core.AppointmentUpdate, ifMatch core.VersionMatch) (core.Appointment, error)
+	Delete(ctx context.Context, owner, id string) error
+}
+
+// ExerciseStore persists patients' exercise prescriptions scoped to an owner.
+type ExerciseStore interface {
+	List(ctx context.Context, owner, patientID string, activeOn time.Time) ([]core.ExercisePrescription, error)
+	Create(ctx context.Context, owner string, e *core.ExercisePrescription) error
+	Replace(ctx context.Context, owner, patientID string, items []core.ExercisePrescription) ([]core.ExercisePrescription, error)
+	GetByID(ctx context.Context, owner, patientID, id string) (core.ExercisePrescription, error)
+	Update(ctx context.Context, owner, patientID, id string, upd *core.ExercisePrescriptionUpdate, ifMatch core.VersionMatch) (core.ExercisePrescription, error)
+	Delete(ctx context.Context, owner, patientID, id string) error
+}
+
+// ExerciseLibraryStore persists each owner's exercise catalog.
+type ExerciseLibraryStore interface {
+	Search(ctx context.Context, owner string, q core.LibraryQuery) ([]core.LibraryExercise, error)
+	Create(ctx context.Context, owner string, e *core.LibraryExercise) error
+	GetByID(ctx context.Context, owner, id string) (core.LibraryExercise, error)
+	Update(ctx context.Context, owner, id string, upd *core.LibraryExerciseUpdate, ifMatch core.VersionMatch) (core.LibraryExercise, error)
+	Delete(ctx context.Context, owner, id string) error
+}
+
+// MeasurementStore persists patients' outcome measurements scoped to an owner.
+type MeasurementStore interface {
+	Create(ctx context.Context, owner string, m *core.Measurement) error
+	List(ctx context.Context, owner, patientID string, q core.MeasurementQuery) ([]core.Measurement, error)
+	Series(ctx context.Context, owner, patientID string, q core.MeasurementQuery) ([]core.MeasurementSeries, error)
+	GetByID(ctx context.Context, owner, patientID, id string) (core.Measurement, error)
+	Update(ctx context.Context, owner, patientID, id string, upd *core.MeasurementUpdate, ifMatch core.VersionMatch) (core.Measurement, error)
+	Delete(ctx context.Context, owner, patientID, id string) error
+}
+
+// QuestionnaireStore persists questionnaire definitions and patients' scored responses.
+type QuestionnaireStore interface {
+	List(ctx context.Context, owner string) ([]core.Questionnaire, error)
+	Get(ctx context.Context, owner, code string) (core.Questionnaire, error)
+	Put(ctx context.Context, owner string, q *core.Questionnaire, ifMatch core.VersionMatch) error
+	Delete(ctx context.Context, owner, code string) error
+	Submit(ctx context.Context, owner string, r *core.QuestionnaireResponse) error
+	History(ctx context.Context, owner, patientID, code string) ([]core.QuestionnaireHistory, error)
+	GetResponse(ctx context.Context, owner, patientID, id string) (core.QuestionnaireResponse, error)
+	DeleteResponse(ctx context.Context, owner, patientID, id string) error
+}
+
+// VisitNoteStore persists patients' per-session SOAP notes and builds their timeline.
+type VisitNoteStore interface {
+	Create(ctx context.Context, owner string, n *core.VisitNote) error
+	List(ctx context.Context, owner, patientID string, from, to time.Time) ([]core.VisitNote, error)
+	GetByID(ctx context.Context, owner, patientID, id string) (core.VisitNote, error)
+	Update(ctx context.Context, owner, patientID, id string, upd *core.VisitNoteUpdate, ifMatch core.VersionMatch) (core.VisitNote, error)
+	Delete(ctx context.Context, owner, patientID, id string) error
+	Timeline(ctx context.Context, owner, patientID string, q core.TimelineQuery) ([]core.TimelineEntry, error)
+}
+
+// EpisodeStore persists patients' episodes of care.
+type EpisodeStore interface {
+	List(ctx context.Context, owner, patientID string) ([]core.Episode, error)
+	Open(ctx context.Context, owner string, e *core.Episode) error
+	GetByID(ctx context.Context, owner, patientID, id string) (core.Episode, error)
+	Update(ctx context.Context, owner, patientID, id string, upd *core.EpisodeUpdate, ifMatch core.VersionMatch) (core.Episode, error)
+}
+
+// DischargeStore records discharges and compiles their summaries.
+type DischargeStore interface {
+	Discharge(ctx context.Context, owner string, d *core.Discharge) error
+	List(ctx context.Context, owner, patientID string) ([]core.Discharge, error)
+	GetByID(ctx context.Context, owner, patientID, id string) (core.Discharge, error)
+	Summary(ctx context.Context, owner, patientID, id string) (core.DischargeSummary, error)
+}
+
+// ReceiptStore keeps the clinic profile and issues numbered payment receipts.
+type ReceiptStore interface {
+	Clinic(ctx context.Context, owner string) (core.ClinicProfile, error)
+	PutClinic(ctx context.Context, owner string, p *core.ClinicProfile) error
+	Issue(ctx context.Context, owner, paymentID string) (core.Receipt, error)
+}
+
+// ExportStore reads patients and payments for export.
+type ExportStore interface {
+	Patients(ctx context.Context, owner string, q core.ExportQuery) ([]core.Patient, error)
+	Payments(ctx context.Context, owner string, q core.ExportQuery) ([]core.ExportedPayment, error)
+}
+
+// UserStore persists login accounts.
+type UserStore interface {
+	GetByUsername(ctx context.Context, username string) (core.User, error)
+	UpsertUser(ctx context.Context, user core.User) error
+}
+
+var (
+	_ PatientStore         = (*PatientRepo)(nil)
+	_ PaymentStore         = (*PaymentRepo)(nil)
+	_ InvoiceStore         = (*InvoiceRepo)(nil)
+	_ AppointmentStore     = (*AppointmentRepo)(nil)
+	_ ExerciseStore        = (*ExerciseRepo)(nil)
+	_ ExerciseLibraryStore = (*ExerciseLibraryRepo)(nil)
+	_ MeasurementStore     = (*MeasurementRepo)(nil)
+	_ QuestionnaireStore   = (*QuestionnaireRepo)(nil)
+	_ VisitNoteStore       = (*VisitNoteRepo)(nil)
+	_ EpisodeStore         = (*EpisodeRepo)(nil)
+	_ DischargeStore       = (*DischargeRepo)(nil)
+	_ ReceiptStore         = (*ReceiptRepo)(nil)
+	_ ExportStore          = (*ExportRepo)(nil)
+	_ UserStore            = (*UserRepo)(nil)
+)
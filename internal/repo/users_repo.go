@@ -3,6 +3,7 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -10,10 +11,10 @@ import (
 )
 
 type UserRepo struct {
-	db *sql.DB
+	db *DB
 }
 
-func NewUserRepo(db *sql.DB) *UserRepo {
+func NewUserRepo(db *DB) *UserRepo {
 	return &UserRepo{db: db}
 }
 
@@ -37,13 +38,21 @@ func (r *UserRepo) UpsertUser(ctx context.Context, user core.User) error {
 	if user.ID == "" {
 		user.ID = uuid.NewString()
 	}
-	_, err := r.db.ExecContext(ctx, `
+	q := `
+		INSERT INTO users (id, username, password_hash, created_time)
+		VALUES (:3, :1, :2, :4)
+		ON CONFLICT (username) DO UPDATE SET password_hash = excluded.password_hash
+	`
+	if r.db.Dialect() == DialectOracle {
+		q = `
 		MERGE INTO users u
-		USING (SELECT :1 AS username, :2 AS password_hash, :3 AS id FROM dual) s
+		USING (SELECT :1 AS username, :2 AS password_hash, :3 AS id, :4 AS created_time FROM dual) s
 		ON (u.username = s.username)
 		WHEN MATCHED THEN UPDATE SET u.password_hash = s.password_hash
 		WHEN NOT MATCHED THEN INSERT (id, username, password_hash, created_time)
-		VALUES (s.id, s.username, s.password_hash, SYSTIMESTAMP)
-	`, user.Username, user.PasswordHash, user.ID)
+		VALUES (s.id, s.username, s.password_hash, s.created_time)
+	`
+	}
+	_, err := r.db.ExecContext(ctx, q, user.Username, user.PasswordHash, user.ID, time.Now())
 	return err
 }
@@ -0,0 +1,361 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"phsio_track_backend/internal/core"
+)
+
+const maxModalities = 20
+
+// VisitNoteRepo stores the per-session SOAP notes of patients and assembles their timeline.
+type VisitNoteRepo struct {
+	db *DB
+}
+
+func NewVisitNoteRepo(db *DB) *VisitNoteRepo {
+	return &VisitNoteRepo{db: db}
+}
+
+// Create records a session. Therapist defaults to the owner and VisitedAt to now; an
+// AppointmentID must be one of the patient's appointments.
+func (r *VisitNoteRepo) Create(ctx context.Context, owner string, n *core.VisitNote) error {
+	if strings.TrimSpace(n.Therapist) == "" {
+		n.Therapist = owner
+	}
+	if n.VisitedAt.IsZero() {
+		n.VisitedAt = core.NewJSONTime(time.Now())
+	}
+	normalizeVisitNote(n)
+	if err := validateVisitNote(*n); err != nil {
+		return err
+	}
+	n.ID = uuid.NewString()
+	return withTx(ctx, r.db, func(tx *Tx) error {
+		if err := assertPatientOwner(ctx, tx, owner, n.PatientID); err != nil {
+			return err
+		}
+		if err := assertPatientAppointment(ctx, tx, owner, n.PatientID, n.AppointmentID); err != nil {
+			return err
+		}
+		now := time.Now()
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO visit_notes (id, patient_id, owner_username, appointment_id, therapist, visited_at, subjective,
+			                         objective, assessment, plan, treatment, modalities, created_time, updated_time, version)
+			VALUES (:1,:2,:3,:4,:5,:6,:7,:8,:9,:10,:11,:12,:13,:14,1)
+		`, n.ID, n.PatientID, owner, nullableText(n.AppointmentID), n.Therapist, n.VisitedAt, nullableText(n.Subjective),
+			nullableText(n.Objective), nullableText(n.Assessment), nullableText(n.Plan), nullableText(n.Treatment),
+			nullableText(strings.Join(n.Modalities, "\n")), now, now)
+		if err != nil {
+			return err
+		}
+		*n, err = getVisitNote(ctx, tx, owner, n.PatientID, n.ID)
+		return err
+	})
+}
+
+// List returns a patient's notes of visits in [from, to), oldest first; zero times are open ends.
+func (r *VisitNoteRepo) List(ctx context.Context, owner, patientID string, from, to time.Time) ([]core.VisitNote, error) {
+	if err := assertPatientOwner(ctx, r.db, owner, patientID); err != nil {
+		return nil, err
+	}
+	return listVisitNotes(ctx, r.db, owner, patientID, from, to)
+}
+
+func (r *VisitNoteRepo) GetByID(ctx context.Context, owner, patientID, id string) (core.VisitNote, error) {
+	return getVisitNote(ctx, r.db, owner, patientID, id)
+}
+
+// Update edits a note. A conditional ifMatch must match.
+func (r *VisitNoteRepo) Update(ctx context.Context, owner, patientID, id string, upd *core.VisitNoteUpdate, ifMatch core.VersionMatch) (core.VisitNote, error) {
+	var updated core.VisitNote
+	err := withTx(ctx, r.db, func(tx *Tx) error {
+		current, err := getVisitNote(ctx, tx, owner, patientID, id)
+		if err != nil {
+			return err
+		}
+		if !ifMatch.Matches(current.Version) {
+			return ErrStale
+		}
+		next := current
+		if upd.AppointmentID != nil {
+			next.AppointmentID = *upd.AppointmentID
+		}
+		if upd.Therapist != nil {
+			next.Therapist = *upd.Therapist
+		}
+		if upd.VisitedAt != nil {
+			next.VisitedAt = *upd.VisitedAt
+		}
+		if upd.Subjective != nil {
+			next.Subjective = *upd.Subjective
+		}
+		if upd.Objective != nil {
+			next.Objective = *upd.Objective
+		}
+		if upd.Assessment != nil {
+			next.Assessment = *upd.Assessment
+		}
+		if upd.Plan != nil {
+			next.Plan = *upd.Plan
+		}
+		if upd.Treatment != nil {
+			next.Treatment = *upd.Treatment
+		}
+		if upd.Modalities != nil {
+			next.Modalities = *upd.Modalities
+		}
+		normalizeVisitNote(&next)
+		if err := validateVisitNote(next); err != nil {
+			return err
+		}
+		if next.AppointmentID != current.AppointmentID {
+			if err := assertPatientAppointment(ctx, tx, owner, patientID, next.AppointmentID); err != nil {
+				return err
+			}
+		}
+		res, err := tx.ExecContext(ctx, `
+			UPDATE visit_notes
+			   SET appointment_id = :1,
+			       therapist = :2,
+			       visited_at = :3,
+			       subjective = :4,
+			       objective = :5,
+			       assessment = :6,
+			       plan = :7,
+			       treatment = :8,
+			       modalities = :9,
+			       updated_time = :10,
+			       version = version + 1
+			 WHERE id = :11 AND owner_username = :12 AND version = :13
+		`, nullableText(next.AppointmentID), next.Therapist, next.VisitedAt, nullableText(next.Subjective),
+			nullableText(next.Objective), nullableText(next.Assessment), nullableText(next.Plan),
+			nullableText(next.Treatment), nullableText(strings.Join(next.Modalities, "\n")), time.Now(),
+			id, owner, current.Version)
+		if err != nil {
+			return err
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return ErrStale
+		}
+		updated, err = getVisitNote(ctx, tx, owner, patientID, id)
+		return err
+	})
+	if err != nil {
+		return core.VisitNote{}, err
+	}
+	return updated, nil
+}
+
+func (r *VisitNoteRepo) Delete(ctx context.Context, owner, patientID, id string) error {
+	res, err := r.db.ExecContext(ctx, `
+		DELETE FROM visit_notes WHERE id=:1 AND patient_id=:2 AND owner_username=:3
+	`, id, patientID, owner)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Timeline merges a patient's visit notes, payments and measurements into one dated list.
+// Entries at the same time keep that order: notes, then payments, then measurements.
+func (r *VisitNoteRepo) Timeline(ctx context.Context, owner, patientID string, q core.TimelineQuery) ([]core.TimelineEntry, error) {
+	if err := assertPatientOwner(ctx, r.db, owner, patientID); err != nil {
+		return nil, err
+	}
+	wants := func(kind string) bool { return len(q.Kinds) == 0 || containsString(q.Kinds, kind) }
+	entries := []core.TimelineEntry{}
+	if wants(core.TimelineNote) {
+		notes, err := listVisitNotes(ctx, r.db, owner, patientID, q.From, q.To)
+		if err != nil {
+			return nil, err
+		}
+		for i := range notes {
+			entries = append(entries, core.TimelineEntry{Kind: core.TimelineNote, At: notes[i].VisitedAt, Note: &notes[i]})
+		}
+	}
+	if wants(core.TimelinePayment) {
+		payments, err := listTimelinePayments(ctx, r.db, owner, patientID, q.From, q.To)
+		if err != nil {
+			return nil, err
+		}
+		for i := range payments {
+			entries = append(entries, core.TimelineEntry{Kind: core.TimelinePayment, At: payments[i].Date, Payment: &payments[i]})
+		}
+	}
+	if wants(core.TimelineMeasurement) {
+		measurements, err := (&MeasurementRepo{db: r.db}).List(ctx, owner, patientID, core.MeasurementQuery{From: q.From, To: q.To})
+		if err != nil {
+			return nil, err
+		}
+		for i := range measurements {
+			entries = append(entries, core.TimelineEntry{
+				Kind: core.TimelineMeasurement, At: measurements[i].MeasuredAt, Measurement: &measurements[i],
+			})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if q.Descending {
+			return entries[i].At.After(entries[j].At.Time)
+		}
+		return entries[i].At.Before(entries[j].At.Time)
+	})
+	return entries, nil
+}
+
+// listTimelinePayments returns a patient's payments made in [from, to), without their allocations.
+func listTimelinePayments(ctx context.Context, q querier, owner, patientID string, from, to time.Time) ([]core.Payment, error) {
+	query := `
+		SELECT id, patient_id, amount_minor, currency, payment_mode, paid_date, version, episode_id
+		FROM payments
+		WHERE patient_id=:1 AND owner_username=:2 AND paid_date IS NOT NULL`
+	args := []interface{}{patientID, owner}
+	bind := func(v interface{}) string {
+		args = append(args, v)
+		return ":" + strconv.Itoa(len(args))
+	}
+	if !from.IsZero() {
+		query += ` AND paid_date >= ` + bind(from)
+	}
+	if !to.IsZero() {
+		query += ` AND paid_date < ` + bind(to)
+	}
+	rows, err := q.QueryContext(ctx, query+` ORDER BY paid_date, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []core.Payment
+	for rows.Next() {
+		var p core.Payment
+		var paid sql.NullTime
+		var episode sql.NullString
+		if err := rows.Scan(&p.ID, &p.PatientID, &p.Amount.Minor, &p.Amount.Currency, &p.Mode, &paid, &p.Version, &episode); err != nil {
+			return nil, err
+		}
+		p.Date = core.NewJSONTime(paid.Time)
+		p.EpisodeID = nullStringToString(episode)
+		items = append(items, p)
+	}
+	return items, rows.Err()
+}
+
+func listVisitNotes(ctx context.Context, q querier, owner, patientID string, from, to time.Time) ([]core.VisitNote, error) {
+	query := `SELECT ` + visitNoteColumns + ` FROM visit_notes WHERE patient_id=:1 AND owner_username=:2`
+	args := []interface{}{patientID, owner}
+	bind := func(v interface{}) string {
+		args = append(args, v)
+		return ":" + strconv.Itoa(len(args))
+	}
+	if !from.IsZero() {
+		query += ` AND visited_at >= ` + bind(from)
+	}
+	if !to.IsZero() {
+		query += ` AND visited_at < ` + bind(to)
+	}
+	rows, err := q.QueryContext(ctx, query+` ORDER BY visited_at, created_time, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []core.VisitNote{}
+	for rows.Next() {
+		n, err := scanVisitNote(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, n)
+	}
+	return items, rows.Err()
+}
+
+func normalizeVisitNote(n *core.VisitNote) {
+	n.AppointmentID = strings.TrimSpace(n.AppointmentID)
+	n.Therapist = strings.TrimSpace(n.Therapist)
+	n.Subjective = strings.TrimSpace(n.Subjective)
+	n.Objective = strings.TrimSpace(n.Objective)
+	n.Assessment = strings.TrimSpace(n.Assessment)
+	n.Plan = strings.TrimSpace(n.Plan)
+	n.Treatment = strings.TrimSpace(n.Treatment)
+	var modalities []string
+	for _, m := range n.Modalities {
+		if m = strings.Join(strings.Fields(m), " "); m != "" {
+			modalities = append(modalities, m)
+		}
+	}
+	n.Modalities = uniqueStrings(modalities)
+}
+
+func validateVisitNote(n core.VisitNote) error {
+	switch {
+	case n.Therapist == "" || utf8.RuneCountInString(n.Therapist) > maxNameLength:
+		return fmt.Errorf("%w: therapist must be 1 to %d characters", ErrInvalid, maxNameLength)
+	case n.VisitedAt.IsZero():
+		return fmt.Errorf("%w: visited_at is required", ErrInvalid)
+	case n.Subjective == "" && n.Objective == "" && n.Assessment == "" && n.Plan == "" && n.Treatment == "":
+		return fmt.Errorf("%w: a visit note needs at least one of subjective, objective, assessment, plan or treatment", ErrInvalid)
+	case len(n.Modalities) > maxModalities:
+		return fmt.Errorf("%w: at most %d modalities", ErrInvalid, maxModalities)
+	}
+	for _, f := range []struct{ name, value string }{
+		{"subjective", n.Subjective}, {"objective", n.Objective}, {"assessment", n.Assessment},
+		{"plan", n.Plan}, {"treatment", n.Treatment},
+	} {
+		if len(f.value) > maxTextBytes {
+			return fmt.Errorf("%w: %s is longer than %d bytes", ErrInvalid, f.name, maxTextBytes)
+		}
+	}
+	for _, m := range n.Modalities {
+		if utf8.RuneCountInString(m) > maxNameLength {
+			return fmt.Errorf("%w: modality %q is longer than %d characters", ErrInvalid, m, maxNameLength)
+		}
+	}
+	if len(strings.Join(n.Modalities, "\n")) > maxTextBytes {
+		return fmt.Errorf("%w: modalities are limited to %d bytes", ErrInvalid, maxTextBytes)
+	}
+	return nil
+}
+
+// visitNoteColumns is the select list read by scanVisitNote.
+const visitNoteColumns = `id, patient_id, appointment_id, therapist, visited_at, subjective, objective, assessment, plan,
+		       treatment, modalities, created_time, updated_time, version`
+
+func scanVisitNote(row rowScanner) (core.VisitNote, error) {
+	var n core.VisitNote
+	var appointment, subjective, objective, assessment, plan, treatment, modalities sql.NullString
+	err := row.Scan(&n.ID, &n.PatientID, &appointment, &n.Therapist, &n.VisitedAt, &subjective, &objective,
+		&assessment, &plan, &treatment, &modalities, &n.CreatedTime, &n.UpdatedTime, &n.Version)
+	n.AppointmentID = nullStringToString(appointment)
+	n.Subjective = nullStringToString(subjective)
+	n.Objective = nullStringToString(objective)
+	n.Assessment = nullStringToString(assessment)
+	n.Plan = nullStringToString(plan)
+	n.Treatment = nullStringToString(treatment)
+	n.Modalities = []string{}
+	if modalities.Valid && modalities.String != "" {
+		n.Modalities = strings.Split(modalities.String, "\n")
+	}
+	return n, err
+}
+
+func getVisitNote(ctx context.Context, q querier, owner, patientID, id string) (core.VisitNote, error) {
+	n, err := scanVisitNote(q.QueryRowContext(ctx, `
+		SELECT `+visitNoteColumns+` FROM visit_notes WHERE id=:1 AND patient_id=:2 AND owner_username=:3
+	`, id, patientID, owner))
+	if err == sql.ErrNoRows {
+		return n, ErrNotFound
+	}
+	return n, err
+}
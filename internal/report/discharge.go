@@ -0,0 +1,123 @@
+package report
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"phsio_track_backend/internal/core"
+)
+
+// DischargeSummary writes the discharge summary of s as a FormatPDF or FormatHTML document.
+func DischargeSummary(w io.Writer, s core.DischargeSummary, format string) error {
+	return dischargeDocument(s, time.Now()).write(w, format)
+}
+
+func dischargeDocument(s core.DischargeSummary, now time.Time) document {
+	p, d := s.Patient, s.Discharge
+	doc := document{
+		Title:    "Discharge summary",
+		Subtitle: p.FullName,
+		Footer:   "Therapist: " + s.Therapist + " - " + generated(now),
+	}
+	demographics := []string{}
+	if p.Age > 0 {
+		demographics = append(demographics, strconv.Itoa(p.Age)+" years")
+	}
+	if p.Gender != "" {
+		demographics = append(demographics, p.Gender)
+	}
+	doc.Fields = []field{
+		{"Patient", p.FullName},
+		{"Age / gender", strings.Join(demographics, ", ")},
+		{"Phone", p.PhoneNumber},
+		{"Discharged on", formatDate(d.DischargedOn)},
+		{"Outcome", label(d.Outcome)},
+	}
+
+	// the closed episode holds the complaint and diagnosis treated; without one the patient does
+	complaint, history, diagnosis, plan := p.ChiefComplaint, p.PresentHistory, p.Diagnosis, p.Rehab
+	if e := s.Episode; e != nil {
+		complaint, history, diagnosis, plan = e.ChiefComplaint, e.PresentHistory, e.Diagnosis, e.Plan
+		period := formatDate(e.OpenedOn) + " - " + formatDate(e.ClosedOn)
+		if e.Title != "" {
+			period = e.Title + ", " + period
+		}
+		doc.Fields = append(doc.Fields, field{"Episode of care", period})
+	}
+	doc.Sections = append(doc.Sections,
+		section{Title: "Chief complaint", Text: complaint},
+		section{Title: "History", Text: history},
+		section{Title: "Medical history", Text: p.MedicalHistory},
+		section{Title: "Diagnosis", Text: diagnosis},
+		section{Title: "Treatment given", Text: plan},
+		section{Title: "Outcome", Text: d.OutcomeNotes},
+	)
+
+	progress := section{
+		Title:  "Progress",
+		Head:   []string{"Measure", "First", "At discharge"},
+		Widths: []float64{2, 1.5, 1.5},
+	}
+	for _, m := range s.Progress {
+		progress.Rows = append(progress.Rows, []string{
+			m.Label, measurementValue(m.First, m.Unit), measurementValue(m.Last, m.Unit),
+		})
+	}
+	doc.Sections = append(doc.Sections, progress)
+
+	programme := section{
+		Title:  "Home exercise programme",
+		Head:   []string{"Exercise", "Dosage", "Frequency"},
+		Widths: []float64{2, 1.5, 1.5},
+	}
+	for _, e := range s.Exercises {
+		programme.Rows = append(programme.Rows, []string{e.Exercise, dosage(e), e.Frequency})
+	}
+	doc.Sections = append(doc.Sections, programme, section{Title: "Home advice", Text: d.HomeAdvice})
+
+	payments := section{Title: "Payments"}
+	if s.Episode != nil {
+		payments.Fields = append(payments.Fields, field{"Paid for this episode", formatMoney(s.EpisodePaid)})
+	}
+	payments.Fields = append(payments.Fields,
+		field{"Total billed", formatMoney(s.Balance.Billed)},
+		field{"Total paid", formatMoney(s.Balance.Paid)},
+		field{"Outstanding", formatMoney(s.Balance.Due)},
+	)
+	if s.Balance.Credit.Minor > 0 {
+		payments.Fields = append(payments.Fields, field{"In credit", formatMoney(s.Balance.Credit)})
+	}
+	doc.Sections = append(doc.Sections, payments)
+	return doc
+}
+
+// measurementValue formats a reading with its unit and date, e.g. "90 deg (02 Mar 2026)".
+func measurementValue(p core.MeasurementPoint, unit string) string {
+	value := p.Grade
+	if value == "" {
+		value = strconv.FormatFloat(p.Value, 'f', -1, 64)
+		if unit != "" {
+			value += " " + unit
+		}
+	}
+	return value + " (" + formatDate(p.MeasuredAt) + ")"
+}
+
+// dosage formats a prescription's sets, reps and hold, e.g. "3 x 10, hold 5 s".
+func dosage(e core.ExercisePrescription) string {
+	parts := []string{}
+	switch {
+	case e.Sets > 0 && e.Reps > 0:
+		parts = append(parts, strconv.Itoa(e.Sets)+" x "+strconv.Itoa(e.Reps))
+	case e.Sets > 0:
+		parts = append(parts, strconv.Itoa(e.Sets)+" sets")
+	case e.Reps > 0:
+		parts = append(parts, strconv.Itoa(e.Reps)+" reps")
+	}
+	if e.HoldSeconds > 0 {
+		parts = append(parts, "hold "+strconv.Itoa(e.HoldSeconds)+" s")
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,42 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"phsio_track_backend/internal/core"
+)
+
+func TestDischargeSummaryPrintsNonLatinText(t *testing.T) {
+	s := core.DischargeSummary{
+		Patient: core.Patient{FullName: "Ирина Соколова", Age: 54, Diagnosis: "Σύνδρομο πρόσκρουσης ώμου"},
+		Discharge: core.Discharge{
+			DischargedOn: core.NewJSONTime(time.Date(2025, 4, 30, 0, 0, 0, 0, time.UTC)),
+			Outcome:      "GOALS_MET",
+			HomeAdvice:   "Keep walking 🙂 every day",
+		},
+		Exercises: []core.ExercisePrescription{{Exercise: "Маятник", Sets: 3, Reps: 10, Frequency: "Ежедневно"}},
+		Therapist: "owner",
+	}
+
+	var buf bytes.Buffer
+	if err := DischargeSummary(&buf, s, FormatPDF); err != nil {
+		t.Fatal(err)
+	}
+	content := pdfContent(t, buf.Bytes())
+	for _, want := range []string{"Ирина Соколова", "Σύνδρομο πρόσκρουσης ώμου", "Маятник", "Ежедневно", "Keep walking ? every day"} {
+		if !bytes.Contains(content, pdfString(want)) {
+			t.Errorf("discharge summary PDF does not print %q", want)
+		}
+	}
+
+	buf.Reset()
+	if err := DischargeSummary(&buf, s, FormatHTML); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Ирина Соколова") || !strings.Contains(buf.String(), "🙂") {
+		t.Error("discharge summary HTML does not keep the text as written")
+	}
+}
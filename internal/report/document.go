@@ -0,0 +1,361 @@
+// Package report renders printable documents such as discharge summaries. Each document
+// is laid out once as titled sections and written as HTML or PDF.
+package report
+
+import (
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+
+	"phsio_track_backend/internal/core"
+)
+
+// Document formats.
+const (
+	FormatPDF  = "pdf"
+	FormatHTML = "html"
+)
+
+// ContentType is the MIME type of a document format.
+func ContentType(format string) string {
+	if format == FormatHTML {
+		return "text/html; charset=utf-8"
+	}
+	return "application/pdf"
+}
+
+// document is a report laid out as a heading, header fields and titled sections.
+type document struct {
+	Title    string
+	Subtitle string
+	Logo     []byte // PNG or JPEG printed beside the heading
+	Fields   []field
+	Sections []section
+	Footer   string
+}
+
+type field struct {
+	Label, Value string
+}
+
+// section holds a paragraph, label/value fields or a table; empty sections are skipped.
+type section struct {
+	Title  string
+	Text   string
+	Fields []field
+	Head   []string
+	Rows   [][]string
+	Widths []float64 // relative column widths of the table, equal when nil
+}
+
+func (s section) empty() bool {
+	return strings.TrimSpace(s.Text) == "" && len(s.Fields) == 0 && len(s.Rows) == 0
+}
+
+func (d document) write(w io.Writer, format string) error {
+	switch format {
+	case FormatPDF:
+		return d.writePDF(w)
+	case FormatHTML:
+		return d.writeHTML(w)
+	}
+	return fmt.Errorf("unknown document format %q", format)
+}
+
+var htmlTemplate = template.Must(template.New("document").Funcs(template.FuncMap{
+	"lines": func(s string) []string { return strings.Split(strings.TrimSpace(s), "\n") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; font-size: 11pt; color: #222; max-width: 48em; margin: 2em auto; }
+h1 { font-size: 16pt; margin-bottom: 0; }
+h2 { font-size: 12pt; border-bottom: 1px solid #999; margin-top: 1.5em; }
+.subtitle { color: #555; margin-top: .2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; vertical-align: top; padding: .2em .5em .2em 0; }
+table.grid th { border-bottom: 1px solid #999; }
+table.fields th { width: 12em; font-weight: normal; color: #555; }
+p { margin: .3em 0; }
+footer { margin-top: 2em; color: #555; font-size: 9pt; }
+.logo { float: right; max-height: 5em; max-width: 12em; }
+</style>
+</head>
+<body>
+{{if .LogoURL}}<img class="logo" src="{{.LogoURL}}" alt="">{{end}}
+<h1>{{.Title}}</h1>
+{{if .Subtitle}}<div class="subtitle">{{range $i, $l := lines .Subtitle}}{{if $i}}<br>{{end}}{{$l}}{{end}}</div>{{end}}
+{{if .Fields}}<table class="fields">{{range .Fields}}<tr><th>{{.Label}}</th><td>{{.Value}}</td></tr>{{end}}</table>{{end}}
+{{range .Sections}}{{if not .Empty}}
+<h2>{{.Title}}</h2>
+{{if .Text}}{{range lines .Text}}<p>{{.}}</p>{{end}}{{end}}
+{{if .Fields}}<table class="fields">{{range .Fields}}<tr><th>{{.Label}}</th><td>{{.Value}}</td></tr>{{end}}</table>{{end}}
+{{if .Rows}}<table class="grid"><tr>{{range .Head}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>{{end}}
+{{end}}{{end}}
+{{if .Footer}}<footer>{{.Footer}}</footer>{{end}}
+</body>
+</html>
+`))
+
+// htmlSection exposes empty to the template.
+type htmlSection struct {
+	section
+	Empty bool
+}
+
+func (d document) writeHTML(w io.Writer) error {
+	sections := make([]htmlSection, len(d.Sections))
+	for i, s := range d.Sections {
+		sections[i] = htmlSection{section: s, Empty: s.empty()}
+	}
+	var logo template.URL
+	if len(d.Logo) > 0 {
+		// the logo is validated when saved, so it is safe to inline
+		logo = template.URL("data:" + http.DetectContentType(d.Logo) + ";base64," + base64.StdEncoding.EncodeToString(d.Logo))
+	}
+	return htmlTemplate.Execute(w, struct {
+		document
+		Sections []htmlSection
+		LogoURL  template.URL
+	}{d, sections, logo})
+}
+
+// The PDF font is DejaVu Sans Condensed, embedded so documents render offline. Unlike the
+// cp1252 core fonts it prints Greek and Cyrillic text and the rupee sign.
+//
+//go:embed fonts/*.ttf
+var fonts embed.FS
+
+const pdfFont = "DejaVu"
+
+var pdfFontFiles = map[string]string{
+	"":  "fonts/DejaVuSansCondensed.ttf",
+	"B": "fonts/DejaVuSansCondensed-Bold.ttf",
+	"I": "fonts/DejaVuSansCondensed-Oblique.ttf",
+}
+
+// newPDF starts an A4 document with the embedded fonts registered.
+func newPDF() *gofpdf.Fpdf {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	for style, name := range pdfFontFiles {
+		b, err := fonts.ReadFile(name)
+		if err != nil {
+			pdf.SetError(err)
+			break
+		}
+		pdf.AddUTF8FontFromBytes(pdfFont, style, b)
+	}
+	return pdf
+}
+
+// pdfText replaces characters beyond the font tables' 16-bit range, such as emoji,
+// which gofpdf cannot measure.
+func pdfText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r > 0xFFFF {
+			return '?'
+		}
+		return r
+	}, s)
+}
+
+const (
+	pdfMargin   = 15.0
+	pdfLineHigh = 5.0
+	pdfLabelW   = 45.0
+	pdfLogoH    = 20.0
+	pdfLogoMaxW = 50.0
+)
+
+// CheckLogo reports whether logo is an image the PDF renderer can print.
+func CheckLogo(logo []byte) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	registerLogo(pdf, logo)
+	return pdf.Error()
+}
+
+// registerLogo adds logo to pdf under the name "logo" and returns its printed width at
+// pdfLogoH, capped at pdfLogoMaxW. Failures are left in pdf.Error.
+func registerLogo(pdf *gofpdf.Fpdf, logo []byte) float64 {
+	imageType := "PNG"
+	if http.DetectContentType(logo) == "image/jpeg" {
+		imageType = "JPG"
+	}
+	info := pdf.RegisterImageOptionsReader("logo", gofpdf.ImageOptions{ImageType: imageType}, bytes.NewReader(logo))
+	if info == nil || info.Height() == 0 {
+		return 0
+	}
+	return min(pdfLogoH*info.Width()/info.Height(), pdfLogoMaxW)
+}
+
+func (d document) writePDF(w io.Writer) error {
+	pdf := newPDF()
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	tr := pdfText
+	pageW, _ := pdf.GetPageSize()
+	width := pageW - 2*pdfMargin
+	if d.Footer != "" {
+		pdf.SetFooterFunc(func() {
+			pdf.SetY(-pdfMargin + 2)
+			pdf.SetFont(pdfFont, "I", 8)
+			pdf.CellFormat(width/2, pdfLineHigh, tr(d.Footer), "", 0, "L", false, 0, "")
+			pdf.CellFormat(width/2, pdfLineHigh, "Page "+strconv.Itoa(pdf.PageNo()), "", 0, "R", false, 0, "")
+		})
+	}
+	pdf.AddPage()
+
+	headW, logoBottom := width, 0.0
+	if len(d.Logo) > 0 {
+		if logoW := registerLogo(pdf, d.Logo); logoW > 0 {
+			h := pdfLogoH
+			if logoW == pdfLogoMaxW {
+				h = 0 // keep the aspect ratio of a wide logo
+			}
+			pdf.ImageOptions("logo", pdfMargin+width-logoW, pdfMargin, logoW, h, false, gofpdf.ImageOptions{}, 0, "")
+			headW -= logoW + 5
+			logoBottom = pdfMargin + pdfLogoH
+		}
+	}
+	pdf.SetFont(pdfFont, "B", 16)
+	pdf.MultiCell(headW, 8, tr(d.Title), "", "L", false)
+	if d.Subtitle != "" {
+		pdf.SetFont(pdfFont, "", 10)
+		pdf.SetTextColor(85, 85, 85)
+		pdf.MultiCell(headW, pdfLineHigh, tr(strings.TrimSpace(d.Subtitle)), "", "L", false)
+		pdf.SetTextColor(0, 0, 0)
+	}
+	if pdf.GetY() < logoBottom {
+		pdf.SetY(logoBottom)
+	}
+	pdf.Ln(2)
+	writePDFFields(pdf, tr, width, d.Fields)
+
+	for _, s := range d.Sections {
+		if s.empty() {
+			continue
+		}
+		pdf.Ln(3)
+		pdf.SetFont(pdfFont, "B", 12)
+		pdf.CellFormat(width, 7, tr(s.Title), "B", 1, "L", false, 0, "")
+		pdf.Ln(1)
+		if strings.TrimSpace(s.Text) != "" {
+			pdf.SetFont(pdfFont, "", 10)
+			pdf.MultiCell(width, pdfLineHigh, tr(strings.TrimSpace(s.Text)), "", "L", false)
+		}
+		writePDFFields(pdf, tr, width, s.Fields)
+		if len(s.Rows) > 0 {
+			widths := columnWidths(s, width)
+			pdf.SetFont(pdfFont, "B", 10)
+			writePDFRow(pdf, tr, widths, s.Head, "B")
+			pdf.SetFont(pdfFont, "", 10)
+			for _, row := range s.Rows {
+				writePDFRow(pdf, tr, widths, row, "")
+			}
+		}
+	}
+	return pdf.Output(w)
+}
+
+func writePDFFields(pdf *gofpdf.Fpdf, tr func(string) string, width float64, fields []field) {
+	for _, f := range fields {
+		pdf.SetFont(pdfFont, "", 10)
+		pdf.SetTextColor(85, 85, 85)
+		y := pdf.GetY()
+		pdf.MultiCell(pdfLabelW, pdfLineHigh, tr(f.Label), "", "L", false)
+		pdf.SetTextColor(0, 0, 0)
+		pdf.SetXY(pdfMargin+pdfLabelW, y)
+		pdf.MultiCell(width-pdfLabelW, pdfLineHigh, tr(f.Value), "", "L", false)
+	}
+}
+
+func columnWidths(s section, width float64) []float64 {
+	n := len(s.Head)
+	if n == 0 && len(s.Rows) > 0 {
+		n = len(s.Rows[0])
+	}
+	widths := make([]float64, n)
+	total := 0.0
+	for i := range widths {
+		widths[i] = 1
+		if i < len(s.Widths) {
+			widths[i] = s.Widths[i]
+		}
+		total += widths[i]
+	}
+	for i := range widths {
+		widths[i] = widths[i] / total * width
+	}
+	return widths
+}
+
+// writePDFRow writes a table row whose cells wrap, starting a new page when it does not fit.
+func writePDFRow(pdf *gofpdf.Fpdf, tr func(string) string, widths []float64, cells []string, border string) {
+	lines := 1
+	for i, w := range widths {
+		if i < len(cells) {
+			if n := len(pdf.SplitText(tr(cells[i]), w-1)); n > lines {
+				lines = n
+			}
+		}
+	}
+	height := float64(lines) * pdfLineHigh
+	_, pageH := pdf.GetPageSize()
+	if pdf.GetY()+height > pageH-pdfMargin {
+		pdf.AddPage()
+	}
+	x, y := pdf.GetX(), pdf.GetY()
+	for i, w := range widths {
+		text := ""
+		if i < len(cells) {
+			text = cells[i]
+		}
+		pdf.SetXY(x, y)
+		pdf.MultiCell(w-1, pdfLineHigh, tr(text), "", "L", false)
+		x += w
+	}
+	pdf.SetXY(pdfMargin, y+height)
+	if border != "" {
+		pdf.Line(pdfMargin, y+height, x, y+height)
+	}
+}
+
+func formatDate(t core.JSONTime) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("02 Jan 2006")
+}
+
+func formatMoney(m core.Money) string {
+	if m.Currency == "" {
+		return m.String()
+	}
+	return m.Currency + " " + m.String()
+}
+
+// label turns a code such as GOALS_PARTLY_MET into "Goals partly met".
+func label(code string) string {
+	s := strings.ToLower(strings.ReplaceAll(code, "_", " "))
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func generated(now time.Time) string {
+	return "Generated " + now.Format("02 Jan 2006 15:04")
+}
@@ -0,0 +1,53 @@
+package report
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"phsio_track_backend/internal/core"
+)
+
+// Receipt writes a payment receipt as a PDF. It needs nothing but the receipt: the fonts
+// are built into the renderer and the logo comes from the clinic profile.
+func Receipt(w io.Writer, r core.Receipt) error {
+	return receiptDocument(r, time.Now()).write(w, FormatPDF)
+}
+
+// ReceiptNumber formats a receipt number as printed, e.g. "000042".
+func ReceiptNumber(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	if len(s) < 6 {
+		s = strings.Repeat("0", 6-len(s)) + s
+	}
+	return s
+}
+
+func receiptDocument(r core.Receipt, now time.Time) document {
+	clinic, p := r.Clinic, r.Payment
+	header := []string{}
+	if clinic.Address != "" {
+		header = append(header, clinic.Address)
+	}
+	if clinic.RegistrationNumber != "" {
+		header = append(header, "Registration no. "+clinic.RegistrationNumber)
+	}
+	receipt := section{
+		Title: "Receipt",
+		Fields: []field{
+			{"Receipt no.", ReceiptNumber(r.Number)},
+			{"Date", formatDate(p.Date)},
+			{"Received from", r.PatientName},
+			{"Amount", formatMoney(p.Amount)},
+			{"Payment mode", label(p.Mode)},
+		},
+	}
+	return document{
+		Title:    clinic.Name,
+		Subtitle: strings.Join(header, "\n"),
+		Logo:     clinic.Logo,
+		Sections: []section{receipt},
+		Footer:   "Issued " + r.IssuedTime.Format("02 Jan 2006") + " - " + generated(now),
+	}
+}
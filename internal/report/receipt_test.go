@@ -0,0 +1,69 @@
+package report
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"phsio_track_backend/internal/core"
+)
+
+var pdfStream = regexp.MustCompile(`(?s)stream\n(.*?)\nendstream`)
+
+// pdfContent inflates the compressed streams of a PDF, where the page text lives.
+func pdfContent(t *testing.T, pdf []byte) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	for _, m := range pdfStream.FindAllSubmatch(pdf, -1) {
+		r, err := zlib.NewReader(bytes.NewReader(m[1]))
+		if err != nil {
+			continue
+		}
+		io.Copy(&out, r)
+		r.Close()
+	}
+	return out.Bytes()
+}
+
+// pdfString is s as the embedded font writes it: UTF-16BE code points.
+func pdfString(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u>>8), byte(u))
+	}
+	return b
+}
+
+func TestReceiptPrintsNonLatinText(t *testing.T) {
+	r := core.Receipt{
+		Number:     42,
+		IssuedTime: core.NewJSONTime(time.Date(2025, 4, 2, 0, 0, 0, 0, time.UTC)),
+		Payment: core.Payment{
+			Amount: core.NewMoney(150000, "INR"),
+			Mode:   "UPI",
+			Date:   core.NewJSONTime(time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)),
+		},
+		PatientName: "Ирина Σοφία",
+		Clinic:      core.ClinicProfile{Name: "Physio Care", Address: "Consultation ₹500"},
+	}
+	var buf bytes.Buffer
+	if err := Receipt(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	content := pdfContent(t, buf.Bytes())
+	for _, s := range []string{"Ирина Σοφία", "Consultation ₹500", "000042"} {
+		if !bytes.Contains(content, pdfString(s)) {
+			t.Errorf("receipt does not print %q", s)
+		}
+	}
+}
+
+func TestPDFTextKeepsTheBasicPlane(t *testing.T) {
+	if got := pdfText("Asha 🙂 ₹"); got != "Asha ? ₹" {
+		t.Errorf("pdfText = %q", got)
+	}
+}
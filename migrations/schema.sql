@@ -1,4 +1,4 @@
--- Oracle-compatible schema
+-- Oracle-compatible schema (reference only; the app applies internal/repo/migrations.go)
 
 -- USERS
 BEGIN
@@ -2,22 +2,56 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
 	"time"
 
 	"phsio_track_backend/internal/config"
+	"phsio_track_backend/internal/core"
+	"phsio_track_backend/internal/export"
 	"phsio_track_backend/internal/repo"
 )
 
-// bootstrap creates tables/indexes and exits.
+// bootstrap manages the database schema and exits.
+// Usage:
+//
+//	go run ./tools/bootstrap                    apply all pending migrations
+//	go run ./tools/bootstrap migrate status     list migrations and whether they are applied
+//	go run ./tools/bootstrap migrate up [-to N] apply pending migrations up to version N
+//	go run ./tools/bootstrap migrate down [-to N]
+//	                                            revert the latest migration, or all above version N
+//	go run ./tools/bootstrap repair last-paid   recompute patients' last paid amount/date from payments
+//	go run ./tools/bootstrap repair search-index
+//	                                            rebuild the patient search terms
+//	go run ./tools/bootstrap repair phones      rewrite stored phone numbers in E.164 (PHONE_DEFAULT_COUNTRY)
+//	go run ./tools/bootstrap repair exercise-library
+//	                                            add the exercises of legacy rehab tables missing from the library
+//	go run ./tools/bootstrap repair questionnaires
+//	                                            add built-in questionnaires missing from the database
+//	go run ./tools/bootstrap export -owner U [-format xlsx|csv] [-from YYYY-MM-DD] [-to YYYY-MM-DD] [-out PATH]
+//	                                            write a user's patients and payments in the importer's layout
 func main() {
 	cfg := config.Load()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	args := os.Args[1:]
+	if len(args) == 0 {
+		args = []string{"migrate", "up"}
+	}
+	if (args[0] != "migrate" && args[0] != "repair" && args[0] != "export") || len(args) < 2 {
+		usage()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
-	db, err := repo.NewDB(ctx, repo.DBConfig{
+	db, err := repo.Open(ctx, repo.DBConfig{
+		Driver:        cfg.DBDriver,
+		DSN:           cfg.DatabaseURL,
+		SQLitePath:    cfg.SQLitePath,
 		User:          cfg.DBUser,
 		Password:      cfg.DBPassword,
 		ConnectString: cfg.DBConnectString,
@@ -28,9 +62,217 @@ func main() {
 	}
 	defer db.Close()
 
-	if err := repo.BootstrapSchema(ctx, db); err != nil {
-		log.Fatalf("bootstrap failed: %v", err)
+	switch args[0] {
+	case "migrate":
+		if err := migrate(ctx, db, args[1], args[2:]); err != nil {
+			log.Fatalf("migrate %s failed: %v", args[1], err)
+		}
+	case "repair":
+		if err := repair(ctx, db, cfg, args[1]); err != nil {
+			log.Fatalf("repair %s failed: %v", args[1], err)
+		}
+	case "export":
+		if err := exportData(ctx, db, args[1:]); err != nil {
+			log.Fatalf("export failed: %v", err)
+		}
+	}
+}
+
+// exportData writes an owner's patients and payments as one XLSX workbook, or as
+// patients.csv and payments.csv in a directory.
+func exportData(ctx context.Context, db *repo.DB, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	owner := fs.String("owner", "", "username whose patients and payments are exported")
+	format := fs.String("format", export.FormatXLSX, "xlsx or csv")
+	from := fs.String("from", "", "first day to export (YYYY-MM-DD)")
+	to := fs.String("to", "", "last day to export, included (YYYY-MM-DD)")
+	out := fs.String("out", "", "workbook to write (default export.xlsx), or directory for the CSV files (default .)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *owner == "" {
+		return fmt.Errorf("-owner is required")
+	}
+	var q core.ExportQuery
+	var err error
+	if *from != "" {
+		if q.From, err = time.Parse("2006-01-02", *from); err != nil {
+			return fmt.Errorf("-from: %v", err)
+		}
+	}
+	if *to != "" {
+		if q.To, err = time.Parse("2006-01-02", *to); err != nil {
+			return fmt.Errorf("-to: %v", err)
+		}
+		q.To = q.To.AddDate(0, 0, 1)
+	}
+
+	r := repo.NewExportRepo(db)
+	patients, err := r.Patients(ctx, *owner, q)
+	if err != nil {
+		return err
 	}
+	payments, err := r.Payments(ctx, *owner, q)
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case export.FormatXLSX:
+		path := *out
+		if path == "" {
+			path = "export.xlsx"
+		}
+		err = writeFile(path, func(w io.Writer) error {
+			return export.WriteXLSX(w, export.Patients(patients), export.Payments(payments))
+		})
+	case export.FormatCSV:
+		dir := *out
+		if dir == "" {
+			dir = "."
+		}
+		err = writeFile(filepath.Join(dir, "patients.csv"), func(w io.Writer) error {
+			return export.WriteCSV(w, export.Patients(patients))
+		})
+		if err == nil {
+			err = writeFile(filepath.Join(dir, "payments.csv"), func(w io.Writer) error {
+				return export.WriteCSV(w, export.Payments(payments))
+			})
+		}
+	default:
+		return fmt.Errorf("-format must be %s or %s", export.FormatXLSX, export.FormatCSV)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("exported %d patients and %d payments\n", len(patients), len(payments))
+	return nil
+}
+
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func repair(ctx context.Context, db *repo.DB, cfg config.Config, what string) error {
+	switch what {
+	case "last-paid":
+		n, err := repo.NewPaymentRepo(db, cfg.Currency).RecomputeLastPaid(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("recomputed last paid for %d patients\n", n)
+		return nil
+	case "search-index":
+		n, err := repo.NewPatientRepo(db, cfg.PhoneCountry).RebuildSearchIndex(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("indexed %d patients\n", n)
+		return nil
+	case "phones":
+		n, invalid, err := repo.NewPatientRepo(db, cfg.PhoneCountry).NormalizePhones(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range invalid {
+			fmt.Printf("left as is: %s\n", s)
+		}
+		fmt.Printf("normalized %d phone numbers, %d could not be parsed\n", n, len(invalid))
+		return nil
+	case "exercise-library":
+		n, err := repo.NewExerciseLibraryRepo(db).SeedFromRehab(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("added %d exercises to the library\n", n)
+		return nil
+	case "questionnaires":
+		n, err := repo.NewQuestionnaireRepo(db).SeedBuiltIns(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("added %d built-in questionnaires\n", n)
+		return nil
+	default:
+		usage()
+		return nil
+	}
+}
+
+func migrate(ctx context.Context, db *repo.DB, cmd string, args []string) error {
+	fs := flag.NewFlagSet("migrate "+cmd, flag.ExitOnError)
+	to := fs.Int("to", -1, "target version")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "status":
+		states, err := repo.MigrationStatus(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, st := range states {
+			state := "pending"
+			if st.Applied {
+				state = "applied " + st.AppliedTime.Format("2006-01-02 15:04:05")
+			}
+			if st.Modified {
+				state += " (MODIFIED since applied)"
+			}
+			fmt.Printf("%04d %-30s %s\n", st.Version, st.Name, state)
+		}
+		return nil
+	case "up":
+		target := *to
+		if target < 0 {
+			target = 0
+		}
+		applied, err := repo.MigrateUp(ctx, db, target)
+		for _, m := range applied {
+			fmt.Printf("applied %04d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println("schema up to date")
+		return nil
+	case "down":
+		target := *to
+		if target < 0 {
+			states, err := repo.MigrationStatus(ctx, db)
+			if err != nil {
+				return err
+			}
+			// default: revert only the newest applied migration
+			target = 0
+			latest := 0
+			for _, st := range states {
+				if st.Applied {
+					target, latest = latest, st.Version
+				}
+			}
+		}
+		reverted, err := repo.MigrateDown(ctx, db, target)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d %s\n", m.Version, m.Name)
+		}
+		return err
+	default:
+		usage()
+		return nil
+	}
+}
 
-	fmt.Println("bootstrap complete")
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: bootstrap [migrate status|up|down [-to N]] | repair last-paid|search-index|phones|exercise-library|questionnaires"+
+		" | export -owner U [-format xlsx|csv] [-from D] [-to D] [-out PATH]")
+	os.Exit(2)
 }
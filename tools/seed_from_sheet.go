@@ -2,10 +2,11 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"encoding/csv"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -18,11 +19,17 @@ import (
 	"phsio_track_backend/internal/repo"
 )
 
-// seed_from_sheet ingests CSV exports of the legacy Google Sheets.
+// seed_from_sheet ingests XLSX or CSV exports of the legacy Google Sheets, and the
+// exports written by `bootstrap export` or the /export endpoints.
 // Usage:
-// go run tools/seed_from_sheet.go --dsn "postgres://..." --details-csv details.csv --payments-csv payments.csv --admin-user admin --admin-pass secret
+// go run tools/seed_from_sheet.go --db-user ... --db-pass ... --db-connect-string ... --tns-admin ... --details-xlsx details.xlsx --payments-xlsx payments.xlsx
+// go run tools/seed_from_sheet.go --db-driver postgres --dsn "postgres://..." --details-xlsx details.xlsx --payments-xlsx payments.xlsx
+// go run tools/seed_from_sheet.go --db-driver sqlite --keep-ids --details-xlsx patients.csv --payments-xlsx payments.csv
 func main() {
 	var (
+		dbDriver        string
+		dsn             string
+		sqlitePath      string
 		dbUser          string
 		dbPass          string
 		dbConnectString string
@@ -35,31 +42,56 @@ func main() {
 		adminPass       string
 		paymentsOnly    bool
 		updateTimesOnly bool
+		keepIDs         bool
 		ownerUsername   string
+		phoneCountry    string
+		currency        string
 	)
 
+	flag.StringVar(&dbDriver, "db-driver", "oracle", "database driver: oracle, postgres or sqlite")
+	flag.StringVar(&dsn, "dsn", "", "postgres connection string (db-driver=postgres)")
+	flag.StringVar(&sqlitePath, "sqlite-path", "physio_track.db", "sqlite database file (db-driver=sqlite)")
 	flag.StringVar(&dbUser, "db-user", "", "oracle db user")
 	flag.StringVar(&dbPass, "db-pass", "", "oracle db password")
 	flag.StringVar(&dbConnectString, "db-connect-string", "", "oracle TNS alias (e.g., sf1qflnhz887u1f0_high)")
 	flag.StringVar(&tnsAdmin, "tns-admin", "", "path to wallet directory")
-	flag.StringVar(&detailsPath, "details-xlsx", "PATIENT_DETAILS.xlsx", "path to details XLSX")
-	flag.StringVar(&paymentsPath, "payments-xlsx", "", "path to payments XLSX (optional)")
+	flag.StringVar(&detailsPath, "details-xlsx", "PATIENT_DETAILS.xlsx", "path to details XLSX (or CSV)")
+	flag.StringVar(&paymentsPath, "payments-xlsx", "", "path to payments XLSX (or CSV, optional)")
 	flag.StringVar(&detailsSheet, "details-sheet", "details", "sheet name for patient details")
 	flag.StringVar(&paymentsSheet, "payments-sheet", "payment", "sheet name for payments")
 	flag.StringVar(&adminUser, "admin-user", "dency", "admin username")
 	flag.StringVar(&adminPass, "admin-pass", "Dency@1121", "admin password")
 	flag.BoolVar(&paymentsOnly, "payments-only", false, "import payments only (skip patients)")
 	flag.BoolVar(&updateTimesOnly, "update-times-only", false, "update created_time/updated_time from details sheet only")
+	flag.BoolVar(&keepIDs, "keep-ids", false, "keep ids that are already UUIDs, e.g. when importing an export of this app")
 	flag.StringVar(&ownerUsername, "owner-username", "dency", "owner username to stamp on records")
+	flag.StringVar(&phoneCountry, "phone-country", "IN", "country of phone numbers without a country code (ISO 3166 alpha-2)")
+	flag.StringVar(&currency, "currency", "INR", "currency of payment amounts without a currency column (ISO 4217)")
 	flag.Parse()
 
-	if dbUser == "" || dbPass == "" || dbConnectString == "" || tnsAdmin == "" || detailsPath == "" {
-		fmt.Println("db-user, db-pass, db-connect-string, tns-admin, details-xlsx are required")
+	if detailsPath == "" {
+		fmt.Println("details-xlsx is required")
+		os.Exit(1)
+	}
+	if !core.IsPhoneCountry(phoneCountry) {
+		fmt.Printf("unknown phone-country %q\n", phoneCountry)
+		os.Exit(1)
+	}
+	currency = strings.ToUpper(currency)
+	if !core.IsCurrency(currency) {
+		fmt.Printf("unknown currency %q\n", currency)
+		os.Exit(1)
+	}
+	if dbDriver == "oracle" && (dbUser == "" || dbPass == "" || dbConnectString == "" || tnsAdmin == "") {
+		fmt.Println("db-user, db-pass, db-connect-string, tns-admin are required for oracle")
 		os.Exit(1)
 	}
 
 	ctx := context.Background()
-	db, err := repo.NewDB(ctx, repo.DBConfig{
+	db, err := repo.Open(ctx, repo.DBConfig{
+		Driver:        dbDriver,
+		DSN:           dsn,
+		SQLitePath:    sqlitePath,
 		User:          dbUser,
 		Password:      dbPass,
 		ConnectString: dbConnectString,
@@ -70,9 +102,14 @@ func main() {
 	}
 	defer db.Close()
 
+	if _, err := repo.MigrateUp(ctx, db, 0); err != nil {
+		panic(err)
+	}
+
 	userRepo := repo.NewUserRepo(db)
-	patientRepo := repo.NewPatientRepo(db)
-	paymentRepo := repo.NewPaymentRepo(db)
+	patientRepo := repo.NewPatientRepo(db, phoneCountry)
+	paymentRepo := repo.NewPaymentRepo(db, currency)
+	exerciseRepo := repo.NewExerciseRepo(db)
 
 	if err := seedAdmin(ctx, userRepo, adminUser, adminPass); err != nil {
 		panic(err)
@@ -87,13 +124,18 @@ func main() {
 	}
 
 	if !paymentsOnly {
-		if err := importDetails(ctx, patientRepo, ownerUsername, detailsPath, detailsSheet); err != nil {
+		if err := importDetails(ctx, patientRepo, exerciseRepo, ownerUsername, phoneCountry, detailsPath, detailsSheet, keepIDs); err != nil {
 			panic(err)
 		}
+		n, err := repo.NewExerciseLibraryRepo(db).SeedFromRehab(ctx)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Added %d exercises to the library\n", n)
 	}
 
 	if paymentsPath != "" {
-		if err := importPayments(ctx, paymentRepo, ownerUsername, paymentsPath, paymentsSheet); err != nil {
+		if err := importPayments(ctx, paymentRepo, ownerUsername, currency, paymentsPath, paymentsSheet, keepIDs); err != nil {
 			panic(err)
 		}
 	}
@@ -101,18 +143,12 @@ func main() {
 	fmt.Println("Import completed")
 }
 
-func seedAdmin(ctx context.Context, repo *repo.UserRepo, username, password string) error {
+func seedAdmin(ctx context.Context, repo repo.UserStore, username, password string) error {
 	return handlers.SeedUser(repo, username, password)
 }
 
-func importDetails(ctx context.Context, repo *repo.PatientRepo, owner string, path, sheet string) error {
-	f, err := excelize.OpenFile(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	rows, err := f.GetRows(sheet)
+func importDetails(ctx context.Context, repo repo.PatientStore, exercises repo.ExerciseStore, owner, phoneCountry string, path, sheet string, keepIDs bool) error {
+	rows, err := readRows(path, sheet)
 	if err != nil {
 		return err
 	}
@@ -128,7 +164,7 @@ func importDetails(ctx context.Context, repo *repo.PatientRepo, owner string, pa
 		createdAt := parseSheetDate(get(row, 13))
 		updatedAt := parseSheetDate(get(row, 14))
 		p := core.Patient{
-			ID:             uuidForString(get(row, 0)),
+			ID:             uuidForString(get(row, 0), keepIDs),
 			FullName:       get(row, 1),
 			PhoneNumber:    get(row, 2),
 			Age:            atoi(get(row, 3)),
@@ -141,26 +177,36 @@ func importDetails(ctx context.Context, repo *repo.PatientRepo, owner string, pa
 			Examination:    get(row, 10),
 			Rehab:          get(row, 11),
 			Diagnosis:      get(row, 12),
-			CreatedTime:    createdAt,
-			UpdatedTime:    updatedAt,
-			LastPaidAmount: atof(get(row, 15)),
+			CreatedTime:    core.NewJSONTime(createdAt),
+			UpdatedTime:    core.NewJSONTime(updatedAt),
 			Status:         get(row, 16),
 		}
+		// the repo normalizes phones too, but would reject the whole row over an unparseable one
+		phone, err := core.NormalizePhone(p.PhoneNumber, phoneCountry)
+		if err != nil {
+			fmt.Printf("row %d: dropping phone: %v\n", i+2, err)
+		}
+		p.PhoneNumber = phone
+		if status, ok := core.NormalizePatientStatus(p.Status); !ok && status != "" {
+			fmt.Printf("row %d: unknown status %q, importing as %s\n", i+2, p.Status, core.PatientActive)
+			p.Status = core.PatientActive
+		}
 		if err := repo.Create(ctx, owner, &p); err != nil {
 			fmt.Printf("error row %d: %v\n", i+2, err)
+			continue
+		}
+		// the raw rehab text is kept on the patient; its exercise table, if any, becomes prescriptions
+		if table := core.ParseLegacyRehab(p.Rehab); len(table) > 0 {
+			if _, err := exercises.Replace(ctx, owner, p.ID, table); err != nil {
+				fmt.Printf("row %d: exercises not imported: %v\n", i+2, err)
+			}
 		}
 	}
 	return nil
 }
 
-func importPayments(ctx context.Context, paymentRepo *repo.PaymentRepo, owner string, path, sheet string) error {
-	f, err := excelize.OpenFile(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	rows, err := f.GetRows(sheet)
+func importPayments(ctx context.Context, paymentRepo repo.PaymentStore, owner, currency string, path, sheet string, keepIDs bool) error {
+	rows, err := readRows(path, sheet)
 	if err != nil {
 		return err
 	}
@@ -173,10 +219,24 @@ func importPayments(ctx context.Context, paymentRepo *repo.PaymentRepo, owner st
 			fmt.Printf("skipping payment row %d: not enough columns\n", i+2)
 			continue
 		}
+		// exports carry each payment's currency after the legacy columns
+		rowCurrency := currency
+		if c := strings.ToUpper(get(row, 5)); c != "" {
+			rowCurrency = c
+		}
+		amount, err := parseAmount(get(row, 2), rowCurrency)
+		if err != nil {
+			fmt.Printf("skipping payment row %d: %v\n", i+2, err)
+			continue
+		}
+		if amount.Minor <= 0 {
+			fmt.Printf("skipping payment row %d: amount %s is not positive\n", i+2, amount)
+			continue
+		}
 		p := core.Payment{
-			ID:        uuidForString(get(row, 1)),
-			PatientID: uuidForString(get(row, 0)),
-			Amount:    atof(get(row, 2)),
+			ID:        uuidForString(get(row, 1), keepIDs),
+			PatientID: uuidForString(get(row, 0), keepIDs),
+			Amount:    amount,
 			Mode:      get(row, 3),
 			Date:      core.NewJSONTime(parseDate(get(row, 4))),
 		}
@@ -195,14 +255,8 @@ func importPayments(ctx context.Context, paymentRepo *repo.PaymentRepo, owner st
 	return nil
 }
 
-func updatePatientTimes(ctx context.Context, db *sql.DB, path, sheet string) error {
-	f, err := excelize.OpenFile(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	rows, err := f.GetRows(sheet)
+func updatePatientTimes(ctx context.Context, db *repo.DB, path, sheet string) error {
+	rows, err := readRows(path, sheet)
 	if err != nil {
 		return err
 	}
@@ -248,13 +302,46 @@ func updatePatientTimes(ctx context.Context, db *sql.DB, path, sheet string) err
 	return nil
 }
 
-func uuidForString(s string) string {
+// uuidForString derives a stable id from a sheet id. With keepUUIDs, ids that are
+// already UUIDs are kept, so records exported by this app keep their ids when imported.
+func uuidForString(s string, keepUUIDs bool) string {
 	if s == "" {
 		return ""
 	}
+	if id, err := uuid.Parse(s); keepUUIDs && err == nil && len(s) == 36 {
+		return id.String()
+	}
 	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(s)).String()
 }
 
+// readRows reads the rows of a sheet of an XLSX file, or of a CSV file, which holds
+// just one sheet.
+func readRows(path, sheet string) ([][]string, error) {
+	if !strings.EqualFold(filepath.Ext(path), ".csv") {
+		f, err := excelize.OpenFile(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return f.GetRows(sheet)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > 0 && len(rows[0]) > 0 {
+		rows[0][0] = strings.TrimPrefix(rows[0][0], "\ufeff")
+	}
+	return rows, nil
+}
+
 func get(row []string, idx int) string {
 	if idx < len(row) {
 		return strings.TrimSpace(row[idx])
@@ -267,9 +354,14 @@ func atoi(s string) int {
 	return n
 }
 
-func atof(s string) float64 {
-	f, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
-	return f
+// parseAmount reads an amount cell as the sheet displays it, e.g. "₹1,500.50".
+// An empty cell is zero; anything more precise than currency allows is an error.
+func parseAmount(s, currency string) (core.Money, error) {
+	s = strings.NewReplacer(",", "", " ", "", "₹", "").Replace(s)
+	if s == "" {
+		return core.NewMoney(0, currency), nil
+	}
+	return core.ParseMoney(s, currency)
 }
 
 func parseDate(s string) time.Time {
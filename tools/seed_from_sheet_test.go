@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"phsio_track_backend/internal/core"
+	"phsio_track_backend/internal/export"
+	"phsio_track_backend/internal/repo"
+)
+
+// newSheetDB opens a migrated in-memory SQLite database with one user, "owner".
+func newSheetDB(t *testing.T) *repo.DB {
+	t.Helper()
+	ctx := context.Background()
+	db, err := repo.NewSQLiteDB(ctx, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := repo.MigrateUp(ctx, db, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.NewUserRepo(db).UpsertUser(ctx, core.User{Username: "owner", PasswordHash: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// exportTables exports the owner's patients and payments as laid out in the files.
+func exportTables(t *testing.T, db *repo.DB) (export.Table, export.Table) {
+	t.Helper()
+	ctx := context.Background()
+	r := repo.NewExportRepo(db)
+	patients, err := r.Patients(ctx, "owner", core.ExportQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payments, err := r.Payments(ctx, "owner", core.ExportQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return export.Patients(patients), export.Payments(payments)
+}
+
+func writeExportFile(t *testing.T, path string, write func(w io.Writer) error) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := write(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	ctx := context.Background()
+
+	// KWD has three decimals, so reading the amounts in the --currency default would fail
+	source := newSheetDB(t)
+	patients := repo.NewPatientRepo(source, "IN")
+	// the sheets keep whole seconds, so the patients are created a day apart to keep their order
+	at := func(d int) core.JSONTime { return core.NewJSONTime(time.Date(2025, 3, d, 9, 30, 0, 0, time.UTC)) }
+	for _, p := range []core.Patient{
+		{FullName: "Ирина Соколова", PhoneNumber: "+91 98765 43210", Age: 54, Gender: "F",
+			ChiefComplaint: "Neck pain, left side\nworse at night", Diagnosis: "Cervical spondylosis",
+			Rehab: "Chin tucks \"10 reps\"", Status: core.PatientOnHold, CreatedTime: at(1)},
+		{FullName: "Asha Rao", Status: core.PatientEnquiry, CreatedTime: at(2)},
+	} {
+		if err := patients.Create(ctx, "owner", &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Status == core.PatientOnHold {
+			pay := core.Payment{PatientID: p.ID, Amount: core.NewMoney(1234, "KWD"), Mode: "UPI",
+				Date: core.NewJSONTime(time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC))}
+			if err := repo.NewPaymentRepo(source, "KWD").Create(ctx, "owner", &pay); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	wantPatients, wantPayments := exportTables(t, source)
+	if len(wantPatients.Rows) != 2 || len(wantPayments.Rows) != 1 {
+		t.Fatalf("exported %d patients and %d payments", len(wantPatients.Rows), len(wantPayments.Rows))
+	}
+
+	dir := t.TempDir()
+	files := map[string][2]string{}
+	csvPatients, csvPayments := filepath.Join(dir, "patients.csv"), filepath.Join(dir, "payments.csv")
+	writeExportFile(t, csvPatients, func(w io.Writer) error { return export.WriteCSV(w, wantPatients) })
+	writeExportFile(t, csvPayments, func(w io.Writer) error { return export.WriteCSV(w, wantPayments) })
+	files[export.FormatCSV] = [2]string{csvPatients, csvPayments}
+	workbook := filepath.Join(dir, "export.xlsx")
+	writeExportFile(t, workbook, func(w io.Writer) error { return export.WriteXLSX(w, wantPatients, wantPayments) })
+	files[export.FormatXLSX] = [2]string{workbook, workbook}
+
+	for format, paths := range files {
+		t.Run(format, func(t *testing.T) {
+			target := newSheetDB(t)
+			err := importDetails(ctx, repo.NewPatientRepo(target, "IN"), repo.NewExerciseRepo(target), "owner", "IN",
+				paths[0], export.PatientsSheet, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// --currency is left at its default: the currency column decides
+			err = importPayments(ctx, repo.NewPaymentRepo(target, "KWD"), "owner", "INR", paths[1], export.PaymentsSheet, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotPatients, gotPayments := exportTables(t, target)
+			if !reflect.DeepEqual(gotPatients.Rows, wantPatients.Rows) {
+				t.Errorf("patients after the round trip:\n got %v\nwant %v", gotPatients.Rows, wantPatients.Rows)
+			}
+			if !reflect.DeepEqual(gotPayments.Rows, wantPayments.Rows) {
+				t.Errorf("payments after the round trip:\n got %v\nwant %v", gotPayments.Rows, wantPayments.Rows)
+			}
+		})
+	}
+}